@@ -4,19 +4,33 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/google/subcommands"
+	"github.com/sirupsen/logrus"
+	"github.com/terassyi/gotcp/pkg/bpf"
+	"github.com/terassyi/gotcp/pkg/dump"
+	"github.com/terassyi/gotcp/pkg/filter"
 	"github.com/terassyi/gotcp/pkg/interfaces"
-	"github.com/terassyi/gotcp/pkg/packet/ethernet"
-	"github.com/terassyi/gotcp/pkg/proto/arp"
-	eth "github.com/terassyi/gotcp/pkg/proto/ethernet"
-	"github.com/terassyi/gotcp/pkg/proto/icmp"
-	"github.com/terassyi/gotcp/pkg/proto/ipv4"
-	"github.com/terassyi/gotcp/pkg/proto/tcp"
+	"github.com/terassyi/gotcp/pkg/packet/decode"
+	"github.com/terassyi/gotcp/pkg/pcap"
 )
 
 type DumpCommand struct {
-	Iface string
+	Iface    string
+	Type     string
+	Read     string
+	Write    string
+	Count    int
+	Hex      bool
+	Link     bool
+	Absolute bool
+	Program  bool
 }
 
 func (d *DumpCommand) Name() string {
@@ -28,63 +42,151 @@ func (d *DumpCommand) Synopsis() string {
 }
 
 func (d *DumpCommand) Usage() string {
-	return `gotcp dump -i <interface name>:
-	dump packets received by the interface`
+	return `gotcp dump {-i <interface name> [-type <interface type>] | -r <file>} [-w <file>] [-c <count>] [-X] [-e] [-S] [-d] [expression]:
+	print packets received by the interface or read from the capture file in one line per packet.
+	only packets matching the filter expression such as "tcp port 80 and host 10.0.0.1" are processed.
+	the expression is compiled to bpf and attached to the socket of the afpacket interface`
 }
 
 func (d *DumpCommand) SetFlags(f *flag.FlagSet) {
 	f.StringVar(&d.Iface, "i", "", "interface")
+	f.StringVar(&d.Type, "type", "afpacket", typeUsage)
+	f.StringVar(&d.Read, "r", "", "read packets from the capture file instead of the interface")
+	f.StringVar(&d.Write, "w", "", "write packets to the capture file, the pcapng format is used when the extension is .pcapng")
+	f.StringVar(&d.Write, "pcap", "", "same as -w")
+	f.IntVar(&d.Count, "c", 0, "exit after the number of packets, 0 is unlimited")
+	f.BoolVar(&d.Hex, "X", false, "print packets in hex and ascii")
+	f.BoolVar(&d.Link, "e", false, "print the ethernet header")
+	f.BoolVar(&d.Absolute, "S", false, "print absolute tcp sequence numbers")
+	f.BoolVar(&d.Program, "d", false, "print the bpf program compiled from the expression and exit")
 }
 
-func (d *DumpCommand) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
-	iface, err := interfaces.New(d.Iface, "afpacket")
+// frameSource returns the next frame and the time received. It returns io.EOF at the end,
+// and interfaces.ErrTimeout while no frame is received.
+type frameSource func() (time.Time, []byte, error)
+
+func (d *DumpCommand) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	expression, err := filter.Compile(strings.Join(f.Args(), " "))
 	if err != nil {
-		panic(err)
+		logrus.WithFields(logrus.Fields{
+			"command": "dump",
+		}).Error(err)
+		return subcommands.ExitUsageError
 	}
-	defer iface.Close()
-
-	arpProtocol := arp.New(arp.NewTable(), false)
-	e, err := eth.New(iface, arpProtocol)
-	icmpProtocol := icmp.New(false)
-
-	tcpProtocol, err := tcp.New(false)
+	prog, err := expression.BPF()
 	if err != nil {
-		return subcommands.ExitFailure
+		logrus.WithFields(logrus.Fields{
+			"command": "dump",
+		}).Error(err)
+		return subcommands.ExitUsageError
 	}
-	ipv4Protocol, err := ipv4.New(e, icmpProtocol, tcpProtocol, false)
+	if d.Program {
+		fmt.Print(bpf.Disassemble(prog))
+		return subcommands.ExitSuccess
+	}
+	source, linkType, closeSource, err := d.open(prog)
 	if err != nil {
-		fmt.Println(err)
+		logrus.WithFields(logrus.Fields{
+			"command": "dump",
+		}).Error(err)
 		return subcommands.ExitFailure
 	}
+	defer closeSource()
+	// the capture stops by the interruption to close the written file
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	ipv4Protocol.Show()
-	go arpProtocol.Handle()
-	//go ipv4Protocol.Handle()
-	go icmpProtocol.Handle()
-
-	//go tcpProtocol.Handle()
-
-	for {
-		buf := make([]byte, 1500)
-		_, err := iface.Recv(buf)
-		if err != nil {
-			panic(err)
+	var w *pcap.File
+	if d.Write != "" {
+		if w, err = pcap.Create(d.Write, linkType); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"command": "dump",
+			}).Error(err)
+			return subcommands.ExitFailure
+		}
+		defer w.Close()
+	}
+	raw := linkType == pcap.LinkTypeRaw
+	dumper := dump.New(os.Stdout, dump.Options{Hex: d.Hex, Link: d.Link, Absolute: d.Absolute, Raw: raw})
+	for n := 0; d.Count == 0 || n < d.Count; {
+		select {
+		case <-ctx.Done():
+			return subcommands.ExitSuccess
+		default:
+		}
+		ts, frame, err := source()
+		if err == io.EOF {
+			break
+		}
+		if err == interfaces.ErrTimeout {
+			// the link is idle, check the interruption again
+			continue
 		}
-		frame, err := ethernet.New(buf)
 		if err != nil {
-			panic(err)
+			logrus.WithFields(logrus.Fields{
+				"command": "dump",
+			}).Error(err)
+			return subcommands.ExitFailure
 		}
-		//frame.Header.Show()
-
-		switch frame.Type() {
-		case ethernet.ETHER_TYPE_IP:
-			//ipv4Protocol.Recv(frame.Payload())
-			go ipv4Protocol.HandlePacket(frame.Payload())
-		case ethernet.ETHER_TYPE_ARP:
-			arpProtocol.Recv(frame.Payload())
-		default:
-			fmt.Println("unsupported packet type.")
+		var packet *decode.Packet
+		if raw {
+			packet = decode.DecodeRaw(frame)
+		} else {
+			packet = decode.Decode(frame)
+		}
+		if !expression.Match(packet) {
+			continue
+		}
+		n++
+		if w != nil {
+			if err := w.WritePacket(ts, pcap.Unknown, frame); err != nil {
+				logrus.WithFields(logrus.Fields{
+					"command": "dump",
+				}).Error(err)
+				return subcommands.ExitFailure
+			}
+		}
+		if err := dumper.PrintPacket(ts, packet, frame); err != nil {
+			return subcommands.ExitFailure
 		}
 	}
 	return subcommands.ExitSuccess
 }
+
+// open opens the capture file when -r is given, otherwise the interface with the program attached.
+// It returns the link type of frames.
+func (d *DumpCommand) open(prog []bpf.Instruction) (frameSource, pcap.LinkType, func(), error) {
+	if d.Read != "" {
+		file, err := pcap.Open(d.Read)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		return func() (time.Time, []byte, error) {
+			packet, err := file.ReadPacket()
+			if err != nil {
+				return time.Time{}, nil, err
+			}
+			return packet.Timestamp, packet.Data, nil
+		}, file.LinkType(), func() { file.Close() }, nil
+	}
+	iface, err := interfaces.New(d.Iface, d.Type)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	// the program is for ethernet frames
+	linkType := interfaces.LinkType(iface)
+	if linkType == pcap.LinkTypeEthernet {
+		if err := interfaces.AttachFilter(iface, prog); err != nil && err != interfaces.ErrFilterNotSupported {
+			iface.Close()
+			return nil, 0, nil, err
+		}
+	}
+	return func() (time.Time, []byte, error) {
+		buf := make([]byte, 65536)
+		n, err := iface.Recv(buf)
+		if err != nil {
+			return time.Time{}, nil, err
+		}
+		return time.Now(), buf[:n], nil
+	}, linkType, func() { iface.Close() }, nil
+}
@@ -4,14 +4,20 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"strings"
 
 	"github.com/google/subcommands"
+	"github.com/sirupsen/logrus"
+	"github.com/terassyi/gotcp/pkg/filter"
 	"github.com/terassyi/gotcp/pkg/ids"
 	"github.com/terassyi/gotcp/pkg/interfaces"
+	"github.com/terassyi/gotcp/pkg/pcap"
 )
 
 type IdsCommand struct {
 	Iface string
+	Type  string
+	Pcap  string
 }
 
 func (*IdsCommand) Name() string {
@@ -23,28 +29,49 @@ func (*IdsCommand) Synopsis() string {
 }
 
 func (*IdsCommand) Usage() string {
-	return "gotcp ids -i <interface name>"
+	return `gotcp ids -i <interface name> [-type <interface type>] [-pcap <file>] [expression]:
+	inspect packets matching the filter expression such as "tcp and not port 22"`
 }
 
 func (ids *IdsCommand) SetFlags(f *flag.FlagSet) {
 	f.StringVar(&ids.Iface, "i", "", "interface")
+	f.StringVar(&ids.Type, "type", "afpacket", typeUsage)
+	f.StringVar(&ids.Pcap, "pcap", "", pcapUsage)
 }
 
 func (id *IdsCommand) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
-	iface, err := interfaces.New(id.Iface, "afpacket")
+	expression, err := filter.Compile(strings.Join(f.Args(), " "))
 	if err != nil {
-		panic(err)
+		logrus.WithFields(logrus.Fields{
+			"command": "ids",
+		}).Error(err)
+		return subcommands.ExitUsageError
 	}
-	defer iface.Close()
+	iface, closeIface, err := openIface(id.Iface, id.Type, id.Pcap)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"command": "ids",
+		}).Error(err)
+		return subcommands.ExitFailure
+	}
+	defer closeIface()
 
 	i := ids.New()
+	i.Filter = expression
+	i.Raw = interfaces.LinkType(iface) == pcap.LinkTypeRaw
 	for {
-		buf := make([]byte, 1500)
-		_, err := iface.Recv(buf)
+		buf := make([]byte, 65536)
+		n, err := iface.Recv(buf)
+		if err == interfaces.ErrTimeout {
+			continue
+		}
 		if err != nil {
-			panic(err)
+			logrus.WithFields(logrus.Fields{
+				"command": "ids",
+			}).Error(err)
+			return subcommands.ExitFailure
 		}
-		if err := i.Recv(buf); err != nil {
+		if err := i.Recv(buf[:n]); err != nil {
 			fmt.Println("")
 		}
 	}
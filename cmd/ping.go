@@ -4,16 +4,25 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"time"
 
 	"github.com/google/subcommands"
 	"github.com/sirupsen/logrus"
-	"github.com/terassyi/gotcp/pkg/proto/icmp/ping"
+	"github.com/terassyi/gotcp/pkg/gotcp"
+	"github.com/terassyi/gotcp/pkg/packet/ipv4"
 )
 
 type PingCommand struct {
-	Iface string
-	Dst   string
-	Debug bool
+	Iface        string
+	Type         string
+	Dst          string
+	Addr         string
+	Mac          string
+	Routes       string
+	KernelRoutes bool
+	RecordRoute  bool
+	Debug        bool
+	Pcap         string
 }
 
 func (p *PingCommand) Name() string {
@@ -25,31 +34,67 @@ func (p *PingCommand) Synopsis() string {
 }
 
 func (p *PingCommand) Usage() string {
-	return `goctp ping -i <interface name> -dest <destination address>:
+	return `goctp ping -i <interface name> [-type <interface type>] [-addr <ip address/prefix>] [-mac <hardware address>] [-routes <file>] [-kernel-routes] [-record-route] -dest <destination address> [-pcap <file>]:
 	send icmp echo request packets and receive reply packets`
 }
 
 func (p *PingCommand) SetFlags(f *flag.FlagSet) {
 	f.StringVar(&p.Iface, "i", "", "interface")
+	f.StringVar(&p.Type, "type", "afpacket", typeUsage)
 	f.StringVar(&p.Dst, "dest", "", "destination address")
+	f.StringVar(&p.Addr, "addr", "", addrUsage)
+	f.StringVar(&p.Mac, "mac", "", macUsage)
+	f.StringVar(&p.Routes, "routes", "", routesUsage)
+	f.BoolVar(&p.KernelRoutes, "kernel-routes", false, kernelRoutesUsage)
+	f.BoolVar(&p.RecordRoute, "record-route", false, "record the route of the round trip")
 	f.BoolVar(&p.Debug, "debug", false, "output debug messages")
+	f.StringVar(&p.Pcap, "pcap", "", pcapUsage)
 }
 
-func (p *PingCommand) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
-	pin, err := ping.New(p.Iface, p.Dst, p.Debug)
-	fmt.Println(p.Dst)
+func (p *PingCommand) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	dst, err := ipv4.StringToIPAddress(p.Dst)
 	if err != nil {
 		logrus.WithFields(logrus.Fields{
 			"command": "ping",
 		}).Error(err)
-		return subcommands.ExitFailure
+		return subcommands.ExitUsageError
 	}
-	if err := pin.Start(); err != nil {
+	routes, err := readRoutes(p.Routes)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"command": "ping",
+		}).Error(err)
+		return subcommands.ExitUsageError
+	}
+	stack, err := startStack(ctx, gotcp.Config{Name: p.Iface, Type: p.Type, Address: p.Addr, Mac: p.Mac, Routes: routes, KernelRoutes: p.KernelRoutes, Debug: p.Debug, Pcap: p.Pcap, LogLevel: "info"}, "ping")
+	if err != nil {
 		logrus.WithFields(logrus.Fields{
 			"command": "ping",
 		}).Error(err)
 		return subcommands.ExitFailure
 	}
-
-	return subcommands.ExitSuccess
+	defer stack.Close()
+	fmt.Printf("PING %s from %s\n", dst, stack.Ipv4().Address)
+	for seq := 1; ; seq++ {
+		var options ipv4.Options
+		if p.RecordRoute {
+			options = append(options, ipv4.NewRecordRoute(9))
+		}
+		rtt, reply, err := stack.Icmp().PingOptions(*dst, time.Second, options)
+		if err != nil {
+			fmt.Printf("%s: icmp_seq=%d %v\n", dst, seq, err)
+		} else {
+			fmt.Printf("reply from %s: icmp_seq=%d time=%f ms\n", dst, seq, float64(rtt)/float64(time.Millisecond))
+			if rr := reply.RecordRoute(); rr != nil {
+				fmt.Printf("RR: %s\n", rr)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return subcommands.ExitSuccess
+		case <-stack.Done():
+			return subcommands.ExitFailure
+		case <-time.After(time.Second):
+		}
+	}
 }
@@ -0,0 +1,198 @@
+package cmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/google/subcommands"
+	"github.com/sirupsen/logrus"
+	"github.com/terassyi/gotcp/pkg/gotcp"
+	"github.com/terassyi/gotcp/pkg/proto/ipv4"
+)
+
+type RouteCommand struct {
+	Iface        string
+	Type         string
+	Addr         string
+	Routes       string
+	KernelRoutes bool
+}
+
+func (*RouteCommand) Name() string {
+	return "route"
+}
+
+func (*RouteCommand) Synopsis() string {
+	return "show and edit routes"
+}
+
+func (*RouteCommand) Usage() string {
+	return `gotcp route [-routes <file>] [-i <interface name> [-type <interface type>] [-addr <ip address/prefix>] [-kernel-routes]] [show]:
+	show routes in the file, or the routing table of the stack on the interface with connected routes
+gotcp route -routes <file> add|del <destination>[/<prefix>]|default [via <gateway>] [dev <interface>] [metric <metric>]:
+	add the route to the file or delete it from the file
+gotcp route [-routes <file>] import:
+	print routes of the kernel, they are appended to the file when given
+`
+}
+
+func (r *RouteCommand) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&r.Iface, "i", "", "interface")
+	f.StringVar(&r.Type, "type", "afpacket", typeUsage)
+	f.StringVar(&r.Addr, "addr", "", addrUsage)
+	f.StringVar(&r.Routes, "routes", "", routesUsage)
+	f.BoolVar(&r.KernelRoutes, "kernel-routes", false, kernelRoutesUsage)
+}
+
+func (r *RouteCommand) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	command := "show"
+	if f.NArg() > 0 {
+		command = f.Arg(0)
+	}
+	var err error
+	switch command {
+	case "show":
+		err = r.show()
+	case "add", "del":
+		if r.Routes == "" || f.NArg() < 2 {
+			fmt.Println(r.Usage())
+			return subcommands.ExitUsageError
+		}
+		err = r.edit(command, strings.Join(f.Args()[1:], " "))
+	case "import":
+		err = r.importKernel()
+	default:
+		fmt.Println(r.Usage())
+		return subcommands.ExitUsageError
+	}
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"command": "route",
+		}).Error(err)
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
+
+func (r *RouteCommand) show() error {
+	routes, err := readRoutes(r.Routes)
+	if err != nil {
+		return err
+	}
+	if r.Iface == "" {
+		for _, route := range routes {
+			fmt.Println(route)
+		}
+		return nil
+	}
+	// the stack is built but not started to resolve connected routes
+	stack, err := gotcp.New(gotcp.Config{Name: r.Iface, Type: r.Type, Address: r.Addr, Routes: routes, KernelRoutes: r.KernelRoutes, LogLevel: "info"})
+	if err != nil {
+		return err
+	}
+	defer stack.Close()
+	for _, route := range stack.Ipv4().Routes() {
+		fmt.Println(route)
+	}
+	return nil
+}
+
+func (r *RouteCommand) edit(command, s string) error {
+	route, err := ipv4.ParseRoute(s)
+	if err != nil {
+		return err
+	}
+	table, err := loadRoutes(r.Routes)
+	if err != nil {
+		return err
+	}
+	if command == "add" {
+		err = table.Add(route)
+	} else {
+		err = table.Delete(route)
+	}
+	if err != nil {
+		return err
+	}
+	return writeRoutes(r.Routes, table.Routes())
+}
+
+func (r *RouteCommand) importKernel() error {
+	file, err := os.Open("/proc/net/route")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	kernel, err := ipv4.ReadKernelRoutes(file)
+	if err != nil {
+		return err
+	}
+	for _, route := range kernel {
+		fmt.Println(route)
+	}
+	if r.Routes == "" {
+		return nil
+	}
+	table, err := loadRoutes(r.Routes)
+	if err != nil {
+		return err
+	}
+	for _, route := range kernel {
+		// routes already in the file are kept
+		table.Add(route)
+	}
+	return writeRoutes(r.Routes, table.Routes())
+}
+
+// readRoutes reads routes one per line from the file, empty lines and comments starting with # are skipped.
+func readRoutes(path string) ([]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var routes []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		routes = append(routes, line)
+	}
+	return routes, nil
+}
+
+// loadRoutes reads the file into the table, the table is empty when the file does not exist.
+func loadRoutes(path string) (*ipv4.Table, error) {
+	table := ipv4.NewTable()
+	routes, err := readRoutes(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return table, nil
+		}
+		return nil, err
+	}
+	for _, line := range routes {
+		route, err := ipv4.ParseRoute(line)
+		if err != nil {
+			return nil, err
+		}
+		if err := table.Add(route); err != nil {
+			return nil, err
+		}
+	}
+	return table, nil
+}
+
+func writeRoutes(path string, routes []*ipv4.Route) error {
+	var b strings.Builder
+	for _, route := range routes {
+		fmt.Fprintln(&b, route)
+	}
+	return os.WriteFile(path, []byte(b.String()), 0644)
+}
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+	"github.com/terassyi/gotcp/pkg/gotcp"
+	"github.com/terassyi/gotcp/pkg/interfaces"
+	"github.com/terassyi/gotcp/pkg/pcap"
+)
+
+const impairUsage = "emulate the impaired link such as latency=10ms,jitter=2ms,loss=0.01,burst=p:r:lossgood:lossbad,dup=0.01,reorder=0.1,corrupt=0.001,rate=<bytes/s>,limit=<frames>,seed=1"
+
+const pcapUsage = "capture frames to the file, the pcapng format is used when the extension is .pcapng"
+
+const typeUsage = "interface type, afpacket, afpacket-mmap, tap or tun"
+
+const addrUsage = "ip address of the stack with the prefix length such as 10.0.0.5/24, the address of the interface is used when empty"
+
+const macUsage = "hardware address of the stack, the address of the interface is used when empty"
+
+const routesUsage = "file of static routes, one route per line such as 10.1.0.0/16 via 10.0.0.254"
+
+const kernelRoutesUsage = "import routes of the kernel on the interface"
+
+// openIface opens the interface capturing frames to the file when path is given.
+// The returned function closes both of them.
+func openIface(name, typ, path string) (interfaces.Iface, func(), error) {
+	iface, err := interfaces.New(name, typ)
+	if err != nil {
+		return nil, nil, err
+	}
+	if path == "" {
+		return iface, func() { iface.Close() }, nil
+	}
+	capture, err := pcap.Create(path, interfaces.LinkType(iface))
+	if err != nil {
+		iface.Close()
+		return nil, nil, err
+	}
+	return interfaces.NewCaptured(iface, capture), func() {
+		iface.Close()
+		capture.Close()
+	}, nil
+}
+
+// parseImpairment returns nil when no impairment is specified.
+func parseImpairment(s string) (*interfaces.Impairment, error) {
+	if s == "" {
+		return nil, nil
+	}
+	impairment, err := interfaces.ParseImpairment(s)
+	if err != nil {
+		return nil, err
+	}
+	return &impairment, nil
+}
+
+// startStack starts the stack and logs errors reported from it.
+func startStack(ctx context.Context, config gotcp.Config, command string) (*gotcp.Stack, error) {
+	stack, err := gotcp.New(config)
+	if err != nil {
+		return nil, err
+	}
+	if err := stack.Start(ctx); err != nil {
+		stack.Close()
+		return nil, err
+	}
+	go func() {
+		for {
+			select {
+			case err := <-stack.Errors():
+				logrus.WithFields(logrus.Fields{
+					"command": command,
+				}).Error(err)
+			case <-stack.Done():
+				return
+			}
+		}
+	}()
+	return stack, nil
+}
@@ -14,10 +14,17 @@ import (
 )
 
 type TcpClientCommand struct {
-	Iface string
-	Addr  string
-	Port  int
-	Debug bool
+	Iface        string
+	Type         string
+	Addr         string
+	Mac          string
+	Routes       string
+	KernelRoutes bool
+	Dst          string
+	Port         int
+	Debug        bool
+	Impair       string
+	Pcap         string
 }
 
 func (c *TcpClientCommand) Name() string {
@@ -29,18 +36,25 @@ func (c *TcpClientCommand) Synopsis() string {
 }
 
 func (c *TcpClientCommand) Usage() string {
-	return `gotcp tcpclient -i <interface name> -addr <ip address> -port <port>
+	return `gotcp tcpclient -i <interface name> [-type <interface type>] [-addr <ip address/prefix>] [-mac <hardware address>] [-routes <file>] [-kernel-routes] -dest <ip address> -port <port> [-impair <impairment>] [-pcap <file>]
 	tcp client to destination host`
 }
 
 func (c *TcpClientCommand) SetFlags(f *flag.FlagSet) {
 	f.StringVar(&c.Iface, "i", "", "interface name")
-	f.StringVar(&c.Addr, "addr", "", "destination host address")
+	f.StringVar(&c.Type, "type", "afpacket", typeUsage)
+	f.StringVar(&c.Addr, "addr", "", addrUsage)
+	f.StringVar(&c.Mac, "mac", "", macUsage)
+	f.StringVar(&c.Routes, "routes", "", routesUsage)
+	f.BoolVar(&c.KernelRoutes, "kernel-routes", false, kernelRoutesUsage)
+	f.StringVar(&c.Dst, "dest", "", "destination host address")
 	f.IntVar(&c.Port, "port", 0, "destination host port")
 	f.BoolVar(&c.Debug, "debug", false, "output debug message")
+	f.StringVar(&c.Impair, "impair", "", impairUsage)
+	f.StringVar(&c.Pcap, "pcap", "", pcapUsage)
 }
 
-func (c *TcpClientCommand) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+func (c *TcpClientCommand) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
 	if c.Debug {
 		logrus.WithFields(logrus.Fields{
 			"command": "tcp client",
@@ -51,8 +65,29 @@ func (c *TcpClientCommand) Execute(_ context.Context, f *flag.FlagSet, _ ...inte
 		}).Info("debug flag is not set")
 	}
 	// // tcp client
-	gt, err := gotcp.TcpInit(c.Iface, c.Debug)
-	conn, err := gt.Dial(c.Addr, c.Port)
+	impairment, err := parseImpairment(c.Impair)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"command": "tcp client",
+		}).Error(err)
+		return subcommands.ExitFailure
+	}
+	routes, err := readRoutes(c.Routes)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"command": "tcp client",
+		}).Error(err)
+		return subcommands.ExitFailure
+	}
+	stack, err := startStack(ctx, gotcp.Config{Name: c.Iface, Type: c.Type, Address: c.Addr, Mac: c.Mac, Routes: routes, KernelRoutes: c.KernelRoutes, Debug: c.Debug, Impairment: impairment, Pcap: c.Pcap}, "tcp client")
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"command": "tcp client",
+		}).Error(err)
+		return subcommands.ExitFailure
+	}
+	defer stack.Close()
+	conn, err := stack.Tcp().Dial(c.Dst, c.Port)
 	if err != nil {
 		logrus.WithFields(logrus.Fields{
 			"command": "tcp client",
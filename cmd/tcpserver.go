@@ -13,9 +13,16 @@ import (
 )
 
 type TcpServerCommand struct {
-	Iface string
-	Port  int
-	Debug bool
+	Iface        string
+	Type         string
+	Addr         string
+	Mac          string
+	Routes       string
+	KernelRoutes bool
+	Port         int
+	Debug        bool
+	Impair       string
+	Pcap         string
 }
 
 func (*TcpServerCommand) Name() string {
@@ -27,17 +34,24 @@ func (*TcpServerCommand) Synopsis() string {
 }
 
 func (*TcpServerCommand) Usage() string {
-	return `gotcp tcpserver -i <interface name> -port <port>
+	return `gotcp tcpserver -i <interface name> [-type <interface type>] [-addr <ip address/prefix>] [-mac <hardware address>] [-routes <file>] [-kernel-routes] -port <port> [-impair <impairment>] [-pcap <file>]
 	tcp server binding port`
 }
 
 func (s *TcpServerCommand) SetFlags(f *flag.FlagSet) {
 	f.StringVar(&s.Iface, "i", "", "interface")
+	f.StringVar(&s.Type, "type", "afpacket", typeUsage)
+	f.StringVar(&s.Addr, "addr", "", addrUsage)
+	f.StringVar(&s.Mac, "mac", "", macUsage)
+	f.StringVar(&s.Routes, "routes", "", routesUsage)
+	f.BoolVar(&s.KernelRoutes, "kernel-routes", false, kernelRoutesUsage)
 	f.IntVar(&s.Port, "port", 0, "binding port")
 	f.BoolVar(&s.Debug, "debug", false, "output debug message")
+	f.StringVar(&s.Impair, "impair", "", impairUsage)
+	f.StringVar(&s.Pcap, "pcap", "", pcapUsage)
 }
 
-func (s *TcpServerCommand) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+func (s *TcpServerCommand) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
 	if s.Debug {
 		logrus.WithFields(
 			logrus.Fields{
@@ -50,11 +64,32 @@ func (s *TcpServerCommand) Execute(_ context.Context, f *flag.FlagSet, _ ...inte
 	}
 
 	// tcp server
-	gt, err := gotcp.TcpInit(s.Iface, s.Debug)
+	impairment, err := parseImpairment(s.Impair)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"command": "tcp server",
+		}).Error(err)
+		return subcommands.ExitFailure
+	}
+	routes, err := readRoutes(s.Routes)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"command": "tcp server",
+		}).Error(err)
+		return subcommands.ExitFailure
+	}
+	stack, err := startStack(ctx, gotcp.Config{Name: s.Iface, Type: s.Type, Address: s.Addr, Mac: s.Mac, Routes: routes, KernelRoutes: s.KernelRoutes, Debug: s.Debug, Impairment: impairment, Pcap: s.Pcap}, "tcp server")
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"command": "tcp server",
+		}).Error(err)
+		return subcommands.ExitFailure
+	}
+	defer stack.Close()
 	logrus.WithFields(logrus.Fields{
-		"command": "tcp client",
+		"command": "tcp server",
 	}).Infof("tcp server running at %d\n", s.Port)
-	listener, err := gt.Listen("0.0.0.0", s.Port)
+	listener, err := stack.Tcp().Listen("0.0.0.0", s.Port)
 	if err != nil {
 		logrus.WithFields(logrus.Fields{
 			"command": "tcp server",
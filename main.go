@@ -19,6 +19,7 @@ func main() {
 	subcommands.Register(&cmd.TcpClientCommand{}, "")
 	subcommands.Register(&cmd.TcpServerCommand{}, "")
 	subcommands.Register(&cmd.IdsCommand{}, "")
+	subcommands.Register(&cmd.RouteCommand{}, "")
 
 	flag.Parse()
 	ctx := context.Background()
@@ -0,0 +1,99 @@
+package bpf
+
+import "fmt"
+
+// Label is a position in the program resolved by Assemble.
+type Label int
+
+// Assembler builds a program with jumps to labels instead of relative offsets.
+type Assembler struct {
+	insts  []instruction
+	labels []int
+}
+
+type instruction struct {
+	Instruction
+	jt, jf Label
+	ja     bool // k of the unconditional jump is the label
+}
+
+// Next is the label of the next instruction, the jump to it falls through.
+const Next Label = -1
+
+// NewAssembler returns an empty assembler.
+func NewAssembler() *Assembler {
+	return &Assembler{}
+}
+
+// NewLabel allocates a label which is placed later by Label.
+func (a *Assembler) NewLabel() Label {
+	a.labels = append(a.labels, -1)
+	return Label(len(a.labels) - 1)
+}
+
+// Label places the label at the next instruction.
+func (a *Assembler) Label(l Label) {
+	a.labels[l] = len(a.insts)
+}
+
+// Emit appends the instruction without jumps.
+func (a *Assembler) Emit(op uint16, k uint32) {
+	a.insts = append(a.insts, instruction{Instruction: Instruction{Op: op, K: k}, jt: Next, jf: Next})
+}
+
+// Jump appends the conditional jump.
+func (a *Assembler) Jump(op uint16, k uint32, jt, jf Label) {
+	a.insts = append(a.insts, instruction{Instruction: Instruction{Op: JMP | op, K: k}, jt: jt, jf: jf})
+}
+
+// Goto appends the unconditional jump to the label.
+func (a *Assembler) Goto(l Label) {
+	a.insts = append(a.insts, instruction{Instruction: Instruction{Op: JMP | JA}, jt: l, jf: Next, ja: true})
+}
+
+// Assemble resolves the labels and returns the program.
+func (a *Assembler) Assemble() ([]Instruction, error) {
+	prog := make([]Instruction, 0, len(a.insts))
+	for pc, inst := range a.insts {
+		i := inst.Instruction
+		if inst.ja {
+			off, err := a.offset(pc, inst.jt)
+			if err != nil {
+				return nil, err
+			}
+			i.K = uint32(off)
+		} else if class(i.Op) == JMP {
+			jt, err := a.offset(pc, inst.jt)
+			if err != nil {
+				return nil, err
+			}
+			jf, err := a.offset(pc, inst.jf)
+			if err != nil {
+				return nil, err
+			}
+			if jt > 255 || jf > 255 {
+				return nil, fmt.Errorf("jump offset is too far: pc=%d", pc)
+			}
+			i.Jt, i.Jf = uint8(jt), uint8(jf)
+		}
+		prog = append(prog, i)
+	}
+	if err := Validate(prog); err != nil {
+		return nil, err
+	}
+	return prog, nil
+}
+
+func (a *Assembler) offset(pc int, l Label) (int, error) {
+	if l < 0 {
+		return 0, nil
+	}
+	if int(l) >= len(a.labels) || a.labels[l] < 0 {
+		return 0, fmt.Errorf("label is not placed: %d", l)
+	}
+	off := a.labels[l] - pc - 1
+	if off < 0 {
+		return 0, fmt.Errorf("backward jump: pc=%d", pc)
+	}
+	return off, nil
+}
@@ -0,0 +1,170 @@
+// Package bpf assembles and runs the classic BPF program attached to packet sockets.
+package bpf
+
+import "fmt"
+
+// Instruction is the layout of struct sock_filter.
+type Instruction struct {
+	Op uint16
+	Jt uint8
+	Jf uint8
+	K  uint32
+}
+
+// instruction classes
+const (
+	LD   uint16 = 0x00
+	LDX  uint16 = 0x01
+	ST   uint16 = 0x02
+	STX  uint16 = 0x03
+	ALU  uint16 = 0x04
+	JMP  uint16 = 0x05
+	RET  uint16 = 0x06
+	MISC uint16 = 0x07
+)
+
+// size of loads
+const (
+	W uint16 = 0x00
+	H uint16 = 0x08
+	B uint16 = 0x10
+)
+
+// addressing modes of loads
+const (
+	IMM uint16 = 0x00
+	ABS uint16 = 0x20
+	IND uint16 = 0x40
+	MEM uint16 = 0x60
+	LEN uint16 = 0x80
+	MSH uint16 = 0xa0
+)
+
+// alu operations
+const (
+	ADD uint16 = 0x00
+	SUB uint16 = 0x10
+	MUL uint16 = 0x20
+	DIV uint16 = 0x30
+	OR  uint16 = 0x40
+	AND uint16 = 0x50
+	LSH uint16 = 0x60
+	RSH uint16 = 0x70
+	NEG uint16 = 0x80
+	MOD uint16 = 0x90
+	XOR uint16 = 0xa0
+)
+
+// jump operations
+const (
+	JA   uint16 = 0x00
+	JEQ  uint16 = 0x10
+	JGT  uint16 = 0x20
+	JGE  uint16 = 0x30
+	JSET uint16 = 0x40
+)
+
+// operand sources
+const (
+	K uint16 = 0x00
+	X uint16 = 0x08
+	A uint16 = 0x10 // return value of RET
+)
+
+// misc operations
+const (
+	TAX uint16 = 0x00
+	TXA uint16 = 0x80
+)
+
+// MemWords is the number of the scratch memory words.
+const MemWords = 16
+
+// MaxInstructions is the limit of the program length in the kernel.
+const MaxInstructions = 4096
+
+func class(op uint16) uint16 { return op & 0x07 }
+func size(op uint16) uint16  { return op & 0x18 }
+func mode(op uint16) uint16  { return op & 0xe0 }
+func aluOp(op uint16) uint16 { return op & 0xf0 }
+func src(op uint16) uint16   { return op & 0x08 }
+
+var aluNames = map[uint16]string{
+	ADD: "add", SUB: "sub", MUL: "mul", DIV: "div", OR: "or", AND: "and",
+	LSH: "lsh", RSH: "rsh", NEG: "neg", MOD: "mod", XOR: "xor",
+}
+
+var jumpNames = map[uint16]string{
+	JEQ: "jeq", JGT: "jgt", JGE: "jge", JSET: "jset",
+}
+
+// String returns the instruction in the syntax of tcpdump -d.
+func (i Instruction) String() string {
+	switch class(i.Op) {
+	case LD, LDX:
+		name := "ld"
+		if class(i.Op) == LDX {
+			name = "ldx"
+		}
+		switch size(i.Op) {
+		case H:
+			name += "h"
+		case B:
+			name += "b"
+		}
+		switch mode(i.Op) {
+		case IMM:
+			return fmt.Sprintf("%s #0x%x", name, i.K)
+		case ABS:
+			return fmt.Sprintf("%s [%d]", name, i.K)
+		case IND:
+			return fmt.Sprintf("%s [x + %d]", name, i.K)
+		case MEM:
+			return fmt.Sprintf("%s M[%d]", name, i.K)
+		case LEN:
+			return fmt.Sprintf("%s #pktlen", name)
+		case MSH:
+			return fmt.Sprintf("ldxb 4*([%d]&0xf)", i.K)
+		}
+	case ST:
+		return fmt.Sprintf("st M[%d]", i.K)
+	case STX:
+		return fmt.Sprintf("stx M[%d]", i.K)
+	case ALU:
+		if aluOp(i.Op) == NEG {
+			return "neg"
+		}
+		if src(i.Op) == X {
+			return aluNames[aluOp(i.Op)] + " x"
+		}
+		return fmt.Sprintf("%s #0x%x", aluNames[aluOp(i.Op)], i.K)
+	case JMP:
+		if aluOp(i.Op) == JA {
+			return fmt.Sprintf("ja %d", i.K)
+		}
+		if src(i.Op) == X {
+			return fmt.Sprintf("%s x jt %d jf %d", jumpNames[aluOp(i.Op)], i.Jt, i.Jf)
+		}
+		return fmt.Sprintf("%s #0x%x jt %d jf %d", jumpNames[aluOp(i.Op)], i.K, i.Jt, i.Jf)
+	case RET:
+		if i.Op&0x18 == A {
+			return "ret a"
+		}
+		return fmt.Sprintf("ret #%d", i.K)
+	case MISC:
+		if i.Op&0xf8 == TXA {
+			return "txa"
+		}
+		return "tax"
+	}
+	return fmt.Sprintf("unknown 0x%x", i.Op)
+}
+
+// Disassemble returns the program in the syntax of tcpdump -d.
+func Disassemble(prog []Instruction) string {
+	var s string
+	for n, i := range prog {
+		s += fmt.Sprintf("(%03d) %s\n", n, i)
+	}
+	return s
+}
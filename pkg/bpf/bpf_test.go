@@ -0,0 +1,96 @@
+package bpf
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	// returns the ipv4 header length plus the first byte of the payload when the packet is ipv4
+	a := NewAssembler()
+	drop := a.NewLabel()
+	a.Emit(LD|H|ABS, 12)
+	a.Jump(JEQ|K, 0x0800, Next, drop)
+	a.Emit(LDX|B|MSH, 14)
+	a.Emit(STX, 1)
+	a.Emit(LD|B|IND, 14)
+	a.Emit(LDX|MEM, 1)
+	a.Emit(ALU|ADD|X, 0)
+	a.Emit(RET|A, 0)
+	a.Label(drop)
+	a.Emit(RET|K, 0)
+	prog, err := a.Assemble()
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet := make([]byte, 40)
+	packet[12], packet[13] = 0x08, 0x00
+	packet[14] = 0x45
+	packet[34] = 3
+	if n := Run(prog, packet); n != 23 {
+		t.Fatalf("want 23, got %d\n%s", n, Disassemble(prog))
+	}
+	packet[13] = 0x06
+	if n := Run(prog, packet); n != 0 {
+		t.Fatalf("non ipv4 is accepted: %d", n)
+	}
+	// the load out of the packet rejects it
+	packet[13] = 0x00
+	if n := Run(prog, packet[:30]); n != 0 {
+		t.Fatalf("short packet is accepted: %d", n)
+	}
+}
+
+func TestAssemble(t *testing.T) {
+	a := NewAssembler()
+	l := a.NewLabel()
+	a.Goto(l)
+	a.Emit(RET|K, 0)
+	if _, err := a.Assemble(); err == nil {
+		t.Fatal("label not placed is assembled")
+	}
+
+	a = NewAssembler()
+	l = a.NewLabel()
+	a.Jump(JEQ|K, 1, l, Next)
+	for i := 0; i < 300; i++ {
+		a.Emit(LD|IMM, 0)
+	}
+	a.Label(l)
+	a.Emit(RET|K, 0)
+	if _, err := a.Assemble(); err == nil {
+		t.Fatal("too far jump is assembled")
+	}
+
+	for _, prog := range [][]Instruction{
+		nil,
+		{{Op: LD | IMM}},
+		{{Op: ST, K: MemWords}, {Op: RET}},
+		{{Op: ALU | DIV | K}, {Op: RET}},
+		{{Op: JMP | JEQ | K, Jt: 1}, {Op: RET}},
+	} {
+		if err := Validate(prog); err == nil {
+			t.Errorf("invalid program is validated: %v", prog)
+		}
+	}
+}
+
+func TestDisassemble(t *testing.T) {
+	s := Disassemble([]Instruction{
+		{Op: LD | H | ABS, K: 12},
+		{Op: JMP | JEQ | K, Jt: 0, Jf: 1, K: 0x800},
+		{Op: RET | K, K: 262144},
+		{Op: RET | K},
+	})
+	want := `(000) ldh [12]
+(001) jeq #0x800 jt 0 jf 1
+(002) ret #262144
+(003) ret #0
+`
+	if s != want {
+		t.Fatalf("want\n%s\ngot\n%s", want, s)
+	}
+	if !strings.Contains(Instruction{Op: LDX | B | MSH, K: 14}.String(), "4*([14]&0xf)") {
+		t.Fatal("msh is not disassembled")
+	}
+}
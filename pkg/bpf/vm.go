@@ -0,0 +1,191 @@
+package bpf
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Validate checks the program the same as the kernel does before attaching.
+func Validate(prog []Instruction) error {
+	if len(prog) == 0 || len(prog) > MaxInstructions {
+		return fmt.Errorf("invalid program length: %d", len(prog))
+	}
+	for pc, i := range prog {
+		switch class(i.Op) {
+		case LD, LDX:
+			if mode(i.Op) == MEM && i.K >= MemWords {
+				return fmt.Errorf("invalid scratch memory index: pc=%d", pc)
+			}
+			if mode(i.Op) == MSH && class(i.Op) != LDX {
+				return fmt.Errorf("invalid instruction: pc=%d", pc)
+			}
+		case ST, STX:
+			if i.K >= MemWords {
+				return fmt.Errorf("invalid scratch memory index: pc=%d", pc)
+			}
+		case ALU:
+			if _, ok := aluNames[aluOp(i.Op)]; !ok {
+				return fmt.Errorf("invalid alu operation: pc=%d", pc)
+			}
+			if src(i.Op) == K && (aluOp(i.Op) == DIV || aluOp(i.Op) == MOD) && i.K == 0 {
+				return fmt.Errorf("division by zero: pc=%d", pc)
+			}
+		case JMP:
+			op := aluOp(i.Op)
+			if op == JA {
+				if pc+1+int(i.K) >= len(prog) {
+					return fmt.Errorf("jump out of program: pc=%d", pc)
+				}
+				continue
+			}
+			if _, ok := jumpNames[op]; !ok {
+				return fmt.Errorf("invalid jump operation: pc=%d", pc)
+			}
+			if pc+1+int(i.Jt) >= len(prog) || pc+1+int(i.Jf) >= len(prog) {
+				return fmt.Errorf("jump out of program: pc=%d", pc)
+			}
+		}
+	}
+	if class(prog[len(prog)-1].Op) != RET {
+		return fmt.Errorf("program must end with ret")
+	}
+	return nil
+}
+
+// Run executes the validated program on the packet and returns the number of bytes to accept.
+// Loads out of the packet reject it as the kernel does.
+func Run(prog []Instruction, packet []byte) uint32 {
+	var a, x uint32
+	var mem [MemWords]uint32
+	load := func(off uint32, sz uint16) (uint32, bool) {
+		var n uint32
+		switch sz {
+		case W:
+			n = 4
+		case H:
+			n = 2
+		case B:
+			n = 1
+		}
+		if uint64(off)+uint64(n) > uint64(len(packet)) {
+			return 0, false
+		}
+		switch sz {
+		case W:
+			return binary.BigEndian.Uint32(packet[off:]), true
+		case H:
+			return uint32(binary.BigEndian.Uint16(packet[off:])), true
+		}
+		return uint32(packet[off]), true
+	}
+	for pc := 0; pc < len(prog); pc++ {
+		i := prog[pc]
+		switch class(i.Op) {
+		case LD, LDX:
+			var v uint32
+			switch mode(i.Op) {
+			case IMM:
+				v = i.K
+			case ABS, IND:
+				off := i.K
+				if mode(i.Op) == IND {
+					off += x
+				}
+				var ok bool
+				if v, ok = load(off, size(i.Op)); !ok {
+					return 0
+				}
+			case MEM:
+				v = mem[i.K]
+			case LEN:
+				v = uint32(len(packet))
+			case MSH:
+				b, ok := load(i.K, B)
+				if !ok {
+					return 0
+				}
+				v = (b & 0xf) * 4
+			}
+			if class(i.Op) == LD {
+				a = v
+			} else {
+				x = v
+			}
+		case ST:
+			mem[i.K] = a
+		case STX:
+			mem[i.K] = x
+		case ALU:
+			v := i.K
+			if src(i.Op) == X {
+				v = x
+			}
+			switch aluOp(i.Op) {
+			case ADD:
+				a += v
+			case SUB:
+				a -= v
+			case MUL:
+				a *= v
+			case DIV:
+				if v == 0 {
+					return 0
+				}
+				a /= v
+			case MOD:
+				if v == 0 {
+					return 0
+				}
+				a %= v
+			case OR:
+				a |= v
+			case AND:
+				a &= v
+			case XOR:
+				a ^= v
+			case LSH:
+				a <<= v
+			case RSH:
+				a >>= v
+			case NEG:
+				a = -a
+			}
+		case JMP:
+			v := i.K
+			if src(i.Op) == X {
+				v = x
+			}
+			var cond bool
+			switch aluOp(i.Op) {
+			case JA:
+				pc += int(i.K)
+				continue
+			case JEQ:
+				cond = a == v
+			case JGT:
+				cond = a > v
+			case JGE:
+				cond = a >= v
+			case JSET:
+				cond = a&v != 0
+			}
+			if cond {
+				pc += int(i.Jt)
+			} else {
+				pc += int(i.Jf)
+			}
+		case RET:
+			if i.Op&0x18 == A {
+				return a
+			}
+			return i.K
+		case MISC:
+			if i.Op&0xf8 == TXA {
+				a = x
+			} else {
+				x = a
+			}
+		}
+	}
+	return 0
+}
@@ -0,0 +1,133 @@
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Virtual is the clock advanced only by Advance.
+// Timers and tickers fire in order of their deadlines while advancing.
+type Virtual struct {
+	now     time.Time
+	waiters []*waiter
+	mutex   sync.Mutex
+	cond    *sync.Cond
+}
+
+type waiter struct {
+	at     time.Time
+	period time.Duration // 0 for timers
+	ch     chan time.Time
+}
+
+// NewVirtual returns the virtual clock starting at start.
+func NewVirtual(start time.Time) *Virtual {
+	v := &Virtual{now: start}
+	v.cond = sync.NewCond(&v.mutex)
+	return v
+}
+
+func (v *Virtual) Now() time.Time {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	return v.now
+}
+
+func (v *Virtual) Since(t time.Time) time.Duration {
+	return v.Now().Sub(t)
+}
+
+func (v *Virtual) Sleep(d time.Duration) {
+	<-v.After(d)
+}
+
+func (v *Virtual) After(d time.Duration) <-chan time.Time {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- v.now
+		return ch
+	}
+	v.add(&waiter{at: v.now.Add(d), ch: ch})
+	return ch
+}
+
+func (v *Virtual) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	w := &waiter{at: v.now.Add(d), period: d, ch: make(chan time.Time, 1)}
+	v.add(w)
+	return &virtualTicker{clock: v, waiter: w}
+}
+
+func (v *Virtual) add(w *waiter) {
+	v.waiters = append(v.waiters, w)
+	v.cond.Broadcast()
+}
+
+func (v *Virtual) remove(w *waiter) {
+	for i, e := range v.waiters {
+		if e == w {
+			v.waiters = append(v.waiters[:i], v.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+// Advance moves the clock forward by d and fires the timers and the tickers due.
+// Like time.Ticker, a ticker keeps only the latest tick when the receiver is slow.
+func (v *Virtual) Advance(d time.Duration) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	end := v.now.Add(d)
+	for {
+		sort.SliceStable(v.waiters, func(i, j int) bool {
+			return v.waiters[i].at.Before(v.waiters[j].at)
+		})
+		if len(v.waiters) == 0 || v.waiters[0].at.After(end) {
+			break
+		}
+		w := v.waiters[0]
+		v.now = w.at
+		select {
+		case <-w.ch:
+		default:
+		}
+		w.ch <- v.now
+		if w.period > 0 {
+			w.at = w.at.Add(w.period)
+		} else {
+			v.waiters = v.waiters[1:]
+		}
+	}
+	v.now = end
+}
+
+// BlockUntil waits until n timers and tickers at least are waiting on the clock.
+func (v *Virtual) BlockUntil(n int) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	for len(v.waiters) < n {
+		v.cond.Wait()
+	}
+}
+
+type virtualTicker struct {
+	clock  *Virtual
+	waiter *waiter
+}
+
+func (t *virtualTicker) C() <-chan time.Time {
+	return t.waiter.ch
+}
+
+func (t *virtualTicker) Stop() {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+	t.clock.remove(t.waiter)
+}
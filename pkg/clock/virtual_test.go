@@ -0,0 +1,45 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVirtual(t *testing.T) {
+	start := time.Unix(0, 0)
+	v := NewVirtual(start)
+	timer := v.After(time.Second)
+	ticker := v.NewTicker(300 * time.Millisecond)
+	defer ticker.Stop()
+
+	v.Advance(500 * time.Millisecond)
+	select {
+	case <-timer:
+		t.Fatal("timer fires early")
+	default:
+	}
+	if tick := <-ticker.C(); !tick.Equal(start.Add(300 * time.Millisecond)) {
+		t.Fatalf("tick at %v", tick)
+	}
+	v.Advance(time.Second)
+	if fired := <-timer; !fired.Equal(start.Add(time.Second)) {
+		t.Fatalf("timer fires at %v", fired)
+	}
+	// only the latest tick is kept
+	if tick := <-ticker.C(); !tick.Equal(start.Add(1500 * time.Millisecond)) {
+		t.Fatalf("tick at %v", tick)
+	}
+	if v.Since(start) != 1500*time.Millisecond {
+		t.Fatalf("now is %v", v.Now())
+	}
+
+	done := make(chan struct{})
+	go func() {
+		v.Sleep(time.Minute)
+		close(done)
+	}()
+	// the ticker and the sleeper
+	v.BlockUntil(2)
+	v.Advance(time.Minute)
+	<-done
+}
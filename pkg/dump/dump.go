@@ -0,0 +1,333 @@
+// Package dump prints frames in one line per packet like tcpdump.
+package dump
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/terassyi/gotcp/pkg/packet/arp"
+	"github.com/terassyi/gotcp/pkg/packet/decode"
+	"github.com/terassyi/gotcp/pkg/packet/ethernet"
+	"github.com/terassyi/gotcp/pkg/packet/icmp"
+	"github.com/terassyi/gotcp/pkg/packet/ipv4"
+	"github.com/terassyi/gotcp/pkg/packet/tcp"
+)
+
+// Options changes the output.
+type Options struct {
+	Hex      bool // dumps the packet in hex and ascii
+	Link     bool // prints the ethernet header, and the hex dump includes it
+	Absolute bool // prints absolute tcp sequence numbers
+	Raw      bool // packets are ipv4 without the link layer header such as read from the tun device
+}
+
+// flow is the direction of the tcp connection.
+type flow struct {
+	src, dst         ipv4.IPAddress
+	srcPort, dstPort uint16
+}
+
+func (f flow) reverse() flow {
+	return flow{src: f.dst, dst: f.src, srcPort: f.dstPort, dstPort: f.srcPort}
+}
+
+// Dumper prints frames to the writer.
+type Dumper struct {
+	w       io.Writer
+	options Options
+	// initial sequence numbers to print relative sequence numbers
+	isn map[flow]uint32
+}
+
+func New(w io.Writer, options Options) *Dumper {
+	return &Dumper{
+		w:       w,
+		options: options,
+		isn:     make(map[flow]uint32),
+	}
+}
+
+// Print decodes and prints the frame received at ts.
+func (d *Dumper) Print(ts time.Time, data []byte) error {
+	if d.options.Raw {
+		return d.PrintPacket(ts, decode.DecodeRaw(data), data)
+	}
+	return d.PrintPacket(ts, decode.Decode(data), data)
+}
+
+// PrintPacket prints the decoded frame, data is used for the hex dump.
+func (d *Dumper) PrintPacket(ts time.Time, p *decode.Packet, data []byte) error {
+	line := ts.Format("15:04:05.000000") + " " + d.Format(p, len(data))
+	if _, err := fmt.Fprintln(d.w, line); err != nil {
+		return err
+	}
+	if d.options.Hex {
+		dump := data
+		if !d.options.Link && p.Ethernet != nil {
+			dump = p.Ethernet.Payload()
+		}
+		if _, err := io.WriteString(d.w, HexDump(dump)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Format returns the summary of the decoded frame of the length.
+func (d *Dumper) Format(p *decode.Packet, length int) string {
+	if d.options.Raw {
+		var b strings.Builder
+		d.formatIPv4(&b, p)
+		return b.String()
+	}
+	if p.Ethernet == nil {
+		return fmt.Sprintf("[|ether] %v", p.Err)
+	}
+	var b strings.Builder
+	if d.options.Link {
+		fmt.Fprintf(&b, "%s > %s, ethertype %s (0x%04x), length %d: ",
+			p.Ethernet.Header.Src, p.Ethernet.Header.Dst, etherTypeName(p.Ethernet.Type()), uint16(p.Ethernet.Type()), length)
+	}
+	switch p.Ethernet.Type() {
+	case ethernet.ETHER_TYPE_ARP:
+		formatArp(&b, p)
+	case ethernet.ETHER_TYPE_IP:
+		d.formatIPv4(&b, p)
+	case ethernet.ETHER_TYPE_IPV6:
+		fmt.Fprintf(&b, "IP6, length %d", len(p.Ethernet.Payload()))
+	default:
+		fmt.Fprintf(&b, "ethertype 0x%04x, length %d", uint16(p.Ethernet.Type()), len(p.Ethernet.Payload()))
+	}
+	return b.String()
+}
+
+func etherTypeName(typ ethernet.EtherType) string {
+	switch typ {
+	case ethernet.ETHER_TYPE_IP:
+		return "IPv4"
+	case ethernet.ETHER_TYPE_ARP:
+		return "ARP"
+	case ethernet.ETHER_TYPE_IPV6:
+		return "IPv6"
+	default:
+		return "Unknown"
+	}
+}
+
+func formatArp(b *strings.Builder, p *decode.Packet) {
+	if p.Arp == nil {
+		fmt.Fprintf(b, "ARP, [|arp] %v", p.Err)
+		return
+	}
+	a := p.Arp
+	length := arp.ARPHeaderSize + 2*(len(a.SourceHardwareAddress)+len(a.SourceProtocolAddress))
+	switch a.Header.OpCode {
+	case arp.ARP_REQUEST:
+		fmt.Fprintf(b, "ARP, Request who-has %s tell %s, length %d", protocolAddress(a.TargetProtocolAddress), protocolAddress(a.SourceProtocolAddress), length)
+	case arp.ARP_REPLY:
+		fmt.Fprintf(b, "ARP, Reply %s is-at %s, length %d", protocolAddress(a.SourceProtocolAddress), hardwareAddress(a.SourceHardwareAddress), length)
+	default:
+		fmt.Fprintf(b, "ARP, Unknown operation (%d), length %d", a.Header.OpCode, length)
+	}
+}
+
+func protocolAddress(addr []byte) string {
+	if a, err := ipv4.Address(addr); err == nil {
+		return a.String()
+	}
+	return fmt.Sprintf("%x", addr)
+}
+
+func hardwareAddress(addr []byte) string {
+	if len(addr) == 6 {
+		return ethernet.HardwareAddress{addr[0], addr[1], addr[2], addr[3], addr[4], addr[5]}.String()
+	}
+	return fmt.Sprintf("%x", addr)
+}
+
+func (d *Dumper) formatIPv4(b *strings.Builder, p *decode.Packet) {
+	if p.IPv4 == nil {
+		fmt.Fprintf(b, "IP [|ip] %v", p.Err)
+		return
+	}
+	ip := p.IPv4
+	src, dst := ip.Header.Src.String(), ip.Header.Dst.String()
+	switch {
+	case p.Tcp != nil:
+		fmt.Fprintf(b, "IP %s.%d > %s.%d: ", src, p.Tcp.Header.SourcePort, dst, p.Tcp.Header.DestinationPort)
+		d.formatTcp(b, ip, p.Tcp)
+	case p.Udp != nil:
+		fmt.Fprintf(b, "IP %s.%d > %s.%d: UDP, length %d", src, p.Udp.Header.SourcePort, dst, p.Udp.Header.DestinationPort, len(p.Udp.Data))
+	case p.Icmp != nil:
+		fmt.Fprintf(b, "IP %s > %s: ", src, dst)
+		formatIcmp(b, p.Icmp)
+	case p.Err != nil:
+		fmt.Fprintf(b, "IP %s > %s: [|%s] %v", src, dst, ip.Header.Protocol, p.Err)
+	default:
+		fmt.Fprintf(b, "IP %s > %s: ip-proto-%d %d", src, dst, ip.Header.Protocol, len(ip.Data))
+	}
+	// the more fragments flag is the lowest bit
+	more := ip.Header.FlOffset.MoreFragments()
+	if offset := ip.Header.FlOffset.FragmentOffset(); more || offset != 0 {
+		fmt.Fprintf(b, " (frag %d:%d@%d", ip.Header.Ident, len(ip.Data), int(offset)*8)
+		if more {
+			b.WriteString("+")
+		}
+		b.WriteString(")")
+	}
+}
+
+func formatIcmp(b *strings.Builder, m *icmp.Packet) {
+	switch m.Header.Type {
+	case icmp.Echo, icmp.EchoReply:
+		kind := "request"
+		if m.Header.Type == icmp.EchoReply {
+			kind = "reply"
+		}
+		if echo, err := icmp.NewEchoMessage(m.Data); err == nil {
+			fmt.Fprintf(b, "ICMP echo %s, id %d, seq %d, length %d", kind, echo.Ident, echo.Seq, 4+len(m.Data))
+			return
+		}
+		fmt.Fprintf(b, "ICMP echo %s, length %d", kind, 4+len(m.Data))
+	default:
+		fmt.Fprintf(b, "ICMP %s, code %d, length %d", strings.ToLower(m.Header.Type.String()), m.Header.Code, 4+len(m.Data))
+	}
+}
+
+func (d *Dumper) formatTcp(b *strings.Builder, ip *ipv4.Packet, t *tcp.Packet) {
+	h := t.Header
+	flags := h.OffsetControlFlag.ControlFlag()
+	f := flow{src: ip.Header.Src, dst: ip.Header.Dst, srcPort: h.SourcePort, dstPort: h.DestinationPort}
+	seq, ack := h.Sequence, h.Ack
+	if !d.options.Absolute {
+		if flags.Syn() && !flags.Ack() {
+			// new connection
+			delete(d.isn, f.reverse())
+			delete(d.isn, f)
+		}
+		// the first segment of the direction is printed in absolute
+		if isn, ok := d.isn[f]; ok {
+			seq -= isn
+		} else {
+			d.isn[f] = seq
+		}
+		if isn, ok := d.isn[f.reverse()]; ok {
+			ack -= isn
+		}
+	}
+	fmt.Fprintf(b, "Flags [%s]", formatFlags(flags))
+	if len(t.Data) > 0 {
+		fmt.Fprintf(b, ", seq %d:%d", seq, seq+uint32(len(t.Data)))
+	} else if flags.Syn() || flags.Fin() || flags.Rst() {
+		fmt.Fprintf(b, ", seq %d", seq)
+	}
+	if flags.Ack() {
+		fmt.Fprintf(b, ", ack %d", ack)
+	}
+	fmt.Fprintf(b, ", win %d", h.WindowSize)
+	if flags.Urg() {
+		fmt.Fprintf(b, ", urg %d", h.Urgent)
+	}
+	if len(t.Option) > 0 {
+		base := uint32(0)
+		if isn, ok := d.isn[f.reverse()]; ok && !d.options.Absolute {
+			base = isn
+		}
+		fmt.Fprintf(b, ", options [%s]", formatOptions(t.Option, base))
+	}
+	fmt.Fprintf(b, ", length %d", len(t.Data))
+}
+
+// formatFlags returns flags in the order of tcpdump.
+func formatFlags(flags tcp.ControlFlag) string {
+	var s string
+	for _, f := range []struct {
+		flag tcp.ControlFlag
+		c    string
+	}{
+		{tcp.FIN, "F"}, {tcp.SYN, "S"}, {tcp.RST, "R"}, {tcp.PSH, "P"},
+		{tcp.ACK, "."}, {tcp.URG, "U"}, {tcp.ECN, "E"}, {tcp.CWR, "W"},
+	} {
+		if flags&f.flag != 0 {
+			s += f.c
+		}
+	}
+	if s == "" {
+		return "none"
+	}
+	return s
+}
+
+// formatOptions returns tcp options, sack blocks are relative to base.
+func formatOptions(ops tcp.Options, base uint32) string {
+	var s []string
+	for _, op := range ops {
+		switch o := op.(type) {
+		case tcp.EndOfOptionList:
+			s = append(s, "eol")
+		case tcp.NoOperation:
+			s = append(s, "nop")
+		case tcp.MaxSegmentSize:
+			s = append(s, fmt.Sprintf("mss %d", uint16(o)))
+		case tcp.WindowScale:
+			s = append(s, fmt.Sprintf("wscale %d", uint8(o)))
+		case tcp.SACKPermitted:
+			s = append(s, "sackOK")
+		case tcp.SACK:
+			var blocks string
+			for i := 0; i+8 <= len(o); i += 8 {
+				blocks += fmt.Sprintf("{%d:%d}", binary.BigEndian.Uint32(o[i:i+4])-base, binary.BigEndian.Uint32(o[i+4:i+8])-base)
+			}
+			s = append(s, fmt.Sprintf("sack %d %s", len(o)/8, blocks))
+		case tcp.TimeStamp:
+			s = append(s, fmt.Sprintf("TS val %d ecr %d", binary.BigEndian.Uint32(o[0:4]), binary.BigEndian.Uint32(o[4:8])))
+		case tcp.MD5Signature:
+			s = append(s, "md5")
+		case tcp.FastOpenCookie:
+			if len(o) == 0 {
+				s = append(s, "tfo cookiereq")
+			} else {
+				s = append(s, fmt.Sprintf("tfo cookie %x", []byte(o)))
+			}
+		default:
+			s = append(s, fmt.Sprintf("opt-%d", op.Kind()))
+		}
+	}
+	return strings.Join(s, ",")
+}
+
+// HexDump returns the data in 16 bytes per line with the ascii like tcpdump -X.
+func HexDump(data []byte) string {
+	var b strings.Builder
+	for offset := 0; offset < len(data); offset += 16 {
+		end := offset + 16
+		if end > len(data) {
+			end = len(data)
+		}
+		line := data[offset:end]
+		fmt.Fprintf(&b, "\t0x%04x:  ", offset)
+		for i := 0; i < 16; i += 2 {
+			switch {
+			case i+1 < len(line):
+				fmt.Fprintf(&b, "%02x%02x ", line[i], line[i+1])
+			case i < len(line):
+				fmt.Fprintf(&b, "%02x   ", line[i])
+			default:
+				b.WriteString("     ")
+			}
+		}
+		b.WriteString(" ")
+		for _, c := range line {
+			if c >= 0x20 && c < 0x7f {
+				b.WriteByte(c)
+			} else {
+				b.WriteByte('.')
+			}
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,138 @@
+package dump
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/terassyi/gotcp/pkg/packet/arp"
+	"github.com/terassyi/gotcp/pkg/packet/ethernet"
+	"github.com/terassyi/gotcp/pkg/packet/icmp"
+	"github.com/terassyi/gotcp/pkg/packet/ipv4"
+	"github.com/terassyi/gotcp/pkg/packet/tcp"
+)
+
+var (
+	clientMac  = ethernet.HardwareAddress{0x02, 0, 0, 0, 0, 2}
+	serverMac  = ethernet.HardwareAddress{0x02, 0, 0, 0, 0, 1}
+	clientAddr = ipv4.IPAddress{10, 0, 0, 2}
+	serverAddr = ipv4.IPAddress{10, 0, 0, 1}
+)
+
+func frame(t *testing.T, src, dst ethernet.HardwareAddress, typ ethernet.EtherType, data []byte) []byte {
+	t.Helper()
+	b, err := ethernet.Build(src, dst, typ, data).Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func ipFrame(t *testing.T, src, dst ipv4.IPAddress, protocol ipv4.IPProtocol, data []byte) []byte {
+	t.Helper()
+	packet, err := ipv4.Build(src, dst, protocol, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := packet.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srcMac, dstMac := clientMac, serverMac
+	if src == serverAddr {
+		srcMac, dstMac = serverMac, clientMac
+	}
+	return frame(t, srcMac, dstMac, ethernet.ETHER_TYPE_IP, b)
+}
+
+func segment(t *testing.T, src, dst ipv4.IPAddress, sport, dport uint16, seq, ack uint32, flag tcp.ControlFlag, ops tcp.Options, data []byte) []byte {
+	t.Helper()
+	s, err := tcp.Build(sport, dport, seq, ack, flag, 29200, 0, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ops != nil {
+		s.AddOption(ops)
+	}
+	b, err := s.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ipFrame(t, src, dst, ipv4.IPTCPProtocol, b)
+}
+
+func TestFormat(t *testing.T) {
+	request, err := arp.Request(clientMac.Bytes(), clientAddr.Bytes(), serverAddr.Bytes(), arp.PROTOCOL_IPv4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requestData, err := request.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	echo, err := (&icmp.EchoMessage{Ident: 7, Seq: 1, Data: []byte("ping")}).Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	echoRequest, err := icmp.Build(icmp.Echo, icmp.EchoRequestCode, echo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	echoData, err := echoRequest.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	udpData := []byte{0x00, 0x35, 0xc0, 0x00, 0x00, 0x0b, 0x00, 0x00, 0x61, 0x62, 0x63}
+
+	// the dumper keeps the sequence numbers across frames
+	buf := new(bytes.Buffer)
+	d := New(buf, Options{})
+	frames := []struct {
+		frame []byte
+		line  string
+	}{
+		{frame(t, clientMac, ethernet.BroadcastAddress, ethernet.ETHER_TYPE_ARP, requestData), "ARP, Request who-has 10.0.0.1 tell 10.0.0.2, length 28"},
+		{ipFrame(t, clientAddr, serverAddr, ipv4.IPICMPv4Protocol, echoData), "IP 10.0.0.2 > 10.0.0.1: ICMP echo request, id 7, seq 1, length 12"},
+		{ipFrame(t, clientAddr, serverAddr, ipv4.IPUDPProtocol, udpData), "IP 10.0.0.2.53 > 10.0.0.1.49152: UDP, length 3"},
+		{segment(t, clientAddr, serverAddr, 40000, 8080, 1000, 0, tcp.SYN, tcp.Options{tcp.MaxSegmentSize(1460), tcp.SACKPermitted{}, tcp.WindowScale(7)}, nil),
+			"IP 10.0.0.2.40000 > 10.0.0.1.8080: Flags [S], seq 1000, win 29200, options [mss 1460,sackOK,wscale 7,nop,nop,nop], length 0"},
+		{segment(t, serverAddr, clientAddr, 8080, 40000, 5000, 1001, tcp.SYN|tcp.ACK, nil, nil),
+			"IP 10.0.0.1.8080 > 10.0.0.2.40000: Flags [S.], seq 5000, ack 1, win 29200, length 0"},
+		{segment(t, clientAddr, serverAddr, 40000, 8080, 1001, 5001, tcp.PSH|tcp.ACK, nil, []byte("hello")),
+			"IP 10.0.0.2.40000 > 10.0.0.1.8080: Flags [P.], seq 1:6, ack 1, win 29200, length 5"},
+		{segment(t, serverAddr, clientAddr, 8080, 40000, 5001, 1006, tcp.FIN|tcp.ACK, nil, nil),
+			"IP 10.0.0.1.8080 > 10.0.0.2.40000: Flags [F.], seq 1, ack 6, win 29200, length 0"},
+		{frame(t, clientMac, serverMac, ethernet.EtherType(0x88cc), []byte{1, 2}), "ethertype 0x88cc, length 2"},
+	}
+	ts := time.Date(2021, 1, 1, 12, 34, 56, 789000, time.Local)
+	var expected string
+	for _, f := range frames {
+		if err := d.Print(ts, f.frame); err != nil {
+			t.Fatal(err)
+		}
+		expected += "12:34:56.000789 " + f.line + "\n"
+	}
+	if buf.String() != expected {
+		t.Fatalf("actual:\n%s\nexpected:\n%s", buf.String(), expected)
+	}
+}
+
+func TestLinkAndHexDump(t *testing.T) {
+	buf := new(bytes.Buffer)
+	d := New(buf, Options{Link: true, Hex: true})
+	if err := d.Print(time.Now(), frame(t, clientMac, serverMac, ethernet.EtherType(0x88cc), []byte("abcdefghijklmnopq"))); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(buf.String(), "\n")
+	if !strings.HasSuffix(lines[0], "02:00:00:00:00:02 > 02:00:00:00:00:01, ethertype Unknown (0x88cc), length 31: ethertype 0x88cc, length 17") {
+		t.Fatalf("actual: %s", lines[0])
+	}
+	// the ethernet header is included in the hex dump
+	if lines[1] != "\t0x0000:  0200 0000 0001 0200 0000 0002 88cc 6162  ..............ab" {
+		t.Fatalf("actual: %q", lines[1])
+	}
+	if lines[2] != "\t0x0010:  6364 6566 6768 696a 6b6c 6d6e 6f70 71    cdefghijklmnopq" {
+		t.Fatalf("actual: %q", lines[2])
+	}
+}
@@ -0,0 +1,197 @@
+package filter
+
+import (
+	"encoding/binary"
+
+	"github.com/terassyi/gotcp/pkg/bpf"
+	"github.com/terassyi/gotcp/pkg/packet/ethernet"
+	"github.com/terassyi/gotcp/pkg/packet/ipv4"
+	"github.com/terassyi/gotcp/pkg/pcap"
+)
+
+// offsets in the ethernet frame
+const (
+	offEtherDst  = 0
+	offEtherSrc  = 6
+	offEtherType = 12
+	offIP        = 14
+	offIPFlags   = offIP + 6
+	offIPProto   = offIP + 9
+	offIPSrc     = offIP + 12
+	offIPDst     = offIP + 16
+	offArpSpa    = offIP + 14
+	offArpTpa    = offIP + 24
+)
+
+// BPF compiles the filter to the classic BPF program for the ethernet frame.
+// The program accepts the whole packet when it matches, otherwise it returns 0.
+// Unlike Match, malformed headers are not checked.
+func (f *Filter) BPF() ([]bpf.Instruction, error) {
+	a := bpf.NewAssembler()
+	if f == nil || f.root == nil {
+		a.Emit(bpf.RET|bpf.K, uint32(pcap.DefaultSnapLen))
+		return a.Assemble()
+	}
+	t, e := a.NewLabel(), a.NewLabel()
+	f.root.compile(a, t, e)
+	a.Label(t)
+	a.Emit(bpf.RET|bpf.K, uint32(pcap.DefaultSnapLen))
+	a.Label(e)
+	a.Emit(bpf.RET|bpf.K, 0)
+	return a.Assemble()
+}
+
+// compile of each node emits the code jumping to t when matched, otherwise to f.
+// The code never falls through.
+
+func (n and) compile(a *bpf.Assembler, t, f bpf.Label) {
+	next := a.NewLabel()
+	n.left.compile(a, next, f)
+	a.Label(next)
+	n.right.compile(a, t, f)
+}
+
+func (n or) compile(a *bpf.Assembler, t, f bpf.Label) {
+	next := a.NewLabel()
+	n.left.compile(a, t, next)
+	a.Label(next)
+	n.right.compile(a, t, f)
+}
+
+func (n not) compile(a *bpf.Assembler, t, f bpf.Label) {
+	n.node.compile(a, f, t)
+}
+
+// compile of the direction emits the check of the source and the destination.
+func (d direction) compile(a *bpf.Assembler, t, f bpf.Label, check func(src bool, t, f bpf.Label)) {
+	switch d {
+	case src:
+		check(true, t, f)
+	case dst:
+		check(false, t, f)
+	case srcAndDst:
+		next := a.NewLabel()
+		check(true, next, f)
+		a.Label(next)
+		check(false, t, f)
+	default:
+		next := a.NewLabel()
+		check(true, t, next)
+		a.Label(next)
+		check(false, t, f)
+	}
+}
+
+// transport falls through when the packet is the first fragment of ipv4 carrying one of the protocols.
+func transport(a *bpf.Assembler, f bpf.Label, protocols ...ipv4.IPProtocol) {
+	a.Emit(bpf.LD|bpf.H|bpf.ABS, offEtherType)
+	a.Jump(bpf.JEQ|bpf.K, uint32(ethernet.ETHER_TYPE_IP), bpf.Next, f)
+	a.Emit(bpf.LD|bpf.H|bpf.ABS, offIPFlags)
+	a.Jump(bpf.JSET|bpf.K, 0x1fff, f, bpf.Next)
+	a.Emit(bpf.LD|bpf.B|bpf.ABS, offIPProto)
+	found := a.NewLabel()
+	for i, protocol := range protocols {
+		if i == len(protocols)-1 {
+			a.Jump(bpf.JEQ|bpf.K, uint32(protocol), found, f)
+		} else {
+			a.Jump(bpf.JEQ|bpf.K, uint32(protocol), found, bpf.Next)
+		}
+	}
+	a.Label(found)
+}
+
+func (n protocol) compile(a *bpf.Assembler, t, f bpf.Label) {
+	switch n {
+	case "ip", "arp":
+		typ := ethernet.ETHER_TYPE_IP
+		if n == "arp" {
+			typ = ethernet.ETHER_TYPE_ARP
+		}
+		a.Emit(bpf.LD|bpf.H|bpf.ABS, offEtherType)
+		a.Jump(bpf.JEQ|bpf.K, uint32(typ), t, f)
+		return
+	case "icmp":
+		transport(a, f, ipv4.IPICMPv4Protocol)
+	case "tcp":
+		transport(a, f, ipv4.IPTCPProtocol)
+	case "udp":
+		transport(a, f, ipv4.IPUDPProtocol)
+	}
+	a.Goto(t)
+}
+
+func (n network) compile(a *bpf.Assembler, t, f bpf.Label) {
+	ip, arp := a.NewLabel(), a.NewLabel()
+	a.Emit(bpf.LD|bpf.H|bpf.ABS, offEtherType)
+	a.Jump(bpf.JEQ|bpf.K, uint32(ethernet.ETHER_TYPE_IP), ip, bpf.Next)
+	a.Jump(bpf.JEQ|bpf.K, uint32(ethernet.ETHER_TYPE_ARP), arp, f)
+	for _, l := range []struct {
+		label    bpf.Label
+		src, dst uint32
+	}{
+		{ip, offIPSrc, offIPDst},
+		{arp, offArpSpa, offArpTpa},
+	} {
+		a.Label(l.label)
+		s, d := l.src, l.dst
+		n.dir.compile(a, t, f, func(src bool, t, f bpf.Label) {
+			off := d
+			if src {
+				off = s
+			}
+			a.Emit(bpf.LD|bpf.W|bpf.ABS, off)
+			if n.mask != 0xffffffff {
+				a.Emit(bpf.ALU|bpf.AND|bpf.K, n.mask)
+			}
+			a.Jump(bpf.JEQ|bpf.K, n.addr&n.mask, t, f)
+		})
+	}
+}
+
+func (n port) compile(a *bpf.Assembler, t, f bpf.Label) {
+	switch n.proto {
+	case "tcp":
+		transport(a, f, ipv4.IPTCPProtocol)
+	case "udp":
+		transport(a, f, ipv4.IPUDPProtocol)
+	default:
+		transport(a, f, ipv4.IPTCPProtocol, ipv4.IPUDPProtocol)
+	}
+	// the ports are at the same offset in tcp and udp
+	a.Emit(bpf.LDX|bpf.B|bpf.MSH, offIP)
+	n.dir.compile(a, t, f, func(src bool, t, f bpf.Label) {
+		off := uint32(offIP + 2)
+		if src {
+			off = offIP
+		}
+		a.Emit(bpf.LD|bpf.H|bpf.IND, off)
+		a.Jump(bpf.JEQ|bpf.K, uint32(n.port), t, f)
+	})
+}
+
+func (n etherHost) compile(a *bpf.Assembler, t, f bpf.Label) {
+	n.dir.compile(a, t, f, func(src bool, t, f bpf.Label) {
+		off := uint32(offEtherDst)
+		if src {
+			off = offEtherSrc
+		}
+		a.Emit(bpf.LD|bpf.W|bpf.ABS, off)
+		a.Jump(bpf.JEQ|bpf.K, binary.BigEndian.Uint32(n.addr[:4]), bpf.Next, f)
+		a.Emit(bpf.LD|bpf.H|bpf.ABS, off+4)
+		a.Jump(bpf.JEQ|bpf.K, uint32(binary.BigEndian.Uint16(n.addr[4:])), t, f)
+	})
+}
+
+func (n tcpFlags) compile(a *bpf.Assembler, t, f bpf.Label) {
+	transport(a, f, ipv4.IPTCPProtocol)
+	a.Emit(bpf.LDX|bpf.B|bpf.MSH, offIP)
+	a.Emit(bpf.LD|bpf.B|bpf.IND, offIP+13)
+	if n.mask != 0xff {
+		a.Emit(bpf.ALU|bpf.AND|bpf.K, uint32(n.mask))
+	}
+	if n.equal {
+		a.Jump(bpf.JEQ|bpf.K, uint32(n.value), t, f)
+	} else {
+		a.Jump(bpf.JEQ|bpf.K, uint32(n.value), f, t)
+	}
+}
@@ -0,0 +1,550 @@
+// Package filter parses and evaluates the subset of the pcap-filter expression.
+//
+//	expr      := term { ("or" | "||") term }
+//	term      := factor { ("and" | "&&") factor }
+//	factor    := ("not" | "!") factor | "(" expr ")" | primitive
+//	primitive := [dir] host <address>
+//	           | [dir] net <address>/<length> | [dir] net <address> mask <netmask>
+//	           | [tcp | udp] [dir] port <port>
+//	           | ether [dir] [host] <hardware address>
+//	           | tcp[tcpflags] [& flags] (= | == | !=) flags
+//	           | ip broadcast
+//	           | ip | arp | icmp | tcp | udp
+//	dir       := src | dst | src or dst | src and dst
+//	flags     := tcp-fin | tcp-syn | tcp-rst | tcp-push | tcp-ack | tcp-urg | tcp-ece | tcp-cwr | <number>, combined with "|"
+//
+// tcp[13] is the same as tcp[tcpflags].
+package filter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/terassyi/gotcp/pkg/bpf"
+	"github.com/terassyi/gotcp/pkg/packet/decode"
+	"github.com/terassyi/gotcp/pkg/packet/ethernet"
+	"github.com/terassyi/gotcp/pkg/packet/ipv4"
+	"github.com/terassyi/gotcp/pkg/packet/tcp"
+)
+
+// Filter is the compiled filter expression.
+type Filter struct {
+	expression string
+	root       node
+}
+
+// Compile parses the expression. The empty expression matches all packets.
+func Compile(expression string) (*Filter, error) {
+	tokens := tokenize(expression)
+	f := &Filter{expression: expression}
+	if len(tokens) == 0 {
+		return f, nil
+	}
+	p := &parser{tokens: tokens}
+	var err error
+	if f.root, err = p.expr(); err != nil {
+		return nil, err
+	}
+	if !p.end() {
+		return nil, fmt.Errorf("unexpected %q in filter", p.peek())
+	}
+	return f, nil
+}
+
+// Match returns true when the packet matches the filter. The nil filter matches all packets.
+func (f *Filter) Match(p *decode.Packet) bool {
+	if f == nil || f.root == nil {
+		return true
+	}
+	return f.root.match(p)
+}
+
+func (f *Filter) String() string {
+	return f.expression
+}
+
+type node interface {
+	match(p *decode.Packet) bool
+	compile(a *bpf.Assembler, t, f bpf.Label)
+}
+
+type direction int
+
+const (
+	srcOrDst direction = iota
+	src
+	dst
+	srcAndDst
+)
+
+// test applies the direction to the test of the source and the destination.
+func (d direction) test(s, t bool) bool {
+	switch d {
+	case src:
+		return s
+	case dst:
+		return t
+	case srcAndDst:
+		return s && t
+	default:
+		return s || t
+	}
+}
+
+type and struct{ left, right node }
+type or struct{ left, right node }
+type not struct{ node node }
+
+func (n and) match(p *decode.Packet) bool { return n.left.match(p) && n.right.match(p) }
+func (n or) match(p *decode.Packet) bool  { return n.left.match(p) || n.right.match(p) }
+func (n not) match(p *decode.Packet) bool { return !n.node.match(p) }
+
+type protocol string
+
+func (n protocol) match(p *decode.Packet) bool {
+	switch n {
+	case "ip":
+		return p.IPv4 != nil
+	case "arp":
+		return p.Arp != nil
+	case "icmp":
+		return p.Icmp != nil
+	case "tcp":
+		return p.Tcp != nil
+	case "udp":
+		return p.Udp != nil
+	}
+	return false
+}
+
+// addresses returns the source and destination ip addresses of ipv4 or arp.
+func addresses(p *decode.Packet) (*ipv4.IPAddress, *ipv4.IPAddress) {
+	if p.IPv4 != nil {
+		return &p.IPv4.Header.Src, &p.IPv4.Header.Dst
+	}
+	if p.Arp != nil {
+		s, err := ipv4.Address(p.Arp.SourceProtocolAddress)
+		if err != nil {
+			return nil, nil
+		}
+		t, err := ipv4.Address(p.Arp.TargetProtocolAddress)
+		if err != nil {
+			return nil, nil
+		}
+		return s, t
+	}
+	return nil, nil
+}
+
+type network struct {
+	dir  direction
+	addr uint32
+	mask uint32
+}
+
+func (n network) contains(addr *ipv4.IPAddress) bool {
+	return addrToUint32(addr)&n.mask == n.addr&n.mask
+}
+
+func (n network) match(p *decode.Packet) bool {
+	s, t := addresses(p)
+	if s == nil {
+		return false
+	}
+	return n.dir.test(n.contains(s), n.contains(t))
+}
+
+type port struct {
+	dir   direction
+	proto string // tcp, udp or empty for both
+	port  uint16
+}
+
+func (n port) match(p *decode.Packet) bool {
+	if p.Tcp != nil && n.proto != "udp" {
+		return n.dir.test(p.Tcp.Header.SourcePort == n.port, p.Tcp.Header.DestinationPort == n.port)
+	}
+	if p.Udp != nil && n.proto != "tcp" {
+		return n.dir.test(p.Udp.Header.SourcePort == n.port, p.Udp.Header.DestinationPort == n.port)
+	}
+	return false
+}
+
+type etherHost struct {
+	dir  direction
+	addr ethernet.HardwareAddress
+}
+
+func (n etherHost) match(p *decode.Packet) bool {
+	if p.Ethernet == nil {
+		return false
+	}
+	return n.dir.test(p.Ethernet.Header.Src == n.addr, p.Ethernet.Header.Dst == n.addr)
+}
+
+// tcpFlags tests flags&mask with the value.
+type tcpFlags struct {
+	mask  tcp.ControlFlag
+	value tcp.ControlFlag
+	equal bool
+}
+
+func (n tcpFlags) match(p *decode.Packet) bool {
+	if p.Tcp == nil {
+		return false
+	}
+	return (p.Tcp.Header.OffsetControlFlag.ControlFlag()&n.mask == n.value) == n.equal
+}
+
+var flagNames = map[string]tcp.ControlFlag{
+	"tcp-fin":  tcp.FIN,
+	"tcp-syn":  tcp.SYN,
+	"tcp-rst":  tcp.RST,
+	"tcp-push": tcp.PSH,
+	"tcp-ack":  tcp.ACK,
+	"tcp-urg":  tcp.URG,
+	"tcp-ece":  tcp.ECN,
+	"tcp-cwr":  tcp.CWR,
+}
+
+const symbols = "()[]&|!="
+
+func tokenize(s string) []string {
+	var tokens []string
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case strings.IndexByte(symbols, c) >= 0:
+			// two characters operators
+			if i+1 < len(s) {
+				if two := s[i : i+2]; two == "&&" || two == "||" || two == "!=" || two == "==" {
+					tokens = append(tokens, two)
+					i += 2
+					continue
+				}
+			}
+			tokens = append(tokens, string(c))
+			i++
+		default:
+			j := i
+			for j < len(s) && s[j] != ' ' && s[j] != '\t' && s[j] != '\n' && strings.IndexByte(symbols, s[j]) < 0 {
+				j++
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		}
+	}
+	return tokens
+}
+
+type parser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *parser) end() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *parser) peek() string {
+	return p.peekAt(0)
+}
+
+func (p *parser) peekAt(n int) string {
+	if p.pos+n >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos+n]
+}
+
+func (p *parser) next() string {
+	t := p.peek()
+	if !p.end() {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) expect(token string) error {
+	if t := p.next(); t != token {
+		return fmt.Errorf("expected %q but got %q in filter", token, t)
+	}
+	return nil
+}
+
+func (p *parser) expr() (node, error) {
+	left, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "or" || p.peek() == "||" {
+		p.next()
+		right, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		left = or{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) term() (node, error) {
+	left, err := p.factor()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "and" || p.peek() == "&&" {
+		p.next()
+		right, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+		left = and{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) factor() (node, error) {
+	switch p.peek() {
+	case "not", "!":
+		p.next()
+		n, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+		return not{n}, nil
+	case "(":
+		p.next()
+		n, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return n, nil
+	}
+	return p.primitive()
+}
+
+func (p *parser) primitive() (node, error) {
+	switch t := p.peek(); t {
+	case "ip", "arp", "icmp":
+		p.next()
+		if t == "ip" && p.peek() == "broadcast" {
+			p.next()
+			// both the all-ones and all-zeros conventions, directed broadcasts need the netmask
+			return and{protocol("ip"), or{
+				network{dir: dst, addr: 0xffffffff, mask: 0xffffffff},
+				network{dir: dst, addr: 0, mask: 0xffffffff},
+			}}, nil
+		}
+		return protocol(t), nil
+	case "tcp", "udp":
+		p.next()
+		if t == "tcp" && p.peek() == "[" {
+			return p.flags()
+		}
+		if p.peek() == "port" || ((p.peek() == "src" || p.peek() == "dst") && p.qualifiedPort()) {
+			dir := p.direction()
+			return p.port(dir, t)
+		}
+		return protocol(t), nil
+	case "ether":
+		p.next()
+		dir := p.direction()
+		if p.peek() == "host" {
+			p.next()
+		}
+		addr, err := parseHardwareAddress(p.next())
+		if err != nil {
+			return nil, err
+		}
+		return etherHost{dir: dir, addr: addr}, nil
+	case "src", "dst", "host", "net", "port":
+		dir := p.direction()
+		switch q := p.next(); q {
+		case "host":
+			addr, err := ipv4.StringToIPAddress(p.next())
+			if err != nil {
+				return nil, fmt.Errorf("invalid host: %v", err)
+			}
+			return network{dir: dir, addr: addrToUint32(addr), mask: 0xffffffff}, nil
+		case "net":
+			return p.network(dir)
+		case "port":
+			p.pos--
+			return p.port(dir, "")
+		default:
+			return nil, fmt.Errorf("expected host, net or port but got %q in filter", q)
+		}
+	case "":
+		return nil, fmt.Errorf("unexpected end of filter")
+	default:
+		return nil, fmt.Errorf("unknown primitive %q in filter", t)
+	}
+}
+
+// qualifiedPort returns true when the direction is followed by port.
+func (p *parser) qualifiedPort() bool {
+	n := 1
+	if (p.peekAt(1) == "or" || p.peekAt(1) == "and") && (p.peekAt(2) == "src" || p.peekAt(2) == "dst") {
+		n = 3
+	}
+	return p.peekAt(n) == "port"
+}
+
+// direction parses the optional direction qualifier.
+func (p *parser) direction() direction {
+	var dir direction
+	switch p.peek() {
+	case "src":
+		dir = src
+	case "dst":
+		dir = dst
+	default:
+		return srcOrDst
+	}
+	p.next()
+	// src or dst, src and dst
+	if c := p.peek(); (c == "or" || c == "and") && (p.peekAt(1) == "src" || p.peekAt(1) == "dst") && p.peekAt(1) != p.tokens[p.pos-1] {
+		p.next()
+		p.next()
+		if c == "or" {
+			return srcOrDst
+		}
+		return srcAndDst
+	}
+	return dir
+}
+
+func (p *parser) port(dir direction, proto string) (node, error) {
+	if err := p.expect("port"); err != nil {
+		return nil, err
+	}
+	t := p.next()
+	n, err := strconv.ParseUint(t, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q in filter", t)
+	}
+	return port{dir: dir, proto: proto, port: uint16(n)}, nil
+}
+
+func (p *parser) network(dir direction) (node, error) {
+	t := p.next()
+	length := 32
+	if i := strings.IndexByte(t, '/'); i >= 0 {
+		l, err := strconv.Atoi(t[i+1:])
+		if err != nil || l < 0 || l > 32 {
+			return nil, fmt.Errorf("invalid prefix length %q in filter", t[i+1:])
+		}
+		t, length = t[:i], l
+	}
+	addr, err := ipv4.StringToIPAddress(t)
+	if err != nil {
+		return nil, fmt.Errorf("invalid net: %v", err)
+	}
+	mask := uint32(0)
+	if length > 0 {
+		mask = ^uint32(0) << (32 - length)
+	}
+	if p.peek() == "mask" {
+		p.next()
+		m, err := ipv4.StringToIPAddress(p.next())
+		if err != nil {
+			return nil, fmt.Errorf("invalid mask: %v", err)
+		}
+		mask = addrToUint32(m)
+	}
+	return network{dir: dir, addr: addrToUint32(addr), mask: mask}, nil
+}
+
+// flags parses [tcpflags] [& flags] (= | == | !=) flags after tcp.
+func (p *parser) flags() (node, error) {
+	if err := p.expect("["); err != nil {
+		return nil, err
+	}
+	if t := p.next(); t != "tcpflags" && t != "13" {
+		return nil, fmt.Errorf("only tcp[tcpflags] is supported in filter")
+	}
+	if err := p.expect("]"); err != nil {
+		return nil, err
+	}
+	n := tcpFlags{mask: 0xff}
+	if p.peek() == "&" {
+		p.next()
+		mask, err := p.flagValue()
+		if err != nil {
+			return nil, err
+		}
+		n.mask = mask
+	}
+	switch op := p.next(); op {
+	case "=", "==":
+		n.equal = true
+	case "!=":
+	default:
+		return nil, fmt.Errorf("expected comparison but got %q in filter", op)
+	}
+	value, err := p.flagValue()
+	if err != nil {
+		return nil, err
+	}
+	n.value = value & n.mask
+	return n, nil
+}
+
+func (p *parser) flagValue() (tcp.ControlFlag, error) {
+	var value tcp.ControlFlag
+	for {
+		var v tcp.ControlFlag
+		if p.peek() == "(" {
+			p.next()
+			inner, err := p.flagValue()
+			if err != nil {
+				return 0, err
+			}
+			if err := p.expect(")"); err != nil {
+				return 0, err
+			}
+			v = inner
+		} else {
+			t := p.next()
+			if f, ok := flagNames[t]; ok {
+				v = f
+			} else if n, err := strconv.ParseUint(t, 0, 8); err == nil {
+				v = tcp.ControlFlag(n)
+			} else {
+				return 0, fmt.Errorf("invalid tcp flags %q in filter", t)
+			}
+		}
+		value |= v
+		if p.peek() != "|" {
+			return value, nil
+		}
+		p.next()
+	}
+}
+
+func addrToUint32(addr *ipv4.IPAddress) uint32 {
+	return uint32(addr[0])<<24 | uint32(addr[1])<<16 | uint32(addr[2])<<8 | uint32(addr[3])
+}
+
+func parseHardwareAddress(s string) (ethernet.HardwareAddress, error) {
+	var addr ethernet.HardwareAddress
+	parts := strings.Split(s, ":")
+	if len(parts) != 6 {
+		return addr, fmt.Errorf("invalid hardware address %q in filter", s)
+	}
+	for i, part := range parts {
+		n, err := strconv.ParseUint(part, 16, 8)
+		if err != nil {
+			return addr, fmt.Errorf("invalid hardware address %q in filter", s)
+		}
+		addr[i] = byte(n)
+	}
+	return addr, nil
+}
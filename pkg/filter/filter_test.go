@@ -0,0 +1,174 @@
+package filter
+
+import (
+	"testing"
+
+	"github.com/terassyi/gotcp/pkg/bpf"
+	"github.com/terassyi/gotcp/pkg/packet/arp"
+	"github.com/terassyi/gotcp/pkg/packet/decode"
+	"github.com/terassyi/gotcp/pkg/packet/ethernet"
+	"github.com/terassyi/gotcp/pkg/packet/ipv4"
+	"github.com/terassyi/gotcp/pkg/packet/tcp"
+)
+
+var (
+	clientMac = ethernet.HardwareAddress{0x02, 0, 0, 0, 0, 2}
+	serverMac = ethernet.HardwareAddress{0x02, 0, 0, 0, 0, 1}
+)
+
+func buildFrame(t *testing.T, typ ethernet.EtherType, data []byte) []byte {
+	t.Helper()
+	b, err := ethernet.Build(clientMac, serverMac, typ, data).Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func buildIP(t *testing.T, protocol ipv4.IPProtocol, data []byte) []byte {
+	t.Helper()
+	packet, err := ipv4.Build(ipv4.IPAddress{10, 0, 0, 2}, ipv4.IPAddress{192, 168, 1, 1}, protocol, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := packet.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buildFrame(t, ethernet.ETHER_TYPE_IP, b)
+}
+
+func TestMatch(t *testing.T) {
+	syn, err := tcp.Build(40000, 80, 1, 0, tcp.SYN, 29200, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	synData, err := syn.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	synAck, err := tcp.Build(40000, 80, 1, 1, tcp.SYN|tcp.ACK, 29200, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	synAckData, err := synAck.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	request, err := arp.Request(clientMac.Bytes(), []byte{10, 0, 0, 2}, []byte{10, 0, 0, 1}, arp.PROTOCOL_IPv4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requestData, err := request.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames := map[string][]byte{
+		"syn":    buildIP(t, ipv4.IPTCPProtocol, synData),
+		"synack": buildIP(t, ipv4.IPTCPProtocol, synAckData),
+		"udp":    buildIP(t, ipv4.IPUDPProtocol, []byte{0x00, 0x35, 0x00, 0x50, 0x00, 0x08, 0x00, 0x00}),
+		"icmp":   buildIP(t, ipv4.IPICMPv4Protocol, []byte{0x08, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x01}),
+		"arp":    buildFrame(t, ethernet.ETHER_TYPE_ARP, requestData),
+	}
+	for _, c := range []struct {
+		expression string
+		matched    []string
+	}{
+		{"", []string{"syn", "synack", "udp", "icmp", "arp"}},
+		{"tcp", []string{"syn", "synack"}},
+		{"ip and not tcp", []string{"udp", "icmp"}},
+		{"arp or icmp", []string{"icmp", "arp"}},
+		{"host 10.0.0.2", []string{"syn", "synack", "udp", "icmp", "arp"}},
+		{"dst host 10.0.0.2", nil},
+		{"src host 10.0.0.2 and dst host 192.168.1.1", []string{"syn", "synack", "udp", "icmp"}},
+		{"src or dst host 10.0.0.1", []string{"arp"}},
+		{"net 192.168.0.0/16", []string{"syn", "synack", "udp", "icmp"}},
+		{"src net 10.0.0.0 mask 255.255.255.0 and dst net 10.0.0.0/24", []string{"arp"}},
+		{"port 80", []string{"syn", "synack", "udp"}},
+		{"tcp port 80", []string{"syn", "synack"}},
+		{"udp src port 53", []string{"udp"}},
+		{"tcp dst port 80 && !(udp || icmp)", []string{"syn", "synack"}},
+		{"ether src 02:00:00:00:00:02", []string{"syn", "synack", "udp", "icmp", "arp"}},
+		{"ether dst host 02:00:00:00:00:02", nil},
+		{"tcp[tcpflags] & tcp-syn != 0", []string{"syn", "synack"}},
+		{"tcp[tcpflags] & (tcp-syn|tcp-ack) == tcp-syn", []string{"syn"}},
+		{"tcp[13] = 0x12", []string{"synack"}},
+	} {
+		f, err := Compile(c.expression)
+		if err != nil {
+			t.Fatalf("%q: %v", c.expression, err)
+		}
+		prog, err := f.BPF()
+		if err != nil {
+			t.Fatalf("%q: %v", c.expression, err)
+		}
+		matched := map[string]bool{}
+		for _, name := range c.matched {
+			matched[name] = true
+		}
+		for name, frame := range frames {
+			if f.Match(decode.Decode(frame)) != matched[name] {
+				t.Errorf("%q: %s is expected to be matched=%v", c.expression, name, matched[name])
+			}
+			if (bpf.Run(prog, frame) != 0) != matched[name] {
+				t.Errorf("%q: %s is expected to be accepted by bpf=%v\n%s", c.expression, name, matched[name], bpf.Disassemble(prog))
+			}
+		}
+	}
+}
+
+func TestIPBroadcast(t *testing.T) {
+	f, err := Compile("ip broadcast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prog, err := f.BPF()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		dst     ipv4.IPAddress
+		matched bool
+	}{
+		{ipv4.IPAddress{255, 255, 255, 255}, true},
+		{ipv4.IPAddress{0, 0, 0, 0}, true},
+		{ipv4.IPAddress{10, 0, 0, 255}, false},
+		{ipv4.IPAddress{10, 0, 0, 1}, false},
+	} {
+		packet, err := ipv4.Build(ipv4.IPAddress{10, 0, 0, 2}, c.dst, ipv4.IPUDPProtocol, []byte{0x00, 0x35, 0x00, 0x50, 0x00, 0x08, 0x00, 0x00})
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := packet.Serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		frame := buildFrame(t, ethernet.ETHER_TYPE_IP, b)
+		if f.Match(decode.Decode(frame)) != c.matched {
+			t.Errorf("%s is expected to be matched=%v", c.dst, c.matched)
+		}
+		if (bpf.Run(prog, frame) != 0) != c.matched {
+			t.Errorf("%s is expected to be accepted by bpf=%v\n%s", c.dst, c.matched, bpf.Disassemble(prog))
+		}
+	}
+}
+
+func TestCompileError(t *testing.T) {
+	for _, expression := range []string{
+		"host",
+		"host 10.0.0",
+		"port http",
+		"tcp and",
+		"(tcp",
+		"tcp)",
+		"net 10.0.0.0/33",
+		"tcp[0] = 1",
+		"tcp[tcpflags] & tcp-foo != 0",
+		"ether host 02:00",
+		"foo",
+	} {
+		if _, err := Compile(expression); err == nil {
+			t.Errorf("%q is compiled", expression)
+		}
+	}
+}
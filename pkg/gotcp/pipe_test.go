@@ -0,0 +1,750 @@
+package gotcp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/terassyi/gotcp/pkg/clock"
+	"github.com/terassyi/gotcp/pkg/interfaces"
+	"github.com/terassyi/gotcp/pkg/packet/decode"
+	"github.com/terassyi/gotcp/pkg/packet/ethernet"
+	icmppacket "github.com/terassyi/gotcp/pkg/packet/icmp"
+	ippacket "github.com/terassyi/gotcp/pkg/packet/ipv4"
+	"github.com/terassyi/gotcp/pkg/proto/tcp"
+)
+
+const (
+	pipeServerAddr = "10.0.0.1"
+	pipeClientAddr = "10.0.0.2"
+)
+
+// startPipeStacks starts the server and client stacks connected by the in-memory pipe.
+func startPipeStacks(t *testing.T) (*Stack, *Stack) {
+	t.Helper()
+	return startWrappedPipeStacks(t, func(iface interfaces.Iface) interfaces.Iface { return iface })
+}
+
+// startWrappedPipeStacks starts the stacks on the ends of the pipe wrapped by wrap.
+func startWrappedPipeStacks(t *testing.T, wrap func(interfaces.Iface) interfaces.Iface) (*Stack, *Stack) {
+	t.Helper()
+	i0, i1 := interfaces.NewPipe(
+		[]byte{0x02, 0x00, 0x00, 0x00, 0x00, 0x01},
+		[]byte{0x02, 0x00, 0x00, 0x00, 0x00, 0x02},
+	)
+	start := func(iface interfaces.Iface, addr string) *Stack {
+		s, err := NewWithIface(Config{
+			Address:  addr,
+			Netmask:  "255.255.255.0",
+			LogLevel: "warn",
+			TCP:      TCPConfig{MSL: 10 * time.Millisecond},
+		}, iface)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Start(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { s.Close() })
+		return s
+	}
+	return start(wrap(i0), pipeServerAddr), start(wrap(i1), pipeClientAddr)
+}
+
+// connectPipeStacks returns the established connections of the server and the client on the new stacks.
+func connectPipeStacks(t *testing.T) (*tcp.Conn, *tcp.Conn) {
+	t.Helper()
+	server, client := startPipeStacks(t)
+	return connectStacks(t, server, client)
+}
+
+// connectStacks returns the established connections of the server and the client.
+func connectStacks(t *testing.T, server, client *Stack) (*tcp.Conn, *tcp.Conn) {
+	t.Helper()
+	l, err := server.Tcp().Listen("0.0.0.0", 8080)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted := make(chan *tcp.Conn, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			t.Error(err)
+		}
+		accepted <- conn
+	}()
+	c, err := client.Tcp().Dial(pipeServerAddr, 8080)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case s := <-accepted:
+		if s == nil {
+			t.FailNow()
+		}
+		return s, c
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection is not accepted")
+	}
+	return nil, nil
+}
+
+func waitState(t *testing.T, conn *tcp.Conn, state string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if conn.Info().State == state {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("state is %s, want %s", conn.Info().State, state)
+}
+
+func TestPipeArp(t *testing.T) {
+	server, client := startPipeStacks(t)
+	if _, err := client.Icmp().Ping(*server.Ipv4().Address, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	// the server learns the client from the request
+	if e := server.Arp().Table.Search(client.Ipv4().Address); e == nil || *e.MacAddress != *client.Ethernet().Address() {
+		t.Fatalf("server entry: %+v", e)
+	}
+	if e := client.Arp().Table.Search(server.Ipv4().Address); e == nil || *e.MacAddress != *server.Ethernet().Address() {
+		t.Fatalf("client entry: %+v", e)
+	}
+}
+
+func TestPipePing(t *testing.T) {
+	server, client := startPipeStacks(t)
+	for i := 0; i < 3; i++ {
+		if _, err := client.Icmp().Ping(*server.Ipv4().Address, time.Second); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := server.Icmp().Ping(*client.Ipv4().Address, time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPipeHandshake(t *testing.T) {
+	s, c := connectPipeStacks(t)
+	waitState(t, s, "ESTABLISHED")
+	waitState(t, c, "ESTABLISHED")
+	if s.Info().Rcv.NXT != c.Info().Snd.NXT || c.Info().Rcv.NXT != s.Info().Snd.NXT {
+		t.Fatalf("sequence mismatch: server %+v client %+v", s.Info(), c.Info())
+	}
+}
+
+func TestPipeBulkTransfer(t *testing.T) {
+	s, c := connectPipeStacks(t)
+	data := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+	go func() {
+		for i := 0; i < len(data); i += 64 * 1024 {
+			if _, err := c.Write(data[i : i+64*1024]); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	received := make([]byte, 0, len(data))
+	buf := make([]byte, 64*1024)
+	for len(received) < len(data) {
+		n, err := s.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		received = append(received, buf[:n]...)
+	}
+	if !bytes.Equal(received, data) {
+		t.Fatal("received data is corrupted")
+	}
+}
+
+func TestPipeActiveClose(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		// the side calling Close first
+		client bool
+	}{
+		{name: "client", client: true},
+		{name: "server", client: false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s, c := connectPipeStacks(t)
+			active, passive := s, c
+			if tt.client {
+				active, passive = c, s
+			}
+			if _, err := active.Write([]byte("bye")); err != nil {
+				t.Fatal(err)
+			}
+			closed := make(chan error, 1)
+			go func() { closed <- active.Close() }()
+
+			buf := make([]byte, 16)
+			n, err := passive.Read(buf)
+			if err != nil || string(buf[:n]) != "bye" {
+				t.Fatalf("read %q: %v", buf[:n], err)
+			}
+			if _, err := passive.Read(buf); err != io.EOF {
+				t.Fatalf("read after fin: %v", err)
+			}
+			// the passive side sends its fin as soon as it receives the fin
+			select {
+			case err := <-closed:
+				if err != nil {
+					t.Fatal(err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("active close does not return")
+			}
+			waitState(t, passive, "CLOSED")
+			waitState(t, active, "CLOSED")
+		})
+	}
+}
+
+func TestPipeImpairedTransfer(t *testing.T) {
+	i0, i1 := interfaces.NewPipe(
+		[]byte{0x02, 0x00, 0x00, 0x00, 0x00, 0x01},
+		[]byte{0x02, 0x00, 0x00, 0x00, 0x00, 0x02},
+	)
+	impairment := &interfaces.Impairment{
+		Latency:   5 * time.Millisecond,
+		Jitter:    5 * time.Millisecond,
+		Duplicate: 0.05,
+		Reorder:   0.1,
+		Seed:      1,
+	}
+	server, err := NewWithIface(Config{Address: pipeServerAddr, LogLevel: "warn"}, i0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewWithIface(Config{Address: pipeClientAddr, LogLevel: "warn", Impairment: impairment}, i1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []*Stack{server, client} {
+		if err := s.Start(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+	}
+	l, err := server.Tcp().Listen("0.0.0.0", 8080)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 256*1024)
+	rand.New(rand.NewSource(1)).Read(data)
+	received := make(chan []byte, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			t.Error(err)
+			received <- nil
+			return
+		}
+		buf := make([]byte, 0, len(data))
+		b := make([]byte, 64*1024)
+		for len(buf) < len(data) {
+			n, err := conn.Read(b)
+			if err != nil {
+				t.Error(err)
+				break
+			}
+			buf = append(buf, b[:n]...)
+		}
+		received <- buf
+	}()
+	conn, err := client.Tcp().Dial(pipeServerAddr, 8080)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case buf := <-received:
+		if !bytes.Equal(buf, data) {
+			t.Fatal("received data is corrupted")
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatalf("transfer does not complete: %+v", conn.Info())
+	}
+}
+
+func TestPipeStaticAddress(t *testing.T) {
+	i0, i1 := interfaces.NewPipe(
+		[]byte{0x02, 0x00, 0x00, 0x00, 0x00, 0x01},
+		[]byte{0x02, 0x00, 0x00, 0x00, 0x00, 0x02},
+	)
+	server, err := NewWithIface(Config{Address: "10.0.0.1/24", Mac: "02:00:00:00:00:0a", LogLevel: "warn"}, i0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewWithIface(Config{Address: "10.0.0.2/24", LogLevel: "warn"}, i1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []*Stack{server, client} {
+		if err := s.Start(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { s.Close() })
+	}
+	if server.Ipv4().Netmask.String() != "255.255.255.0" {
+		t.Fatalf("netmask: %s", server.Ipv4().Netmask)
+	}
+	if _, err := client.Icmp().Ping(*server.Ipv4().Address, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if e := client.Arp().Table.Search(server.Ipv4().Address); e == nil || e.MacAddress.String() != "02:00:00:00:00:0a" {
+		t.Fatalf("client entry: %+v", e)
+	}
+	// frames to the hardware address of the interface are not for the stack
+	other := ethernet.HardwareAddress{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}
+	if _, err := client.Arp().Table.Update(&other, server.Ipv4().Address); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Icmp().Ping(*server.Ipv4().Address, 200*time.Millisecond); err == nil {
+		t.Fatal("frame to the other host is received")
+	}
+}
+
+func TestPipeMultiHomed(t *testing.T) {
+	i0, i1 := interfaces.NewPipe(
+		[]byte{0x02, 0x00, 0x00, 0x00, 0x00, 0x01},
+		[]byte{0x02, 0x00, 0x00, 0x00, 0x00, 0x02},
+	)
+	j0, j1 := interfaces.NewPipe(
+		[]byte{0x02, 0x00, 0x00, 0x00, 0x01, 0x01},
+		[]byte{0x02, 0x00, 0x00, 0x00, 0x01, 0x02},
+	)
+	start := func(config Config, iface interfaces.Iface) *Stack {
+		config.LogLevel = "warn"
+		s, err := NewWithIface(config, iface)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Start(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { s.Close() })
+		return s
+	}
+	server := start(Config{
+		Address:    "10.0.0.1/24",
+		Aliases:    []string{"10.0.0.11/24"},
+		Interfaces: []InterfaceConfig{{Iface: j0, Addresses: []string{"10.1.0.1/24"}}},
+	}, i0)
+	a := start(Config{Address: "10.0.0.2/24", Routes: []string{"10.1.0.0/24 via 10.0.0.1"}}, i1)
+	b := start(Config{Address: "10.1.0.2/24"}, j1)
+
+	if len(server.Links()) != 2 || len(server.Ipv4().Interfaces()) != 3 {
+		t.Fatalf("links: %d, interfaces: %d", len(server.Links()), len(server.Ipv4().Interfaces()))
+	}
+	for _, addr := range []string{"10.0.0.1", "10.0.0.11"} {
+		dst, _ := ippacket.StringToIPAddress(addr)
+		if _, err := a.Icmp().Ping(*dst, time.Second); err != nil {
+			t.Fatalf("ping %s: %v", addr, err)
+		}
+	}
+	// the address of the second interface is reachable on the first one as a weak host
+	if _, err := a.Icmp().Ping(ippacket.IPAddress{10, 1, 0, 1}, time.Second); err != nil {
+		t.Fatalf("ping 10.1.0.1: %v", err)
+	}
+	// the echo request goes out of the second interface
+	if _, err := server.Icmp().Ping(*b.Ipv4().Address, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	accept := func(l *tcp.Listener) <-chan *tcp.Conn {
+		accepted := make(chan *tcp.Conn, 1)
+		go func() {
+			conn, err := l.Accept()
+			if err != nil {
+				t.Error(err)
+			}
+			accepted <- conn
+		}()
+		return accepted
+	}
+	// bound to the address of the second interface
+	l, err := server.Tcp().Listen("10.1.0.1", 8080)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted := accept(l)
+	if _, err := b.Tcp().Dial("10.1.0.1", 8080); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-accepted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection is not accepted on 10.1.0.1")
+	}
+	// the connection to the secondary address is answered from it
+	l, err = server.Tcp().Listen("0.0.0.0", 8081)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted = accept(l)
+	c, err := a.Tcp().Dial("10.0.0.11", 8081)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s *tcp.Conn
+	select {
+	case s = <-accepted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection is not accepted on 10.0.0.11")
+	}
+	if s.Peer.Addr.String() != "10.0.0.11" {
+		t.Fatalf("local address: %s", s.Peer.Addr)
+	}
+	if _, err := s.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 16)
+	n, err := c.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "hello" {
+		t.Fatalf("want hello, got %q", buf[:n])
+	}
+}
+
+func TestPipeRoute(t *testing.T) {
+	i0, i1 := interfaces.NewPipe(
+		[]byte{0x02, 0x00, 0x00, 0x00, 0x00, 0x01},
+		[]byte{0x02, 0x00, 0x00, 0x00, 0x00, 0x02},
+	)
+	for _, s := range []struct {
+		config Config
+		iface  interfaces.Iface
+	}{
+		{Config{Address: "10.0.0.1/24"}, i0},
+		{Config{Address: "10.0.0.2/24", Routes: []string{"10.2.0.0/16 via 10.0.0.1"}}, i1},
+	} {
+		s.config.LogLevel = "warn"
+		stack, err := NewWithIface(s.config, s.iface)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := stack.Start(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { stack.Close() })
+		if s.iface != i1 {
+			continue
+		}
+		dst, _ := ippacket.StringToIPAddress("10.2.0.5")
+		// the reply does not matter, the gateway is resolved instead of the destination
+		stack.Icmp().Ping(*dst, 100*time.Millisecond)
+		gw, _ := ippacket.StringToIPAddress("10.0.0.1")
+		if stack.Arp().Table.Search(gw) == nil || stack.Arp().Table.Search(dst) != nil {
+			t.Fatal("the gateway is not resolved")
+		}
+		unreachable, _ := ippacket.StringToIPAddress("10.3.0.1")
+		if _, err := stack.Icmp().Ping(*unreachable, 100*time.Millisecond); err == nil {
+			t.Fatal("no route is found")
+		}
+	}
+}
+
+func TestPipeRecordRoute(t *testing.T) {
+	server, client := startPipeStacks(t)
+	_, reply, err := client.Icmp().PingOptions(*server.Ipv4().Address, time.Second, ippacket.Options{ippacket.NewRecordRoute(9)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rr := reply.RecordRoute(); rr == nil || rr.String() != pipeClientAddr+" "+pipeServerAddr {
+		t.Fatalf("recorded route: %v", rr)
+	}
+	// source routed datagrams are dropped by default
+	sr := &ippacket.SourceRoute{Pointer: 8, Route: []ippacket.IPAddress{*client.Ipv4().Address}}
+	if _, _, err := client.Icmp().PingOptions(*server.Ipv4().Address, 200*time.Millisecond, ippacket.Options{sr}); err == nil {
+		t.Fatal("source routed datagram is accepted")
+	}
+	if drops := server.Ipv4().Drops(); drops.SourceRoute != 1 {
+		t.Fatalf("drops: %+v", drops)
+	}
+}
+
+// tappedIface passes copies of received frames to the test.
+type tappedIface struct {
+	interfaces.Iface
+	frames chan []byte
+}
+
+func (t *tappedIface) Recv(buf []byte) (int, error) {
+	n, err := t.Iface.Recv(buf)
+	if err == nil {
+		select {
+		case t.frames <- append([]byte(nil), buf[:n]...):
+		default:
+		}
+	}
+	return n, err
+}
+
+func TestPipeProtocolUnreachable(t *testing.T) {
+	i0, i1 := interfaces.NewPipe(
+		[]byte{0x02, 0x00, 0x00, 0x00, 0x00, 0x01},
+		[]byte{0x02, 0x00, 0x00, 0x00, 0x00, 0x02},
+	)
+	tapped := &tappedIface{Iface: i1, frames: make(chan []byte, 64)}
+	server, err := NewWithIface(Config{Address: pipeServerAddr + "/24", LogLevel: "warn"}, i0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewWithIface(Config{Address: pipeClientAddr + "/24", LogLevel: "warn"}, tapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []*Stack{server, client} {
+		if err := s.Start(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { s.Close() })
+	}
+	udp := []byte{0x00, 0x35, 0x00, 0x35, 0x00, 0x08, 0x00, 0x00}
+
+	// broadcasts pass the filter of the server, but errors are not sent in reply
+	for _, dst := range []ippacket.IPAddress{{10, 0, 0, 255}, {255, 255, 255, 255}} {
+		packet, err := ippacket.Build(*client.Ipv4().Address, dst, ippacket.IPUDPProtocol, udp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := packet.Serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		frame, err := ethernet.Build(*client.Ethernet().Address(), ethernet.BroadcastAddress, ethernet.ETHER_TYPE_IP, data).Serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := i1.Send(frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(time.Second)
+	for server.Ipv4().Drops().Protocol != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("drops: %+v", server.Ipv4().Drops())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, err := client.Ipv4().Send(*server.Ipv4().Address, ippacket.IPUDPProtocol, udp); err != nil {
+		t.Fatal(err)
+	}
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case frame := <-tapped.frames:
+			p := decode.Decode(frame)
+			if p.Icmp == nil {
+				continue
+			}
+			if p.Icmp.Header.Type != icmppacket.DestinationUnreachable || p.Icmp.Header.Code != icmppacket.DestinationProtocolUnreachableCode {
+				t.Fatalf("icmp type=%d code=%d", p.Icmp.Header.Type, p.Icmp.Header.Code)
+			}
+			// the first error quotes the unicast datagram
+			original, err := ippacket.New(p.Icmp.Data[4:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if original.Header.Dst != *server.Ipv4().Address {
+				t.Fatalf("error in reply to %s", original.Header.Dst)
+			}
+			return
+		case <-timeout:
+			t.Fatal("protocol unreachable is not received")
+		}
+	}
+}
+
+func TestPipeReassemblyTimeExceeded(t *testing.T) {
+	i0, i1 := interfaces.NewPipe(
+		[]byte{0x02, 0x00, 0x00, 0x00, 0x00, 0x01},
+		[]byte{0x02, 0x00, 0x00, 0x00, 0x00, 0x02},
+	)
+	tapped := &tappedIface{Iface: i1, frames: make(chan []byte, 64)}
+	v := clock.NewVirtual(time.Unix(0, 0))
+	server, err := NewWithIface(Config{Address: pipeServerAddr + "/24", LogLevel: "warn", Clock: v}, i0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewWithIface(Config{Address: pipeClientAddr + "/24", LogLevel: "warn"}, tapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []*Stack{server, client} {
+		if err := s.Start(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { s.Close() })
+	}
+	// the first fragment, the rest never arrives
+	packet, err := ippacket.Build(*client.Ipv4().Address, *server.Ipv4().Address, ippacket.IPUDPProtocol, make([]byte, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet.Header.Ident = 0x1234
+	packet.Header.FlOffset = ippacket.NewFlagsFragmentOffset(ippacket.FlagMoreFragments, 0)
+	if err := packet.ReCalculateChecksum(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := packet.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame, err := ethernet.Build(*client.Ethernet().Address(), *server.Ethernet().Address(), ethernet.ETHER_TYPE_IP, data).Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := i1.Send(frame); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	timeExceeded := func(wait time.Duration) *decode.Packet {
+		timeout := time.After(wait)
+		for {
+			select {
+			case frame := <-tapped.frames:
+				if p := decode.Decode(frame); p.Icmp != nil {
+					return p
+				}
+			case <-timeout:
+				return nil
+			}
+		}
+	}
+	advance := func(d time.Duration) {
+		for ; d > 0; d -= time.Second {
+			v.Advance(time.Second)
+			// let the timer routine run
+			time.Sleep(time.Millisecond)
+		}
+	}
+	advance(29 * time.Second)
+	if p := timeExceeded(100 * time.Millisecond); p != nil {
+		t.Fatalf("icmp before the timeout: type=%d code=%d", p.Icmp.Header.Type, p.Icmp.Header.Code)
+	}
+	advance(2 * time.Second)
+	p := timeExceeded(2 * time.Second)
+	if p == nil {
+		t.Fatal("time exceeded is not received")
+	}
+	if p.Icmp.Header.Type != icmppacket.TimeExceeded || p.Icmp.Header.Code != icmppacket.FragmentTimeExceeded {
+		t.Fatalf("icmp type=%d code=%d", p.Icmp.Header.Type, p.Icmp.Header.Code)
+	}
+	// the header and 8 bytes of the first fragment are quoted
+	if original := p.Icmp.Data[4:]; len(original) != 28 || !bytes.Equal(original, data[:28]) {
+		t.Fatalf("original datagram: %x", original)
+	}
+}
+
+// batchedIface passes frames received at once in batches as the rx ring does.
+// The frame is overwritten after f returns, so the stack must not keep it.
+type batchedIface struct {
+	interfaces.Iface
+	frames  chan []byte
+	scratch []byte
+}
+
+func newBatchedIface(iface interfaces.Iface) interfaces.Iface {
+	b := &batchedIface{Iface: iface, frames: make(chan []byte, 64), scratch: make([]byte, 1514)}
+	go func() {
+		buf := make([]byte, 1514)
+		for {
+			n, err := iface.Recv(buf)
+			if err == interfaces.ErrTimeout {
+				continue
+			}
+			if err != nil {
+				close(b.frames)
+				return
+			}
+			b.frames <- append([]byte(nil), buf[:n]...)
+		}
+	}()
+	return b
+}
+
+func (b *batchedIface) RecvBatch(f func(frame []byte)) error {
+	pass := func(frame []byte) {
+		n := copy(b.scratch, frame)
+		f(b.scratch[:n])
+		for i := range b.scratch {
+			b.scratch[i] = 0xff
+		}
+	}
+	select {
+	case frame, ok := <-b.frames:
+		if !ok {
+			return io.EOF
+		}
+		pass(frame)
+	case <-time.After(100 * time.Millisecond):
+		return interfaces.ErrTimeout
+	}
+	for {
+		select {
+		case frame, ok := <-b.frames:
+			if !ok {
+				return nil
+			}
+			pass(frame)
+		default:
+			return nil
+		}
+	}
+}
+
+func (b *batchedIface) SendBatch(frames [][]byte) (int, error) {
+	for i, frame := range frames {
+		if _, err := b.Send(frame); err != nil {
+			return i, err
+		}
+	}
+	return len(frames), nil
+}
+
+func TestPipeBatchedReceive(t *testing.T) {
+	// reordered segments are kept until the hole is filled
+	server, client := startWrappedPipeStacks(t, func(iface interfaces.Iface) interfaces.Iface {
+		return newBatchedIface(interfaces.NewImpaired(iface, interfaces.Impairment{Latency: time.Millisecond, Reorder: 0.5, Seed: 1}))
+	})
+	if _, err := client.Icmp().Ping(*server.Ipv4().Address, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	s, c := connectStacks(t, server, client)
+	data := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+	go func() {
+		if _, err := c.Write(data); err != nil {
+			t.Error(err)
+		}
+	}()
+	received := make([]byte, 0, len(data))
+	buf := make([]byte, 64*1024)
+	for len(received) < len(data) {
+		n, err := s.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		received = append(received, buf[:n]...)
+	}
+	if !bytes.Equal(received, data) {
+		t.Fatal("received data is corrupted")
+	}
+}
@@ -0,0 +1,105 @@
+package gotcp
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/terassyi/gotcp/pkg/interfaces"
+	etherframe "github.com/terassyi/gotcp/pkg/packet/ethernet"
+	icmppacket "github.com/terassyi/gotcp/pkg/packet/icmp"
+	ippacket "github.com/terassyi/gotcp/pkg/packet/ipv4"
+	tcppacket "github.com/terassyi/gotcp/pkg/packet/tcp"
+)
+
+// TestReplayCapturedSession captures the session on the server and replays it to the new server.
+func TestReplayCapturedSession(t *testing.T) {
+	serverMac := []byte{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}
+	path := filepath.Join(t.TempDir(), "server.pcapng")
+	i0, i1 := interfaces.NewPipe(serverMac, []byte{0x02, 0x00, 0x00, 0x00, 0x00, 0x02})
+	config := func(addr string) Config {
+		return Config{Address: addr, Netmask: "255.255.255.0", LogLevel: "warn"}
+	}
+	serverConfig := config(pipeServerAddr)
+	serverConfig.Pcap = path
+	server, err := NewWithIface(serverConfig, i0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewWithIface(config(pipeClientAddr), i1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []*Stack{server, client} {
+		if err := s.Start(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := client.Icmp().Ping(*server.Ipv4().Address, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	l, err := server.Tcp().Listen("0.0.0.0", 8080)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go l.Accept()
+	if _, err := client.Tcp().Dial(pipeServerAddr, 8080); err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+	server.Close()
+
+	r, err := interfaces.NewReplay(path, interfaces.ReplayConfig{Mac: serverMac, Record: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed, err := NewWithIface(config(pipeServerAddr), r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replayed.Close()
+	rl, err := replayed.Tcp().Listen("0.0.0.0", 8080)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go rl.Accept()
+	if err := replayed.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	<-r.Done()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		echoReply, synAck := false, false
+		for _, f := range r.Sent() {
+			frame, err := etherframe.New(f)
+			if err != nil || frame.Type() != etherframe.ETHER_TYPE_IP {
+				continue
+			}
+			packet, err := ippacket.New(frame.Payload())
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch packet.Header.Protocol {
+			case ippacket.IPICMPv4Protocol:
+				m, err := icmppacket.New(packet.Data)
+				if err == nil && m.Header.Type == icmppacket.EchoReply {
+					echoReply = true
+				}
+			case ippacket.IPTCPProtocol:
+				segment, err := tcppacket.New(packet.Data)
+				if err == nil && segment.Header.OffsetControlFlag.ControlFlag() == tcppacket.SYN|tcppacket.ACK {
+					synAck = true
+				}
+			}
+		}
+		if echoReply && synAck {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("echo reply: %v syn ack: %v", echoReply, synAck)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
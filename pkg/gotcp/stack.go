@@ -0,0 +1,708 @@
+package gotcp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/terassyi/gotcp/pkg/clock"
+	"github.com/terassyi/gotcp/pkg/filter"
+	"github.com/terassyi/gotcp/pkg/interfaces"
+	etherframe "github.com/terassyi/gotcp/pkg/packet/ethernet"
+	ippacket "github.com/terassyi/gotcp/pkg/packet/ipv4"
+	"github.com/terassyi/gotcp/pkg/pcap"
+	"github.com/terassyi/gotcp/pkg/proto/arp"
+	"github.com/terassyi/gotcp/pkg/proto/ethernet"
+	"github.com/terassyi/gotcp/pkg/proto/icmp"
+	"github.com/terassyi/gotcp/pkg/proto/ipv4"
+	"github.com/terassyi/gotcp/pkg/proto/tcp"
+)
+
+const (
+	afpacket     string = "afpacket"
+	afpacketMmap string = "afpacket-mmap"
+	tap          string = "tap"
+	tun          string = "tun"
+)
+
+const (
+	defaultMTU           int = 1500
+	defaultRecvQueueSize int = 100
+	defaultSendQueueSize int = 100
+	etherHeaderLength    int = 14
+	receiveBatchSize     int = 128 * 1024 // a block of the rx ring
+	receiveBatches       int = 4
+)
+
+const kernelRoutes string = "/proc/net/route"
+
+// Config is the configuration of the Stack.
+type Config struct {
+	Name    string // interface name
+	Type    string // interface type, afpacket, afpacket-mmap, tap or tun. afpacket is used by default.
+	Address string // static ip address with the optional prefix length such as 10.0.0.5/24, the address of the interface is used when empty. required on tap and tun.
+	Mac     string // static hardware address, the address of the interface is used when empty
+	Netmask string // netmask, the prefix length of Address is used when empty
+	Gateway string // the default route
+	MTU     int
+	Aliases []string // secondary addresses of the interface with the prefix length
+
+	Routes       []string // static routes such as "10.1.0.0/16 via 10.0.0.254 metric 10"
+	KernelRoutes bool     // import routes of the kernel on interfaces of the stack
+
+	AcceptSourceRoute bool // deliver datagrams with source route options, they are dropped by default
+
+	Interfaces []InterfaceConfig // additional interfaces of the multi-homed stack
+
+	Impairment *interfaces.Impairment // emulates the impaired link on sending frames of the interface
+	Pcap       string                 // file to capture frames on the interface, pcapng when the extension is .pcapng
+
+	RecvQueueSize int // frames received but not dispatched yet, batches of frames on interfaces receiving in batches
+	SendQueueSize int // tcp segments waiting to be sent
+
+	Clock clock.Clock // timers of protocols, the real clock is used when nil
+
+	Debug    bool   // output messages of protocols
+	LogLevel string // logrus level, debug is used when empty
+
+	TCP TCPConfig
+}
+
+// InterfaceConfig is the configuration of the additional interface.
+type InterfaceConfig struct {
+	Name      string
+	Type      string           // afpacket is used by default
+	Iface     interfaces.Iface // opened interface such as a pipe, Name and Type are ignored when set
+	Addresses []string         // ip addresses with the prefix length, at least one is required
+	Mac       string           // static hardware address, the address of the interface is used when empty
+	MTU       int              // Config.MTU is used when zero
+}
+
+// TCPConfig is tunables of tcp.
+type TCPConfig struct {
+	ECN bool
+	MSL time.Duration
+}
+
+// Stack is the protocol stack on the interfaces.
+type Stack struct {
+	config  Config
+	links   []*link // the first one is the primary interface
+	capture *pcap.File
+	ip      *ipv4.Ipv4
+	icmp    *icmp.Icmp
+	tcp     *tcp.Tcp
+	errors  chan error
+	done    chan struct{}
+	wg      sync.WaitGroup
+	started bool
+	once    sync.Once
+	mutex   sync.Mutex
+}
+
+// link is the interface attached to the stack.
+type link struct {
+	iface interfaces.Iface
+	eth   *ethernet.Ethernet
+	arp   *arp.Arp
+	mtu   int
+}
+
+// batch is the frames received at once on the link.
+// The buffer is reused after the frames are dispatched, protocols copy the frames they keep.
+type batch struct {
+	link   *link
+	buf    []byte
+	used   int // bytes of buf holding frames
+	frames [][]byte
+	free   chan<- *batch
+}
+
+// add copies the frame valid only while the interface passes it.
+func (b *batch) add(frame []byte) {
+	if len(b.buf)-b.used < len(frame) {
+		// the buffer is full, the frame is kept alone
+		b.frames = append(b.frames, append([]byte(nil), frame...))
+		return
+	}
+	n := copy(b.buf[b.used:], frame)
+	b.frames = append(b.frames, b.buf[b.used:b.used+n:b.used+n])
+	b.used += n
+}
+
+// release returns the batch to the link to receive next frames.
+func (b *batch) release() {
+	b.frames = b.frames[:0]
+	b.used = 0
+	b.free <- b
+}
+
+// New builds the stack from the config. No goroutine runs until Start is called.
+func New(config Config) (*Stack, error) {
+	if config.Type == "" {
+		config.Type = afpacket
+	}
+	iface, err := openIface(config.Name, config.Type)
+	if err != nil {
+		return nil, err
+	}
+	s, err := newStack(config, iface)
+	if err != nil {
+		iface.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func openIface(name, typ string) (interfaces.Iface, error) {
+	switch typ {
+	case afpacket, afpacketMmap, tap, tun:
+		iface, err := interfaces.New(name, typ)
+		if err != nil {
+			return nil, err
+		}
+		// the stack does not receive frames sent by itself
+		interfaces.SkipOutgoing(iface)
+		return iface, nil
+	default:
+		return nil, fmt.Errorf("unsupported interface type: %s", typ)
+	}
+}
+
+// NewWithIface builds the stack on the given interface such as a pipe. config.Name and config.Type are ignored.
+func NewWithIface(config Config, iface interfaces.Iface) (*Stack, error) {
+	return newStack(config, iface)
+}
+
+func newStack(config Config, iface interfaces.Iface) (*Stack, error) {
+	var capture *pcap.File
+	if config.Pcap != "" {
+		var err error
+		if capture, err = pcap.Create(config.Pcap, interfaces.LinkType(iface)); err != nil {
+			return nil, err
+		}
+		// frames are captured on the link, so impaired frames are recorded as they are on the wire
+		iface = interfaces.NewCaptured(iface, capture)
+	}
+	s, err := buildStack(config, iface)
+	if err != nil {
+		if capture != nil {
+			capture.Close()
+		}
+		return nil, err
+	}
+	s.capture = capture
+	return s, nil
+}
+
+func buildStack(config Config, iface interfaces.Iface) (*Stack, error) {
+	if config.MTU == 0 {
+		config.MTU = defaultMTU
+	}
+	if config.RecvQueueSize == 0 {
+		config.RecvQueueSize = defaultRecvQueueSize
+	}
+	if config.SendQueueSize == 0 {
+		config.SendQueueSize = defaultSendQueueSize
+	}
+	if config.Impairment != nil {
+		iface = interfaces.NewImpaired(iface, *config.Impairment)
+	}
+	// the address of the tap and tun device belongs to the kernel side
+	raw := interfaces.LinkType(iface) == pcap.LinkTypeRaw
+	if config.Address == "" && (raw || config.Type == tap) {
+		return nil, fmt.Errorf("address of the stack is required on %s", iface.Name())
+	}
+	arpProtocol := arp.New(arp.NewTable(), config.Debug)
+	e, err := newEthernet(config.Mac, iface, raw, arpProtocol)
+	if err != nil {
+		return nil, err
+	}
+	var ip *ipv4.Ipv4
+	icmpProtocol := icmp.New(config.Debug)
+	tcpProtocol, err := tcp.New(config.Debug)
+	if err != nil {
+		return nil, err
+	}
+	tcpProtocol.SendQueue = make(chan tcp.AddressedPacket, config.SendQueueSize)
+	tcpProtocol.ECN = config.TCP.ECN
+	if config.TCP.MSL != 0 {
+		tcpProtocol.MSL = config.TCP.MSL
+	}
+	if config.Address == "" {
+		ip, err = ipv4.New(e, icmpProtocol, tcpProtocol, config.Debug)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		addr, mask, err := parseAddress(config.Address)
+		if err != nil {
+			return nil, err
+		}
+		ip = ipv4.NewWithAddress(e, addr, icmpProtocol, tcpProtocol, config.Debug)
+		ip.Netmask = mask
+	}
+	if config.Netmask != "" {
+		if ip.Netmask, err = ippacket.StringToIPAddress(config.Netmask); err != nil {
+			return nil, err
+		}
+	}
+	ip.MTU = config.MTU
+	arpProtocol.SetAddress(ip.Address, e.Address())
+	for _, alias := range config.Aliases {
+		addr, mask, err := parseAddress(alias)
+		if err != nil {
+			return nil, err
+		}
+		ip.AddInterface(&ipv4.Interface{Eth: e, Address: addr, Netmask: mask, MTU: config.MTU})
+		arpProtocol.AddAddress(addr)
+	}
+	if config.Clock != nil {
+		arpProtocol.SetClock(config.Clock)
+		icmpProtocol.Clock = config.Clock
+		tcpProtocol.Clock = config.Clock
+		ip.Clock = config.Clock
+	}
+	ip.AcceptSourceRoute = config.AcceptSourceRoute
+	links := []*link{{iface: iface, eth: e, arp: arpProtocol, mtu: config.MTU}}
+	for _, c := range config.Interfaces {
+		l, err := addLink(c, config, ip)
+		if err != nil {
+			for _, l := range links[1:] {
+				l.eth.Close()
+			}
+			return nil, err
+		}
+		links = append(links, l)
+	}
+	if err := addRoutes(config, ip, links); err != nil {
+		for _, l := range links[1:] {
+			l.eth.Close()
+		}
+		return nil, err
+	}
+	// filters are attached after all addresses are assigned,
+	// every link accepts datagrams to any address of the stack as a weak host
+	for _, l := range links {
+		if interfaces.LinkType(l.iface) == pcap.LinkTypeRaw {
+			continue
+		}
+		if err := attachFilter(l.iface, l.eth.Address(), ip.Interfaces()); err != nil {
+			for _, l := range links[1:] {
+				l.eth.Close()
+			}
+			return nil, err
+		}
+	}
+
+	level := logrus.DebugLevel
+	if config.LogLevel != "" {
+		if level, err = logrus.ParseLevel(config.LogLevel); err != nil {
+			return nil, err
+		}
+	}
+	logrus.SetLevel(level)
+
+	return &Stack{
+		config: config,
+		links:  links,
+		ip:     ip,
+		icmp:   icmpProtocol,
+		tcp:    tcpProtocol,
+		errors: make(chan error, 16),
+		done:   make(chan struct{}),
+	}, nil
+}
+
+// addRoutes adds static routes, the default route and routes of the kernel.
+func addRoutes(config Config, ip *ipv4.Ipv4, links []*link) error {
+	routes := config.Routes
+	if config.Gateway != "" {
+		routes = append(routes, "default via "+config.Gateway)
+	}
+	for _, s := range routes {
+		r, err := ipv4.ParseRoute(s)
+		if err != nil {
+			return err
+		}
+		if err := ip.Table.Add(r); err != nil {
+			return err
+		}
+	}
+	if !config.KernelRoutes {
+		return nil
+	}
+	file, err := os.Open(kernelRoutes)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	kernel, err := ipv4.ReadKernelRoutes(file)
+	if err != nil {
+		return err
+	}
+	for _, r := range kernel {
+		for _, l := range links {
+			// connected routes of the kernel are imported as well since the netmask of the stack may be unknown
+			if r.Dev == l.iface.Name() {
+				// the same route given statically is kept
+				ip.Table.Add(r)
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// addLink opens the additional interface and assigns addresses to it.
+func addLink(c InterfaceConfig, config Config, ip *ipv4.Ipv4) (*link, error) {
+	if len(c.Addresses) == 0 {
+		return nil, fmt.Errorf("address of the stack is required on %s", c.Name)
+	}
+	if c.MTU == 0 {
+		c.MTU = config.MTU
+	}
+	iface := c.Iface
+	if iface == nil {
+		if c.Type == "" {
+			c.Type = afpacket
+		}
+		var err error
+		if iface, err = openIface(c.Name, c.Type); err != nil {
+			return nil, err
+		}
+	}
+	l, err := newLink(c, config, iface, ip)
+	if err != nil && c.Iface == nil {
+		iface.Close()
+	}
+	return l, err
+}
+
+func newLink(c InterfaceConfig, config Config, iface interfaces.Iface, ip *ipv4.Ipv4) (*link, error) {
+	raw := interfaces.LinkType(iface) == pcap.LinkTypeRaw
+	arpProtocol := arp.New(arp.NewTable(), config.Debug)
+	if config.Clock != nil {
+		arpProtocol.SetClock(config.Clock)
+	}
+	e, err := newEthernet(c.Mac, iface, raw, arpProtocol)
+	if err != nil {
+		return nil, err
+	}
+	for n, a := range c.Addresses {
+		addr, mask, err := parseAddress(a)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			arpProtocol.SetAddress(addr, e.Address())
+		} else {
+			arpProtocol.AddAddress(addr)
+		}
+		ip.AddInterface(&ipv4.Interface{Eth: e, Address: addr, Netmask: mask, MTU: c.MTU})
+	}
+	return &link{iface: iface, eth: e, arp: arpProtocol, mtu: c.MTU}, nil
+}
+
+// parseAddress parses the address with the optional prefix length. The netmask is nil without the prefix length.
+func parseAddress(s string) (*ippacket.IPAddress, *ippacket.IPAddress, error) {
+	if !strings.Contains(s, "/") {
+		addr, err := ippacket.StringToIPAddress(s)
+		return addr, nil, err
+	}
+	ip, network, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, nil, err
+	}
+	addr, err := ippacket.Address(ip.To4())
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid ipv4 address: %s", s)
+	}
+	mask, err := ippacket.Address(network.Mask)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid ipv4 address: %s", s)
+	}
+	return addr, mask, nil
+}
+
+// newEthernet creates the link layer, datagrams are passed without ethernet headers on the tun device.
+func newEthernet(hwaddr string, iface interfaces.Iface, raw bool, arpProtocol *arp.Arp) (*ethernet.Ethernet, error) {
+	if raw {
+		return ethernet.NewRaw(iface, arpProtocol), nil
+	}
+	if hwaddr == "" {
+		return ethernet.New(iface, arpProtocol)
+	}
+	mac, err := net.ParseMAC(hwaddr)
+	if err != nil {
+		return nil, err
+	}
+	addr, err := etherframe.Address(mac)
+	if err != nil {
+		return nil, err
+	}
+	return ethernet.NewWithAddress(iface, addr, arpProtocol), nil
+}
+
+// attachFilter makes the interface receive only arp and ipv4 packets to the addresses and broadcasts
+// instead of all frames on the promiscuous socket.
+func attachFilter(iface interfaces.Iface, mac *etherframe.HardwareAddress, addrs []*ipv4.Interface) error {
+	hosts := []string{"ip broadcast"}
+	for _, addr := range addrs {
+		hosts = append(hosts, "dst host "+addr.Address.String())
+		if b := addr.Broadcast(); b != nil {
+			hosts = append(hosts, "dst host "+b.String())
+		}
+	}
+	f, err := filter.Compile(fmt.Sprintf("(ether dst %s or ether dst %s) and (arp or (ip and (%s)))", mac, etherframe.BroadcastAddress, strings.Join(hosts, " or ")))
+	if err != nil {
+		return err
+	}
+	prog, err := f.BPF()
+	if err != nil {
+		return err
+	}
+	if err := interfaces.AttachFilter(iface, prog); err != nil && err != interfaces.ErrFilterNotSupported {
+		return fmt.Errorf("failed to attach the filter to %s: %v", iface.Name(), err)
+	}
+	return nil
+}
+
+// Start runs the protocols until Close is called or ctx is done.
+func (s *Stack) Start(ctx context.Context) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.started {
+		return fmt.Errorf("stack is already started")
+	}
+	select {
+	case <-s.done:
+		return fmt.Errorf("stack is closed")
+	default:
+	}
+	s.started = true
+
+	s.run(s.icmp.Handle)
+	s.run(s.ip.TcpSend)
+	s.run(s.ip.ReassemblyTimer)
+
+	// frames of all links are dispatched in one goroutine
+	rcvQueue := make(chan *batch, s.config.RecvQueueSize)
+	for _, l := range s.links {
+		l := l
+		s.run(l.arp.Handle)
+		s.run(func() { s.receive(l, rcvQueue) })
+	}
+	s.run(func() { s.dispatch(rcvQueue) })
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.Close()
+		case <-s.done:
+		}
+	}()
+	return nil
+}
+
+func (s *Stack) run(f func()) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		f()
+	}()
+}
+
+// receive hands frames of the link to the dispatcher in batches, one hand-off for all frames received at once.
+// Interfaces not receiving in batches pass one frame in each batch.
+func (s *Stack) receive(l *link, rcvQueue chan<- *batch) {
+	frameSize := l.mtu + etherHeaderLength
+	size, batches := frameSize, s.config.RecvQueueSize
+	recv := func(b *batch) error {
+		n, err := l.iface.Recv(b.buf[:frameSize])
+		if err != nil {
+			return err
+		}
+		b.frames = append(b.frames, b.buf[:n:n])
+		return nil
+	}
+	if r, ok := l.iface.(interfaces.Batcher); ok {
+		size, batches = receiveBatchSize, receiveBatches
+		recv = func(b *batch) error {
+			return r.RecvBatch(b.add)
+		}
+	}
+	free := make(chan *batch, batches)
+	for i := 0; i < batches; i++ {
+		free <- &batch{link: l, buf: make([]byte, size), free: free}
+	}
+	for {
+		var b *batch
+		select {
+		case b = <-free:
+		case <-s.done:
+			return
+		}
+		err := recv(b)
+		select {
+		case <-s.done:
+			return
+		default:
+		}
+		if err == interfaces.ErrTimeout || err == nil && len(b.frames) == 0 {
+			b.release()
+			continue
+		}
+		if err != nil {
+			s.report(fmt.Errorf("failed to receive from %s: %v", l.iface.Name(), err))
+			return
+		}
+		select {
+		case rcvQueue <- b:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *Stack) dispatch(rcvQueue <-chan *batch) {
+	// Handlers of each protocol never block, so frames are dispatched in order as soon as received.
+	for {
+		var b *batch
+		select {
+		case b = <-rcvQueue:
+		case <-s.done:
+			return
+		}
+		for _, frame := range b.frames {
+			s.dispatchFrame(b.link, frame)
+		}
+		b.release()
+	}
+}
+
+func (s *Stack) dispatchFrame(l *link, buf []byte) {
+	if l.eth.Raw() {
+		s.dispatchRaw(buf)
+		return
+	}
+	frame, err := etherframe.New(buf)
+	if err != nil {
+		s.report(err)
+		return
+	}
+	// frames to other hosts are seen on the promiscuous interface without the filter
+	if dst := frame.Header.Dst; dst != *l.eth.Address() && dst != etherframe.BroadcastAddress {
+		return
+	}
+	switch frame.Type() {
+	case etherframe.ETHER_TYPE_IP:
+		s.ip.HandlePacket(frame.Payload())
+	case etherframe.ETHER_TYPE_ARP:
+		l.arp.Recv(frame.Payload())
+	case etherframe.ETHER_TYPE_IPV6:
+		logrus.WithFields(logrus.Fields{
+			"command": "stack",
+		}).Debug("ipv6 is not supported")
+	default:
+		logrus.WithFields(logrus.Fields{
+			"command": "stack",
+		}).Debug("unknown ethernet type.")
+	}
+}
+
+// dispatchRaw handles the packet without the ethernet header from the tun device.
+func (s *Stack) dispatchRaw(buf []byte) {
+	if len(buf) == 0 || buf[0]>>4 != 4 {
+		logrus.WithFields(logrus.Fields{
+			"command": "stack",
+		}).Debug("not ipv4 packet.")
+		return
+	}
+	s.ip.HandlePacket(buf)
+}
+
+// report sends the error without blocking, errors are dropped when nobody reads them.
+func (s *Stack) report(err error) {
+	select {
+	case s.errors <- err:
+	default:
+	}
+}
+
+// Errors returns the channel of errors occurred in the running stack.
+func (s *Stack) Errors() <-chan error {
+	return s.errors
+}
+
+// Close stops all goroutines of the stack and closes the interface.
+func (s *Stack) Close() error {
+	var err error
+	s.once.Do(func() {
+		close(s.done)
+		for _, l := range s.links {
+			l.arp.Stop()
+		}
+		s.icmp.Stop()
+		s.ip.Stop()
+		s.tcp.Stop()
+		s.wg.Wait()
+		for _, l := range s.links {
+			if cerr := l.eth.Close(); err == nil {
+				err = cerr
+			}
+		}
+		if s.capture != nil {
+			if cerr := s.capture.Close(); err == nil {
+				err = cerr
+			}
+		}
+	})
+	return err
+}
+
+// Done returns the channel closed when the stack is closed.
+func (s *Stack) Done() <-chan struct{} {
+	return s.done
+}
+
+// Iface returns the primary interface.
+func (s *Stack) Iface() interfaces.Iface {
+	return s.links[0].iface
+}
+
+// Ethernet returns the link layer of the primary interface.
+func (s *Stack) Ethernet() *ethernet.Ethernet {
+	return s.links[0].eth
+}
+
+// Arp returns the arp of the primary interface.
+func (s *Stack) Arp() *arp.Arp {
+	return s.links[0].arp
+}
+
+// Links returns the link layers of all interfaces, the first one is the primary interface.
+func (s *Stack) Links() []*ethernet.Ethernet {
+	links := make([]*ethernet.Ethernet, 0, len(s.links))
+	for _, l := range s.links {
+		links = append(links, l.eth)
+	}
+	return links
+}
+
+func (s *Stack) Ipv4() *ipv4.Ipv4 {
+	return s.ip
+}
+
+func (s *Stack) Icmp() *icmp.Icmp {
+	return s.icmp
+}
+
+func (s *Stack) Tcp() *tcp.Tcp {
+	return s.tcp
+}
@@ -0,0 +1,62 @@
+package gotcp
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/terassyi/gotcp/pkg/interfaces"
+)
+
+type fakeIface struct {
+	closed chan struct{}
+}
+
+func (f *fakeIface) Name() string { return "fake0" }
+func (f *fakeIface) Fd() int      { return -1 }
+func (f *fakeIface) Recv(buf []byte) (int, error) {
+	select {
+	case <-f.closed:
+		return 0, errors.New("closed")
+	case <-time.After(10 * time.Millisecond):
+		return 0, interfaces.ErrTimeout
+	}
+}
+func (f *fakeIface) Send(buf []byte) (int, error) { return len(buf), nil }
+func (f *fakeIface) Close() error {
+	close(f.closed)
+	return nil
+}
+func (f *fakeIface) Address() ([]byte, error) {
+	return []byte{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}, nil
+}
+
+func TestStackLifecycle(t *testing.T) {
+	iface := &fakeIface{closed: make(chan struct{})}
+	s, err := newStack(Config{Name: "fake0", Address: "10.0.0.1", Netmask: "255.255.255.0", MTU: 1500, RecvQueueSize: 1, SendQueueSize: 1}, iface)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Ipv4().Address.String() != "10.0.0.1" || s.Arp().IpAddress.String() != "10.0.0.1" {
+		t.Fatalf("actual address: %s", s.Ipv4().Address)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := s.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(ctx); err == nil {
+		t.Fatal("started twice")
+	}
+	cancel()
+	select {
+	case <-s.Done():
+	case <-time.After(time.Second):
+		t.Fatal("stack is not closed by the context")
+	}
+	// all goroutines have returned
+	s.wg.Wait()
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,72 @@
+package gotcp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/terassyi/gotcp/pkg/proto/tcp"
+)
+
+// TestTunTap connects the kernel tcp to the stack behind the tap and tun device.
+// It needs root and the ip command.
+func TestTunTap(t *testing.T) {
+	for n, typ := range []string{tap, tun} {
+		t.Run(typ, func(t *testing.T) {
+			name := fmt.Sprintf("gt%s%d", typ, os.Getpid()%10000)
+			kernel, addr := fmt.Sprintf("10.77.%d.1", n), fmt.Sprintf("10.77.%d.2", n)
+			s, err := New(Config{Name: name, Type: typ, Address: addr, Netmask: "255.255.255.0", LogLevel: "warn"})
+			if err != nil {
+				t.Skipf("failed to open %s: %v", typ, err)
+			}
+			t.Cleanup(func() { s.Close() })
+			if out, err := exec.Command("ip", "addr", "add", kernel+"/24", "dev", name).CombinedOutput(); err != nil {
+				t.Skipf("failed to set the address: %v: %s", err, out)
+			}
+			if typ == tap && *s.Ethernet().Address() == [6]byte{} {
+				t.Fatal("hardware address is not generated")
+			}
+			if err := s.Start(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			l, err := s.Tcp().Listen("0.0.0.0", 8080)
+			if err != nil {
+				t.Fatal(err)
+			}
+			accepted := make(chan *tcp.Conn, 1)
+			go func() {
+				conn, err := l.Accept()
+				if err != nil {
+					t.Error(err)
+				}
+				accepted <- conn
+			}()
+			conn, err := net.DialTimeout("tcp", addr+":8080", 5*time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			if _, err := conn.Write([]byte("hello")); err != nil {
+				t.Fatal(err)
+			}
+			var c *tcp.Conn
+			select {
+			case c = <-accepted:
+			case <-time.After(5 * time.Second):
+				t.Fatal("connection is not accepted")
+			}
+			buf := make([]byte, 16)
+			n, err := c.Read(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(buf[:n]) != "hello" {
+				t.Fatalf("want hello, got %q", buf[:n])
+			}
+		})
+	}
+}
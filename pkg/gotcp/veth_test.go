@@ -0,0 +1,107 @@
+package gotcp
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"testing"
+)
+
+const (
+	vethServer = "gotcpveth0"
+	vethClient = "gotcpveth1"
+)
+
+// setupVeth creates the veth pair, it requires root.
+func setupVeth(b *testing.B) {
+	b.Helper()
+	if os.Geteuid() != 0 {
+		b.Skip("veth requires root")
+	}
+	if _, err := exec.LookPath("ip"); err != nil {
+		b.Skip("ip command is not found")
+	}
+	cmds := [][]string{
+		{"link", "add", vethServer, "type", "veth", "peer", "name", vethClient},
+		{"link", "set", vethServer, "up"},
+		{"link", "set", vethClient, "up"},
+	}
+	for _, args := range cmds {
+		if out, err := exec.Command("ip", args...).CombinedOutput(); err != nil {
+			b.Skipf("failed to setup veth: %v %s", err, out)
+		}
+	}
+	b.Cleanup(func() {
+		exec.Command("ip", "link", "del", vethServer).Run()
+	})
+}
+
+func startVethStack(b *testing.B, name, addr string) *Stack {
+	b.Helper()
+	s, err := New(Config{Name: name, Address: addr, Netmask: "255.255.255.0"})
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := s.Start(context.Background()); err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { s.Close() })
+	return s
+}
+
+func BenchmarkVethThroughput(b *testing.B) {
+	setupVeth(b)
+	server := startVethStack(b, vethServer, "10.203.0.1")
+	client := startVethStack(b, vethClient, "10.203.0.2")
+	// the entries are configured not to measure arp resolution
+	if err := server.Arp().Table.Insert(client.Ethernet().Address(), client.Ipv4().Address); err != nil {
+		b.Fatal(err)
+	}
+	if err := client.Arp().Table.Insert(server.Ethernet().Address(), server.Ipv4().Address); err != nil {
+		b.Fatal(err)
+	}
+
+	l, err := server.Tcp().Listen("0.0.0.0", 8080)
+	if err != nil {
+		b.Fatal(err)
+	}
+	const chunk = 64 * 1024
+	total := chunk * b.N
+	received := make(chan int, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			received <- 0
+			return
+		}
+		buf := make([]byte, chunk)
+		n := 0
+		for n < total {
+			m, err := conn.Read(buf)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				break
+			}
+			n += m
+		}
+		received <- n
+	}()
+	conn, err := client.Tcp().Dial("10.203.0.1", 8080)
+	if err != nil {
+		b.Fatal(err)
+	}
+	data := make([]byte, chunk)
+	b.SetBytes(chunk)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := conn.Write(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if n := <-received; n != total {
+		b.Fatalf("received %d bytes, want %d", n, total)
+	}
+}
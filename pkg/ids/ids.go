@@ -1,14 +1,16 @@
 package ids
 
 import (
+	"github.com/terassyi/gotcp/pkg/filter"
 	"github.com/terassyi/gotcp/pkg/logger"
-	"github.com/terassyi/gotcp/pkg/packet/ethernet"
+	"github.com/terassyi/gotcp/pkg/packet/decode"
 	"github.com/terassyi/gotcp/pkg/packet/ipv4"
-	"github.com/terassyi/gotcp/pkg/packet/tcp"
 )
 
 type Ids struct {
 	logger *logger.Logger
+	Filter *filter.Filter // only matched packets are inspected, all packets when nil
+	Raw    bool           // packets are ipv4 without the link layer header such as read from the tun device
 }
 
 type idsData struct {
@@ -20,7 +22,7 @@ type idsData struct {
 }
 
 func (i *Ids) show(d *idsData) {
-	i.logger.Infof("%v\n", d.dstIp.String(), d.srcIp.String(), d.dstPort, d.srcPort, d.proto)
+	i.logger.Infof("%s %s %d %d %s\n", d.dstIp.String(), d.srcIp.String(), d.dstPort, d.srcPort, d.proto)
 }
 
 func New() *Ids {
@@ -29,41 +31,41 @@ func New() *Ids {
 
 func (i *Ids) Recv(d []byte) error {
 	//fmt.Println(hex.Dump(d))
-	frame, err := ethernet.New(d)
-	if err != nil {
-		return err
+	var p *decode.Packet
+	if i.Raw {
+		p = decode.DecodeRaw(d)
+	} else {
+		p = decode.Decode(d)
 	}
-
-	switch frame.Type() {
-	case ethernet.ETHER_TYPE_IP:
-		ipPacket, err := ipv4.New(frame.Payload())
-		if err != nil {
-			return err
-		}
-		data := &idsData{
-			dstIp:   &ipPacket.Header.Dst,
-			srcIp:   &ipPacket.Header.Src,
-			dstPort: 0,
-			srcPort: 0,
-		}
-		switch ipPacket.Header.Protocol {
-		case ipv4.IPICMPv4Protocol:
-			data.proto = "icmp"
-		case ipv4.IPTCPProtocol:
-			tcpSegment, err := tcp.New(ipPacket.Data)
-			if err != nil {
-				return err
-			}
-			data.dstPort = int(tcpSegment.Header.DestinationPort)
-			data.srcPort = int(tcpSegment.Header.SourcePort)
-			data.proto = "tcp"
-		case ipv4.IPUDPProtocol:
-			data.proto = "udp"
-		default:
-			data.proto = "unsupported"
-		}
-		i.show(data)
+	if !i.Filter.Match(p) {
+		return nil
+	}
+	if p.Err != nil {
+		return p.Err
+	}
+	if p.IPv4 == nil {
+		return nil
+	}
+	data := &idsData{
+		dstIp:   &p.IPv4.Header.Dst,
+		srcIp:   &p.IPv4.Header.Src,
+		dstPort: 0,
+		srcPort: 0,
+	}
+	switch {
+	case p.Icmp != nil:
+		data.proto = "icmp"
+	case p.Tcp != nil:
+		data.dstPort = int(p.Tcp.Header.DestinationPort)
+		data.srcPort = int(p.Tcp.Header.SourcePort)
+		data.proto = "tcp"
+	case p.Udp != nil:
+		data.dstPort = int(p.Udp.Header.DestinationPort)
+		data.srcPort = int(p.Udp.Header.SourcePort)
+		data.proto = "udp"
 	default:
+		data.proto = "unsupported"
 	}
+	i.show(data)
 	return nil
 }
@@ -4,12 +4,16 @@ import (
 	"encoding/binary"
 	"fmt"
 	"syscall"
+	"time"
 	"unsafe"
 )
 
+const recvTimeout = 100 * time.Millisecond
+
 type afPacket struct {
 	fd   int
 	name string
+	skip bool // skip frames sent from this host
 }
 
 func newAfPacket(name string) (*afPacket, error) {
@@ -31,8 +35,26 @@ func (af *afPacket) Fd() int {
 	return af.fd
 }
 
+// Recv returns ErrTimeout when no frame arrives in a while.
+// Frames sent from this host are also captured by the socket, they are skipped after SkipOutgoing.
 func (af *afPacket) Recv(buf []byte) (int, error) {
-	return syscall.Read(af.fd, buf)
+	for {
+		n, from, err := syscall.Recvfrom(af.fd, buf, 0)
+		if err == syscall.EAGAIN || err == syscall.EINTR {
+			return 0, ErrTimeout
+		}
+		if err != nil {
+			return n, err
+		}
+		if ll, ok := from.(*syscall.SockaddrLinklayer); ok && af.skip && ll.Pkttype == syscall.PACKET_OUTGOING {
+			continue
+		}
+		return n, nil
+	}
+}
+
+func (af *afPacket) skipOutgoing() {
+	af.skip = true
 }
 
 func (af *afPacket) Send(buf []byte) (int, error) {
@@ -76,6 +98,12 @@ func openPFPacket(name string) (int, error) {
 		syscall.Close(fd)
 		return -1, err
 	}
+	// wake up the reader periodically to allow shutting down
+	tv := syscall.NsecToTimeval(int64(recvTimeout))
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		syscall.Close(fd)
+		return -1, fmt.Errorf("setsockopt error: %v", err)
+	}
 	flags, err := siocgifflags(name)
 	if err != nil {
 		syscall.Close(fd)
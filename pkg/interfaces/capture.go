@@ -0,0 +1,35 @@
+package interfaces
+
+import (
+	"time"
+
+	"github.com/terassyi/gotcp/pkg/pcap"
+)
+
+// captured records frames sent and received through the interface like tcpdump.
+type captured struct {
+	Iface
+	w pcap.Writer
+}
+
+// NewCaptured wraps the interface to write every frame to w with the time and the direction.
+// Errors of writing are ignored not to affect the stack.
+func NewCaptured(iface Iface, w pcap.Writer) Iface {
+	return &captured{Iface: iface, w: w}
+}
+
+func (c *captured) Recv(buf []byte) (int, error) {
+	n, err := c.Iface.Recv(buf)
+	if err == nil && n > 0 {
+		c.w.WritePacket(time.Now(), pcap.Inbound, buf[:n])
+	}
+	return n, err
+}
+
+func (c *captured) Send(buf []byte) (int, error) {
+	n, err := c.Iface.Send(buf)
+	if err == nil {
+		c.w.WritePacket(time.Now(), pcap.Outbound, buf)
+	}
+	return n, err
+}
@@ -0,0 +1,57 @@
+package interfaces
+
+import (
+	"errors"
+	"syscall"
+
+	"github.com/terassyi/gotcp/pkg/bpf"
+)
+
+// ErrFilterNotSupported is returned by AttachFilter when the interface can not filter frames.
+var ErrFilterNotSupported = errors.New("filter is not supported")
+
+type filterable interface {
+	attachFilter(prog []bpf.Instruction) error
+}
+
+// AttachFilter attaches the classic BPF program to the interface so that Recv returns only accepted frames.
+// Frames are truncated to the length returned by the program.
+func AttachFilter(iface Iface, prog []bpf.Instruction) error {
+	if err := bpf.Validate(prog); err != nil {
+		return err
+	}
+	if i, ok := underlying(iface).(filterable); ok {
+		return i.attachFilter(prog)
+	}
+	return ErrFilterNotSupported
+}
+
+func (af *afPacket) attachFilter(prog []bpf.Instruction) error {
+	filter := make([]syscall.SockFilter, 0, len(prog))
+	for _, i := range prog {
+		filter = append(filter, syscall.SockFilter{Code: i.Op, Jt: i.Jt, Jf: i.Jf, K: i.K})
+	}
+	return syscall.AttachLsf(af.fd, filter)
+}
+
+func (p *pipe) attachFilter(prog []bpf.Instruction) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.filter = prog
+	return nil
+}
+
+// accept runs the filter on the received frame.
+func (p *pipe) accept(frame []byte) []byte {
+	p.mu.Lock()
+	prog := p.filter
+	p.mu.Unlock()
+	if prog == nil {
+		return frame
+	}
+	n := bpf.Run(prog, frame)
+	if int(n) < len(frame) {
+		return frame[:n]
+	}
+	return frame
+}
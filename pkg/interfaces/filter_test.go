@@ -0,0 +1,62 @@
+package interfaces
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/terassyi/gotcp/pkg/bpf"
+	"github.com/terassyi/gotcp/pkg/pcap"
+)
+
+func TestAttachFilter(t *testing.T) {
+	// accepts the first 20 bytes of arp
+	a := bpf.NewAssembler()
+	drop := a.NewLabel()
+	a.Emit(bpf.LD|bpf.H|bpf.ABS, 12)
+	a.Jump(bpf.JEQ|bpf.K, 0x0806, bpf.Next, drop)
+	a.Emit(bpf.RET|bpf.K, 20)
+	a.Label(drop)
+	a.Emit(bpf.RET|bpf.K, 0)
+	prog, err := a.Assemble()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i0, i1 := NewPipe([]byte{0x02, 0, 0, 0, 0, 1}, []byte{0x02, 0, 0, 0, 0, 2})
+	defer i0.Close()
+	defer i1.Close()
+	var buf bytes.Buffer
+	w, err := pcap.NewWriter(&buf, pcap.LinkTypeEthernet, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the filter is attached to the pipe under the capture
+	if err := AttachFilter(NewCaptured(i1, w), prog); err != nil {
+		t.Fatal(err)
+	}
+	ip := make([]byte, 60)
+	ip[12] = 0x08
+	arp := make([]byte, 60)
+	arp[12], arp[13] = 0x08, 0x06
+	arp[59] = 1
+	for _, frame := range [][]byte{ip, arp} {
+		if _, err := i0.Send(frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b := make([]byte, 1500)
+	n, err := i1.Recv(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b[:n], arp[:20]) {
+		t.Fatalf("want the truncated arp, got %x", b[:n])
+	}
+	if _, err := i1.Recv(b); err != ErrTimeout {
+		t.Fatalf("want timeout, got %v", err)
+	}
+
+	if err := AttachFilter(i1, nil); err == nil {
+		t.Fatal("empty program is attached")
+	}
+}
@@ -1,6 +1,14 @@
 package interfaces
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+
+	"github.com/terassyi/gotcp/pkg/pcap"
+)
+
+// ErrTimeout is returned by Recv when no frame is received before the timeout.
+var ErrTimeout = errors.New("receive timeout")
 
 type Iface interface {
 	Name() string
@@ -15,10 +23,54 @@ func New(name, typ string) (Iface, error) {
 	switch typ {
 	case "afpacket":
 		return newAfPacket(name)
+	case "afpacket-mmap":
+		return newAfPacketRing(name, RingConfig{})
 	case "tun":
-		return newTunDevice(name)
+		return newTunDevice(name, false)
+	case "tap":
+		return newTunDevice(name, true)
 	default:
 		return nil, fmt.Errorf("invalid type")
 
 	}
 }
+
+type outgoingSkipper interface {
+	skipOutgoing()
+}
+
+// SkipOutgoing makes Recv skip frames sent from this host, which the packet socket captures as well.
+// The stack does not receive its own frames with it, captures such as dump keep them.
+// It is called before receiving frames, interfaces not capturing their own frames are unchanged.
+func SkipOutgoing(iface Iface) {
+	if i, ok := underlying(iface).(outgoingSkipper); ok {
+		i.skipOutgoing()
+	}
+}
+
+type linkTyper interface {
+	linkType() pcap.LinkType
+}
+
+// LinkType returns the link layer of frames passed through the interface.
+// It is ethernet except the tun device passing ipv4 packets.
+func LinkType(iface Iface) pcap.LinkType {
+	if i, ok := underlying(iface).(linkTyper); ok {
+		return i.linkType()
+	}
+	return pcap.LinkTypeEthernet
+}
+
+// underlying returns the interface wrapped by the capture and the impairment.
+func underlying(iface Iface) Iface {
+	for {
+		switch i := iface.(type) {
+		case *captured:
+			iface = i.Iface
+		case *impaired:
+			iface = i.Iface
+		default:
+			return iface
+		}
+	}
+}
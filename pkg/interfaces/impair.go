@@ -0,0 +1,288 @@
+package interfaces
+
+import (
+	"container/heap"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Impairment is the netem like configuration of the link.
+// All probabilities are from 0 to 1.
+type Impairment struct {
+	Latency   time.Duration
+	Jitter    time.Duration // latency varies uniformly in Latency +/- Jitter
+	Loss      float64       // random loss
+	Burst     *GilbertElliott
+	Duplicate float64
+	Reorder   float64 // frames sent without the latency, others overtake them
+	Corrupt   float64 // one bit of the frame is flipped
+	Rate      int     // bytes per second, 0 is unlimited
+	Limit     int     // frames queued in the link, 0 is unlimited
+	Seed      int64
+}
+
+// GilbertElliott is the burst loss model of two states.
+type GilbertElliott struct {
+	P        float64 // transition from the good state to the bad state
+	R        float64 // transition from the bad state to the good state
+	LossGood float64 // loss in the good state
+	LossBad  float64 // loss in the bad state
+}
+
+// impaired applies the impairment to the frames sent through the interface.
+// Received frames are not affected, wrap the peer to impair both directions.
+type impaired struct {
+	Iface
+	config Impairment
+	rand   *rand.Rand
+	bad    bool      // state of the gilbert elliott model
+	free   time.Time // time when the link finishes the queued frames
+	queue  frameQueue
+	seq    uint64
+	wake   chan struct{}
+	closed chan struct{}
+	once   sync.Once
+	mutex  sync.Mutex
+}
+
+type delayedFrame struct {
+	at   time.Time
+	seq  uint64 // keeps the order of the frames with the same time
+	data []byte
+}
+
+type frameQueue []*delayedFrame
+
+func (q frameQueue) Len() int { return len(q) }
+func (q frameQueue) Less(i, j int) bool {
+	if q[i].at.Equal(q[j].at) {
+		return q[i].seq < q[j].seq
+	}
+	return q[i].at.Before(q[j].at)
+}
+func (q frameQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *frameQueue) Push(x interface{}) { *q = append(*q, x.(*delayedFrame)) }
+func (q *frameQueue) Pop() interface{} {
+	old := *q
+	f := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return f
+}
+
+// NewImpaired wraps the interface to emulate the impaired link.
+// The same seed makes the same decisions for the same frames.
+func NewImpaired(iface Iface, config Impairment) Iface {
+	i := &impaired{
+		Iface:  iface,
+		config: config,
+		rand:   rand.New(rand.NewSource(config.Seed)),
+		wake:   make(chan struct{}, 1),
+		closed: make(chan struct{}),
+	}
+	go i.schedule()
+	return i
+}
+
+func (i *impaired) Send(buf []byte) (int, error) {
+	select {
+	case <-i.closed:
+		return 0, fmt.Errorf("%s is closed", i.Name())
+	default:
+	}
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	if i.lost() {
+		return len(buf), nil
+	}
+	copies := 1
+	if i.chance(i.config.Duplicate) {
+		copies = 2
+	}
+	for c := 0; c < copies; c++ {
+		if i.config.Limit > 0 && len(i.queue) >= i.config.Limit {
+			// tail drop
+			break
+		}
+		frame := make([]byte, len(buf))
+		copy(frame, buf)
+		if len(frame) > 0 && i.chance(i.config.Corrupt) {
+			n := i.rand.Intn(len(frame) * 8)
+			frame[n/8] ^= 1 << (n % 8)
+		}
+		i.seq++
+		heap.Push(&i.queue, &delayedFrame{at: i.departure(len(frame)), seq: i.seq, data: frame})
+	}
+	select {
+	case i.wake <- struct{}{}:
+	default:
+	}
+	return len(buf), nil
+}
+
+// lost decides whether the frame is lost by the random loss and the burst loss.
+func (i *impaired) lost() bool {
+	if i.chance(i.config.Loss) {
+		return true
+	}
+	ge := i.config.Burst
+	if ge == nil {
+		return false
+	}
+	if i.bad {
+		if i.chance(ge.R) {
+			i.bad = false
+		}
+	} else if i.chance(ge.P) {
+		i.bad = true
+	}
+	if i.bad {
+		return i.chance(ge.LossBad)
+	}
+	return i.chance(ge.LossGood)
+}
+
+func (i *impaired) chance(p float64) bool {
+	return p > 0 && i.rand.Float64() < p
+}
+
+// departure returns the time when the frame leaves the link.
+func (i *impaired) departure(length int) time.Time {
+	now := time.Now()
+	start := now
+	if i.config.Rate > 0 {
+		if i.free.After(now) {
+			start = i.free
+		}
+		i.free = start.Add(time.Duration(length) * time.Second / time.Duration(i.config.Rate))
+		start = i.free
+	}
+	if i.chance(i.config.Reorder) {
+		return start
+	}
+	delay := i.config.Latency
+	if i.config.Jitter > 0 {
+		delay += time.Duration(i.rand.Int63n(int64(2*i.config.Jitter))) - i.config.Jitter
+	}
+	if delay < 0 {
+		delay = 0
+	}
+	return start.Add(delay)
+}
+
+func (i *impaired) schedule() {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		i.mutex.Lock()
+		var due [][]byte
+		now := time.Now()
+		for len(i.queue) > 0 && !i.queue[0].at.After(now) {
+			due = append(due, heap.Pop(&i.queue).(*delayedFrame).data)
+		}
+		next := time.Hour
+		if len(i.queue) > 0 {
+			next = i.queue[0].at.Sub(now)
+		}
+		i.mutex.Unlock()
+		for _, frame := range due {
+			// errors cannot be returned to the sender, the frame is lost
+			i.Iface.Send(frame)
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(next)
+		select {
+		case <-timer.C:
+		case <-i.wake:
+		case <-i.closed:
+			return
+		}
+	}
+}
+
+func (i *impaired) Close() error {
+	i.once.Do(func() {
+		close(i.closed)
+	})
+	return i.Iface.Close()
+}
+
+// ParseImpairment parses the comma separated list of the impairment such as
+// "latency=10ms,jitter=2ms,loss=0.01,burst=0.01:0.3:0:0.5,dup=0.01,reorder=0.1,corrupt=0.001,rate=1000000,limit=100,seed=1".
+// burst is p:r:loss in good state:loss in bad state of the gilbert elliott model.
+func ParseImpairment(s string) (Impairment, error) {
+	var config Impairment
+	if s == "" {
+		return config, nil
+	}
+	for _, item := range strings.Split(s, ",") {
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 {
+			return config, fmt.Errorf("invalid impairment: %s", item)
+		}
+		var err error
+		switch kv[0] {
+		case "latency":
+			config.Latency, err = time.ParseDuration(kv[1])
+		case "jitter":
+			config.Jitter, err = time.ParseDuration(kv[1])
+		case "loss":
+			config.Loss, err = parseProbability(kv[1])
+		case "burst":
+			config.Burst, err = parseGilbertElliott(kv[1])
+		case "dup":
+			config.Duplicate, err = parseProbability(kv[1])
+		case "reorder":
+			config.Reorder, err = parseProbability(kv[1])
+		case "corrupt":
+			config.Corrupt, err = parseProbability(kv[1])
+		case "rate":
+			config.Rate, err = strconv.Atoi(kv[1])
+		case "limit":
+			config.Limit, err = strconv.Atoi(kv[1])
+		case "seed":
+			config.Seed, err = strconv.ParseInt(kv[1], 10, 64)
+		default:
+			err = fmt.Errorf("unknown impairment: %s", kv[0])
+		}
+		if err != nil {
+			return config, err
+		}
+	}
+	return config, nil
+}
+
+func parseProbability(s string) (float64, error) {
+	p, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	if p < 0 || p > 1 {
+		return 0, fmt.Errorf("probability must be from 0 to 1: %s", s)
+	}
+	return p, nil
+}
+
+func parseGilbertElliott(s string) (*GilbertElliott, error) {
+	params := strings.Split(s, ":")
+	if len(params) != 4 {
+		return nil, fmt.Errorf("burst requires p:r:loss in good state:loss in bad state")
+	}
+	var values [4]float64
+	for n, param := range params {
+		p, err := parseProbability(param)
+		if err != nil {
+			return nil, err
+		}
+		values[n] = p
+	}
+	return &GilbertElliott{P: values[0], R: values[1], LossGood: values[2], LossBad: values[3]}, nil
+}
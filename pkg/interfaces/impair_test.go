@@ -0,0 +1,138 @@
+package interfaces
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// sendFrames sends the numbered frames through the impaired pipe and returns the received frames.
+func sendFrames(t *testing.T, config Impairment, count, size int) [][]byte {
+	t.Helper()
+	p0, p1 := NewPipe([]byte{0x02, 0, 0, 0, 0, 1}, []byte{0x02, 0, 0, 0, 0, 2})
+	iface := NewImpaired(p0, config)
+	defer iface.Close()
+	for n := 0; n < count; n++ {
+		frame := make([]byte, size)
+		binary.BigEndian.PutUint32(frame, uint32(n))
+		if _, err := iface.Send(frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var frames [][]byte
+	for {
+		buf := make([]byte, size)
+		n, err := p1.Recv(buf)
+		if err == ErrTimeout {
+			return frames
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, buf[:n])
+	}
+}
+
+func TestImpairedSeed(t *testing.T) {
+	config := Impairment{Loss: 0.1, Duplicate: 0.1, Corrupt: 0.1, Seed: 42}
+	a := sendFrames(t, config, 500, 64)
+	b := sendFrames(t, config, 500, 64)
+	if !reflect.DeepEqual(a, b) {
+		t.Fatal("same seed makes different results")
+	}
+	if len(a) < 400 || len(a) > 550 {
+		t.Fatalf("received %d frames", len(a))
+	}
+	config.Seed = 43
+	if c := sendFrames(t, config, 500, 64); reflect.DeepEqual(a, c) {
+		t.Fatal("different seed makes the same result")
+	}
+}
+
+func TestImpairedBurstLoss(t *testing.T) {
+	config := Impairment{Burst: &GilbertElliott{P: 0.05, R: 0.2, LossGood: 0, LossBad: 1}, Seed: 1}
+	frames := sendFrames(t, config, 1000, 16)
+	// stationary loss is p/(p+r) and the mean burst length is 1/r
+	lost, bursts := 0, 0
+	next := uint32(0)
+	for _, f := range frames {
+		n := binary.BigEndian.Uint32(f)
+		if n != next {
+			lost += int(n - next)
+			bursts++
+		}
+		next = n + 1
+	}
+	if lost < 100 || lost > 300 {
+		t.Fatalf("lost %d frames", lost)
+	}
+	if l := float64(lost) / float64(bursts); l < 2.5 {
+		t.Fatalf("mean burst length is %f", l)
+	}
+}
+
+func TestImpairedDelay(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		config Impairment
+		min    time.Duration
+	}{
+		{name: "latency", config: Impairment{Latency: 50 * time.Millisecond, Jitter: 10 * time.Millisecond}, min: 40 * time.Millisecond},
+		// 10 frames of 1000 bytes at 100KB/s
+		{name: "rate", config: Impairment{Rate: 100000}, min: 100 * time.Millisecond},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			p0, p1 := NewPipe([]byte{0x02, 0, 0, 0, 0, 1}, []byte{0x02, 0, 0, 0, 0, 2})
+			iface := NewImpaired(p0, tt.config)
+			defer iface.Close()
+			start := time.Now()
+			for n := 0; n < 10; n++ {
+				iface.Send(make([]byte, 1000))
+			}
+			buf := make([]byte, 1000)
+			for n := 0; n < 10; n++ {
+				if _, err := p1.Recv(buf); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if d := time.Since(start); d < tt.min {
+				t.Fatalf("frames arrive in %v", d)
+			}
+		})
+	}
+}
+
+func TestImpairedLimit(t *testing.T) {
+	frames := sendFrames(t, Impairment{Latency: 10 * time.Millisecond, Limit: 5}, 10, 16)
+	if len(frames) != 5 {
+		t.Fatalf("received %d frames", len(frames))
+	}
+}
+
+func TestParseImpairment(t *testing.T) {
+	config, err := ParseImpairment("latency=10ms,jitter=2ms,loss=0.01,burst=0.01:0.3:0:0.5,dup=0.02,reorder=0.1,corrupt=0.001,rate=1000000,limit=100,seed=7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Impairment{
+		Latency:   10 * time.Millisecond,
+		Jitter:    2 * time.Millisecond,
+		Loss:      0.01,
+		Burst:     &GilbertElliott{P: 0.01, R: 0.3, LossGood: 0, LossBad: 0.5},
+		Duplicate: 0.02,
+		Reorder:   0.1,
+		Corrupt:   0.001,
+		Rate:      1000000,
+		Limit:     100,
+		Seed:      7,
+	}
+	if !reflect.DeepEqual(config, want) {
+		t.Fatalf("actual %+v", config)
+	}
+	for _, s := range []string{"loss", "loss=2", "burst=0.1:0.2", "unknown=1"} {
+		if _, err := ParseImpairment(s); err == nil {
+			t.Fatalf("%s is accepted", s)
+		}
+	}
+}
@@ -91,7 +91,7 @@ func tunsetiff(fd uintptr, name string, flags uint16) (string, error) {
 		_pad  [22]byte
 	}{}
 	copy(ifreq.name[:syscall.IFNAMSIZ-1], []byte(name))
-	ifreq.flags = syscall.IFF_TAP | syscall.IFF_NO_PI
+	ifreq.flags = flags
 	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TUNSETIFF, uintptr(unsafe.Pointer(&ifreq))); errno != 0 {
 		return "", errno
 	}
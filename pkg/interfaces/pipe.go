@@ -0,0 +1,98 @@
+package interfaces
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/terassyi/gotcp/pkg/bpf"
+)
+
+const pipeQueueSize = 1024
+
+// pipe is the in-memory link connecting two stacks in a process.
+type pipe struct {
+	name   string
+	mac    []byte
+	rx     chan []byte
+	peer   *pipe
+	closed chan struct{}
+	once   sync.Once
+	mu     sync.Mutex
+	filter []bpf.Instruction
+}
+
+// NewPipe returns a pair of the interfaces connected each other with the hardware addresses.
+// Frames are dropped when the receiver does not keep up like a real link.
+func NewPipe(mac0, mac1 []byte) (Iface, Iface) {
+	p0 := newPipe("pipe0", mac0)
+	p1 := newPipe("pipe1", mac1)
+	p0.peer = p1
+	p1.peer = p0
+	return p0, p1
+}
+
+func newPipe(name string, mac []byte) *pipe {
+	return &pipe{
+		name:   name,
+		mac:    mac,
+		rx:     make(chan []byte, pipeQueueSize),
+		closed: make(chan struct{}),
+	}
+}
+
+func (p *pipe) Name() string {
+	return p.name
+}
+
+func (p *pipe) Fd() int {
+	return -1
+}
+
+// Recv returns ErrTimeout when no frame arrives in a while as afpacket does.
+// Frames rejected by the attached filter are skipped.
+func (p *pipe) Recv(buf []byte) (int, error) {
+	timer := time.NewTimer(recvTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case frame := <-p.rx:
+			if frame = p.accept(frame); len(frame) == 0 {
+				continue
+			}
+			return copy(buf, frame), nil
+		case <-p.closed:
+			return 0, io.EOF
+		case <-timer.C:
+			return 0, ErrTimeout
+		}
+	}
+}
+
+func (p *pipe) Send(buf []byte) (int, error) {
+	select {
+	case <-p.closed:
+		return 0, fmt.Errorf("%s is closed", p.name)
+	default:
+	}
+	frame := make([]byte, len(buf))
+	copy(frame, buf)
+	select {
+	case p.peer.rx <- frame:
+	default:
+		// drop
+	}
+	return len(buf), nil
+}
+
+func (p *pipe) Close() error {
+	p.once.Do(func() {
+		close(p.closed)
+	})
+	return nil
+}
+
+func (p *pipe) Address() ([]byte, error) {
+	return p.mac, nil
+}
@@ -0,0 +1,180 @@
+package interfaces
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/terassyi/gotcp/pkg/clock"
+	"github.com/terassyi/gotcp/pkg/pcap"
+)
+
+// ReplayConfig is the configuration to replay the capture file.
+type ReplayConfig struct {
+	Mac      []byte      // hardware address of the replayed host, frames sent from it are not replayed
+	Realtime bool        // frames are received at the original timing, otherwise as fast as possible
+	Record   bool        // frames sent by the stack are kept for Sent, otherwise they are dropped
+	Clock    clock.Clock // the clock of the original timing, the real clock is used when nil
+}
+
+// Replay is the interface receiving frames from the capture file.
+// Frames recorded as outbound, or sent from the hardware address when the direction is not recorded,
+// are the frames the replayed host sent and they are skipped.
+type Replay struct {
+	name   string
+	file   *pcap.ReadFile
+	config ReplayConfig
+	next   *pcap.Packet
+	first  time.Time // timestamp of the first frame
+	start  time.Time // time when the first frame is received
+	sent   [][]byte
+	done   chan struct{}
+	closed chan struct{}
+	once   sync.Once
+	eof    sync.Once
+	mutex  sync.Mutex
+}
+
+// NewReplay opens the capture file of ethernet frames in the classic pcap or the pcapng format.
+func NewReplay(path string, config ReplayConfig) (*Replay, error) {
+	if len(config.Mac) != 6 {
+		return nil, fmt.Errorf("hardware address of the replayed host is required")
+	}
+	if config.Clock == nil {
+		config.Clock = clock.Real
+	}
+	file, err := pcap.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if file.LinkType() != pcap.LinkTypeEthernet {
+		file.Close()
+		return nil, fmt.Errorf("unsupported link type: %d", file.LinkType())
+	}
+	return &Replay{
+		name:   path,
+		file:   file,
+		config: config,
+		done:   make(chan struct{}),
+		closed: make(chan struct{}),
+	}, nil
+}
+
+func (r *Replay) Name() string {
+	return r.name
+}
+
+func (r *Replay) Fd() int {
+	return -1
+}
+
+// Recv returns the next frame in the file. ErrTimeout is returned while waiting for the original timing
+// and after all frames are replayed.
+func (r *Replay) Recv(buf []byte) (int, error) {
+	select {
+	case <-r.closed:
+		return 0, io.EOF
+	default:
+	}
+	if r.next == nil {
+		packet, err := r.read()
+		if err == io.EOF {
+			r.eof.Do(func() { close(r.done) })
+			return 0, r.wait(recvTimeout)
+		}
+		if err != nil {
+			return 0, err
+		}
+		if r.start.IsZero() {
+			r.first = packet.Timestamp
+			r.start = r.config.Clock.Now()
+		}
+		r.next = packet
+	}
+	if r.config.Realtime {
+		due := r.start.Add(r.next.Timestamp.Sub(r.first))
+		if d := due.Sub(r.config.Clock.Now()); d > 0 {
+			if d > recvTimeout {
+				return 0, r.wait(recvTimeout)
+			}
+			if err := r.wait(d); err != ErrTimeout {
+				return 0, err
+			}
+		}
+	}
+	n := copy(buf, r.next.Data)
+	r.next = nil
+	return n, nil
+}
+
+// read returns the next frame to replay.
+func (r *Replay) read() (*pcap.Packet, error) {
+	for {
+		packet, err := r.file.ReadPacket()
+		if err != nil {
+			return nil, err
+		}
+		if packet.Direction == pcap.Outbound {
+			continue
+		}
+		if packet.Direction == pcap.Unknown && len(packet.Data) >= 12 && bytes.Equal(packet.Data[6:12], r.config.Mac) {
+			continue
+		}
+		return packet, nil
+	}
+}
+
+// wait returns ErrTimeout after d, or io.EOF when closed.
+func (r *Replay) wait(d time.Duration) error {
+	select {
+	case <-r.config.Clock.After(d):
+		return ErrTimeout
+	case <-r.closed:
+		return io.EOF
+	}
+}
+
+func (r *Replay) Send(buf []byte) (int, error) {
+	select {
+	case <-r.closed:
+		return 0, fmt.Errorf("%s is closed", r.name)
+	default:
+	}
+	if r.config.Record {
+		frame := make([]byte, len(buf))
+		copy(frame, buf)
+		r.mutex.Lock()
+		r.sent = append(r.sent, frame)
+		r.mutex.Unlock()
+	}
+	return len(buf), nil
+}
+
+// Sent returns frames sent by the stack when Record is set.
+func (r *Replay) Sent() [][]byte {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	sent := make([][]byte, len(r.sent))
+	copy(sent, r.sent)
+	return sent
+}
+
+// Done returns the channel closed when all frames in the file are received.
+func (r *Replay) Done() <-chan struct{} {
+	return r.done
+}
+
+func (r *Replay) Close() error {
+	var err error
+	r.once.Do(func() {
+		close(r.closed)
+		err = r.file.Close()
+	})
+	return err
+}
+
+func (r *Replay) Address() ([]byte, error) {
+	return r.config.Mac, nil
+}
@@ -0,0 +1,109 @@
+package interfaces
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/terassyi/gotcp/pkg/clock"
+	"github.com/terassyi/gotcp/pkg/pcap"
+)
+
+var (
+	replayHost = []byte{0x02, 0, 0, 0, 0, 1}
+	replayPeer = []byte{0x02, 0, 0, 0, 0, 2}
+)
+
+// writeReplayFile writes frames from the peer at every second and a frame from the host between them.
+func writeReplayFile(t *testing.T, name string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	f, err := pcap.Create(path, pcap.LinkTypeEthernet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	start := time.Unix(1600000000, 0)
+	for n := 0; n < 3; n++ {
+		in := append(append(append([]byte{}, replayHost...), replayPeer...), 0x08, 0x00, byte(n))
+		if err := f.WritePacket(start.Add(time.Duration(n)*time.Second), pcap.Inbound, in); err != nil {
+			t.Fatal(err)
+		}
+		out := append(append(append([]byte{}, replayPeer...), replayHost...), 0x08, 0x00, byte(n))
+		if err := f.WritePacket(start.Add(time.Duration(n)*time.Second+time.Millisecond), pcap.Outbound, out); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path
+}
+
+func TestReplay(t *testing.T) {
+	// the direction is recorded in pcapng, the hardware address is used for pcap
+	for _, name := range []string{"replay.pcap", "replay.pcapng"} {
+		t.Run(name, func(t *testing.T) {
+			r, err := NewReplay(writeReplayFile(t, name), ReplayConfig{Mac: replayHost, Record: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			for n := 0; n < 3; n++ {
+				buf := make([]byte, 64)
+				l, err := r.Recv(buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(buf[6:12], replayPeer) || buf[l-1] != byte(n) {
+					t.Fatalf("actual: %x", buf[:l])
+				}
+			}
+			if _, err := r.Recv(make([]byte, 64)); err != ErrTimeout {
+				t.Fatalf("actual: %v", err)
+			}
+			select {
+			case <-r.Done():
+			default:
+				t.Fatal("replay is not done")
+			}
+			if _, err := r.Send([]byte{1, 2, 3}); err != nil {
+				t.Fatal(err)
+			}
+			if sent := r.Sent(); len(sent) != 1 || !bytes.Equal(sent[0], []byte{1, 2, 3}) {
+				t.Fatalf("actual: %v", sent)
+			}
+			r.Close()
+			if _, err := r.Recv(make([]byte, 64)); err != io.EOF {
+				t.Fatalf("actual: %v", err)
+			}
+		})
+	}
+}
+
+func TestReplayRealtime(t *testing.T) {
+	c := clock.NewVirtual(time.Unix(0, 0))
+	r, err := NewReplay(writeReplayFile(t, "replay.pcapng"), ReplayConfig{Mac: replayHost, Realtime: true, Clock: c})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	buf := make([]byte, 64)
+	if _, err := r.Recv(buf); err != nil {
+		t.Fatal(err)
+	}
+	// the next frame is a second later
+	received := make(chan error, 1)
+	go func() {
+		_, err := r.Recv(buf)
+		received <- err
+	}()
+	c.BlockUntil(1)
+	c.Advance(recvTimeout)
+	if err := <-received; err != ErrTimeout {
+		t.Fatalf("actual: %v", err)
+	}
+	c.Advance(time.Second - recvTimeout)
+	if _, err := r.Recv(buf); err != nil || buf[14] != 1 {
+		t.Fatalf("actual: %v %x", err, buf)
+	}
+}
@@ -0,0 +1,473 @@
+package interfaces
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/terassyi/gotcp/pkg/bpf"
+)
+
+// packet socket options not defined in syscall
+const (
+	packetTxRing  = 13
+	packetVersion = 10
+
+	tpacketV2 = 1
+	tpacketV3 = 2
+
+	tpStatusKernel       = 0
+	tpStatusUser         = 1
+	tpStatusAvailable    = 0
+	tpStatusSendRequest  = 1
+	tpStatusWrongFormat  = 4
+	tpacket2HeaderLength = 32 // TPACKET_ALIGN(sizeof(struct tpacket2_hdr))
+	tpacket3HeaderLength = 48 // TPACKET_ALIGN(sizeof(struct tpacket3_hdr))
+
+	pollIn  = 0x1
+	pollOut = 0x4
+)
+
+// offsets in struct tpacket_block_desc
+const (
+	blockStatus     = 8
+	blockNumPackets = 12
+	blockFirst      = 16
+)
+
+// offsets in struct tpacket3_hdr followed by struct sockaddr_ll
+const (
+	rxNextOffset = 0
+	rxSnapLen    = 12
+	rxMac        = 24
+	rxPktType    = tpacket3HeaderLength + 10
+)
+
+// offsets in struct tpacket2_hdr
+const (
+	txStatus = 0
+	txLength = 4
+	txData   = tpacket2HeaderLength // the frame follows the header when PACKET_TX_HAS_OFF is not set
+)
+
+// RingConfig is the size of the rings. Zero values are replaced with the defaults.
+type RingConfig struct {
+	BlockSize int           // rx block size, multiple of the page size
+	BlockNum  int           // number of rx blocks
+	Timeout   time.Duration // rx block is passed to the user after the timeout even if not filled, it bounds the latency of sparse traffic
+	FrameSize int           // tx frame size including the header
+	FrameNum  int           // number of tx frames
+}
+
+var defaultRingConfig = RingConfig{
+	BlockSize: 1 << 17,
+	BlockNum:  32,
+	Timeout:   time.Millisecond,
+	FrameSize: 2048,
+	FrameNum:  512,
+}
+
+// Batcher is implemented by interfaces handling frames in batches.
+type Batcher interface {
+	// RecvBatch calls f for each frame received at once. The frame is valid only while f runs.
+	// It returns ErrTimeout when no frame arrives in a while.
+	RecvBatch(f func(frame []byte)) error
+	// SendBatch sends the frames with a syscall and returns the number of frames sent.
+	SendBatch(frames [][]byte) (int, error)
+}
+
+// afPacketRing receives frames from the TPACKET_V3 rx ring and sends them through the TPACKET_V2 tx ring.
+// A packet socket has one version, so the rings are mapped on two sockets.
+type afPacketRing struct {
+	name   string
+	config RingConfig
+
+	rxFd    int
+	rx      []byte
+	rxMutex sync.Mutex
+	block   int // current block
+	offset  int // offset of the next frame in the block
+	remain  int // frames left in the block
+
+	txFd    int
+	tx      []byte
+	txMutex sync.Mutex
+	frame   int // next tx frame
+
+	skip bool // skip frames sent from this host
+}
+
+// NewAfPacketRing opens the interface with the PACKET_MMAP rings.
+// Frames are copied between the rings and the user without a syscall per frame.
+func NewAfPacketRing(name string, config RingConfig) (Iface, error) {
+	return newAfPacketRing(name, config)
+}
+
+func newAfPacketRing(name string, config RingConfig) (*afPacketRing, error) {
+	if config.BlockSize == 0 {
+		config.BlockSize = defaultRingConfig.BlockSize
+	}
+	if config.BlockNum == 0 {
+		config.BlockNum = defaultRingConfig.BlockNum
+	}
+	if config.Timeout == 0 {
+		config.Timeout = defaultRingConfig.Timeout
+	}
+	if config.FrameSize == 0 {
+		config.FrameSize = defaultRingConfig.FrameSize
+	}
+	if config.FrameNum == 0 {
+		config.FrameNum = defaultRingConfig.FrameNum
+	}
+	r := &afPacketRing{name: name, config: config, rxFd: -1, txFd: -1}
+	if err := r.openRx(); err != nil {
+		r.Close()
+		return nil, err
+	}
+	if err := r.openTx(); err != nil {
+		r.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *afPacketRing) openRx() error {
+	fd, err := openPFPacket(r.name)
+	if err != nil {
+		return err
+	}
+	r.rxFd = fd
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_PACKET, packetVersion, tpacketV3); err != nil {
+		return fmt.Errorf("failed to set tpacket v3: %v", err)
+	}
+	// struct tpacket_req3
+	req := struct {
+		blockSize, blockNum, frameSize, frameNum uint32
+		retireBlockTimeout, sizeofPriv, feature  uint32
+	}{
+		blockSize:          uint32(r.config.BlockSize),
+		blockNum:           uint32(r.config.BlockNum),
+		frameSize:          uint32(r.config.BlockSize), // frames are variable length in v3
+		frameNum:           uint32(r.config.BlockNum),
+		retireBlockTimeout: uint32((r.config.Timeout + time.Millisecond - 1) / time.Millisecond),
+	}
+	if err := setsockopt(fd, syscall.SOL_PACKET, syscall.PACKET_RX_RING, unsafe.Pointer(&req), unsafe.Sizeof(req)); err != nil {
+		return fmt.Errorf("failed to set rx ring: %v", err)
+	}
+	if r.rx, err = syscall.Mmap(fd, 0, r.config.BlockSize*r.config.BlockNum, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED); err != nil {
+		return fmt.Errorf("failed to map rx ring: %v", err)
+	}
+	return nil
+}
+
+func (r *afPacketRing) openTx() error {
+	// the protocol 0 socket receives nothing
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, 0)
+	if err != nil {
+		return fmt.Errorf("socket open error %v", err)
+	}
+	r.txFd = fd
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_PACKET, packetVersion, tpacketV2); err != nil {
+		return fmt.Errorf("failed to set tpacket v2: %v", err)
+	}
+	pageSize := syscall.Getpagesize()
+	// struct tpacket_req, frames must not cross blocks
+	framesPerBlock := pageSize / r.config.FrameSize
+	if framesPerBlock == 0 {
+		framesPerBlock = 1
+	}
+	blockSize := framesPerBlock * r.config.FrameSize
+	if blockSize%pageSize != 0 {
+		blockSize = (blockSize/pageSize + 1) * pageSize
+		framesPerBlock = blockSize / r.config.FrameSize
+	}
+	blockNum := (r.config.FrameNum + framesPerBlock - 1) / framesPerBlock
+	r.config.FrameNum = blockNum * framesPerBlock
+	req := struct {
+		blockSize, blockNum, frameSize, frameNum uint32
+	}{
+		blockSize: uint32(blockSize),
+		blockNum:  uint32(blockNum),
+		frameSize: uint32(r.config.FrameSize),
+		frameNum:  uint32(r.config.FrameNum),
+	}
+	if err := setsockopt(fd, syscall.SOL_PACKET, packetTxRing, unsafe.Pointer(&req), unsafe.Sizeof(req)); err != nil {
+		return fmt.Errorf("failed to set tx ring: %v", err)
+	}
+	if r.tx, err = syscall.Mmap(fd, 0, blockSize*blockNum, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED); err != nil {
+		return fmt.Errorf("failed to map tx ring: %v", err)
+	}
+	index, err := siocgifindex(r.name)
+	if err != nil {
+		return fmt.Errorf("siogifindex error: %v", err)
+	}
+	if err := syscall.Bind(fd, &syscall.SockaddrLinklayer{Ifindex: int(index)}); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *afPacketRing) Name() string {
+	return r.name
+}
+
+// Fd returns the socket of the rx ring.
+func (r *afPacketRing) Fd() int {
+	return r.rxFd
+}
+
+// Recv copies the next frame in the rx ring. A syscall is called only when the ring is empty.
+func (r *afPacketRing) Recv(buf []byte) (int, error) {
+	r.rxMutex.Lock()
+	defer r.rxMutex.Unlock()
+	if r.rx == nil {
+		return 0, syscall.EBADF
+	}
+	for {
+		if err := r.wait(); err != nil {
+			return 0, err
+		}
+		frame, ok := r.next()
+		n := 0
+		if ok {
+			n = copy(buf, frame)
+		}
+		if r.remain == 0 {
+			r.release()
+		}
+		if ok {
+			return n, nil
+		}
+	}
+}
+
+// RecvBatch calls f for all frames in the next block of the rx ring.
+func (r *afPacketRing) RecvBatch(f func(frame []byte)) error {
+	r.rxMutex.Lock()
+	defer r.rxMutex.Unlock()
+	if r.rx == nil {
+		return syscall.EBADF
+	}
+	if err := r.wait(); err != nil {
+		return err
+	}
+	for r.remain > 0 {
+		if frame, ok := r.next(); ok {
+			f(frame)
+		}
+	}
+	r.release()
+	return nil
+}
+
+func (r *afPacketRing) blockStatus() *uint32 {
+	return (*uint32)(unsafe.Pointer(&r.rx[r.block*r.config.BlockSize+blockStatus]))
+}
+
+// wait waits for the current block passed to the user.
+func (r *afPacketRing) wait() error {
+	if r.remain > 0 {
+		return nil
+	}
+	for {
+		if atomic.LoadUint32(r.blockStatus())&tpStatusUser != 0 {
+			break
+		}
+		ready, err := poll(r.rxFd, pollIn, recvTimeout)
+		if err != nil {
+			return err
+		}
+		if !ready {
+			return ErrTimeout
+		}
+	}
+	base := r.block * r.config.BlockSize
+	r.remain = int(hostEndian.Uint32(r.rx[base+blockNumPackets:]))
+	r.offset = base + int(hostEndian.Uint32(r.rx[base+blockFirst:]))
+	if r.remain == 0 {
+		r.release()
+		return r.wait()
+	}
+	return nil
+}
+
+// next returns the next frame in the current block. Frames sent from this host are skipped after SkipOutgoing.
+func (r *afPacketRing) next() ([]byte, bool) {
+	hdr := r.rx[r.offset:]
+	snapLen := int(hostEndian.Uint32(hdr[rxSnapLen:]))
+	mac := int(hostEndian.Uint16(hdr[rxMac:]))
+	frame := hdr[mac : mac+snapLen]
+	outgoing := hdr[rxPktType] == syscall.PACKET_OUTGOING
+	r.offset += int(hostEndian.Uint32(hdr[rxNextOffset:]))
+	r.remain--
+	return frame, !(r.skip && outgoing)
+}
+
+func (r *afPacketRing) skipOutgoing() {
+	r.skip = true
+}
+
+// release returns the current block to the kernel.
+func (r *afPacketRing) release() {
+	atomic.StoreUint32(r.blockStatus(), tpStatusKernel)
+	r.block = (r.block + 1) % r.config.BlockNum
+	r.remain = 0
+}
+
+// Send copies the frame to the tx ring and kicks the kernel.
+func (r *afPacketRing) Send(buf []byte) (int, error) {
+	n, err := r.SendBatch([][]byte{buf})
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, fmt.Errorf("tx ring of %s is full", r.name)
+	}
+	return len(buf), nil
+}
+
+// SendBatch copies the frames to the tx ring and sends them with a syscall.
+// It returns the number of frames queued before the ring becomes full.
+func (r *afPacketRing) SendBatch(frames [][]byte) (int, error) {
+	r.txMutex.Lock()
+	defer r.txMutex.Unlock()
+	if r.tx == nil {
+		return 0, syscall.EBADF
+	}
+	sent := 0
+	for _, frame := range frames {
+		if len(frame) > r.config.FrameSize-txData {
+			return sent, fmt.Errorf("frame is too large for the tx ring: %d", len(frame))
+		}
+		slot, err := r.slot()
+		if err != nil {
+			return sent, err
+		}
+		if slot == nil {
+			break
+		}
+		copy(slot[txData:], frame)
+		hostEndian.PutUint32(slot[txLength:], uint32(len(frame)))
+		atomic.StoreUint32((*uint32)(unsafe.Pointer(&slot[txStatus])), tpStatusSendRequest)
+		r.frame = (r.frame + 1) % r.config.FrameNum
+		sent++
+	}
+	if sent == 0 {
+		return 0, nil
+	}
+	if err := r.flush(); err != nil {
+		return sent, err
+	}
+	return sent, nil
+}
+
+// slot returns the next available tx frame, or nil when the ring is full.
+func (r *afPacketRing) slot() ([]byte, error) {
+	slot := r.tx[r.frame*r.config.FrameSize : (r.frame+1)*r.config.FrameSize]
+	status := (*uint32)(unsafe.Pointer(&slot[txStatus]))
+	for i := 0; ; i++ {
+		switch s := atomic.LoadUint32(status); {
+		case s == tpStatusAvailable:
+			return slot, nil
+		case s&tpStatusWrongFormat != 0:
+			atomic.StoreUint32(status, tpStatusAvailable)
+			return nil, fmt.Errorf("frame is rejected by %s", r.name)
+		}
+		if i > 0 {
+			return nil, nil
+		}
+		// the frame queued before is still sending
+		if _, err := poll(r.txFd, pollOut, recvTimeout); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (r *afPacketRing) flush() error {
+	for {
+		_, _, errno := syscall.Syscall6(syscall.SYS_SENDTO, uintptr(r.txFd), 0, 0, syscall.MSG_DONTWAIT, 0, 0)
+		if errno == syscall.EINTR {
+			continue
+		}
+		if errno != 0 && errno != syscall.EAGAIN && errno != syscall.ENOBUFS {
+			return errno
+		}
+		return nil
+	}
+}
+
+// Close waits for Recv and Send running because the rings are unmapped.
+func (r *afPacketRing) Close() error {
+	r.rxMutex.Lock()
+	defer r.rxMutex.Unlock()
+	r.txMutex.Lock()
+	defer r.txMutex.Unlock()
+	var err error
+	if r.rx != nil {
+		err = syscall.Munmap(r.rx)
+		r.rx = nil
+	}
+	if r.tx != nil {
+		if merr := syscall.Munmap(r.tx); err == nil {
+			err = merr
+		}
+		r.tx = nil
+	}
+	for _, fd := range []int{r.rxFd, r.txFd} {
+		if fd < 0 {
+			continue
+		}
+		if cerr := syscall.Close(fd); err == nil {
+			err = cerr
+		}
+	}
+	r.rxFd, r.txFd = -1, -1
+	return err
+}
+
+func (r *afPacketRing) Address() ([]byte, error) {
+	return siocgifhwaddr(r.name)
+}
+
+func (r *afPacketRing) attachFilter(prog []bpf.Instruction) error {
+	return (&afPacket{fd: r.rxFd, name: r.name}).attachFilter(prog)
+}
+
+func setsockopt(fd, level, name int, value unsafe.Pointer, length uintptr) error {
+	_, _, errno := syscall.Syscall6(syscall.SYS_SETSOCKOPT, uintptr(fd), uintptr(level), uintptr(name), uintptr(value), length, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// poll waits for the events on the socket and returns false on the timeout.
+func poll(fd int, events int16, timeout time.Duration) (bool, error) {
+	pfd := struct {
+		fd      int32
+		events  int16
+		revents int16
+	}{fd: int32(fd), events: events}
+	ts := syscall.NsecToTimespec(int64(timeout))
+	n, _, errno := syscall.Syscall6(syscall.SYS_PPOLL, uintptr(unsafe.Pointer(&pfd)), 1, uintptr(unsafe.Pointer(&ts)), 0, 0, 0)
+	if errno == syscall.EINTR {
+		return false, nil
+	}
+	if errno != 0 {
+		return false, errno
+	}
+	return n > 0, nil
+}
+
+// hostEndian is the byte order of the headers in the rings.
+var hostEndian binary.ByteOrder = func() binary.ByteOrder {
+	i := uint16(1)
+	if *(*byte)(unsafe.Pointer(&i)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}()
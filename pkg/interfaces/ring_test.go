@@ -0,0 +1,253 @@
+package interfaces
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+// testEtherType is the local experimental ethertype to tell test frames from others on the link.
+const testEtherType = 0x88b5
+
+// setupVeth creates the veth pair. It needs root and the ip command.
+func setupVeth(tb testing.TB) (string, string) {
+	tb.Helper()
+	a, b := fmt.Sprintf("gtr%da", os.Getpid()), fmt.Sprintf("gtr%db", os.Getpid())
+	for _, args := range [][]string{
+		{"link", "add", a, "type", "veth", "peer", "name", b},
+		{"link", "set", a, "up"},
+		{"link", "set", b, "up"},
+	} {
+		if out, err := exec.Command("ip", args...).CombinedOutput(); err != nil {
+			exec.Command("ip", "link", "del", a).Run()
+			tb.Skipf("failed to set up veth: %v: %s", err, out)
+		}
+	}
+	tb.Cleanup(func() { exec.Command("ip", "link", "del", a).Run() })
+	return a, b
+}
+
+func testFrame(seq int) []byte {
+	frame := make([]byte, 64)
+	copy(frame, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x02, 0, 0, 0, 0, 1})
+	frame[12], frame[13] = testEtherType>>8, testEtherType&0xff
+	frame[14], frame[15] = byte(seq>>8), byte(seq)
+	return frame
+}
+
+// recvTestFrame returns the next test frame skipping others such as ipv6 neighbor discovery.
+func recvTestFrame(tb testing.TB, iface Iface, buf []byte) []byte {
+	tb.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		n, err := iface.Recv(buf)
+		if err == ErrTimeout {
+			continue
+		}
+		if err != nil {
+			tb.Fatal(err)
+		}
+		if n >= 16 && buf[12] == testEtherType>>8 && buf[13] == testEtherType&0xff {
+			return buf[:n]
+		}
+	}
+	tb.Fatal("no frame is received")
+	return nil
+}
+
+func TestAfPacketRing(t *testing.T) {
+	a, b := setupVeth(t)
+	ring, err := NewAfPacketRing(a, RingConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ring.Close()
+	SkipOutgoing(ring)
+	peer, err := newAfPacket(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+
+	buf := make([]byte, 1514)
+	for i := 0; i < 3; i++ {
+		if _, err := peer.Send(testFrame(i)); err != nil {
+			t.Fatal(err)
+		}
+		if frame := recvTestFrame(t, ring, buf); !bytes.Equal(frame, testFrame(i)) {
+			t.Fatalf("rx ring: want %x, got %x", testFrame(i), frame)
+		}
+		if _, err := ring.Send(testFrame(i)); err != nil {
+			t.Fatal(err)
+		}
+		if frame := recvTestFrame(t, peer, buf); !bytes.Equal(frame, testFrame(i)) {
+			t.Fatalf("tx ring: want %x, got %x", testFrame(i), frame)
+		}
+	}
+
+	// frames sent by the ring itself are not received
+	if _, err := ring.(Batcher).SendBatch([][]byte{testFrame(10), testFrame(11)}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 10; i < 12; i++ {
+		if frame := recvTestFrame(t, peer, buf); !bytes.Equal(frame, testFrame(i)) {
+			t.Fatalf("tx ring batch: want %x, got %x", testFrame(i), frame)
+		}
+	}
+	if _, err := peer.Send(testFrame(20)); err != nil {
+		t.Fatal(err)
+	}
+	if frame := recvTestFrame(t, ring, buf); !bytes.Equal(frame, testFrame(20)) {
+		t.Fatalf("rx ring: want %x, got %x", testFrame(20), frame)
+	}
+}
+
+func TestSkipOutgoing(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		open func(name string) (Iface, error)
+	}{
+		{"afpacket", func(name string) (Iface, error) { return newAfPacket(name) }},
+		{"afpacket-mmap", func(name string) (Iface, error) { return newAfPacketRing(name, RingConfig{}) }},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := setupVeth(t)
+			iface, err := tt.open(a)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer iface.Close()
+			peer, err := newAfPacket(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer peer.Close()
+			// frames sent by another socket of this host
+			host, err := newAfPacket(a)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer host.Close()
+			buf := make([]byte, 1514)
+			// captured as the default
+			if _, err := host.Send(testFrame(1)); err != nil {
+				t.Fatal(err)
+			}
+			if frame := recvTestFrame(t, iface, buf); !bytes.Equal(frame, testFrame(1)) {
+				t.Fatalf("want %x, got %x", testFrame(1), frame)
+			}
+			SkipOutgoing(iface)
+			if _, err := host.Send(testFrame(2)); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := peer.Send(testFrame(3)); err != nil {
+				t.Fatal(err)
+			}
+			if frame := recvTestFrame(t, iface, buf); !bytes.Equal(frame, testFrame(3)) {
+				t.Fatalf("want %x, got %x", testFrame(3), frame)
+			}
+		})
+	}
+}
+
+// benchmarkRecv measures packets per second received while the peer keeps sending through the tx ring.
+func benchmarkRecv(b *testing.B, open func(name string) (Iface, error)) {
+	x, y := setupVeth(b)
+	iface, err := open(x)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer iface.Close()
+	peer, err := newAfPacketRing(y, RingConfig{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer peer.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		frames := make([][]byte, 64)
+		for i := range frames {
+			frames[i] = testFrame(i)
+		}
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			peer.SendBatch(frames)
+		}
+	}()
+	buf := make([]byte, 1514)
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		recvTestFrame(b, iface, buf)
+	}
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "pps")
+}
+
+func BenchmarkAfPacketRecv(b *testing.B) {
+	benchmarkRecv(b, func(name string) (Iface, error) { return newAfPacket(name) })
+}
+
+func BenchmarkAfPacketRingRecv(b *testing.B) {
+	benchmarkRecv(b, func(name string) (Iface, error) { return newAfPacketRing(name, RingConfig{}) })
+}
+
+func benchmarkSend(b *testing.B, open func(name string) (Iface, error)) {
+	x, _ := setupVeth(b)
+	iface, err := open(x)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer iface.Close()
+	frame := testFrame(0)
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		if _, err := iface.Send(frame); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "pps")
+}
+
+func BenchmarkAfPacketSend(b *testing.B) {
+	benchmarkSend(b, func(name string) (Iface, error) { return newAfPacket(name) })
+}
+
+func BenchmarkAfPacketRingSend(b *testing.B) {
+	benchmarkSend(b, func(name string) (Iface, error) { return newAfPacketRing(name, RingConfig{}) })
+}
+
+func BenchmarkAfPacketRingSendBatch(b *testing.B) {
+	x, _ := setupVeth(b)
+	ring, err := newAfPacketRing(x, RingConfig{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer ring.Close()
+	frames := make([][]byte, 64)
+	for i := range frames {
+		frames[i] = testFrame(i)
+	}
+	b.ResetTimer()
+	start := time.Now()
+	for sent := 0; sent < b.N; {
+		n := len(frames)
+		if b.N-sent < n {
+			n = b.N - sent
+		}
+		m, err := ring.SendBatch(frames[:n])
+		if err != nil {
+			b.Fatal(err)
+		}
+		sent += m
+	}
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "pps")
+}
@@ -1,27 +1,49 @@
 package interfaces
 
 import (
+	"crypto/rand"
+	"errors"
 	"fmt"
 	"os"
 	"syscall"
+	"time"
+
+	"github.com/terassyi/gotcp/pkg/pcap"
 )
 
 const tuntap = "/dev/net/tun"
 
+// tunDevice is the tap device passing ethernet frames or the tun device passing ipv4 packets.
+// The kernel is the other end of the link.
 type tunDevice struct {
 	file *os.File
 	name string
+	tap  bool
+	mac  []byte
 }
 
-func newTunDevice(name string) (*tunDevice, error) {
-	name, file, err := openDevice(name)
+func newTunDevice(name string, tap bool) (*tunDevice, error) {
+	var flags uint16 = syscall.IFF_TUN | syscall.IFF_NO_PI
+	if tap {
+		flags = syscall.IFF_TAP | syscall.IFF_NO_PI
+	}
+	name, file, err := openDevice(name, flags)
 	if err != nil {
 		return nil, err
 	}
-	return &tunDevice{
+	tun := &tunDevice{
 		file: file,
 		name: name,
-	}, nil
+		tap:  tap,
+	}
+	if tap {
+		// the address of the device belongs to the kernel side, the stack owns the different one
+		if tun.mac, err = generateAddress(); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return tun, nil
 }
 
 func (tun *tunDevice) Name() string {
@@ -29,11 +51,23 @@ func (tun *tunDevice) Name() string {
 }
 
 func (tun *tunDevice) Fd() int {
-	return int(tun.file.Fd())
+	fd := -1
+	if conn, err := tun.file.SyscallConn(); err == nil {
+		conn.Control(func(f uintptr) { fd = int(f) })
+	}
+	return fd
 }
 
+// Recv returns ErrTimeout when no frame arrives in a while as afpacket does.
 func (tun *tunDevice) Recv(buf []byte) (int, error) {
-	return tun.file.Read(buf)
+	if err := tun.file.SetReadDeadline(time.Now().Add(recvTimeout)); err != nil {
+		return 0, err
+	}
+	n, err := tun.file.Read(buf)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return 0, ErrTimeout
+	}
+	return n, err
 }
 
 func (tun *tunDevice) Send(buf []byte) (int, error) {
@@ -44,33 +78,54 @@ func (tun *tunDevice) Close() error {
 	return tun.file.Close()
 }
 
+// Address returns the hardware address generated for the stack on the tap device.
+// The tun device has no hardware address.
 func (tun *tunDevice) Address() ([]byte, error) {
-	addr, err := siocgifhwaddr(tun.name)
-	if err != nil {
+	if !tun.tap {
+		return nil, fmt.Errorf("%s is a tun device without hardware address", tun.name)
+	}
+	return tun.mac, nil
+}
+
+func (tun *tunDevice) linkType() pcap.LinkType {
+	if tun.tap {
+		return pcap.LinkTypeEthernet
+	}
+	return pcap.LinkTypeRaw
+}
+
+// generateAddress returns the random unicast and locally administered hardware address.
+func generateAddress() ([]byte, error) {
+	mac := make([]byte, 6)
+	if _, err := rand.Read(mac); err != nil {
 		return nil, err
 	}
-	return addr, nil
+	mac[0] = mac[0]&^0x01 | 0x02
+	return mac, nil
 }
 
-func openDevice(name string) (string, *os.File, error) {
+func openDevice(name string, flags uint16) (string, *os.File, error) {
 	if len(name) >= syscall.IFNAMSIZ {
 		return "", nil, fmt.Errorf("name is too long")
 	}
-	file, err := os.OpenFile(tuntap, os.O_RDWR, 0600)
+	// The device is attached before the file is registered to the poller,
+	// the registration of the unattached device sometimes fails and disables the read deadline.
+	fd, err := syscall.Open(tuntap, syscall.O_RDWR|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0600)
 	if err != nil {
 		return "", nil, err
 	}
-	name, err = tunsetiff(file.Fd(), name, syscall.IFF_TUN|syscall.IFF_NO_PI)
-	if err != nil {
+	if name, err = tunsetiff(uintptr(fd), name, flags); err != nil {
+		syscall.Close(fd)
 		return "", nil, err
 	}
-	flags, err := siocgifflags(name)
+	file := os.NewFile(uintptr(fd), tuntap)
+	ifflags, err := siocgifflags(name)
 	if err != nil {
 		file.Close()
 		return "", nil, err
 	}
-	flags |= (syscall.IFF_UP | syscall.IFF_RUNNING)
-	if err := siocsifflags(name, flags); err != nil {
+	ifflags |= (syscall.IFF_UP | syscall.IFF_RUNNING)
+	if err := siocsifflags(name, ifflags); err != nil {
 		file.Close()
 		return "", nil, err
 	}
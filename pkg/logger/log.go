@@ -25,7 +25,7 @@ func (l *Logger) Info(args ...interface{}) {
 	if l.flag {
 		logrus.WithFields(logrus.Fields{
 			"protocol": l.proto,
-		}).Info(args)
+		}).Info(args...)
 	}
 }
 
@@ -33,7 +33,7 @@ func (l *Logger) Debug(args ...interface{}) {
 	if l.flag {
 		logrus.WithFields(logrus.Fields{
 			"protocol": l.proto,
-		}).Debug(args)
+		}).Debug(args...)
 	}
 }
 
@@ -41,7 +41,7 @@ func (l *Logger) Warn(args ...interface{}) {
 	if l.flag {
 		logrus.WithFields(logrus.Fields{
 			"protocol": l.proto,
-		}).Warn(args)
+		}).Warn(args...)
 	}
 }
 
@@ -49,7 +49,7 @@ func (l *Logger) Error(args ...interface{}) {
 	if l.flag {
 		logrus.WithFields(logrus.Fields{
 			"protocol": l.proto,
-		}).Error(args)
+		}).Error(args...)
 	}
 }
 
@@ -57,7 +57,7 @@ func (l *Logger) Infof(format string, args ...interface{}) {
 	if l.flag {
 		logrus.WithFields(logrus.Fields{
 			"protocol": l.proto,
-		}).Infof(format, args)
+		}).Infof(format, args...)
 	}
 }
 
@@ -65,7 +65,7 @@ func (l *Logger) Debugf(format string, args ...interface{}) {
 	if l.flag {
 		logrus.WithFields(logrus.Fields{
 			"protocol": l.proto,
-		}).Debug(format, args)
+		}).Debugf(format, args...)
 	}
 }
 
@@ -73,7 +73,7 @@ func (l *Logger) Warnf(format string, args ...interface{}) {
 	if l.flag {
 		logrus.WithFields(logrus.Fields{
 			"protocol": l.proto,
-		}).Warnf(format, args)
+		}).Warnf(format, args...)
 	}
 }
 
@@ -81,6 +81,6 @@ func (l *Logger) Errorf(format string, args ...interface{}) {
 	if l.flag {
 		logrus.WithFields(logrus.Fields{
 			"protocol": l.proto,
-		}).Errorf(format, args)
+		}).Errorf(format, args...)
 	}
 }
@@ -105,6 +105,9 @@ func (arp *Packet) Handle() {
 }
 
 func printHadrwareAddress(hwaddr []byte) string {
+	if len(hwaddr) != 6 {
+		return "unknown address"
+	}
 	return fmt.Sprintf("%02x:%02x:%02x:%02x:%02x:%02x", hwaddr[0], hwaddr[1], hwaddr[2], hwaddr[3], hwaddr[4], hwaddr[5])
 }
 
@@ -0,0 +1,31 @@
+package arp
+
+import (
+	"bytes"
+	"testing"
+)
+
+func FuzzNew(f *testing.F) {
+	f.Add([]byte{
+		0x00, 0x01, 0x08, 0x00, 0x06, 0x04, 0x00, 0x01,
+		0x02, 0x00, 0x00, 0x00, 0x00, 0x01, 0x0a, 0x00, 0x00, 0x01,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x0a, 0x00, 0x00, 0x02,
+	})
+	f.Add([]byte{0x00, 0x01, 0x08, 0x00, 0xff, 0xff, 0x00, 0x02})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		packet, err := New(data)
+		if err != nil {
+			return
+		}
+		printHadrwareAddress(packet.SourceHardwareAddress)
+		printProtocolAddress(packet.SourceProtocolAddress)
+		b, err := packet.Serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		length := 8 + 2*(int(packet.Header.HardwareSize)+int(packet.Header.ProtocolSize))
+		if len(b) != length || !bytes.Equal(b, data[:length]) {
+			t.Fatalf("round trip: %x => %x", data, b)
+		}
+	})
+}
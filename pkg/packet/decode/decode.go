@@ -0,0 +1,64 @@
+// Package decode decodes all layers of the ethernet frame at once for analyzers such as dump and ids.
+package decode
+
+import (
+	"github.com/terassyi/gotcp/pkg/packet/arp"
+	"github.com/terassyi/gotcp/pkg/packet/ethernet"
+	"github.com/terassyi/gotcp/pkg/packet/icmp"
+	"github.com/terassyi/gotcp/pkg/packet/ipv4"
+	"github.com/terassyi/gotcp/pkg/packet/tcp"
+	"github.com/terassyi/gotcp/pkg/packet/udp"
+)
+
+// Packet is the decoded frame. Layers not in the frame are nil.
+type Packet struct {
+	Ethernet *ethernet.EthernetFrame
+	Arp      *arp.Packet
+	IPv4     *ipv4.Packet
+	Icmp     *icmp.Packet
+	Tcp      *tcp.Packet
+	Udp      *udp.Packet
+	// Err is the error of the layer failed to decode, outer layers are kept.
+	Err error
+}
+
+// Decode decodes the frame as far as possible.
+// The transport layer of the fragment except the first one is not decoded.
+func Decode(data []byte) *Packet {
+	p := &Packet{}
+	frame, err := ethernet.New(data)
+	if err != nil {
+		p.Err = err
+		return p
+	}
+	p.Ethernet = frame
+	switch frame.Type() {
+	case ethernet.ETHER_TYPE_ARP:
+		p.Arp, p.Err = arp.New(frame.Payload())
+	case ethernet.ETHER_TYPE_IP:
+		p.decodeIPv4(frame.Payload())
+	}
+	return p
+}
+
+// DecodeRaw decodes the ipv4 packet without the link layer header such as read from the tun device.
+func DecodeRaw(data []byte) *Packet {
+	p := &Packet{}
+	p.decodeIPv4(data)
+	return p
+}
+
+func (p *Packet) decodeIPv4(data []byte) {
+	p.IPv4, p.Err = ipv4.New(data)
+	if p.Err != nil || p.IPv4.Header.FlOffset.FragmentOffset() != 0 {
+		return
+	}
+	switch p.IPv4.Header.Protocol {
+	case ipv4.IPICMPv4Protocol:
+		p.Icmp, p.Err = icmp.New(p.IPv4.Data)
+	case ipv4.IPTCPProtocol:
+		p.Tcp, p.Err = tcp.New(p.IPv4.Data)
+	case ipv4.IPUDPProtocol:
+		p.Udp, p.Err = udp.New(p.IPv4.Data)
+	}
+}
@@ -0,0 +1,29 @@
+package ethernet
+
+import (
+	"bytes"
+	"testing"
+)
+
+func FuzzNew(f *testing.F) {
+	f.Add([]byte{
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+		0x02, 0x00, 0x00, 0x00, 0x00, 0x01,
+		0x08, 0x06,
+		0x00, 0x01, 0x08, 0x00, 0x06, 0x04, 0x00, 0x01,
+	})
+	f.Add([]byte{0x02, 0x00, 0x00, 0x00, 0x00, 0x01})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		frame, err := New(data)
+		if err != nil {
+			return
+		}
+		b, err := frame.Serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, data) {
+			t.Fatalf("round trip: %x => %x", data, b)
+		}
+	})
+}
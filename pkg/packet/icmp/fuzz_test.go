@@ -0,0 +1,51 @@
+package icmp
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func FuzzNew(f *testing.F) {
+	f.Add([]byte{0x08, 0x00, 0xf7, 0xfd, 0x00, 0x01, 0x00, 0x01})
+	f.Add([]byte{0x03, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x45})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		packet, err := New(data)
+		if err != nil {
+			return
+		}
+		b, err := packet.Serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the checksum is recalculated by the decoder
+		if len(b) != len(data) || !bytes.Equal(b[:2], data[:2]) || !bytes.Equal(b[4:], data[4:]) {
+			t.Fatalf("round trip: %x => %x", data, b)
+		}
+		again, err := New(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(packet, again) {
+			t.Fatalf("round trip: %v => %v", packet, again)
+		}
+	})
+}
+
+func FuzzNewEchoMessage(f *testing.F) {
+	f.Add([]byte{0x00, 0x01, 0x00, 0x01, 0x61, 0x62})
+	f.Add([]byte{0x00, 0x01})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		message, err := NewEchoMessage(data)
+		if err != nil {
+			return
+		}
+		b, err := message.Serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, data) {
+			t.Fatalf("round trip: %x => %x", data, b)
+		}
+	})
+}
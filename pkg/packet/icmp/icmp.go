@@ -131,6 +131,9 @@ func Build(typ Type, code uint8, data []byte) (*Packet, error) {
 }
 
 func NewEchoMessage(data []byte) (*EchoMessage, error) {
+	if len(data) < 4 {
+		return nil, fmt.Errorf("echo message is too short")
+	}
 	message := &EchoMessage{}
 	message.Ident = binary.BigEndian.Uint16(data[0:2])
 	message.Seq = binary.BigEndian.Uint16(data[2:4])
@@ -139,7 +142,7 @@ func NewEchoMessage(data []byte) (*EchoMessage, error) {
 }
 
 func (e *EchoMessage) Serialize() ([]byte, error) {
-	buf := bytes.NewBuffer(make([]byte, 4))
+	buf := bytes.NewBuffer(make([]byte, 0, 4+len(e.Data)))
 	if err := binary.Write(buf, binary.BigEndian, e.Ident); err != nil {
 		return nil, err
 	}
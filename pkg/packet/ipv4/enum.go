@@ -5,3 +5,41 @@ const (
 	IPTCPProtocol    IPProtocol = 6
 	IPUDPProtocol    IPProtocol = 17
 )
+
+// flags of the fragmentation
+const (
+	FlagMoreFragments uint8 = 0x1
+	FlagDontFragment  uint8 = 0x2
+)
+
+// option types, the copied flag is the highest bit (RFC 791)
+const (
+	OptionEnd               OptionType = 0
+	OptionNop               OptionType = 1
+	OptionRecordRoute       OptionType = 7
+	OptionTimestamp         OptionType = 68
+	OptionLooseSourceRoute  OptionType = 131
+	OptionStrictSourceRoute OptionType = 137
+	OptionRouterAlert       OptionType = 148
+)
+
+// flags of the timestamp option
+const (
+	TimestampOnly         uint8 = 0
+	TimestampAndAddress   uint8 = 1
+	TimestampPrespecified uint8 = 3
+)
+
+// MaxOptionLength is the maximum length of options in the header.
+const MaxOptionLength int = 40
+
+// MaxLength is the maximum length of the datagram.
+const MaxLength int = 65535
+
+// ECN codepoints in the two low bits of the TOS field (RFC 3168)
+const (
+	ECNNotECT uint8 = 0x00
+	ECNECT1   uint8 = 0x01
+	ECNECT0   uint8 = 0x02
+	ECNCE     uint8 = 0x03
+)
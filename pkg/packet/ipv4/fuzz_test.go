@@ -0,0 +1,56 @@
+package ipv4
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/terassyi/gotcp/pkg/util"
+)
+
+func FuzzNew(f *testing.F) {
+	f.Add([]byte{
+		0x45, 0x00, 0x00, 0x1c, 0x00, 0x00, 0x40, 0x00,
+		0x40, 0x01, 0x00, 0x00, 0x0a, 0x00, 0x00, 0x01,
+		0x0a, 0x00, 0x00, 0x02,
+		0x08, 0x00, 0xf7, 0xfd, 0x00, 0x01, 0x00, 0x01,
+	})
+	// record route option
+	f.Add([]byte{
+		0x46, 0x00, 0x00, 0x18, 0x00, 0x00, 0x00, 0x00,
+		0x40, 0x06, 0x00, 0x00, 0x0a, 0x00, 0x00, 0x01,
+		0x0a, 0x00, 0x00, 0x02, 0x07, 0x03, 0x04, 0x00,
+	})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		packet, err := New(data)
+		if err != nil {
+			return
+		}
+		b, err := packet.Serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, data[:packet.Header.Length]) {
+			t.Fatalf("round trip: %x => %x", data, b)
+		}
+		again, err := New(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(packet, again) {
+			t.Fatalf("round trip: %v => %v", packet, again)
+		}
+		// malformed options are reported as errors
+		packet.Options()
+		if err := packet.ReCalculateChecksum(); err != nil {
+			t.Fatal(err)
+		}
+		b, err = packet.Serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sum := util.Checksum2(b, int(packet.Header.VHL.IHL())<<2, 0); sum != 0 {
+			t.Fatalf("invalid checksum: %x", sum)
+		}
+	})
+}
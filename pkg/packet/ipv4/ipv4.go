@@ -54,12 +54,31 @@ func (vi VerIHL) IHL() uint8 {
 
 type FlagsFragmentOffset uint16
 
+// Flags returns the 3 bits of the reserved, don't fragment and more fragments flags.
 func (fo FlagsFragmentOffset) Flags() uint8 {
-	return uint8(fo) >> 5
+	return uint8(fo >> 13)
 }
 
+// FragmentOffset returns the offset in units of 8 bytes.
 func (fo FlagsFragmentOffset) FragmentOffset() uint16 {
-	return uint16(fo) & 0x1FF
+	return uint16(fo) & 0x1FFF
+}
+
+func NewFlagsFragmentOffset(flags uint8, offset uint16) FlagsFragmentOffset {
+	return FlagsFragmentOffset(uint16(flags)<<13 | offset&0x1FFF)
+}
+
+func (fo FlagsFragmentOffset) MoreFragments() bool {
+	return fo.Flags()&FlagMoreFragments != 0
+}
+
+func (fo FlagsFragmentOffset) DontFragment() bool {
+	return fo.Flags()&FlagDontFragment != 0
+}
+
+// Fragmented returns true when the packet is a fragment of the datagram.
+func (fo FlagsFragmentOffset) Fragmented() bool {
+	return fo.MoreFragments() || fo.FragmentOffset() != 0
 }
 
 type IPAddress [4]byte
@@ -141,10 +160,13 @@ func New(data []byte) (*Packet, error) {
 	if int(header.Length) > len(data) {
 		return nil, fmt.Errorf("packet length is too short header-length=%d length-of-data=%d\n", int(header.Length), len(data))
 	}
-	if int(header.TTL) == 0 {
-		return nil, fmt.Errorf("ttl is zero")
+	headerLength := int(header.VHL.IHL()) << 2
+	if headerLength < 20 {
+		return nil, fmt.Errorf("invalid header length: %d", headerLength)
+	}
+	if int(header.Length) < headerLength {
+		return nil, fmt.Errorf("total length %d is shorter than header length %d", header.Length, headerLength)
 	}
-	headerLength := int(header.VHL.IHL() << 2)
 	// sum := util.Checksum2(data, headerLength, 0)
 	// fmt.Printf("checksum [%x]:[%x]\n", sum, header.Checksum)
 	// if sum != header.Checksum {
@@ -152,7 +174,7 @@ func New(data []byte) (*Packet, error) {
 	// }
 	packet := &Packet{
 		Header:        *header,
-		OptionPadding: make([]byte, header.VHL.IHL()-20),
+		OptionPadding: make([]byte, headerLength-20),
 	}
 	if err := binary.Read(buf, binary.BigEndian, packet.OptionPadding); err != nil {
 		return nil, fmt.Errorf("error making option and padding: %v", err)
@@ -167,6 +189,9 @@ func (ip *Packet) Serialize() ([]byte, error) {
 	if err := binary.Write(buf, binary.BigEndian, ip.Header); err != nil {
 		return nil, err
 	}
+	if err := binary.Write(buf, binary.BigEndian, ip.OptionPadding); err != nil {
+		return nil, err
+	}
 	if err := binary.Write(buf, binary.BigEndian, ip.Data); err != nil {
 		return nil, err
 	}
@@ -181,6 +206,25 @@ func (iphdr *Header) DeclTTL() {
 	iphdr.TTL--
 }
 
+// ECN returns the ECN codepoint of the TOS field.
+func (iphdr *Header) ECN() uint8 {
+	return iphdr.TOS & 0x03
+}
+
+func (iphdr *Header) SetECN(ecn uint8) {
+	iphdr.TOS = iphdr.TOS&0xfc | ecn&0x03
+}
+
+// MarkCE marks the packet as congestion experienced like an AQM router does.
+// Packets from not ECN-capable transports are not marked.
+func (ip *Packet) MarkCE() error {
+	if ip.Header.ECN() == ECNNotECT {
+		return nil
+	}
+	ip.Header.SetECN(ECNCE)
+	return ip.ReCalculateChecksum()
+}
+
 // func (ip *Packet) CalculateChecksum() bool {
 
 // }
@@ -199,8 +243,7 @@ func (ip *Packet) ReCalculateChecksum() error {
 	return nil
 }
 
-func Build(src, dst IPAddress, protocol IPProtocol, data []byte) (*Packet, error) {
-	//no option
+func Build(src, dst IPAddress, protocol IPProtocol, data []byte, options ...Option) (*Packet, error) {
 	header := &Header{
 		VHL:      VerIHL(0x45),
 		TOS:      uint8(0),
@@ -217,12 +260,115 @@ func Build(src, dst IPAddress, protocol IPProtocol, data []byte) (*Packet, error
 		Header: *header,
 		Data:   data,
 	}
+	if err := packet.SetOptions(options); err != nil {
+		return nil, err
+	}
 	if err := packet.ReCalculateChecksum(); err != nil {
 		return nil, err
 	}
 	return packet, nil
 }
 
+// Options parses options in the header.
+func (ip *Packet) Options() (Options, error) {
+	return OptionsFromByte(ip.OptionPadding)
+}
+
+// SetOptions replaces options padded to 4 bytes, the header length and the total length are updated.
+// The checksum has to be recalculated.
+func (ip *Packet) SetOptions(options Options) error {
+	b := options.Byte()
+	for len(b)%4 != 0 {
+		b = append(b, byte(OptionEnd))
+	}
+	if len(b) > MaxOptionLength {
+		return fmt.Errorf("options are too long: %d", len(b))
+	}
+	ip.OptionPadding = b
+	ip.Header.VHL = VerIHL(0x40 | (20+len(b))/4)
+	ip.Header.Length = uint16(20 + len(b) + len(ip.Data))
+	return nil
+}
+
+// Fragment splits the packet into fragments whose length is at most mtu.
+// The packet is returned as it is when it fits in mtu. Options with the copied flag are carried by all fragments.
+func (ip *Packet) Fragment(mtu int) ([]*Packet, error) {
+	headerLength := 20 + len(ip.OptionPadding)
+	if headerLength+len(ip.Data) <= mtu {
+		return []*Packet{ip}, nil
+	}
+	if ip.Header.FlOffset.DontFragment() {
+		return nil, fmt.Errorf("datagram exceeds mtu %d with don't fragment flag: %d", mtu, headerLength+len(ip.Data))
+	}
+	copied := ip.copiedOptions()
+	var fragments []*Packet
+	base := int(ip.Header.FlOffset.FragmentOffset()) * 8
+	for offset := 0; offset < len(ip.Data); {
+		options := copied
+		if offset == 0 {
+			options = ip.OptionPadding
+		}
+		size := (mtu - 20 - len(options)) &^ 7
+		if size <= 0 {
+			return nil, fmt.Errorf("mtu %d is too small to fragment", mtu)
+		}
+		end := offset + size
+		flags := ip.Header.FlOffset.Flags() | FlagMoreFragments
+		if end >= len(ip.Data) {
+			end = len(ip.Data)
+			// the last fragment of the fragment keeps more fragments flag
+			flags = ip.Header.FlOffset.Flags()
+		}
+		header := ip.Header
+		header.VHL = VerIHL(0x40 | (20+len(options))/4)
+		header.Length = uint16(20 + len(options) + end - offset)
+		header.FlOffset = NewFlagsFragmentOffset(flags, uint16((base+offset)/8))
+		fragment := &Packet{
+			Header:        header,
+			OptionPadding: options,
+			Data:          ip.Data[offset:end],
+		}
+		if err := fragment.ReCalculateChecksum(); err != nil {
+			return nil, err
+		}
+		fragments = append(fragments, fragment)
+		offset = end
+	}
+	return fragments, nil
+}
+
+// copiedOptions returns options to be copied into all fragments, padded to 4 bytes.
+func (ip *Packet) copiedOptions() []byte {
+	var options []byte
+	for i := 0; i < len(ip.OptionPadding); {
+		typ := ip.OptionPadding[i]
+		if typ == 0 {
+			// end of option list
+			break
+		}
+		if typ == 1 {
+			// no operation
+			i++
+			continue
+		}
+		if i+1 >= len(ip.OptionPadding) {
+			break
+		}
+		length := int(ip.OptionPadding[i+1])
+		if length < 2 || i+length > len(ip.OptionPadding) {
+			break
+		}
+		if typ&0x80 != 0 {
+			options = append(options, ip.OptionPadding[i:i+length]...)
+		}
+		i += length
+	}
+	for len(options)%4 != 0 {
+		options = append(options, 0)
+	}
+	return options
+}
+
 func StringToIPAddress(addr string) (*IPAddress, error) {
 	s := strings.Split(addr, ".")
 	var address []byte
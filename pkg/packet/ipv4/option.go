@@ -0,0 +1,451 @@
+package ipv4
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type OptionType uint8
+
+// Copied returns true when the option is copied into all fragments.
+func (t OptionType) Copied() bool {
+	return t&0x80 != 0
+}
+
+type Option interface {
+	Type() OptionType
+	Length() int
+	Byte() []byte
+}
+
+type Options []Option
+
+// OptionsFromByte parses options in the header, options of unknown types are kept as RawOption.
+func OptionsFromByte(data []byte) (Options, error) {
+	var ops Options
+	for i := 0; i < len(data); i++ {
+		typ := OptionType(data[i])
+		if typ == OptionEnd {
+			// the rest is padding
+			ops = append(ops, EndOfOptionList{})
+			break
+		}
+		if typ == OptionNop {
+			ops = append(ops, NoOperation{})
+			continue
+		}
+		// other options have the length octet which includes the type and the length
+		if i+1 >= len(data) || int(data[i+1]) < 2 || i+int(data[i+1]) > len(data) {
+			return ops, fmt.Errorf("invalid ip option length: type=%d", typ)
+		}
+		l := int(data[i+1])
+		body := data[i : i+l]
+		switch typ {
+		case OptionRecordRoute:
+			pointer, route, err := parseRoute(body)
+			if err != nil {
+				return ops, fmt.Errorf("invalid record route option: %v", err)
+			}
+			ops = append(ops, &RecordRoute{Pointer: pointer, Route: route})
+		case OptionLooseSourceRoute, OptionStrictSourceRoute:
+			pointer, route, err := parseRoute(body)
+			if err != nil {
+				return ops, fmt.Errorf("invalid source route option: %v", err)
+			}
+			ops = append(ops, &SourceRoute{Strict: typ == OptionStrictSourceRoute, Pointer: pointer, Route: route})
+		case OptionTimestamp:
+			ts, err := parseTimestamp(body)
+			if err != nil {
+				return ops, fmt.Errorf("invalid timestamp option: %v", err)
+			}
+			ops = append(ops, ts)
+		case OptionRouterAlert:
+			if l != 4 {
+				return ops, fmt.Errorf("invalid router alert option length")
+			}
+			ops = append(ops, RouterAlert(binary.BigEndian.Uint16(body[2:4])))
+		default:
+			ops = append(ops, RawOption{Kind: typ, Data: append([]byte(nil), body[2:]...)})
+		}
+		i += l - 1
+	}
+	return ops, nil
+}
+
+func (op Options) Byte() []byte {
+	var data []byte
+	for _, o := range op {
+		data = append(data, o.Byte()...)
+	}
+	return data
+}
+
+func (op Options) RecordRoute() *RecordRoute {
+	for _, o := range op {
+		if r, ok := o.(*RecordRoute); ok {
+			return r
+		}
+	}
+	return nil
+}
+
+func (op Options) Timestamp() *Timestamp {
+	for _, o := range op {
+		if t, ok := o.(*Timestamp); ok {
+			return t
+		}
+	}
+	return nil
+}
+
+func (op Options) SourceRoute() *SourceRoute {
+	for _, o := range op {
+		if s, ok := o.(*SourceRoute); ok {
+			return s
+		}
+	}
+	return nil
+}
+
+func (op Options) RouterAlert() *RouterAlert {
+	for _, o := range op {
+		if r, ok := o.(RouterAlert); ok {
+			return &r
+		}
+	}
+	return nil
+}
+
+type EndOfOptionList struct{}
+
+func (EndOfOptionList) Type() OptionType {
+	return OptionEnd
+}
+
+func (EndOfOptionList) Length() int {
+	return 1
+}
+
+func (EndOfOptionList) Byte() []byte {
+	return []byte{byte(OptionEnd)}
+}
+
+type NoOperation struct{}
+
+func (NoOperation) Type() OptionType {
+	return OptionNop
+}
+
+func (NoOperation) Length() int {
+	return 1
+}
+
+func (NoOperation) Byte() []byte {
+	return []byte{byte(OptionNop)}
+}
+
+// RecordRoute records addresses of hosts and routers the datagram passes through.
+type RecordRoute struct {
+	Pointer uint8       // octet offset of the next slot from the option type starting from 1
+	Route   []IPAddress // all slots including empty ones
+}
+
+// NewRecordRoute returns the record route option with n empty slots, 9 slots at most fit in the header.
+func NewRecordRoute(n int) *RecordRoute {
+	return &RecordRoute{Pointer: 4, Route: make([]IPAddress, n)}
+}
+
+func (*RecordRoute) Type() OptionType {
+	return OptionRecordRoute
+}
+
+func (r *RecordRoute) Length() int {
+	return 3 + 4*len(r.Route)
+}
+
+func (r *RecordRoute) Byte() []byte {
+	return routeByte(OptionRecordRoute, r.Pointer, r.Route)
+}
+
+// Recorded returns addresses recorded so far.
+func (r *RecordRoute) Recorded() []IPAddress {
+	return r.Route[:routeIndex(r.Pointer, len(r.Route))]
+}
+
+// Record records the address in the next slot, it returns false when no slot is left.
+func (r *RecordRoute) Record(addr IPAddress) bool {
+	i := routeIndex(r.Pointer, len(r.Route))
+	if i >= len(r.Route) {
+		return false
+	}
+	r.Route[i] = addr
+	r.Pointer += 4
+	return true
+}
+
+func (r *RecordRoute) String() string {
+	return routeString(r.Recorded())
+}
+
+// SourceRoute is the loose or strict source route.
+// Route is the list of hops to visit, visited hops are replaced by the addresses of routers.
+type SourceRoute struct {
+	Strict  bool
+	Pointer uint8
+	Route   []IPAddress
+}
+
+// NewSourceRoute returns the source route via hops, the destination of the datagram is the first hop
+// and the last hop is the final destination.
+func NewSourceRoute(strict bool, hops []IPAddress) *SourceRoute {
+	return &SourceRoute{Strict: strict, Pointer: 4, Route: append([]IPAddress(nil), hops...)}
+}
+
+func (s *SourceRoute) Type() OptionType {
+	if s.Strict {
+		return OptionStrictSourceRoute
+	}
+	return OptionLooseSourceRoute
+}
+
+func (s *SourceRoute) Length() int {
+	return 3 + 4*len(s.Route)
+}
+
+func (s *SourceRoute) Byte() []byte {
+	return routeByte(s.Type(), s.Pointer, s.Route)
+}
+
+// Completed returns true when the datagram reaches the final destination.
+func (s *SourceRoute) Completed() bool {
+	return routeIndex(s.Pointer, len(s.Route)) >= len(s.Route)
+}
+
+// Reverse returns the first hop and the route of the reply to src which sent the datagram along the completed route (RFC 1122 3.2.1.8).
+// Slots of the completed route are the addresses of routers in the order of visited.
+func (s *SourceRoute) Reverse(src IPAddress) (IPAddress, *SourceRoute) {
+	if len(s.Route) == 0 {
+		return src, nil
+	}
+	hops := make([]IPAddress, 0, len(s.Route)+1)
+	for i := len(s.Route) - 1; i >= 0; i-- {
+		hops = append(hops, s.Route[i])
+	}
+	hops = append(hops, src)
+	return hops[0], NewSourceRoute(s.Strict, hops[1:])
+}
+
+func (s *SourceRoute) String() string {
+	return routeString(s.Route)
+}
+
+// Timestamp records the time in milliseconds from midnight UT, with addresses depending on Flag.
+type Timestamp struct {
+	Pointer  uint8 // octet offset of the next slot from the option type starting from 1
+	Overflow uint8 // number of hosts which could not record
+	Flag     uint8
+	Entries  []TimestampEntry // all slots including empty ones
+}
+
+type TimestampEntry struct {
+	Address IPAddress // not used with TimestampOnly, prespecified with TimestampPrespecified
+	Time    uint32
+}
+
+// NewTimestamp returns the timestamp option with n empty slots, 9 slots of TimestampOnly or 4 slots of others fit in the header.
+func NewTimestamp(flag uint8, n int) *Timestamp {
+	return &Timestamp{Pointer: 5, Flag: flag, Entries: make([]TimestampEntry, n)}
+}
+
+// NewPrespecifiedTimestamp returns the timestamp option recorded only by the hosts of addrs in the order.
+func NewPrespecifiedTimestamp(addrs []IPAddress) *Timestamp {
+	t := NewTimestamp(TimestampPrespecified, len(addrs))
+	for i, addr := range addrs {
+		t.Entries[i].Address = addr
+	}
+	return t
+}
+
+func (*Timestamp) Type() OptionType {
+	return OptionTimestamp
+}
+
+func (t *Timestamp) entrySize() int {
+	if t.Flag == TimestampOnly {
+		return 4
+	}
+	return 8
+}
+
+func (t *Timestamp) Length() int {
+	return 4 + t.entrySize()*len(t.Entries)
+}
+
+func (t *Timestamp) Byte() []byte {
+	b := []byte{byte(OptionTimestamp), byte(t.Length()), t.Pointer, t.Overflow<<4 | t.Flag&0x0f}
+	for _, e := range t.Entries {
+		if t.Flag != TimestampOnly {
+			b = append(b, e.Address[:]...)
+		}
+		b = append(b, byte(e.Time>>24), byte(e.Time>>16), byte(e.Time>>8), byte(e.Time))
+	}
+	return b
+}
+
+func (t *Timestamp) next() int {
+	return (int(t.Pointer) - 5) / t.entrySize()
+}
+
+// Recorded returns entries recorded so far.
+func (t *Timestamp) Recorded() []TimestampEntry {
+	n := t.next()
+	if n > len(t.Entries) {
+		n = len(t.Entries)
+	}
+	return t.Entries[:n]
+}
+
+// Stamp records the time by the host of addr, the overflow is counted when no slot is left.
+func (t *Timestamp) Stamp(addr IPAddress, now time.Time) {
+	i := t.next()
+	if i >= len(t.Entries) {
+		if t.Overflow < 0x0f {
+			t.Overflow++
+		}
+		return
+	}
+	if t.Flag == TimestampPrespecified && t.Entries[i].Address != addr {
+		return
+	}
+	if t.Flag == TimestampAndAddress {
+		t.Entries[i].Address = addr
+	}
+	t.Entries[i].Time = TimestampValue(now)
+	t.Pointer += uint8(t.entrySize())
+}
+
+func (t *Timestamp) String() string {
+	var b strings.Builder
+	for i, e := range t.Recorded() {
+		if i > 0 {
+			b.WriteString(" ")
+		}
+		if t.Flag != TimestampOnly {
+			fmt.Fprintf(&b, "%s ", e.Address)
+		}
+		fmt.Fprintf(&b, "%d", e.Time)
+	}
+	if t.Overflow > 0 {
+		fmt.Fprintf(&b, " (%d hosts overflowed)", t.Overflow)
+	}
+	return b.String()
+}
+
+// TimestampValue returns milliseconds from midnight UT.
+func TimestampValue(now time.Time) uint32 {
+	now = now.UTC()
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	return uint32(now.Sub(midnight) / time.Millisecond)
+}
+
+// RouterAlert tells routers to examine the datagram (RFC 2113).
+type RouterAlert uint16
+
+func (RouterAlert) Type() OptionType {
+	return OptionRouterAlert
+}
+
+func (RouterAlert) Length() int {
+	return 4
+}
+
+func (r RouterAlert) Byte() []byte {
+	return []byte{byte(OptionRouterAlert), 4, byte(r >> 8), byte(r)}
+}
+
+// RawOption is the option of the type not supported.
+type RawOption struct {
+	Kind OptionType
+	Data []byte
+}
+
+func (r RawOption) Type() OptionType {
+	return r.Kind
+}
+
+func (r RawOption) Length() int {
+	return 2 + len(r.Data)
+}
+
+func (r RawOption) Byte() []byte {
+	return append([]byte{byte(r.Kind), byte(r.Length())}, r.Data...)
+}
+
+func parseRoute(body []byte) (uint8, []IPAddress, error) {
+	if len(body) < 3 || (len(body)-3)%4 != 0 {
+		return 0, nil, fmt.Errorf("length %d", len(body))
+	}
+	pointer := body[2]
+	if pointer < 4 || pointer%4 != 0 {
+		return 0, nil, fmt.Errorf("pointer %d", pointer)
+	}
+	route := make([]IPAddress, (len(body)-3)/4)
+	for i := range route {
+		copy(route[i][:], body[3+4*i:])
+	}
+	return pointer, route, nil
+}
+
+func parseTimestamp(body []byte) (*Timestamp, error) {
+	if len(body) < 4 {
+		return nil, fmt.Errorf("length %d", len(body))
+	}
+	t := &Timestamp{Pointer: body[2], Overflow: body[3] >> 4, Flag: body[3] & 0x0f}
+	if t.Flag != TimestampOnly && t.Flag != TimestampAndAddress && t.Flag != TimestampPrespecified {
+		return nil, fmt.Errorf("flag %d", t.Flag)
+	}
+	size := t.entrySize()
+	if (len(body)-4)%size != 0 {
+		return nil, fmt.Errorf("length %d", len(body))
+	}
+	if t.Pointer < 5 || (int(t.Pointer)-5)%size != 0 {
+		return nil, fmt.Errorf("pointer %d", t.Pointer)
+	}
+	t.Entries = make([]TimestampEntry, (len(body)-4)/size)
+	for i := range t.Entries {
+		e := body[4+size*i:]
+		if size == 8 {
+			copy(t.Entries[i].Address[:], e)
+			e = e[4:]
+		}
+		t.Entries[i].Time = binary.BigEndian.Uint32(e)
+	}
+	return t, nil
+}
+
+// routeIndex returns the index of the slot the pointer points to, it is len when all slots are used.
+func routeIndex(pointer uint8, n int) int {
+	i := (int(pointer) - 4) / 4
+	if i > n {
+		return n
+	}
+	return i
+}
+
+func routeByte(typ OptionType, pointer uint8, route []IPAddress) []byte {
+	b := []byte{byte(typ), byte(3 + 4*len(route)), pointer}
+	for _, addr := range route {
+		b = append(b, addr[:]...)
+	}
+	return b
+}
+
+func routeString(route []IPAddress) string {
+	s := make([]string, 0, len(route))
+	for _, addr := range route {
+		s = append(s, addr.String())
+	}
+	return strings.Join(s, " ")
+}
@@ -0,0 +1,98 @@
+package ipv4
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestOptionsFromByte(t *testing.T) {
+	data := []byte{
+		0x01,
+		0x07, 0x0b, 0x08, 0x0a, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00,
+		0x94, 0x04, 0x00, 0x00,
+		0x83, 0x07, 0x04, 0x0a, 0x00, 0x00, 0x02,
+		0x44, 0x0c, 0x0d, 0x11, 0x0a, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x10, 0x00, 0x00,
+	}
+	ops, err := OptionsFromByte(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ops) != 6 {
+		t.Fatalf("actual length: %d", len(ops))
+	}
+	if rr := ops.RecordRoute(); rr == nil || rr.String() != "10.0.0.1" || len(rr.Route) != 2 {
+		t.Fatalf("actual: %v", rr)
+	}
+	if ops.RouterAlert() == nil {
+		t.Fatal("router alert is not found")
+	}
+	if sr := ops.SourceRoute(); sr == nil || sr.Strict || sr.Completed() || sr.String() != "10.0.0.2" {
+		t.Fatalf("actual: %v", sr)
+	}
+	ts := ops.Timestamp()
+	if ts == nil || ts.Flag != TimestampAndAddress || ts.Overflow != 1 || len(ts.Recorded()) != 1 || ts.Recorded()[0].Time != 0x10 {
+		t.Fatalf("actual: %+v", ts)
+	}
+	if b := append(ops.Byte(), 0x00); !bytes.Equal(b, data) {
+		t.Fatalf("round trip: %x", b)
+	}
+	for _, invalid := range [][]byte{
+		{0x07, 0x04, 0x04, 0x00},
+		{0x07, 0x07, 0x03, 0x00, 0x00, 0x00, 0x00},
+		{0x44, 0x08, 0x05, 0x02, 0x00, 0x00, 0x00, 0x00},
+		{0x94, 0x02},
+		{0x83, 0x09},
+	} {
+		if _, err := OptionsFromByte(invalid); err == nil {
+			t.Fatalf("%x is accepted", invalid)
+		}
+	}
+}
+
+func TestBuildOptions(t *testing.T) {
+	src, _ := StringToIPAddress("10.0.0.1")
+	dst, _ := StringToIPAddress("10.0.0.2")
+	rr := NewRecordRoute(9)
+	rr.Record(*src)
+	ts := NewTimestamp(TimestampOnly, 1)
+	ts.Stamp(*src, time.Date(2020, 1, 1, 0, 0, 1, 0, time.UTC))
+	ts.Stamp(*dst, time.Now())
+	packet, err := Build(*src, *dst, IPICMPv4Protocol, []byte{0, 1, 2, 3}, rr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := packet.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := New(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Header.VHL.IHL() != 15 || len(parsed.Data) != 4 {
+		t.Fatalf("actual: ihl=%d data=%d", parsed.Header.VHL.IHL(), len(parsed.Data))
+	}
+	ops, err := parsed.Options()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := ops.RecordRoute(); r == nil || r.String() != "10.0.0.1" {
+		t.Fatalf("actual: %v", r)
+	}
+	if ts.Recorded()[0].Time != 1000 || ts.Overflow != 1 {
+		t.Fatalf("actual: %+v", ts)
+	}
+	if _, err := Build(*src, *dst, IPICMPv4Protocol, nil, rr, ts); err == nil {
+		t.Fatal("options over 40 bytes are built")
+	}
+
+	// the reply goes back through routers in the reverse order
+	r1, _ := StringToIPAddress("10.1.0.1")
+	r2, _ := StringToIPAddress("10.2.0.1")
+	sr := &SourceRoute{Pointer: 12, Route: []IPAddress{*r1, *r2}}
+	hop, reversed := sr.Reverse(*src)
+	if !sr.Completed() || hop != *r2 || reversed.String() != "10.1.0.1 10.0.0.1" || reversed.Pointer != 4 {
+		t.Fatalf("actual: %s via %s", hop, reversed)
+	}
+}
@@ -19,4 +19,10 @@ const (
 	SP  OptionKind = 4
 	SCK OptionKind = 5
 	TS  OptionKind = 8
+	MD5 OptionKind = 19
+	TFO OptionKind = 34
+	EXP OptionKind = 254
 )
+
+// fastOpenMagic identifies the TCP Fast Open cookie in the shared experimental option (RFC 6994).
+const fastOpenMagic uint16 = 0xf989
@@ -0,0 +1,64 @@
+package tcp
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func FuzzNew(f *testing.F) {
+	f.Add(syn_packet_data)
+	f.Add(syn_packet_data_no_option)
+	f.Add(append(append([]byte{}, syn_packet_data_no_option...), 0x68, 0x65, 0x6c, 0x6c, 0x6f))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		packet, err := New(data)
+		if err != nil {
+			return
+		}
+		// the experimental fast open option is rewritten with the IANA assigned kind,
+		// only packets keeping the option length are written back as they were.
+		if len(packet.Option.Byte()) != packet.Header.OffsetControlFlag.Offset()-20 {
+			return
+		}
+		b, err := packet.Serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, data) {
+			t.Fatalf("round trip: %x => %x", data, b)
+		}
+		again, err := New(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(packet, again) {
+			t.Fatalf("round trip: %v => %v", packet, again)
+		}
+	})
+}
+
+func FuzzOptionsFromByte(f *testing.F) {
+	f.Add(syn_packet_data[20:])
+	f.Add([]byte{0x22, 0x0a, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0xfe, 0x06, 0xf9, 0x89, 0x0a, 0x0b})
+	f.Add([]byte{0x05, 0x0a, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x02, 0x00, 0x00})
+	f.Add([]byte{0x13, 0x12, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
+	f.Add([]byte{0x02, 0x04, 0x05})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ops, err := OptionsFromByte(data)
+		if err != nil {
+			return
+		}
+		for _, op := range ops {
+			if len(op.Byte()) != op.Length() {
+				t.Fatalf("kind %d: length %d, written %d", op.Kind(), op.Length(), len(op.Byte()))
+			}
+		}
+		again, err := OptionsFromByte(ops.Byte())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(ops, again) {
+			t.Fatalf("round trip: %v => %v", ops, again)
+		}
+	})
+}
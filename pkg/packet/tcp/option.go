@@ -22,32 +22,60 @@ func OptionsFromByte(data []byte) (Options, error) {
 	var ops Options
 	for i := 0; i < len(data); i++ {
 		//fmt.Println(i)
-		switch OptionKind(data[i]) {
-		case End:
+		kind := OptionKind(data[i])
+		if kind == End {
 			ops = append(ops, EndOfOptionList{})
-		case Nop:
+			continue
+		}
+		if kind == Nop {
 			ops = append(ops, NoOperation{})
+			continue
+		}
+		// other options have the length octet which includes the kind and the length
+		if i+1 >= len(data) || int(data[i+1]) < 2 || i+int(data[i+1]) > len(data) {
+			return ops, fmt.Errorf("invalid tcp option length: kind=%d", kind)
+		}
+		l := int(data[i+1])
+		switch kind {
 		case MSS:
-			mss := (data[i+2] << 8) + data[i+3]
-			ops = append(ops, MaxSegmentSize(uint16(mss)))
-			i += 3
+			if l != 4 {
+				return ops, fmt.Errorf("invalid max segment size option length")
+			}
+			mss := uint16(data[i+2])<<8 | uint16(data[i+3])
+			ops = append(ops, MaxSegmentSize(mss))
 		case WS:
+			if l != 3 {
+				return ops, fmt.Errorf("invalid window scale option length")
+			}
 			ops = append(ops, WindowScale(data[i+2]))
-			i += 2
 		case SP:
+			if l != 2 {
+				return ops, fmt.Errorf("invalid sack permitted option length")
+			}
 			ops = append(ops, SACKPermitted{})
-			i += 1
 		case SCK:
-			l := data[i+1]
-			d := data[i+2 : i+int(l)]
-			ops = append(ops, SACK(d))
-			i += int(l) - 1
+			ops = append(ops, SACK(data[i+2:i+l]))
 		case TS:
+			if l != 10 {
+				return ops, fmt.Errorf("invalid timestamp option length")
+			}
 			ops = append(ops, TimeStamp(data[i+2:i+10]))
-			i += 9
+		case MD5:
+			if l != 18 {
+				return ops, fmt.Errorf("invalid md5 signature option length")
+			}
+			ops = append(ops, MD5Signature(data[i+2:i+18]))
+		case TFO:
+			ops = append(ops, FastOpenCookie(data[i+2:i+l]))
+		case EXP:
+			// other experiments sharing this kind are skipped
+			if l >= 4 && binary.BigEndian.Uint16(data[i+2:i+4]) == fastOpenMagic {
+				ops = append(ops, FastOpenCookie(data[i+4:i+l]))
+			}
 		default:
 			return ops, fmt.Errorf("unknown tcp option type")
 		}
+		i += l - 1
 	}
 	return ops, nil
 }
@@ -60,6 +88,37 @@ func (op Options) Byte() []byte {
 	return data
 }
 
+func (op Options) MaxSegmentSize() *MaxSegmentSize {
+	for _, o := range op {
+		switch m := o.(type) {
+		case MaxSegmentSize:
+			return &m
+		default:
+		}
+	}
+	return nil
+}
+
+func (op Options) WindowScale() *WindowScale {
+	for _, o := range op {
+		switch w := o.(type) {
+		case WindowScale:
+			return &w
+		default:
+		}
+	}
+	return nil
+}
+
+func (op Options) SACKPermitted() bool {
+	for _, o := range op {
+		if _, ok := o.(SACKPermitted); ok {
+			return true
+		}
+	}
+	return false
+}
+
 func (op Options) TimeStamp() *TimeStamp {
 	for _, o := range op {
 		switch t := o.(type) {
@@ -71,6 +130,30 @@ func (op Options) TimeStamp() *TimeStamp {
 	return nil
 }
 
+func (op Options) MD5Signature() *MD5Signature {
+	for _, o := range op {
+		switch m := o.(type) {
+		case MD5Signature:
+			return &m
+		default:
+		}
+	}
+	return nil
+}
+
+// FastOpenCookie returns the fast open option if present.
+// An empty cookie means the peer requests a new cookie.
+func (op Options) FastOpenCookie() *FastOpenCookie {
+	for _, o := range op {
+		switch c := o.(type) {
+		case FastOpenCookie:
+			return &c
+		default:
+		}
+	}
+	return nil
+}
+
 type EndOfOptionList struct{}
 
 func (EndOfOptionList) Kind() OptionKind {
@@ -199,7 +282,12 @@ func (t TimeStamp) Byte() []byte {
 }
 
 func NewTimeStamp() (*TimeStamp, error) {
-	now := uint32(time.Now().Unix())
+	return NewTimeStampAt(time.Now())
+}
+
+// NewTimeStampAt returns the timestamp option whose TSval is at.
+func NewTimeStampAt(at time.Time) (*TimeStamp, error) {
+	now := uint32(at.Unix())
 	tsval := bytes.NewBuffer(make([]byte, 0))
 	tsecr := make([]byte, 4)
 	if err := binary.Write(tsval, binary.BigEndian, now); err != nil {
@@ -210,7 +298,47 @@ func NewTimeStamp() (*TimeStamp, error) {
 }
 
 func (t TimeStamp) Exchange() TimeStamp {
-	tsval := t.Data()[0:4]
-	tsecr := t.Data()[4:]
-	return append(tsecr, tsval...)
+	// copy not to overwrite the following options in the received buffer
+	ex := make(TimeStamp, 0, 8)
+	ex = append(ex, t.Data()[4:8]...)
+	return append(ex, t.Data()[0:4]...)
+}
+
+// MD5Signature is the TCP MD5 signature option (RFC 2385).
+type MD5Signature []byte
+
+func (MD5Signature) Kind() OptionKind {
+	return OptionKind(19)
+}
+
+func (MD5Signature) Length() int {
+	return 18
+}
+
+func (m MD5Signature) Data() []byte {
+	return m
+}
+
+func (m MD5Signature) Byte() []byte {
+	return append([]byte{byte(19), byte(18)}, m.Data()...)
+}
+
+// FastOpenCookie is the TCP Fast Open cookie option (RFC 7413).
+// It is always written with the IANA assigned kind.
+type FastOpenCookie []byte
+
+func (FastOpenCookie) Kind() OptionKind {
+	return OptionKind(34)
+}
+
+func (c FastOpenCookie) Length() int {
+	return len(c) + 2
+}
+
+func (c FastOpenCookie) Data() []byte {
+	return c
+}
+
+func (c FastOpenCookie) Byte() []byte {
+	return append([]byte{byte(34), byte(c.Length())}, c.Data()...)
 }
@@ -50,3 +50,33 @@ func TestTimeStamp_Exchange(t *testing.T) {
 		t.Fatalf("base: %v :: exchanged: %v", ts.Data(), ex.Data())
 	}
 }
+
+func TestOptionsFromByteFastOpen(t *testing.T) {
+	data := []byte{
+		0x22, 0x0a, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08,
+		0xfe, 0x06, 0xf9, 0x89, 0x0a, 0x0b,
+		0x22, 0x02,
+	}
+	ops, err := OptionsFromByte(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ops) != 3 {
+		t.Fatalf("actual length: %d", len(ops))
+	}
+	cookie := ops.FastOpenCookie()
+	if cookie == nil || len(*cookie) != 8 || (*cookie)[7] != 0x08 {
+		t.Fatalf("actual: %v", cookie)
+	}
+	exp, ok := ops[1].(FastOpenCookie)
+	if !ok || len(exp) != 2 || exp[0] != 0x0a {
+		t.Fatalf("actual: %v", ops[1])
+	}
+	req, ok := ops[2].(FastOpenCookie)
+	if !ok || len(req) != 0 {
+		t.Fatalf("actual: %v", ops[2])
+	}
+	if len(cookie.Byte()) != cookie.Length() {
+		t.Fatalf("actual: %d", len(cookie.Byte()))
+	}
+}
@@ -2,6 +2,8 @@ package tcp
 
 import (
 	"bytes"
+	"crypto/md5"
+	"crypto/subtle"
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
@@ -38,6 +40,8 @@ type Packet struct {
 	Header Header
 	Option Options
 	Data   []byte
+	// MD5Key is the key to sign the segment when the checksum is calculated.
+	MD5Key []byte
 }
 
 type OffsetControlFlag uint16
@@ -55,11 +59,6 @@ func (of OffsetControlFlag) Offset() int {
 	return 4 * int(of8>>4)
 }
 
-func (of OffsetControlFlag) changeHeaderLength(diff int) OffsetControlFlag {
-	now := of.Offset()
-	return newOffsetControlFlag(uint8(now+diff), of.ControlFlag())
-}
-
 func (of OffsetControlFlag) ControlFlag() ControlFlag {
 	return ControlFlag(uint8(of))
 }
@@ -168,6 +167,9 @@ func New(data []byte) (*Packet, error) {
 	if err := binary.Read(buf, binary.BigEndian, header); err != nil {
 		return nil, fmt.Errorf("failed to read header: %v", err)
 	}
+	if header.OffsetControlFlag.Offset() < 20 {
+		return nil, fmt.Errorf("invalid data offset: %d", header.OffsetControlFlag.Offset())
+	}
 	optionLength := header.OffsetControlFlag.Offset() - 20
 	packet := &Packet{
 		Header: *header,
@@ -204,6 +206,11 @@ func (tp *Packet) Serialize() ([]byte, error) {
 
 func (tp *Packet) ReCalculateChecksum(src, dst ipv4.IPAddress) error {
 	tp.Header.Checksum = uint16(0)
+	if tp.MD5Key != nil {
+		if err := tp.sign(src, dst); err != nil {
+			return err
+		}
+	}
 	pseudo, err := newPseudoHeader(src, dst, tp)
 	if err != nil {
 		return err
@@ -246,19 +253,91 @@ func Build(src, dst uint16, seq, ack uint32, flag ControlFlag, window, urgent ui
 	return packet, nil
 }
 
+// SetFlag adds the control flags to the header.
+func (tp *Packet) SetFlag(flag ControlFlag) {
+	of := tp.Header.OffsetControlFlag
+	tp.Header.OffsetControlFlag = newOffsetControlFlag(uint8(of.Offset()), of.ControlFlag()|flag)
+}
+
 func (tp *Packet) AddOption(ops Options) {
 	totalLength := 0
 	for _, op := range ops {
 		totalLength += op.Length()
 	}
-	nopPadding := 4 - (totalLength % 4)
+	nopPadding := (4 - totalLength%4) % 4
 	if nopPadding != 0 {
 		for i := 0; i < nopPadding; i++ {
 			ops = append(ops, NoOperation{})
 		}
 	}
 	tp.Option = ops
-	tp.Header.OffsetControlFlag = tp.Header.OffsetControlFlag.changeHeaderLength(totalLength + nopPadding)
+	tp.Header.OffsetControlFlag = newOffsetControlFlag(uint8(20+totalLength+nopPadding), tp.Header.OffsetControlFlag.ControlFlag())
+}
+
+// AddSignature adds the md5 signature option, the digest is filled when the checksum is calculated.
+func (tp *Packet) AddSignature(key []byte) {
+	tp.MD5Key = key
+	if tp.Option.MD5Signature() != nil {
+		return
+	}
+	// drop the padding to make room for the signature
+	ops := Options{MD5Signature(make([]byte, md5.Size))}
+	for _, op := range tp.Option {
+		if op.Kind() == Nop || op.Kind() == End {
+			continue
+		}
+		ops = append(ops, op)
+	}
+	tp.AddOption(ops)
+}
+
+// Digest calculates the md5 signature (RFC 2385) of the segment.
+func (tp *Packet) Digest(src, dst ipv4.IPAddress, key []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0))
+	// pseudo header
+	buf.Write(src.Bytes())
+	buf.Write(dst.Bytes())
+	buf.Write([]byte{0, byte(ipv4.IPTCPProtocol)})
+	if err := binary.Write(buf, binary.BigEndian, uint16(tp.Length())); err != nil {
+		return nil, err
+	}
+	// tcp header excluding options with zero checksum
+	header := tp.Header
+	header.Checksum = 0
+	if err := binary.Write(buf, binary.BigEndian, header); err != nil {
+		return nil, err
+	}
+	buf.Write(tp.Data)
+	buf.Write(key)
+	sum := md5.Sum(buf.Bytes())
+	return sum[:], nil
+}
+
+// VerifySignature reports whether the segment has a valid md5 signature.
+func (tp *Packet) VerifySignature(src, dst ipv4.IPAddress, key []byte) bool {
+	sig := tp.Option.MD5Signature()
+	if sig == nil {
+		return false
+	}
+	digest, err := tp.Digest(src, dst, key)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(digest, *sig) == 1
+}
+
+func (tp *Packet) sign(src, dst ipv4.IPAddress) error {
+	digest, err := tp.Digest(src, dst, tp.MD5Key)
+	if err != nil {
+		return err
+	}
+	for i, op := range tp.Option {
+		if op.Kind() == MD5 {
+			tp.Option[i] = MD5Signature(digest)
+			return nil
+		}
+	}
+	return fmt.Errorf("md5 signature option is not added")
 }
 
 func (tp *Packet) Length() uint32 {
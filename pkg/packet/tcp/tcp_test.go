@@ -2,7 +2,10 @@ package tcp
 
 import (
 	"encoding/binary"
+	"encoding/hex"
 	"testing"
+
+	"github.com/terassyi/gotcp/pkg/packet/ipv4"
 )
 
 var syn_packet_data = []byte{
@@ -64,3 +67,88 @@ func TestAddOption(t *testing.T) {
 		t.Fatalf("actual offset value: %d", packet.Header.OffsetControlFlag.Offset())
 	}
 }
+
+func TestSetFlag(t *testing.T) {
+	packet, err := New(syn_packet_data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet.SetFlag(ECN | CWR)
+	if packet.Header.OffsetControlFlag.ControlFlag() != SYN|ECN|CWR {
+		t.Fatalf("actual: %v", packet.Header.OffsetControlFlag.ControlFlag().String())
+	}
+	if packet.Header.OffsetControlFlag.Offset() != 40 {
+		t.Fatalf("actual offset: %v", packet.Header.OffsetControlFlag.Offset())
+	}
+}
+
+func TestDigest(t *testing.T) {
+	src := ipv4.IPAddress{10, 0, 0, 1}
+	dst := ipv4.IPAddress{10, 0, 0, 2}
+	key := []byte("bgp-secret")
+	tests := []struct {
+		src, dst ipv4.IPAddress
+		packet   *Packet
+		wanted   string
+	}{
+		{
+			src: src, dst: dst,
+			packet: &Packet{Header: Header{SourcePort: 40000, DestinationPort: 179, Sequence: 0x01020304,
+				OffsetControlFlag: newOffsetControlFlag(20, SYN), WindowSize: 65535, Checksum: 0xbeef}},
+			wanted: "98a016c2d2b8139fa668a44982eb84f8",
+		},
+		{
+			src: dst, dst: src,
+			packet: &Packet{Header: Header{SourcePort: 179, DestinationPort: 40000, Sequence: 1000, Ack: 2000,
+				OffsetControlFlag: newOffsetControlFlag(20, ACK|PSH), WindowSize: 512}, Data: []byte("OPEN")},
+			wanted: "f23590f7c68e3ee64dadd0585e398034",
+		},
+	}
+	for _, tt := range tests {
+		tt.packet.AddSignature(key)
+		if tt.packet.Header.OffsetControlFlag.Offset() != 40 {
+			t.Fatalf("actual offset: %d", tt.packet.Header.OffsetControlFlag.Offset())
+		}
+		digest, err := tt.packet.Digest(tt.src, tt.dst, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(digest) != tt.wanted {
+			t.Fatalf("actual: %x wanted: %s", digest, tt.wanted)
+		}
+	}
+}
+
+func TestSignature(t *testing.T) {
+	src := ipv4.IPAddress{10, 0, 0, 1}
+	dst := ipv4.IPAddress{10, 0, 0, 2}
+	packet, err := Build(40000, 179, 1, 0, SYN, 65535, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet.AddOption(Options{MaxSegmentSize(1460), WindowScale(7)})
+	packet.AddSignature([]byte("bgp-secret"))
+	if packet.Header.OffsetControlFlag.Offset() != 48 {
+		t.Fatalf("actual offset: %d", packet.Header.OffsetControlFlag.Offset())
+	}
+	if err := packet.ReCalculateChecksum(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	data, err := packet.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	received, err := New(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !received.VerifySignature(src, dst, []byte("bgp-secret")) {
+		t.Fatal("valid signature is not verified")
+	}
+	if received.VerifySignature(src, dst, []byte("wrong")) {
+		t.Fatal("signature with a wrong key is verified")
+	}
+	if received.VerifySignature(src, ipv4.IPAddress{10, 0, 0, 3}, []byte("bgp-secret")) {
+		t.Fatal("signature for another address is verified")
+	}
+}
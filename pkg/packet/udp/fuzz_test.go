@@ -0,0 +1,24 @@
+package udp
+
+import (
+	"bytes"
+	"testing"
+)
+
+func FuzzNew(f *testing.F) {
+	f.Add([]byte{0x00, 0x35, 0xc0, 0x00, 0x00, 0x0c, 0x00, 0x00, 0x61, 0x62, 0x63, 0x64})
+	f.Add([]byte{0x00, 0x35, 0xc0, 0x00, 0x00, 0x04, 0x00, 0x00})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		packet, err := New(data)
+		if err != nil {
+			return
+		}
+		b, err := packet.Serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, data[:packet.Header.Length]) {
+			t.Fatalf("round trip: %x => %x", data, b)
+		}
+	})
+}
@@ -0,0 +1,47 @@
+package udp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+const HeaderLength int = 8
+
+type Header struct {
+	SourcePort      uint16
+	DestinationPort uint16
+	Length          uint16 // length of the header and the data
+	Checksum        uint16
+}
+
+type Packet struct {
+	Header Header
+	Data   []byte
+}
+
+func New(data []byte) (*Packet, error) {
+	header := &Header{}
+	buf := bytes.NewBuffer(data)
+	if err := binary.Read(buf, binary.BigEndian, header); err != nil {
+		return nil, fmt.Errorf("failed to read header: %v", err)
+	}
+	if int(header.Length) < HeaderLength || int(header.Length) > len(data) {
+		return nil, fmt.Errorf("invalid udp length: %d", header.Length)
+	}
+	return &Packet{
+		Header: *header,
+		Data:   data[HeaderLength:header.Length],
+	}, nil
+}
+
+func (up *Packet) Serialize() ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0, HeaderLength+len(up.Data)))
+	if err := binary.Write(buf, binary.BigEndian, up.Header); err != nil {
+		return nil, fmt.Errorf("failed to write: %v", err)
+	}
+	if err := binary.Write(buf, binary.BigEndian, up.Data); err != nil {
+		return nil, fmt.Errorf("failed to write: %v", err)
+	}
+	return buf.Bytes(), nil
+}
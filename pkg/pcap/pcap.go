@@ -0,0 +1,153 @@
+// Package pcap writes captured frames in the classic pcap and the pcapng format.
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// LinkType is the link layer header type of captured frames.
+type LinkType uint32
+
+const (
+	LinkTypeEthernet LinkType = 1
+	LinkTypeRaw      LinkType = 101 // ip packets without the link layer header
+)
+
+// DefaultSnapLen is the maximum length of each frame saved in files.
+const DefaultSnapLen int = 262144
+
+// Direction is the direction of the frame seen from the stack.
+type Direction uint8
+
+const (
+	Unknown Direction = iota
+	Inbound
+	Outbound
+)
+
+func (d Direction) String() string {
+	switch d {
+	case Inbound:
+		return "in"
+	case Outbound:
+		return "out"
+	default:
+		return "unknown"
+	}
+}
+
+// Writer writes captured frames.
+type Writer interface {
+	WritePacket(ts time.Time, dir Direction, data []byte) error
+}
+
+const (
+	pcapMagic        uint32 = 0xa1b2c3d4 // timestamps in microseconds
+	pcapMagicNano    uint32 = 0xa1b23c4d // timestamps in nanoseconds
+	pcapVersionMajor uint16 = 2
+	pcapVersionMinor uint16 = 4
+)
+
+type fileHeader struct {
+	Magic        uint32
+	VersionMajor uint16
+	VersionMinor uint16
+	ThisZone     int32
+	SigFigs      uint32
+	SnapLen      uint32
+	LinkType     LinkType
+}
+
+type recordHeader struct {
+	Sec     uint32
+	Usec    uint32
+	InclLen uint32
+	OrigLen uint32
+}
+
+// pcapWriter writes the classic pcap format. The direction is not recorded in this format.
+type pcapWriter struct {
+	w       io.Writer
+	snapLen int
+	mutex   sync.Mutex
+}
+
+// NewWriter writes the file header of the classic pcap format to w.
+func NewWriter(w io.Writer, linkType LinkType, snapLen int) (Writer, error) {
+	if snapLen <= 0 {
+		snapLen = DefaultSnapLen
+	}
+	header := fileHeader{
+		Magic:        pcapMagic,
+		VersionMajor: pcapVersionMajor,
+		VersionMinor: pcapVersionMinor,
+		SnapLen:      uint32(snapLen),
+		LinkType:     linkType,
+	}
+	if err := binary.Write(w, binary.LittleEndian, header); err != nil {
+		return nil, fmt.Errorf("failed to write pcap header: %v", err)
+	}
+	return &pcapWriter{w: w, snapLen: snapLen}, nil
+}
+
+func (p *pcapWriter) WritePacket(ts time.Time, _ Direction, data []byte) error {
+	captured := data
+	if len(captured) > p.snapLen {
+		captured = captured[:p.snapLen]
+	}
+	header := recordHeader{
+		Sec:     uint32(ts.Unix()),
+		Usec:    uint32(ts.Nanosecond() / 1000),
+		InclLen: uint32(len(captured)),
+		OrigLen: uint32(len(data)),
+	}
+	// a record is written at once not to leave broken records in the file
+	buf := bytes.NewBuffer(make([]byte, 0, 16+len(captured)))
+	if err := binary.Write(buf, binary.LittleEndian, header); err != nil {
+		return err
+	}
+	buf.Write(captured)
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if _, err := p.w.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to write pcap record: %v", err)
+	}
+	return nil
+}
+
+// File is the capture file.
+type File struct {
+	Writer
+	file *os.File
+}
+
+// Create creates the capture file of the link type.
+// The pcapng format is used when the extension is .pcapng, otherwise the classic pcap format.
+func Create(path string, linkType LinkType) (*File, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	var w Writer
+	if filepath.Ext(path) == ".pcapng" {
+		w, err = NewNgWriter(f, linkType, DefaultSnapLen)
+	} else {
+		w, err = NewWriter(f, linkType, DefaultSnapLen)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &File{Writer: w, file: f}, nil
+}
+
+func (f *File) Close() error {
+	return f.file.Close()
+}
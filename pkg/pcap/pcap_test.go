@@ -0,0 +1,91 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestWriter(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w, err := NewWriter(buf, LinkTypeEthernet, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Unix(1600000000, 123456789)
+	if err := w.WritePacket(ts, Outbound, []byte{1, 2, 3, 4, 5, 6}); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+	if len(b) != 24+16+4 {
+		t.Fatalf("actual length: %d", len(b))
+	}
+	if magic := binary.LittleEndian.Uint32(b[0:4]); magic != pcapMagic {
+		t.Fatalf("actual magic: %x", magic)
+	}
+	if link := binary.LittleEndian.Uint32(b[20:24]); link != uint32(LinkTypeEthernet) {
+		t.Fatalf("actual link type: %d", link)
+	}
+	record := b[24:]
+	if sec := binary.LittleEndian.Uint32(record[0:4]); sec != 1600000000 {
+		t.Fatalf("actual sec: %d", sec)
+	}
+	if usec := binary.LittleEndian.Uint32(record[4:8]); usec != 123456 {
+		t.Fatalf("actual usec: %d", usec)
+	}
+	// truncated by the snap length
+	if incl, orig := binary.LittleEndian.Uint32(record[8:12]), binary.LittleEndian.Uint32(record[12:16]); incl != 4 || orig != 6 {
+		t.Fatalf("actual included: %d original: %d", incl, orig)
+	}
+	if !bytes.Equal(record[16:], []byte{1, 2, 3, 4}) {
+		t.Fatalf("actual data: %v", record[16:])
+	}
+}
+
+func TestNgWriter(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w, err := NewNgWriter(buf, LinkTypeEthernet, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Unix(0, 0x0000000123456789)
+	if err := w.WritePacket(ts, Inbound, []byte{1, 2, 3, 4, 5}); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+	var blocks [][]byte
+	for len(b) > 0 {
+		length := binary.LittleEndian.Uint32(b[4:8])
+		if length%4 != 0 || binary.LittleEndian.Uint32(b[length-4:length]) != length {
+			t.Fatalf("invalid block length: %d", length)
+		}
+		blocks = append(blocks, b[:length])
+		b = b[length:]
+	}
+	if len(blocks) != 3 {
+		t.Fatalf("actual blocks: %d", len(blocks))
+	}
+	for i, typ := range []uint32{blockTypeSectionHeader, blockTypeInterface, blockTypeEnhancedPacket} {
+		if actual := binary.LittleEndian.Uint32(blocks[i][0:4]); actual != typ {
+			t.Fatalf("block %d: actual type: %x", i, actual)
+		}
+	}
+	if magic := binary.LittleEndian.Uint32(blocks[0][8:12]); magic != byteOrderMagic {
+		t.Fatalf("actual magic: %x", magic)
+	}
+	epb := blocks[2][8:]
+	if high, low := binary.LittleEndian.Uint32(epb[4:8]), binary.LittleEndian.Uint32(epb[8:12]); high != 0x1 || low != 0x23456789 {
+		t.Fatalf("actual timestamp: %x %x", high, low)
+	}
+	if captured := binary.LittleEndian.Uint32(epb[12:16]); captured != 5 {
+		t.Fatalf("actual captured length: %d", captured)
+	}
+	if !bytes.Equal(epb[20:28], []byte{1, 2, 3, 4, 5, 0, 0, 0}) {
+		t.Fatalf("actual data: %v", epb[20:28])
+	}
+	// epb_flags option
+	if code, flags := binary.LittleEndian.Uint16(epb[28:30]), binary.LittleEndian.Uint32(epb[32:36]); code != optionEnhancedPacketFlags || flags&0x3 != 1 {
+		t.Fatalf("actual option: %d flags: %x", code, flags)
+	}
+}
@@ -0,0 +1,105 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	blockTypeSectionHeader    uint32 = 0x0a0d0d0a
+	blockTypeInterface        uint32 = 0x00000001
+	blockTypeSimplePacket     uint32 = 0x00000003
+	blockTypeEnhancedPacket   uint32 = 0x00000006
+	byteOrderMagic            uint32 = 0x1a2b3c4d
+	optionEndOfOpt            uint16 = 0
+	optionInterfaceTsResol    uint16 = 9
+	optionEnhancedPacketFlags uint16 = 2
+)
+
+// ngWriter writes the pcapng format with a section of one interface.
+// Timestamps are in nanoseconds and the direction is recorded in the flags of each packet.
+type ngWriter struct {
+	w       io.Writer
+	snapLen int
+	mutex   sync.Mutex
+}
+
+// NewNgWriter writes the section header and the interface description of the pcapng format to w.
+func NewNgWriter(w io.Writer, linkType LinkType, snapLen int) (Writer, error) {
+	if snapLen <= 0 {
+		snapLen = DefaultSnapLen
+	}
+	shb := new(bytes.Buffer)
+	binary.Write(shb, binary.LittleEndian, byteOrderMagic)
+	binary.Write(shb, binary.LittleEndian, uint16(1)) // major version
+	binary.Write(shb, binary.LittleEndian, uint16(0)) // minor version
+	binary.Write(shb, binary.LittleEndian, int64(-1)) // section length is not specified
+	if _, err := w.Write(block(blockTypeSectionHeader, shb.Bytes())); err != nil {
+		return nil, fmt.Errorf("failed to write section header block: %v", err)
+	}
+	idb := new(bytes.Buffer)
+	binary.Write(idb, binary.LittleEndian, uint16(linkType))
+	binary.Write(idb, binary.LittleEndian, uint16(0)) // reserved
+	binary.Write(idb, binary.LittleEndian, uint32(snapLen))
+	writeOption(idb, optionInterfaceTsResol, []byte{9}) // 10^-9
+	writeOption(idb, optionEndOfOpt, nil)
+	if _, err := w.Write(block(blockTypeInterface, idb.Bytes())); err != nil {
+		return nil, fmt.Errorf("failed to write interface description block: %v", err)
+	}
+	return &ngWriter{w: w, snapLen: snapLen}, nil
+}
+
+func (n *ngWriter) WritePacket(ts time.Time, dir Direction, data []byte) error {
+	captured := data
+	if len(captured) > n.snapLen {
+		captured = captured[:n.snapLen]
+	}
+	nano := uint64(ts.UnixNano())
+	epb := bytes.NewBuffer(make([]byte, 0, 40+len(captured)))
+	binary.Write(epb, binary.LittleEndian, uint32(0)) // interface id
+	binary.Write(epb, binary.LittleEndian, uint32(nano>>32))
+	binary.Write(epb, binary.LittleEndian, uint32(nano))
+	binary.Write(epb, binary.LittleEndian, uint32(len(captured)))
+	binary.Write(epb, binary.LittleEndian, uint32(len(data)))
+	epb.Write(captured)
+	epb.Write(make([]byte, padding(len(captured))))
+	if dir != Unknown {
+		flags := make([]byte, 4)
+		binary.LittleEndian.PutUint32(flags, uint32(dir)) // inbound is 1 and outbound is 2 in the lowest 2 bits
+		writeOption(epb, optionEnhancedPacketFlags, flags)
+		writeOption(epb, optionEndOfOpt, nil)
+	}
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if _, err := n.w.Write(block(blockTypeEnhancedPacket, epb.Bytes())); err != nil {
+		return fmt.Errorf("failed to write enhanced packet block: %v", err)
+	}
+	return nil
+}
+
+// block frames the body with the block type and the total length.
+func block(typ uint32, body []byte) []byte {
+	length := uint32(12 + len(body))
+	buf := bytes.NewBuffer(make([]byte, 0, length))
+	binary.Write(buf, binary.LittleEndian, typ)
+	binary.Write(buf, binary.LittleEndian, length)
+	buf.Write(body)
+	binary.Write(buf, binary.LittleEndian, length)
+	return buf.Bytes()
+}
+
+func writeOption(buf *bytes.Buffer, code uint16, value []byte) {
+	binary.Write(buf, binary.LittleEndian, code)
+	binary.Write(buf, binary.LittleEndian, uint16(len(value)))
+	buf.Write(value)
+	buf.Write(make([]byte, padding(len(value))))
+}
+
+// padding returns the length to align to 32 bits.
+func padding(length int) int {
+	return (4 - length%4) % 4
+}
@@ -0,0 +1,336 @@
+package pcap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+	"time"
+)
+
+// Packet is the frame read from the capture file.
+type Packet struct {
+	Timestamp time.Time
+	Direction Direction // Unknown when the format does not record it
+	Length    int       // original length of the frame
+	Data      []byte
+}
+
+// Reader reads frames from the capture file.
+type Reader interface {
+	// ReadPacket returns io.EOF after the last frame.
+	ReadPacket() (*Packet, error)
+	LinkType() LinkType
+}
+
+// NewReader reads the classic pcap or the pcapng format detected by the magic number.
+func NewReader(r io.Reader) (Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(4)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read magic number: %v", err)
+	}
+	if binary.LittleEndian.Uint32(magic) == blockTypeSectionHeader {
+		return newNgReader(br)
+	}
+	return newPcapReader(br)
+}
+
+// ReadFile is the capture file opened to read.
+type ReadFile struct {
+	Reader
+	file *os.File
+}
+
+// Open opens the capture file of the classic pcap or the pcapng format.
+func Open(path string) (*ReadFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &ReadFile{Reader: r, file: f}, nil
+}
+
+func (f *ReadFile) Close() error {
+	return f.file.Close()
+}
+
+// maxSnapLen is the upper bound of the record length accepted from files.
+const maxSnapLen uint32 = 16 << 20
+
+type pcapReader struct {
+	r        io.Reader
+	order    binary.ByteOrder
+	nano     bool
+	linkType LinkType
+	snapLen  uint32
+}
+
+func newPcapReader(r io.Reader) (*pcapReader, error) {
+	header := make([]byte, 24)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("failed to read pcap header: %v", err)
+	}
+	p := &pcapReader{r: r}
+	switch {
+	case binary.LittleEndian.Uint32(header) == pcapMagic:
+		p.order = binary.LittleEndian
+	case binary.BigEndian.Uint32(header) == pcapMagic:
+		p.order = binary.BigEndian
+	case binary.LittleEndian.Uint32(header) == pcapMagicNano:
+		p.order, p.nano = binary.LittleEndian, true
+	case binary.BigEndian.Uint32(header) == pcapMagicNano:
+		p.order, p.nano = binary.BigEndian, true
+	default:
+		return nil, fmt.Errorf("unknown capture file format: %x", header[:4])
+	}
+	p.snapLen = p.order.Uint32(header[16:20])
+	p.linkType = LinkType(p.order.Uint32(header[20:24]))
+	return p, nil
+}
+
+func (p *pcapReader) LinkType() LinkType {
+	return p.linkType
+}
+
+// maxRecordLen is the larger of the snapshot length of the file and DefaultSnapLen,
+// bounded by maxSnapLen not to allocate the buffer of a corrupted length.
+func (p *pcapReader) maxRecordLen() uint32 {
+	n := uint32(DefaultSnapLen)
+	if p.snapLen > n {
+		n = p.snapLen
+	}
+	if n > maxSnapLen {
+		n = maxSnapLen
+	}
+	return n
+}
+
+func (p *pcapReader) ReadPacket() (*Packet, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(p.r, header); err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("failed to read pcap record: %v", err)
+	}
+	sec := int64(p.order.Uint32(header[0:4]))
+	frac := int64(p.order.Uint32(header[4:8]))
+	incl := p.order.Uint32(header[8:12])
+	if incl > p.maxRecordLen() {
+		return nil, fmt.Errorf("too large pcap record: %d", incl)
+	}
+	if !p.nano {
+		frac *= 1000
+	}
+	data := make([]byte, incl)
+	if _, err := io.ReadFull(p.r, data); err != nil {
+		return nil, fmt.Errorf("failed to read pcap record: %v", err)
+	}
+	return &Packet{
+		Timestamp: time.Unix(sec, frac),
+		Length:    int(p.order.Uint32(header[12:16])),
+		Data:      data,
+	}, nil
+}
+
+type ngInterface struct {
+	linkType LinkType
+	// timestamps are in units of 10^-resolution seconds or 2^-resolution when the highest bit is set
+	resolution uint8
+}
+
+type ngReader struct {
+	r          io.Reader
+	order      binary.ByteOrder
+	interfaces []ngInterface
+}
+
+func newNgReader(r io.Reader) (*ngReader, error) {
+	n := &ngReader{r: r}
+	typ, _, err := n.readBlock()
+	if err != nil {
+		return nil, err
+	}
+	if typ != blockTypeSectionHeader {
+		return nil, fmt.Errorf("pcapng file does not start with section header block")
+	}
+	return n, nil
+}
+
+// readBlock returns the type and the body of the next block.
+// The byte order is determined by the section header block.
+func (n *ngReader) readBlock() (uint32, []byte, error) {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(n.r, header[:8]); err != nil {
+		if err == io.EOF {
+			return 0, nil, io.EOF
+		}
+		return 0, nil, fmt.Errorf("failed to read pcapng block: %v", err)
+	}
+	if binary.LittleEndian.Uint32(header[0:4]) == blockTypeSectionHeader {
+		if _, err := io.ReadFull(n.r, header[8:12]); err != nil {
+			return 0, nil, fmt.Errorf("failed to read section header block: %v", err)
+		}
+		switch {
+		case binary.LittleEndian.Uint32(header[8:12]) == byteOrderMagic:
+			n.order = binary.LittleEndian
+		case binary.BigEndian.Uint32(header[8:12]) == byteOrderMagic:
+			n.order = binary.BigEndian
+		default:
+			return 0, nil, fmt.Errorf("invalid byte order magic: %x", header[8:12])
+		}
+	}
+	typ := n.order.Uint32(header[0:4])
+	length := n.order.Uint32(header[4:8])
+	read := uint32(8)
+	if typ == blockTypeSectionHeader {
+		read = 12
+	}
+	if length < read+4 || length%4 != 0 || length > uint32(DefaultSnapLen)+1024 {
+		return 0, nil, fmt.Errorf("invalid pcapng block length: %d", length)
+	}
+	rest := make([]byte, length-read)
+	if _, err := io.ReadFull(n.r, rest); err != nil {
+		return 0, nil, fmt.Errorf("failed to read pcapng block: %v", err)
+	}
+	if n.order.Uint32(rest[len(rest)-4:]) != length {
+		return 0, nil, fmt.Errorf("pcapng block length mismatch")
+	}
+	if typ == blockTypeSectionHeader {
+		return typ, append(header[8:12], rest[:len(rest)-4]...), nil
+	}
+	return typ, rest[:len(rest)-4], nil
+}
+
+func (n *ngReader) readInterface(body []byte) error {
+	if len(body) < 8 {
+		return fmt.Errorf("interface description block is too short")
+	}
+	iface := ngInterface{
+		linkType:   LinkType(n.order.Uint16(body[0:2])),
+		resolution: 6,
+	}
+	options := body[8:]
+	for len(options) >= 4 {
+		code := n.order.Uint16(options[0:2])
+		length := int(n.order.Uint16(options[2:4]))
+		if code == optionEndOfOpt || 4+length > len(options) {
+			break
+		}
+		if code == optionInterfaceTsResol && length >= 1 {
+			iface.resolution = options[4]
+		}
+		options = options[4+length+padding(length):]
+	}
+	n.interfaces = append(n.interfaces, iface)
+	return nil
+}
+
+func (n *ngReader) LinkType() LinkType {
+	if len(n.interfaces) == 0 {
+		return LinkTypeEthernet
+	}
+	return n.interfaces[0].linkType
+}
+
+func (n *ngReader) ReadPacket() (*Packet, error) {
+	for {
+		typ, body, err := n.readBlock()
+		if err != nil {
+			return nil, err
+		}
+		switch typ {
+		case blockTypeSectionHeader:
+			// interface ids are numbered in each section
+			n.interfaces = nil
+		case blockTypeInterface:
+			if err := n.readInterface(body); err != nil {
+				return nil, err
+			}
+		case blockTypeEnhancedPacket:
+			return n.readEnhancedPacket(body)
+		case blockTypeSimplePacket:
+			if len(body) < 4 {
+				return nil, fmt.Errorf("simple packet block is too short")
+			}
+			length := int(n.order.Uint32(body[0:4]))
+			data := body[4:]
+			if length < len(data) {
+				data = data[:length]
+			}
+			return &Packet{Length: length, Data: data}, nil
+		default:
+			// other blocks such as statistics are skipped
+		}
+	}
+}
+
+func (n *ngReader) readEnhancedPacket(body []byte) (*Packet, error) {
+	if len(body) < 20 {
+		return nil, fmt.Errorf("enhanced packet block is too short")
+	}
+	id := int(n.order.Uint32(body[0:4]))
+	if id >= len(n.interfaces) {
+		return nil, fmt.Errorf("unknown interface id: %d", id)
+	}
+	ts := uint64(n.order.Uint32(body[4:8]))<<32 | uint64(n.order.Uint32(body[8:12]))
+	captured := int(n.order.Uint32(body[12:16]))
+	if 20+captured > len(body) {
+		return nil, fmt.Errorf("invalid captured length: %d", captured)
+	}
+	packet := &Packet{
+		Timestamp: timestamp(ts, n.interfaces[id].resolution),
+		Length:    int(n.order.Uint32(body[16:20])),
+		Data:      body[20 : 20+captured],
+	}
+	options := body[20+captured+padding(captured):]
+	for len(options) >= 4 {
+		code := n.order.Uint16(options[0:2])
+		length := int(n.order.Uint16(options[2:4]))
+		if code == optionEndOfOpt || 4+length > len(options) {
+			break
+		}
+		if code == optionEnhancedPacketFlags && length == 4 {
+			packet.Direction = Direction(n.order.Uint32(options[4:8]) & 0x3)
+		}
+		options = options[4+length+padding(length):]
+	}
+	return packet, nil
+}
+
+func timestamp(ts uint64, resolution uint8) time.Time {
+	if resolution&0x80 != 0 {
+		// power of 2
+		exp := resolution & 0x7f
+		if exp >= 64 {
+			return time.Unix(0, 0)
+		}
+		if exp == 0 {
+			return time.Unix(int64(ts), 0)
+		}
+		frac := ts & (1<<exp - 1)
+		hi, lo := bits.Mul64(frac, 1e9)
+		return time.Unix(int64(ts>>exp), int64(hi<<(64-exp)|lo>>exp))
+	}
+	units := uint64(1)
+	for i := uint8(0); i < resolution && i < 19; i++ {
+		units *= 10
+	}
+	sec := ts / units
+	frac := ts % units
+	var nsec uint64
+	if units >= 1e9 {
+		nsec = frac / (units / 1e9)
+	} else {
+		nsec = frac * (1e9 / units)
+	}
+	return time.Unix(int64(sec), int64(nsec))
+}
@@ -0,0 +1,104 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReader(t *testing.T) {
+	frames := []struct {
+		ts   time.Time
+		dir  Direction
+		data []byte
+	}{
+		{time.Unix(1600000000, 1000), Inbound, []byte{1, 2, 3}},
+		{time.Unix(1600000001, 2000), Outbound, []byte{4, 5, 6, 7, 8}},
+	}
+	for _, format := range []struct {
+		name   string
+		writer func(io.Writer) (Writer, error)
+		dir    bool
+	}{
+		{"pcap", func(w io.Writer) (Writer, error) { return NewWriter(w, LinkTypeEthernet, 0) }, false},
+		{"pcapng", func(w io.Writer) (Writer, error) { return NewNgWriter(w, LinkTypeEthernet, 0) }, true},
+	} {
+		t.Run(format.name, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			w, err := format.writer(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, f := range frames {
+				if err := w.WritePacket(f.ts, f.dir, f.data); err != nil {
+					t.Fatal(err)
+				}
+			}
+			r, err := NewReader(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.LinkType() != LinkTypeEthernet {
+				t.Fatalf("actual link type: %d", r.LinkType())
+			}
+			for _, f := range frames {
+				packet, err := r.ReadPacket()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !packet.Timestamp.Equal(f.ts) || !bytes.Equal(packet.Data, f.data) || packet.Length != len(f.data) {
+					t.Fatalf("actual: %v", packet)
+				}
+				if format.dir && packet.Direction != f.dir {
+					t.Fatalf("actual direction: %s", packet.Direction)
+				}
+			}
+			if _, err := r.ReadPacket(); err != io.EOF {
+				t.Fatalf("actual: %v", err)
+			}
+		})
+	}
+}
+
+func TestReaderTooLargeRecord(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		snapLen int
+		incl    uint32
+		ok      bool
+	}{
+		{"default", 65535, uint32(DefaultSnapLen), true},
+		{"over default", 65535, uint32(DefaultSnapLen) + 1, false},
+		{"snaplen", 1 << 20, 1 << 20, true},
+		{"over snaplen", 1 << 20, 1<<20 + 1, false},
+		{"over bound", 0x7fffffff, maxSnapLen + 1, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			if _, err := NewWriter(buf, LinkTypeEthernet, tt.snapLen); err != nil {
+				t.Fatal(err)
+			}
+			header := make([]byte, 16)
+			binary.LittleEndian.PutUint32(header[8:12], tt.incl)
+			binary.LittleEndian.PutUint32(header[12:16], tt.incl)
+			buf.Write(header)
+			if tt.ok {
+				buf.Write(make([]byte, tt.incl))
+			}
+			r, err := NewReader(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			packet, err := r.ReadPacket()
+			if tt.ok && (err != nil || len(packet.Data) != int(tt.incl)) {
+				t.Fatalf("actual: %v", err)
+			}
+			if !tt.ok && (err == nil || !strings.Contains(err.Error(), "too large")) {
+				t.Fatalf("too large record is accepted: %v", err)
+			}
+		})
+	}
+}
@@ -2,7 +2,9 @@ package arp
 
 import (
 	"fmt"
+	"sync"
 
+	"github.com/terassyi/gotcp/pkg/clock"
 	"github.com/terassyi/gotcp/pkg/ioctl"
 	"github.com/terassyi/gotcp/pkg/logger"
 	"github.com/terassyi/gotcp/pkg/packet/arp"
@@ -19,7 +21,11 @@ type Arp struct {
 	Table      *Table
 	IpAddress  *ipv4.IPAddress
 	MacAddress *ethernet.HardwareAddress
-	Updated    chan struct{}
+	aliases    []ipv4.IPAddress // secondary addresses on the link
+	waiters    map[ipv4.IPAddress][]chan struct{}
+	mutex      sync.Mutex
+	output     func(dst *ethernet.HardwareAddress, data []byte) error
+	Clock      clock.Clock
 	logger     *logger.Logger
 }
 
@@ -27,7 +33,8 @@ func New(table *Table, debug bool) *Arp {
 	ap := &Arp{
 		ProtocolBuffer: proto.NewProtocolBuffer(),
 		Table:          table,
-		Updated:        make(chan struct{}),
+		waiters:        make(map[ipv4.IPAddress][]chan struct{}),
+		Clock:          clock.Real,
 		logger:         logger.New(debug, "arp"),
 	}
 	return ap
@@ -55,29 +62,70 @@ func (ap *Arp) SetAddr(name string) error {
 	return nil
 }
 
+// SetAddress configures the addresses statically.
+func (ap *Arp) SetAddress(ipaddr *ipv4.IPAddress, macaddr *ethernet.HardwareAddress) {
+	ap.IpAddress = ipaddr
+	ap.MacAddress = macaddr
+}
+
+// AddAddress adds the secondary address to reply for.
+func (ap *Arp) AddAddress(ipaddr *ipv4.IPAddress) {
+	ap.mutex.Lock()
+	defer ap.mutex.Unlock()
+	ap.aliases = append(ap.aliases, *ipaddr)
+}
+
+// owns returns true when the address is assigned to the link.
+func (ap *Arp) owns(ipaddr *ipv4.IPAddress) bool {
+	if ap.IpAddress != nil && *ipaddr == *ap.IpAddress {
+		return true
+	}
+	ap.mutex.Lock()
+	defer ap.mutex.Unlock()
+	for _, a := range ap.aliases {
+		if *ipaddr == a {
+			return true
+		}
+	}
+	return false
+}
+
+// SetClock sets the clock of the resolution timeout and the aging of the table.
+func (ap *Arp) SetClock(c clock.Clock) {
+	ap.Clock = c
+	ap.Table.Clock = c
+}
+
+// SetOutput sets the function to send arp packets in ethernet frames.
+func (ap *Arp) SetOutput(output func(dst *ethernet.HardwareAddress, data []byte) error) {
+	ap.output = output
+}
+
 func (ap *Arp) Recv(buf []byte) {
-	ap.Buffer <- buf
+	// the buffer of the frame is not kept
+	ap.Buffer <- append([]byte(nil), buf...)
 }
 
 // Handle will be called with goroutine
 func (ap *Arp) Handle() {
 	//fmt.Println("[info] arp handle start")
 	for {
-		buf, ok := <-ap.Buffer
-		if ok {
-			//fmt.Println("[info] receive arp packet")
-			packet, err := arp.New(buf)
-			if err != nil {
-				ap.logger.Error(err)
-				return
-			}
-			//packet.Show()
-			if err := ap.manage(packet); err != nil {
-				ap.logger.Error(err)
-				return
-			}
-		} else {
-			ap.logger.Error("failed to recv")
+		var buf []byte
+		select {
+		case buf = <-ap.Buffer:
+		case <-ap.Done:
+			return
+		}
+		//fmt.Println("[info] receive arp packet")
+		packet, err := arp.New(buf)
+		if err != nil {
+			ap.logger.Error(err)
+			continue
+		}
+		//packet.Show()
+		if err := ap.manage(packet); err != nil {
+			ap.logger.Error(err)
+			continue
 		}
 	}
 }
@@ -110,7 +158,7 @@ func (ap *Arp) reply(packet *arp.Packet) error {
 		if ap.logger.DebugMode() {
 			//ap.Table.Show()
 		}
-		ap.Updated <- struct{}{}
+		ap.resolved(ipaddr)
 		return nil
 	}
 	//ap.Table.Show()
@@ -118,16 +166,86 @@ func (ap *Arp) reply(packet *arp.Packet) error {
 	if err != nil {
 		return err
 	}
-	ap.Updated <- struct{}{}
-	if ok {
+	ap.resolved(ipaddr)
+	if !ok {
 		return fmt.Errorf("cannot find an entry")
 	}
 	return nil
 }
 
-func (ap *Arp) request(packet *arp.Packet) error {
+// Wait returns the channel closed when the entry of the address is inserted or updated.
+// Call it before sending the request not to miss the reply.
+func (ap *Arp) Wait(ipaddr *ipv4.IPAddress) <-chan struct{} {
+	ap.mutex.Lock()
+	defer ap.mutex.Unlock()
+	ch := make(chan struct{})
+	ap.waiters[*ipaddr] = append(ap.waiters[*ipaddr], ch)
+	return ch
+}
 
-	return nil
+// Cancel removes the channel returned by Wait.
+func (ap *Arp) Cancel(ipaddr *ipv4.IPAddress, ch <-chan struct{}) {
+	ap.mutex.Lock()
+	defer ap.mutex.Unlock()
+	waiters := ap.waiters[*ipaddr]
+	for i, w := range waiters {
+		if w == ch {
+			ap.waiters[*ipaddr] = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(ap.waiters[*ipaddr]) == 0 {
+		delete(ap.waiters, *ipaddr)
+	}
+}
+
+func (ap *Arp) resolved(ipaddr *ipv4.IPAddress) {
+	ap.mutex.Lock()
+	defer ap.mutex.Unlock()
+	for _, ch := range ap.waiters[*ipaddr] {
+		close(ch)
+	}
+	delete(ap.waiters, *ipaddr)
+}
+
+// request replies to the request for our address and learns the sender (RFC 826).
+func (ap *Arp) request(packet *arp.Packet) error {
+	target, err := ipv4.Address(packet.TargetProtocolAddress)
+	if err != nil {
+		return err
+	}
+	if !ap.owns(target) {
+		return nil
+	}
+	macaddr, err := ethernet.Address(packet.SourceHardwareAddress)
+	if err != nil {
+		return err
+	}
+	ipaddr, err := ipv4.Address(packet.SourceProtocolAddress)
+	if err != nil {
+		return err
+	}
+	if ok, err := ap.Table.Update(macaddr, ipaddr); err != nil {
+		return err
+	} else if !ok {
+		if err := ap.Table.Insert(macaddr, ipaddr); err != nil {
+			return err
+		}
+	}
+	ap.resolved(ipaddr)
+	if ap.output == nil {
+		return fmt.Errorf("arp output is not set")
+	}
+	rep, err := ap.Reply(macaddr, ipaddr)
+	if err != nil {
+		return err
+	}
+	rep.SourceProtocolAddress = target.Bytes()
+	data, err := rep.Serialize()
+	if err != nil {
+		return err
+	}
+	return ap.output(macaddr, data)
 }
 
 func (ap *Arp) Request(targetProtocolAddress *ipv4.IPAddress) (*arp.Packet, error) {
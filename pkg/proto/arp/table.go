@@ -6,15 +6,20 @@ import (
 	"sync"
 	"time"
 
+	"github.com/terassyi/gotcp/pkg/clock"
 	"github.com/terassyi/gotcp/pkg/packet/ethernet"
 	"github.com/terassyi/gotcp/pkg/packet/ipv4"
 )
 
 type Table struct {
-	Entrys []*Entry
-	Mutex  sync.RWMutex
+	Entrys  []*Entry
+	Mutex   sync.RWMutex
+	Timeout time.Duration // entries not updated in timeout are expired, 0 never expires
+	Clock   clock.Clock
 }
 
+const defaultEntryTimeout time.Duration = 5 * time.Minute
+
 type Entry struct {
 	IpAddress  *ipv4.IPAddress
 	MacAddress *ethernet.HardwareAddress
@@ -23,8 +28,10 @@ type Entry struct {
 
 func NewTable() *Table {
 	return &Table{
-		Entrys: make([]*Entry, 0, 10),
-		Mutex:  sync.RWMutex{},
+		Entrys:  make([]*Entry, 0, 10),
+		Mutex:   sync.RWMutex{},
+		Timeout: defaultEntryTimeout,
+		Clock:   clock.Real,
 	}
 }
 
@@ -44,15 +51,40 @@ func (t *Table) Search(ipaddr *ipv4.IPAddress) *Entry {
 	}
 	for _, e := range t.Entrys {
 		if bytes.Equal(e.IpAddress.Bytes(), ipaddr.Bytes()) {
+			if t.expired(e) {
+				return nil
+			}
 			return e
 		}
 	}
 	return nil
 }
 
+func (t *Table) expired(e *Entry) bool {
+	return t.Timeout > 0 && t.Clock.Since(e.TimeStamp) >= t.Timeout
+}
+
+// Expire removes the expired entries.
+func (t *Table) Expire() {
+	t.Mutex.Lock()
+	defer t.Mutex.Unlock()
+	t.expire()
+}
+
+func (t *Table) expire() {
+	entries := t.Entrys[:0]
+	for _, e := range t.Entrys {
+		if !t.expired(e) {
+			entries = append(entries, e)
+		}
+	}
+	t.Entrys = entries
+}
+
 func (t *Table) Insert(macaddr *ethernet.HardwareAddress, ipaddr *ipv4.IPAddress) error {
 	t.Mutex.Lock()
 	defer t.Mutex.Unlock()
+	t.expire()
 	if len(t.Entrys) > 10 {
 		return fmt.Errorf("arp table is full")
 	}
@@ -62,6 +94,7 @@ func (t *Table) Insert(macaddr *ethernet.HardwareAddress, ipaddr *ipv4.IPAddress
 		}
 	}
 	e := NewEntry(ipaddr, macaddr)
+	e.TimeStamp = t.Clock.Now()
 	t.Entrys = append(t.Entrys, e)
 	return nil
 }
@@ -72,7 +105,7 @@ func (t *Table) Update(macaddr *ethernet.HardwareAddress, ipaddr *ipv4.IPAddress
 	for _, e := range t.Entrys {
 		if bytes.Equal(e.IpAddress.Bytes(), ipaddr.Bytes()) {
 			e.MacAddress = macaddr
-			e.TimeStamp = time.Now()
+			e.TimeStamp = t.Clock.Now()
 			return true, nil
 		}
 	}
@@ -0,0 +1,44 @@
+package arp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/terassyi/gotcp/pkg/clock"
+	"github.com/terassyi/gotcp/pkg/packet/ethernet"
+	"github.com/terassyi/gotcp/pkg/packet/ipv4"
+)
+
+func TestTableAging(t *testing.T) {
+	v := clock.NewVirtual(time.Unix(0, 0))
+	table := NewTable()
+	table.Clock = v
+	ip := &ipv4.IPAddress{10, 0, 0, 1}
+	mac := &ethernet.HardwareAddress{0x02, 0, 0, 0, 0, 1}
+	if err := table.Insert(mac, ip); err != nil {
+		t.Fatal(err)
+	}
+	v.Advance(table.Timeout - time.Second)
+	if table.Search(ip) == nil {
+		t.Fatal("entry expires early")
+	}
+	// update refreshes the entry
+	if ok, err := table.Update(mac, ip); !ok || err != nil {
+		t.Fatalf("update: %v %v", ok, err)
+	}
+	v.Advance(table.Timeout - time.Second)
+	if table.Search(ip) == nil {
+		t.Fatal("updated entry expires")
+	}
+	v.Advance(time.Second)
+	if table.Search(ip) != nil {
+		t.Fatal("entry does not expire")
+	}
+	table.Expire()
+	if len(table.Entrys) != 0 {
+		t.Fatalf("%d entries remain", len(table.Entrys))
+	}
+	if err := table.Insert(mac, ip); err != nil {
+		t.Fatal(err)
+	}
+}
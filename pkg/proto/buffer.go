@@ -1,9 +1,23 @@
 package proto
 
+import "sync"
+
 type ProtocolBuffer struct {
 	Buffer chan []byte
+	Done   chan struct{} // closed to stop the handler
+	once   sync.Once
 }
 
 func NewProtocolBuffer() *ProtocolBuffer {
-	return &ProtocolBuffer{Buffer: make(chan []byte, 4)}
+	return &ProtocolBuffer{
+		Buffer: make(chan []byte, 4),
+		Done:   make(chan struct{}),
+	}
+}
+
+// Stop makes the handler of the protocol return.
+func (pb *ProtocolBuffer) Stop() {
+	pb.once.Do(func() {
+		close(pb.Done)
+	})
 }
@@ -2,6 +2,7 @@ package ethernet
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/terassyi/gotcp/pkg/interfaces"
 	"github.com/terassyi/gotcp/pkg/packet/ethernet"
@@ -10,11 +11,17 @@ import (
 	"github.com/terassyi/gotcp/pkg/proto/arp"
 )
 
+const (
+	arpTimeout time.Duration = time.Second
+	arpRetry   int           = 3
+)
+
 type Ethernet struct {
 	*proto.ProtocolBuffer
 	iface   interfaces.Iface
 	address *ethernet.HardwareAddress
 	Arp     *arp.Arp
+	raw     bool // datagrams are passed without ethernet headers
 }
 
 func New(iface interfaces.Iface, arp *arp.Arp) (*Ethernet, error) {
@@ -26,11 +33,26 @@ func New(iface interfaces.Iface, arp *arp.Arp) (*Ethernet, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Ethernet{
+	return NewWithAddress(iface, addr, arp), nil
+}
+
+// NewWithAddress creates the protocol with the configured hardware address instead of the interface's one.
+func NewWithAddress(iface interfaces.Iface, addr *ethernet.HardwareAddress, arp *arp.Arp) *Ethernet {
+	e := &Ethernet{
 		iface:   iface,
 		address: addr,
 		Arp:     arp,
-	}, nil
+	}
+	arp.SetOutput(e.arpSend)
+	return e
+}
+
+// NewRaw creates the protocol on the layer 3 interface such as tun.
+// Ipv4 datagrams are sent as they are without arp, the hardware address is zero.
+func NewRaw(iface interfaces.Iface, arp *arp.Arp) *Ethernet {
+	e := NewWithAddress(iface, &ethernet.HardwareAddress{}, arp)
+	e.raw = true
+	return e
 }
 
 func (e *Ethernet) Name() string {
@@ -41,8 +63,17 @@ func (e *Ethernet) Address() *ethernet.HardwareAddress {
 	return e.address
 }
 
+// Raw returns true on the layer 3 interface.
+func (e *Ethernet) Raw() bool {
+	return e.raw
+}
+
 func (e *Ethernet) Close() error {
-	return e.Close()
+	return e.iface.Close()
+}
+
+func (e *Ethernet) Iface() interfaces.Iface {
+	return e.iface
 }
 
 func (e *Ethernet) Recv(buf []byte) (int, error) {
@@ -54,29 +85,13 @@ func (e *Ethernet) ipSend(dstmac *ethernet.HardwareAddress, dstip *ipv4.IPAddres
 	if dstmac == nil && dstip == nil {
 		return 0, fmt.Errorf("dest address is not specified.")
 	}
+	if e.raw {
+		return e.iface.Send(data)
+	}
 	if dstmac == nil {
-		entry := e.Arp.Table.Search(dstip)
-		if entry == nil {
-			// send Arp request
-			req, err := e.Arp.Request(dstip)
-			if err != nil {
-				return 0, err
-			}
-			reqByte, err := req.Serialize()
-			if err != nil {
-				return 0, err
-			}
-			if err := e.arpSend(&ethernet.BroadcastAddress, reqByte); err != nil {
-				return 0, err
-			}
-
-			// enqueue in wait queue
-			for {
-				_, ok := <-e.Arp.Updated
-				if ok {
-					return e.ipSend(dstmac, dstip, data)
-				}
-			}
+		entry, err := e.resolve(dstip)
+		if err != nil {
+			return 0, err
 		}
 		frame := ethernet.Build(*e.address, *entry.MacAddress, ethernet.ETHER_TYPE_IP, data)
 		frameBytes, err := frame.Serialize()
@@ -94,7 +109,45 @@ func (e *Ethernet) ipSend(dstmac *ethernet.HardwareAddress, dstip *ipv4.IPAddres
 	return e.iface.Send(frameBytes)
 }
 
+// resolve returns the arp entry, the request is sent again when no reply is received in arpTimeout.
+func (e *Ethernet) resolve(dstip *ipv4.IPAddress) (*arp.Entry, error) {
+	for i := 0; i < arpRetry; i++ {
+		wait := e.Arp.Wait(dstip)
+		if entry := e.Arp.Table.Search(dstip); entry != nil {
+			e.Arp.Cancel(dstip, wait)
+			return entry, nil
+		}
+		// send Arp request
+		req, err := e.Arp.Request(dstip)
+		if err != nil {
+			e.Arp.Cancel(dstip, wait)
+			return nil, err
+		}
+		reqByte, err := req.Serialize()
+		if err != nil {
+			e.Arp.Cancel(dstip, wait)
+			return nil, err
+		}
+		if err := e.arpSend(&ethernet.BroadcastAddress, reqByte); err != nil {
+			e.Arp.Cancel(dstip, wait)
+			return nil, err
+		}
+		select {
+		case <-wait:
+		case <-e.Arp.Clock.After(arpTimeout):
+			e.Arp.Cancel(dstip, wait)
+		}
+	}
+	if entry := e.Arp.Table.Search(dstip); entry != nil {
+		return entry, nil
+	}
+	return nil, fmt.Errorf("failed to resolve %s", dstip.String())
+}
+
 func (e *Ethernet) arpSend(dst *ethernet.HardwareAddress, data []byte) error {
+	if e.raw {
+		return fmt.Errorf("arp is not used on %s", e.iface.Name())
+	}
 	frame := ethernet.Build(*e.address, *dst, ethernet.ETHER_TYPE_ARP, data)
 	frameByte, err := frame.Serialize()
 	if err != nil {
@@ -1,39 +1,242 @@
 package icmp
 
 import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/terassyi/gotcp/pkg/clock"
 	"github.com/terassyi/gotcp/pkg/logger"
 	"github.com/terassyi/gotcp/pkg/packet/icmp"
+	"github.com/terassyi/gotcp/pkg/packet/ipv4"
 	"github.com/terassyi/gotcp/pkg/proto"
 )
 
 type Icmp struct {
 	*proto.ProtocolBuffer
-	logger *logger.Logger
+	queue   chan datagram
+	output  func(src *ipv4.IPAddress, dst ipv4.IPAddress, options ipv4.Options, data []byte) error
+	ident   uint16
+	seq     uint16
+	waiters map[uint32]chan ipv4.Options // keyed by ident and seq of echo requests, receives ip options of the reply
+	mutex   *sync.Mutex
+	Clock   clock.Clock
+	logger  *logger.Logger
+}
+
+type datagram struct {
+	src     ipv4.IPAddress
+	dst     ipv4.IPAddress
+	options ipv4.Options
+	data    []byte
 }
 
 func New(debug bool) *Icmp {
 	return &Icmp{
 		ProtocolBuffer: proto.NewProtocolBuffer(),
+		queue:          make(chan datagram, 16),
+		ident:          uint16(os.Getpid()),
+		waiters:        make(map[uint32]chan ipv4.Options),
+		mutex:          &sync.Mutex{},
+		Clock:          clock.Real,
 		logger:         logger.New(debug, "icmp"),
 	}
 }
 
-func (i *Icmp) Recv(buf []byte) {
-	i.Buffer <- buf
+// SetOutput sets the function to send icmp messages in ip datagrams with ip options.
+// The source address is chosen by the output when src is nil.
+func (i *Icmp) SetOutput(output func(src *ipv4.IPAddress, dst ipv4.IPAddress, options ipv4.Options, data []byte) error) {
+	i.output = output
+}
+
+// Recv queues the message from src to our address dst with ip options, the message is dropped when the queue is full.
+func (i *Icmp) Recv(src, dst ipv4.IPAddress, options ipv4.Options, buf []byte) {
+	// the buffer of the frame is not kept
+	select {
+	case i.queue <- datagram{src: src, dst: dst, options: options, data: append([]byte(nil), buf...)}:
+	default:
+		i.logger.Debug("icmp queue is full. drop message.")
+	}
 }
 
 func (i *Icmp) Handle() {
 	for {
-		buf, ok := <-i.Buffer
-		if ok {
-			packet, err := icmp.New(buf)
-			if err != nil {
-				i.logger.Errorf("icmp packet serialize error: %v", err)
-				return
-			}
-			if i.logger.DebugMode() {
-				packet.Show()
-			}
+		var d datagram
+		select {
+		case d = <-i.queue:
+		case <-i.Done:
+			return
+		}
+		packet, err := icmp.New(d.data)
+		if err != nil {
+			i.logger.Errorf("icmp packet serialize error: %v", err)
+			continue
+		}
+		if i.logger.DebugMode() {
+			packet.Show()
+		}
+		if err := i.handle(d, packet); err != nil {
+			i.logger.Error(err)
+		}
+	}
+}
+
+func (i *Icmp) handle(d datagram, packet *icmp.Packet) error {
+	switch packet.Header.Type {
+	case icmp.Echo:
+		rep, err := icmp.Build(icmp.EchoReply, icmp.EchoReplyCode, packet.Data)
+		if err != nil {
+			return err
 		}
+		// reply from the address the request is sent to
+		dst, options := replyOptions(d)
+		return i.send(&d.dst, dst, options, rep)
+	case icmp.EchoReply:
+		message, err := icmp.NewEchoMessage(packet.Data)
+		if err != nil {
+			return err
+		}
+		i.mutex.Lock()
+		defer i.mutex.Unlock()
+		key := uint32(message.Ident)<<16 | uint32(message.Seq)
+		if ch, ok := i.waiters[key]; ok {
+			ch <- d.options
+			delete(i.waiters, key)
+		}
+	default:
+	}
+	return nil
+}
+
+// replyOptions returns the destination and options of the echo reply.
+// Record route and timestamp options are returned to include the round trip,
+// and the reply goes back along the reversed source route (RFC 1122 3.2.2.6).
+func replyOptions(d datagram) (ipv4.IPAddress, ipv4.Options) {
+	var options ipv4.Options
+	if rr := d.options.RecordRoute(); rr != nil {
+		options = append(options, rr)
+	}
+	if ts := d.options.Timestamp(); ts != nil {
+		options = append(options, ts)
+	}
+	if sr := d.options.SourceRoute(); sr != nil {
+		hop, reversed := sr.Reverse(d.src)
+		if reversed != nil {
+			options = append(options, reversed)
+		}
+		return hop, options
+	}
+	return d.src, options
+}
+
+func (i *Icmp) send(src *ipv4.IPAddress, dst ipv4.IPAddress, options ipv4.Options, packet *icmp.Packet) error {
+	if i.output == nil {
+		return fmt.Errorf("icmp output is not set")
+	}
+	data, err := packet.Serialize()
+	if err != nil {
+		return err
+	}
+	return i.output(src, dst, options, data)
+}
+
+// Error sends the error message to the source of the original datagram.
+// original is the datagram from the ip header, the header and the leading 8 bytes of data are returned.
+// Messages are not sent in reply to icmp errors, fragments except the first one or non-unicast addresses (RFC 1122 3.2.2).
+func (i *Icmp) Error(typ icmp.Type, code uint8, original []byte) error {
+	if len(original) < 20 {
+		return fmt.Errorf("original datagram is too short")
+	}
+	ihl := int(original[0]&0x0f) * 4
+	if ihl < 20 || len(original) < ihl {
+		return fmt.Errorf("original datagram is too short")
+	}
+	if !errorAllowed(original, ihl) {
+		return nil
+	}
+	n := ihl + 8
+	if n > len(original) {
+		n = len(original)
+	}
+	// unused 4 bytes precede the original datagram
+	data := append(make([]byte, 4), original[:n]...)
+	packet, err := icmp.Build(typ, code, data)
+	if err != nil {
+		return err
+	}
+	src := ipv4.NewIPAddress(original[12:16])
+	dst := ipv4.NewIPAddress(original[16:20])
+	return i.send(&dst, src, nil, packet)
+}
+
+func errorAllowed(original []byte, ihl int) bool {
+	// not the first fragment
+	if binary.BigEndian.Uint16(original[6:8])&0x1fff != 0 {
+		return false
+	}
+	for _, addr := range [][]byte{original[12:16], original[16:20]} {
+		if addr[0] == 0 || addr[0] >= 224 || addr[0] == 127 || bytes.Equal(addr, []byte{255, 255, 255, 255}) {
+			return false
+		}
+	}
+	if ipv4.IPProtocol(original[9]) == ipv4.IPICMPv4Protocol && len(original) > ihl {
+		switch icmp.Type(original[ihl]) {
+		case icmp.EchoReply, icmp.Echo, icmp.RouterAdvertisement, icmp.RouterSolicitation,
+			icmp.Timestamp, icmp.TimestampReply, icmp.InformationRequest, icmp.InformationReply,
+			icmp.AddressMaskRequest, icmp.AddressMaskReply:
+		default:
+			// errors about icmp errors
+			return false
+		}
+	}
+	return true
+}
+
+// Ping sends an echo request to dst and returns the round trip time.
+func (i *Icmp) Ping(dst ipv4.IPAddress, timeout time.Duration) (time.Duration, error) {
+	rtt, _, err := i.PingOptions(dst, timeout, nil)
+	return rtt, err
+}
+
+// PingOptions sends an echo request with ip options such as record route,
+// and returns the round trip time and ip options of the reply.
+func (i *Icmp) PingOptions(dst ipv4.IPAddress, timeout time.Duration, options ipv4.Options) (time.Duration, ipv4.Options, error) {
+	i.mutex.Lock()
+	i.seq++
+	message := icmp.EchoMessage{
+		Ident: i.ident,
+		Seq:   i.seq,
+		Data:  []byte("ping from gotcp"),
+	}
+	key := uint32(message.Ident)<<16 | uint32(message.Seq)
+	ch := make(chan ipv4.Options, 1)
+	i.waiters[key] = ch
+	i.mutex.Unlock()
+	defer func() {
+		i.mutex.Lock()
+		delete(i.waiters, key)
+		i.mutex.Unlock()
+	}()
+
+	data, err := message.Serialize()
+	if err != nil {
+		return 0, nil, err
+	}
+	req, err := icmp.Build(icmp.Echo, icmp.EchoRequestCode, data)
+	if err != nil {
+		return 0, nil, err
+	}
+	start := i.Clock.Now()
+	if err := i.send(nil, dst, options, req); err != nil {
+		return 0, nil, err
+	}
+	select {
+	case reply := <-ch:
+		return i.Clock.Since(start), reply, nil
+	case <-i.Clock.After(timeout):
+		return 0, nil, fmt.Errorf("no reply from %s", dst.String())
 	}
 }
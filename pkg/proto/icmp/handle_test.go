@@ -0,0 +1,89 @@
+package icmp
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/terassyi/gotcp/pkg/packet/icmp"
+	"github.com/terassyi/gotcp/pkg/packet/ipv4"
+)
+
+func TestError(t *testing.T) {
+	i := New(false)
+	type sent struct {
+		src  *ipv4.IPAddress
+		dst  ipv4.IPAddress
+		data []byte
+	}
+	var messages []sent
+	i.SetOutput(func(src *ipv4.IPAddress, dst ipv4.IPAddress, options ipv4.Options, data []byte) error {
+		messages = append(messages, sent{src: src, dst: dst, data: data})
+		return nil
+	})
+	build := func(src, dst ipv4.IPAddress, protocol ipv4.IPProtocol, data []byte, f func(p *ipv4.Packet)) []byte {
+		packet, err := ipv4.Build(src, dst, protocol, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f != nil {
+			f(packet)
+		}
+		b, err := packet.Serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	host, peer := ipv4.IPAddress{10, 0, 0, 1}, ipv4.IPAddress{10, 0, 0, 2}
+	udp := make([]byte, 16)
+	echo := []byte{byte(icmp.Echo), 0, 0, 0, 0, 1, 0, 1}
+	unreachable := []byte{byte(icmp.DestinationUnreachable), 3, 0, 0, 0, 0, 0, 0}
+	for _, c := range []struct {
+		name     string
+		original []byte
+		allowed  bool
+	}{
+		{"unicast", build(peer, host, ipv4.IPUDPProtocol, udp, nil), true},
+		{"echo", build(peer, host, ipv4.IPICMPv4Protocol, echo, nil), true},
+		{"first fragment", build(peer, host, ipv4.IPUDPProtocol, udp, func(p *ipv4.Packet) {
+			p.Header.FlOffset = ipv4.NewFlagsFragmentOffset(ipv4.FlagMoreFragments, 0)
+		}), true},
+		{"following fragment", build(peer, host, ipv4.IPUDPProtocol, udp, func(p *ipv4.Packet) {
+			p.Header.FlOffset = ipv4.NewFlagsFragmentOffset(0, 2)
+		}), false},
+		{"icmp error", build(peer, host, ipv4.IPICMPv4Protocol, unreachable, nil), false},
+		{"limited broadcast", build(peer, ipv4.IPAddress{255, 255, 255, 255}, ipv4.IPUDPProtocol, udp, nil), false},
+		{"multicast", build(peer, ipv4.IPAddress{224, 0, 0, 1}, ipv4.IPUDPProtocol, udp, nil), false},
+		{"loopback source", build(ipv4.IPAddress{127, 0, 0, 1}, host, ipv4.IPUDPProtocol, udp, nil), false},
+		{"zero source", build(ipv4.IPAddress{}, host, ipv4.IPUDPProtocol, udp, nil), false},
+	} {
+		messages = nil
+		if err := i.Error(icmp.DestinationUnreachable, icmp.DestinationProtocolUnreachableCode, c.original); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if (len(messages) == 1) != c.allowed {
+			t.Fatalf("%s: %d messages are sent", c.name, len(messages))
+		}
+		if !c.allowed {
+			continue
+		}
+		m := messages[0]
+		if m.dst != peer || m.src == nil || *m.src != host {
+			t.Fatalf("%s: sent from %v to %s", c.name, m.src, m.dst)
+		}
+		packet, err := icmp.New(m.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if packet.Header.Type != icmp.DestinationUnreachable || packet.Header.Code != icmp.DestinationProtocolUnreachableCode {
+			t.Fatalf("%s: type=%d code=%d", c.name, packet.Header.Type, packet.Header.Code)
+		}
+		// the header and the leading 8 bytes follow unused 4 bytes
+		if !bytes.Equal(packet.Data, append(make([]byte, 4), c.original[:28]...)) {
+			t.Fatalf("%s: data %x", c.name, packet.Data)
+		}
+	}
+	if err := i.Error(icmp.DestinationUnreachable, icmp.DestinationProtocolUnreachableCode, make([]byte, 19)); err == nil {
+		t.Fatal("too short datagram is accepted")
+	}
+}
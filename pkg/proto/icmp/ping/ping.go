@@ -5,6 +5,7 @@ import (
 	"os"
 	"time"
 
+	"github.com/terassyi/gotcp/pkg/clock"
 	"github.com/terassyi/gotcp/pkg/interfaces"
 	"github.com/terassyi/gotcp/pkg/logger"
 	"github.com/terassyi/gotcp/pkg/packet/ethernet"
@@ -25,15 +26,27 @@ type Ping struct {
 	seqNo    int
 	SendTime []time.Time
 	queue    chan struct{}
+	Clock    clock.Clock
 	logger   *logger.Logger
 }
 
 func New(name, dst string, debug bool) (*Ping, error) {
-	addr, err := ippacket.StringToIPAddress(dst)
+	iface, err := interfaces.New(name, "afpacket")
 	if err != nil {
 		return nil, err
 	}
-	iface, err := interfaces.New(name, "afpacket")
+	interfaces.SkipOutgoing(iface)
+	p, err := NewWithIface(iface, name, dst, debug)
+	if err != nil {
+		iface.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// NewWithIface sends echo requests through the given interface, name is used to get the address.
+func NewWithIface(iface interfaces.Iface, name, dst string, debug bool) (*Ping, error) {
+	addr, err := ippacket.StringToIPAddress(dst)
 	if err != nil {
 		return nil, err
 	}
@@ -57,6 +70,7 @@ func New(name, dst string, debug bool) (*Ping, error) {
 		seqNo:          0,
 		SendTime:       make([]time.Time, 0, 128),
 		queue:          make(chan struct{}),
+		Clock:          clock.Real,
 		logger:         logger.New(debug, "icmp"),
 	}, nil
 }
@@ -68,8 +82,8 @@ func (p *Ping) Start() error {
 
 	p.logger.Info("gotcp ping start")
 	//p.ip.Show()
-	p.logger.Info("pid: %d\n", p.ident)
-	p.logger.Info("dest: %s\n", p.dst)
+	p.logger.Infof("pid: %d\n", p.ident)
+	p.logger.Infof("dest: %s\n", p.dst)
 
 	if err := p.start(); err != nil {
 		return err
@@ -122,12 +136,13 @@ func (p *Ping) pingRecv() {
 					p.logger.Errorf("failed to encode echo payload: %v\n", err)
 					continue
 				}
-				if len(p.SendTime) <= int(message.Seq) {
+				// seq starts from 1
+				if message.Seq == 0 || len(p.SendTime) < int(message.Seq) {
 					p.logger.Errorf("invalid seq: %d\n", message.Seq)
 					continue
 				}
-				sendTime := p.SendTime[int(message.Seq)].UnixNano() / int64(time.Microsecond)
-				recvTime := time.Now().UnixNano() / int64(time.Microsecond)
+				sendTime := p.SendTime[int(message.Seq)-1].UnixNano() / int64(time.Microsecond)
+				recvTime := p.Clock.Now().UnixNano() / int64(time.Microsecond)
 				sec := recvTime - sendTime
 
 				fmt.Printf("%d bytes from %s: icmp_seq=%d ttl=%d time=%f ms\n",
@@ -135,9 +150,9 @@ func (p *Ping) pingRecv() {
 					ipPacket.Header.Src.String(),
 					message.Seq,
 					ipPacket.Header.TTL,
-					sec)
+					float64(sec)/1000)
 
-				time.Sleep(time.Second)
+				p.Clock.Sleep(time.Second)
 				p.queue <- struct{}{}
 			}
 		}
@@ -148,7 +163,7 @@ func (p *Ping) pingSend() {
 	for {
 		_, ok := <-p.queue
 		if ok {
-			p.SendTime = append(p.SendTime, time.Now())
+			p.SendTime = append(p.SendTime, p.Clock.Now())
 			p.seqNo += 1
 			message := icmp.EchoMessage{
 				Ident: uint16(p.ident),
@@ -0,0 +1,198 @@
+package ipv4
+
+import (
+	"sync"
+	"time"
+
+	"github.com/terassyi/gotcp/pkg/packet/ipv4"
+)
+
+const (
+	defaultReassemblyTimeout time.Duration = 30 * time.Second
+	defaultReassemblyLimit   int           = 4 * 1024 * 1024
+)
+
+// fragmentKey identifies fragments of the same datagram (RFC 791).
+type fragmentKey struct {
+	src      ipv4.IPAddress
+	dst      ipv4.IPAddress
+	protocol ipv4.IPProtocol
+	ident    uint16
+}
+
+type fragment struct {
+	offset int
+	data   []byte
+}
+
+func (f fragment) end() int {
+	return f.offset + len(f.data)
+}
+
+// datagram is the datagram under reassembly.
+type datagram struct {
+	first     *ipv4.Packet // the fragment of offset 0 carrying the header and options
+	fragments []fragment   // sorted by the offset
+	length    int          // length of data known from the last fragment, -1 until received
+	size      int
+	deadline  time.Time
+}
+
+// reassembler holds fragments until datagrams are completed or expired.
+type reassembler struct {
+	datagrams map[fragmentKey]*datagram
+	order     []fragmentKey // in the order of the first fragment received to evict old datagrams
+	size      int
+	Timeout   time.Duration
+	Limit     int // bytes of fragments held
+	mutex     sync.Mutex
+}
+
+func newReassembler() *reassembler {
+	return &reassembler{
+		datagrams: make(map[fragmentKey]*datagram),
+		Timeout:   defaultReassemblyTimeout,
+		Limit:     defaultReassemblyLimit,
+	}
+}
+
+// add adds the fragment and returns the datagram when all fragments are received.
+// Datagrams with overlapping fragments are dropped as Linux does.
+func (r *reassembler) add(packet *ipv4.Packet, now time.Time) *ipv4.Packet {
+	key := fragmentKey{src: packet.Header.Src, dst: packet.Header.Dst, protocol: packet.Header.Protocol, ident: packet.Header.Ident}
+	more := packet.Header.FlOffset.MoreFragments()
+	f := fragment{offset: int(packet.Header.FlOffset.FragmentOffset()) * 8, data: packet.Data}
+	if f.end() > ipv4.MaxLength-20-len(packet.OptionPadding) || (more && (len(f.data) == 0 || len(f.data)%8 != 0)) {
+		return nil
+	}
+	// the buffer of the frame is not kept
+	f.data = append([]byte(nil), f.data...)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	d, ok := r.datagrams[key]
+	if !ok {
+		d = &datagram{length: -1, deadline: now.Add(r.Timeout)}
+		r.datagrams[key] = d
+		r.order = append(r.order, key)
+	}
+	inserted, ok := d.insert(f, more)
+	if !ok {
+		r.remove(key)
+		return nil
+	}
+	if !inserted {
+		return nil
+	}
+	if f.offset == 0 {
+		d.first = &ipv4.Packet{Header: packet.Header, OptionPadding: append([]byte(nil), packet.OptionPadding...), Data: f.data}
+	}
+	r.size += len(f.data)
+	d.size += len(f.data)
+	for r.size > r.Limit && len(r.order) > 0 {
+		// evict the oldest datagram
+		r.remove(r.order[0])
+	}
+	if _, ok := r.datagrams[key]; !ok || !d.complete() {
+		return nil
+	}
+	r.remove(key)
+	return d.assemble()
+}
+
+// insert returns false as ok when the fragment is inconsistent with others,
+// and false as inserted when the fragment is the duplicate.
+func (d *datagram) insert(f fragment, more bool) (inserted bool, ok bool) {
+	if !more {
+		if d.length >= 0 && d.length != f.end() {
+			return false, false
+		}
+		if n := len(d.fragments); n > 0 && d.fragments[n-1].end() > f.end() {
+			return false, false
+		}
+		d.length = f.end()
+	}
+	if d.length >= 0 && f.end() > d.length {
+		return false, false
+	}
+	i := 0
+	for ; i < len(d.fragments); i++ {
+		e := d.fragments[i]
+		if e.offset == f.offset && len(e.data) == len(f.data) {
+			return false, true
+		}
+		if f.offset < e.end() && e.offset < f.end() {
+			return false, false
+		}
+		if f.offset < e.offset {
+			break
+		}
+	}
+	d.fragments = append(d.fragments, fragment{})
+	copy(d.fragments[i+1:], d.fragments[i:])
+	d.fragments[i] = f
+	return true, true
+}
+
+func (d *datagram) complete() bool {
+	if d.first == nil || d.length < 0 {
+		return false
+	}
+	next := 0
+	for _, f := range d.fragments {
+		if f.offset != next {
+			return false
+		}
+		next = f.end()
+	}
+	return next == d.length
+}
+
+func (d *datagram) assemble() *ipv4.Packet {
+	data := make([]byte, 0, d.length)
+	for _, f := range d.fragments {
+		data = append(data, f.data...)
+	}
+	header := d.first.Header
+	header.FlOffset = ipv4.NewFlagsFragmentOffset(header.FlOffset.Flags()&^ipv4.FlagMoreFragments, 0)
+	header.Length = uint16(20 + len(d.first.OptionPadding) + len(data))
+	packet := &ipv4.Packet{
+		Header:        header,
+		OptionPadding: d.first.OptionPadding,
+		Data:          data,
+	}
+	packet.ReCalculateChecksum()
+	return packet
+}
+
+func (r *reassembler) remove(key fragmentKey) {
+	d, ok := r.datagrams[key]
+	if !ok {
+		return
+	}
+	r.size -= d.size
+	delete(r.datagrams, key)
+	for i, k := range r.order {
+		if k == key {
+			r.order = append(r.order[:i:i], r.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// expire removes datagrams not completed until the deadline and returns first fragments of them.
+func (r *reassembler) expire(now time.Time) []*ipv4.Packet {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var firsts []*ipv4.Packet
+	for _, key := range append([]fragmentKey(nil), r.order...) {
+		d := r.datagrams[key]
+		if now.Before(d.deadline) {
+			continue
+		}
+		if d.first != nil {
+			firsts = append(firsts, d.first)
+		}
+		r.remove(key)
+	}
+	return firsts
+}
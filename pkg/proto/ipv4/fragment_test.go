@@ -0,0 +1,104 @@
+package ipv4
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/terassyi/gotcp/pkg/packet/ipv4"
+)
+
+func fragments(t *testing.T, size, mtu int) (*ipv4.Packet, []*ipv4.Packet) {
+	t.Helper()
+	src, _ := ipv4.StringToIPAddress("10.0.0.1")
+	dst, _ := ipv4.StringToIPAddress("10.0.0.2")
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	packet, err := ipv4.Build(*src, *dst, ipv4.IPICMPv4Protocol, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet.Header.Ident = 1
+	fragments, err := packet.Fragment(mtu)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return packet, fragments
+}
+
+func TestReassembly(t *testing.T) {
+	packet, frags := fragments(t, 3000, 576)
+	if len(frags) != 6 {
+		t.Fatalf("want 6 fragments, got %d", len(frags))
+	}
+	for _, f := range frags {
+		if int(f.Header.Length) > 576 {
+			t.Fatalf("fragment exceeds mtu: %d", f.Header.Length)
+		}
+	}
+	r := newReassembler()
+	now := time.Now()
+	// out of order with a duplicate
+	for _, i := range []int{5, 2, 0, 2, 4, 3} {
+		if p := r.add(frags[i], now); p != nil {
+			t.Fatalf("reassembled before fragment 1 is received")
+		}
+	}
+	p := r.add(frags[1], now)
+	if p == nil {
+		t.Fatal("not reassembled")
+	}
+	if !bytes.Equal(p.Data, packet.Data) || p.Header.Length != packet.Header.Length || p.Header.FlOffset.Fragmented() {
+		t.Fatalf("reassembled datagram differs: %+v", p.Header)
+	}
+	if r.size != 0 || len(r.datagrams) != 0 {
+		t.Fatal("fragments are left")
+	}
+
+	// overlapping fragments drop the datagram
+	overlap := *frags[1]
+	overlap.Header.FlOffset = ipv4.NewFlagsFragmentOffset(ipv4.FlagMoreFragments, frags[1].Header.FlOffset.FragmentOffset()-1)
+	r.add(frags[0], now)
+	r.add(&overlap, now)
+	if len(r.datagrams) != 0 {
+		t.Fatal("overlapping fragment is accepted")
+	}
+
+	// don't fragment
+	packet.Header.FlOffset = ipv4.NewFlagsFragmentOffset(ipv4.FlagDontFragment, 0)
+	if _, err := packet.Fragment(576); err == nil {
+		t.Fatal("datagram with don't fragment is fragmented")
+	}
+}
+
+func TestReassemblyExpire(t *testing.T) {
+	_, frags := fragments(t, 2000, 1000)
+	r := newReassembler()
+	now := time.Now()
+	r.add(frags[0], now)
+	if expired := r.expire(now.Add(r.Timeout - time.Second)); len(expired) != 0 {
+		t.Fatal("expired before the timeout")
+	}
+	expired := r.expire(now.Add(r.Timeout))
+	if len(expired) != 1 || expired[0].Header.FlOffset.FragmentOffset() != 0 {
+		t.Fatalf("the first fragment is not returned: %v", expired)
+	}
+	if r.add(frags[1], now) != nil || r.add(frags[2], now) != nil {
+		t.Fatal("reassembled without the first fragment")
+	}
+
+	// the oldest datagram is evicted over the limit
+	r = newReassembler()
+	r.Limit = 1500
+	_, other := fragments(t, 2000, 1000)
+	for _, f := range other {
+		f.Header.Ident = 2
+	}
+	r.add(frags[0], now)
+	r.add(other[0], now)
+	if len(r.datagrams) != 1 || r.order[0].ident != 2 {
+		t.Fatal("the oldest datagram is not evicted")
+	}
+}
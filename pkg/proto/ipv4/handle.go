@@ -2,11 +2,16 @@ package ipv4
 
 import (
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 	"unsafe"
 
+	"github.com/terassyi/gotcp/pkg/clock"
 	"github.com/terassyi/gotcp/pkg/logger"
 	etherframe "github.com/terassyi/gotcp/pkg/packet/ethernet"
+	icmppacket "github.com/terassyi/gotcp/pkg/packet/icmp"
 	"github.com/terassyi/gotcp/pkg/packet/ipv4"
 	"github.com/terassyi/gotcp/pkg/proto"
 	"github.com/terassyi/gotcp/pkg/proto/ethernet"
@@ -16,13 +21,28 @@ import (
 
 type Ipv4 struct {
 	*proto.ProtocolBuffer
+	// the primary interface
 	Eth     *ethernet.Ethernet
 	Address *ipv4.IPAddress
-	Icmp    *icmp.Icmp
-	Tcp     *tcp.Tcp
-	logger  *logger.Logger
+	Netmask *ipv4.IPAddress
+	MTU     int
+
+	interfaces []*Interface // added by AddInterface
+	Table      *Table       // static routes
+	Icmp       *icmp.Icmp
+	Tcp        *tcp.Tcp
+	Clock      clock.Clock
+	ident      uint32 // identification of the next datagram
+	reassembly *reassembler
+	drops      dropStats
+	mutex      sync.RWMutex
+	logger     *logger.Logger
+
+	AcceptSourceRoute bool // deliver datagrams with source route options
 }
 
+const defaultMTU int = 1500
+
 func New(eth *ethernet.Ethernet, i *icmp.Icmp, tcp *tcp.Tcp, debug bool) (*Ipv4, error) {
 	addr, err := siocgifaddr(eth.Name())
 	if err != nil {
@@ -32,22 +52,45 @@ func New(eth *ethernet.Ethernet, i *icmp.Icmp, tcp *tcp.Tcp, debug bool) (*Ipv4,
 	if err != nil {
 		return nil, err
 	}
+	ip := NewWithAddress(eth, a, i, tcp, debug)
+	if mask, err := siocgifnetmask(eth.Name()); err == nil {
+		ip.Netmask, _ = ipv4.Address(mask)
+	}
+	return ip, nil
+}
 
-	return &Ipv4{
+// NewWithAddress creates the protocol with the statically configured address.
+func NewWithAddress(eth *ethernet.Ethernet, addr *ipv4.IPAddress, i *icmp.Icmp, tcp *tcp.Tcp, debug bool) *Ipv4 {
+	ip := &Ipv4{
 		ProtocolBuffer: proto.NewProtocolBuffer(),
 		Eth:            eth,
-		Address:        a,
+		Address:        addr,
+		MTU:            defaultMTU,
+		Table:          NewTable(),
 		Icmp:           i,
 		Tcp:            tcp,
+		Clock:          clock.Real,
+		reassembly:     newReassembler(),
 		logger:         logger.New(debug, "ipv4"),
-	}, nil
+	}
+	if i != nil {
+		i.SetOutput(func(src *ipv4.IPAddress, dst ipv4.IPAddress, options ipv4.Options, data []byte) error {
+			if src != nil && !ip.Local(*src) {
+				// replies to the broadcast are sent from the interface address
+				src = nil
+			}
+			_, err := ip.SendOptions(src, dst, ipv4.IPICMPv4Protocol, ipv4.ECNNotECT, options, data)
+			return err
+		})
+	}
+	return ip
 }
 
 func (ip *Ipv4) Show() {
 	ip.logger.Info("------ip interface ------")
-	ip.logger.Info("name: %v\n", ip.Eth.Name())
-	ip.logger.Info("ip addr: %v\n", ip.Address.String())
-	ip.logger.Info("mac addr: %v\n", ip.Eth.Address().String())
+	ip.logger.Infof("name: %v\n", ip.Eth.Name())
+	ip.logger.Infof("ip addr: %v\n", ip.Address.String())
+	ip.logger.Infof("mac addr: %v\n", ip.Eth.Address().String())
 }
 
 func (ip *Ipv4) Recv(buf []byte) {
@@ -56,75 +99,175 @@ func (ip *Ipv4) Recv(buf []byte) {
 
 func (ip *Ipv4) Handle() {
 	for {
-		buf, ok := <-ip.Buffer
-		if ok {
-			packet, err := ipv4.New(buf)
-			if err != nil {
-				ip.logger.Errorf("ipv4 packet serialize error: %v", err)
-				return
-			}
-			if err := ip.manage(packet); err != nil {
-				ip.logger.Error(err)
-				return
-			}
+		var buf []byte
+		select {
+		case buf = <-ip.Buffer:
+		case <-ip.Done:
+			return
 		}
+		ip.HandlePacket(buf)
 	}
 }
 
 func (ip *Ipv4) HandlePacket(buf []byte) {
+	if !ip.validate(buf) {
+		return
+	}
 	packet, err := ipv4.New(buf)
 	if err != nil {
 		ip.logger.Errorf("ipv4 packet serialize error: %v", err)
 		return
 	}
-	if err := ip.manage(packet); err != nil {
+	if !ip.accept(&packet.Header) {
+		return
+	}
+	if packet.Header.FlOffset.Fragmented() {
+		if packet = ip.reassembly.add(packet, ip.Clock.Now()); packet == nil {
+			// waiting for other fragments
+			return
+		}
+	}
+	options, ok := ip.receiveOptions(packet)
+	if !ok {
+		return
+	}
+	if err := ip.manage(packet, options); err != nil {
 		ip.logger.Error(err)
 		return
 	}
 }
 
-func (ip *Ipv4) manage(packet *ipv4.Packet) error {
+func (ip *Ipv4) manage(packet *ipv4.Packet, options ipv4.Options) error {
 
 	switch packet.Header.Protocol {
 	case ipv4.IPICMPv4Protocol:
-		ip.Icmp.Recv(packet.Data)
+		if ip.Icmp == nil {
+			return fmt.Errorf("icmp is not supported")
+		}
+		ip.Icmp.Recv(packet.Header.Src, packet.Header.Dst, options, packet.Data)
 	case ipv4.IPTCPProtocol:
-		ip.Tcp.HandlePacket(&packet.Header.Src, packet.Data)
+		// tcp is unicast only, segments to broadcasts are discarded (RFC 1122 4.2.3.10)
+		if !ip.Local(packet.Header.Dst) {
+			ip.drop(&ip.drops.destination, "tcp segment to a broadcast address")
+			return nil
+		}
+		ip.Tcp.HandleDatagram(&packet.Header, packet.Data)
 	default:
-		return fmt.Errorf("unsupported protocol")
+		ip.drop(&ip.drops.protocol, fmt.Sprintf("unsupported protocol %d", packet.Header.Protocol))
+		// errors are not sent in reply to broadcasts (RFC 1122 3.2.2)
+		if ip.Icmp == nil || !ip.Local(packet.Header.Dst) {
+			return nil
+		}
+		buf, err := packet.Serialize()
+		if err != nil {
+			return err
+		}
+		return ip.Icmp.Error(icmppacket.DestinationUnreachable, icmppacket.DestinationProtocolUnreachableCode, buf)
 	}
 	return nil
 }
 
 func (ip *Ipv4) Send(dst ipv4.IPAddress, protocol ipv4.IPProtocol, data []byte) (int, error) {
-	packet, err := ipv4.Build(*ip.Address, dst, protocol, data)
+	return ip.SendECN(dst, protocol, ipv4.ECNNotECT, data)
+}
+
+// SendECN sends the datagram with the ECN codepoint, ECT(0) is set for data of ECN-capable transports.
+func (ip *Ipv4) SendECN(dst ipv4.IPAddress, protocol ipv4.IPProtocol, ecn uint8, data []byte) (int, error) {
+	return ip.SendFrom(nil, dst, protocol, ecn, data)
+}
+
+// SendFrom sends the datagram from the local address, the address of the egress interface is used when src is nil.
+func (ip *Ipv4) SendFrom(src *ipv4.IPAddress, dst ipv4.IPAddress, protocol ipv4.IPProtocol, ecn uint8, data []byte) (int, error) {
+	return ip.SendOptions(src, dst, protocol, ecn, nil, data)
+}
+
+// SendOptions sends the datagram with ip options, the source address is recorded in record route and timestamp options.
+func (ip *Ipv4) SendOptions(src *ipv4.IPAddress, dst ipv4.IPAddress, protocol ipv4.IPProtocol, ecn uint8, options ipv4.Options, data []byte) (int, error) {
+	iface, nextHop, err := ip.route(dst)
+	if err != nil {
+		return 0, err
+	}
+	if src == nil {
+		src = iface.Address
+	}
+	ip.stampOptions(options, *src)
+	packet, err := ipv4.Build(*src, dst, protocol, data, options...)
 	if err != nil {
 		return 0, err
 	}
+	packet.Header.SetECN(ecn)
+	packet.Header.Ident = uint16(atomic.AddUint32(&ip.ident, 1))
 	if err := packet.ReCalculateChecksum(); err != nil {
 		return 0, err
 	}
-	ipByte, err := packet.Serialize()
+	fragments, err := packet.Fragment(iface.MTU)
 	if err != nil {
 		return 0, err
 	}
+	n := 0
+	for _, fragment := range fragments {
+		ipByte, err := fragment.Serialize()
+		if err != nil {
+			return n, err
+		}
+		if _, err := iface.Eth.Send(nil, &nextHop, etherframe.ETHER_TYPE_IP, ipByte); err != nil {
+			return n, err
+		}
+		n += len(ipByte)
+	}
+	return n, nil
+}
 
-	if _, err := ip.Eth.Send(nil, &dst, etherframe.ETHER_TYPE_IP, ipByte); err != nil {
-		return 0, err
+// ReassemblyTimer drops datagrams not reassembled in time and notifies the source by time exceeded messages.
+// this function will be called as goroutine
+func (ip *Ipv4) ReassemblyTimer() {
+	ticker := ip.Clock.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C():
+			ip.expireFragments(now)
+		case <-ip.Done:
+			return
+		}
 	}
+}
 
-	return len(ipByte), nil
+func (ip *Ipv4) expireFragments(now time.Time) {
+	for _, first := range ip.reassembly.expire(now) {
+		ip.logger.Debugf("fragment reassembly time exceeded: %s -> %s id=%d", first.Header.Src, first.Header.Dst, first.Header.Ident)
+		// time exceeded is sent only when the first fragment is received (RFC 792)
+		if ip.Icmp == nil {
+			continue
+		}
+		buf, err := first.Serialize()
+		if err != nil {
+			ip.logger.Error(err)
+			continue
+		}
+		if err := ip.Icmp.Error(icmppacket.TimeExceeded, icmppacket.FragmentTimeExceeded, buf); err != nil {
+			ip.logger.Error(err)
+		}
+	}
 }
 
 // this function will be called as goroutine
 func (ip *Ipv4) TcpSend() {
 	for {
-		addrPacket, ok := <-ip.Tcp.SendQueue
-		if !ok {
-			ip.logger.Error("failed to handle tcp packet for sending")
-			continue
+		var addrPacket tcp.AddressedPacket
+		select {
+		case addrPacket = <-ip.Tcp.SendQueue:
+		case <-ip.Done:
+			return
+		}
+		src := addrPacket.Local
+		if src == nil {
+			if src = ip.Source(*addrPacket.Address); src == nil {
+				ip.logger.Errorf("network is unreachable: %s", addrPacket.Address)
+				continue
+			}
 		}
-		if err := addrPacket.Packet.ReCalculateChecksum(*ip.Address, *addrPacket.Address); err != nil {
+		if err := addrPacket.Packet.ReCalculateChecksum(*src, *addrPacket.Address); err != nil {
 			ip.logger.Error("failed to handle tcp packet for sending")
 			continue
 		}
@@ -132,7 +275,7 @@ func (ip *Ipv4) TcpSend() {
 		if err != nil {
 			ip.logger.Error(err)
 		}
-		_, err = ip.Send(*addrPacket.Address, ipv4.IPTCPProtocol, data)
+		_, err = ip.SendFrom(src, *addrPacket.Address, ipv4.IPTCPProtocol, addrPacket.ECN, data)
 		if err != nil {
 			ip.logger.Error(err)
 		}
@@ -140,6 +283,15 @@ func (ip *Ipv4) TcpSend() {
 }
 
 func siocgifaddr(name string) ([]byte, error) {
+	return ifaddr(name, syscall.SIOCGIFADDR)
+}
+
+func siocgifnetmask(name string) ([]byte, error) {
+	return ifaddr(name, syscall.SIOCGIFNETMASK)
+}
+
+// ifaddr gets the address of the interface by the ioctl request.
+func ifaddr(name string, req uintptr) ([]byte, error) {
 
 	type sockaddr struct {
 		family uint16
@@ -157,7 +309,7 @@ func siocgifaddr(name string) ([]byte, error) {
 		_pad [8]byte
 	}{}
 	copy(ifreq.name[:syscall.IFNAMSIZ-1], name)
-	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(soc), syscall.SIOCGIFADDR, uintptr(unsafe.Pointer(&ifreq))); errno != 0 {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(soc), req, uintptr(unsafe.Pointer(&ifreq))); errno != 0 {
 		return nil, errno
 	}
 	return ifreq.addr.addr[2:6], nil
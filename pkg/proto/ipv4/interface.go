@@ -0,0 +1,120 @@
+package ipv4
+
+import (
+	"fmt"
+
+	"github.com/terassyi/gotcp/pkg/packet/ipv4"
+	"github.com/terassyi/gotcp/pkg/proto/ethernet"
+)
+
+// Interface is the address assigned to the link.
+// The link has several interfaces when multiple addresses are assigned to it.
+type Interface struct {
+	Eth     *ethernet.Ethernet
+	Address *ipv4.IPAddress
+	Netmask *ipv4.IPAddress // the subnet is reachable on the link, all destinations are assumed to be on the link when nil
+	MTU     int
+}
+
+// connected returns the route to the subnet of the interface.
+func (i *Interface) connected() *Route {
+	r := &Route{Dev: i.Eth.Name(), iface: i}
+	if i.Netmask != nil {
+		r.Netmask = *i.Netmask
+		for n := range r.Destination {
+			r.Destination[n] = i.Address[n] & i.Netmask[n]
+		}
+	}
+	return r
+}
+
+// Broadcast returns the directed broadcast address of the subnet, nil without the netmask or for the host route.
+func (i *Interface) Broadcast() *ipv4.IPAddress {
+	if i.Netmask == nil || *i.Netmask == (ipv4.IPAddress{255, 255, 255, 255}) {
+		return nil
+	}
+	var addr ipv4.IPAddress
+	for n := range addr {
+		addr[n] = i.Address[n] | ^i.Netmask[n]
+	}
+	return &addr
+}
+
+// AddInterface assigns the address to the link in addition to the primary one.
+func (ip *Ipv4) AddInterface(iface *Interface) {
+	if iface.MTU == 0 {
+		iface.MTU = defaultMTU
+	}
+	ip.mutex.Lock()
+	defer ip.mutex.Unlock()
+	ip.interfaces = append(ip.interfaces, iface)
+}
+
+// Interfaces returns all interfaces, the first one is the primary interface.
+func (ip *Ipv4) Interfaces() []*Interface {
+	ip.mutex.RLock()
+	defer ip.mutex.RUnlock()
+	ifaces := make([]*Interface, 0, len(ip.interfaces)+1)
+	ifaces = append(ifaces, &Interface{Eth: ip.Eth, Address: ip.Address, Netmask: ip.Netmask, MTU: ip.MTU})
+	return append(ifaces, ip.interfaces...)
+}
+
+// Local returns true when the address is assigned to one of interfaces.
+func (ip *Ipv4) Local(addr ipv4.IPAddress) bool {
+	for _, i := range ip.Interfaces() {
+		if *i.Address == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// Routes returns connected routes of interfaces followed by static routes.
+func (ip *Ipv4) Routes() []*Route {
+	var routes []*Route
+	for _, i := range ip.Interfaces() {
+		routes = append(routes, i.connected())
+	}
+	return append(routes, ip.Table.Routes()...)
+}
+
+// route returns the egress interface and the next hop of the destination by the longest prefix match.
+func (ip *Ipv4) route(dst ipv4.IPAddress) (*Interface, ipv4.IPAddress, error) {
+	r := lookup(ip.Routes(), dst)
+	if r == nil {
+		return nil, dst, fmt.Errorf("network is unreachable: %s", dst)
+	}
+	if r.iface != nil {
+		return r.iface, dst, nil
+	}
+	hop := dst
+	if r.Gateway != nil {
+		hop = *r.Gateway
+	}
+	// the interface on the link of the next hop
+	var candidate *Interface
+	for _, i := range ip.Interfaces() {
+		if r.Dev != "" && i.Eth.Name() != r.Dev {
+			continue
+		}
+		if i.connected().contains(hop) {
+			return i, hop, nil
+		}
+		if candidate == nil && r.Dev != "" {
+			candidate = i
+		}
+	}
+	if candidate == nil {
+		return nil, dst, fmt.Errorf("next hop %s of %s is not reachable", hop, dst)
+	}
+	return candidate, hop, nil
+}
+
+// Source returns the address of the egress interface to the destination, nil when unreachable.
+func (ip *Ipv4) Source(dst ipv4.IPAddress) *ipv4.IPAddress {
+	i, _, err := ip.route(dst)
+	if err != nil {
+		return nil
+	}
+	return i.Address
+}
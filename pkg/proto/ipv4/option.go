@@ -0,0 +1,52 @@
+package ipv4
+
+import (
+	icmppacket "github.com/terassyi/gotcp/pkg/packet/icmp"
+	"github.com/terassyi/gotcp/pkg/packet/ipv4"
+)
+
+// receiveOptions parses options of the received datagram and applies the source route policy.
+// Source routed datagrams are dropped unless AcceptSourceRoute is set like Linux does by default.
+func (ip *Ipv4) receiveOptions(packet *ipv4.Packet) (ipv4.Options, bool) {
+	if len(packet.OptionPadding) == 0 {
+		return nil, true
+	}
+	options, err := packet.Options()
+	if err != nil {
+		ip.drop(&ip.drops.options, err.Error())
+		return nil, false
+	}
+	sr := options.SourceRoute()
+	if sr == nil {
+		return options, true
+	}
+	if !ip.AcceptSourceRoute {
+		ip.drop(&ip.drops.sourceRoute, "source routed datagram is not accepted")
+		return nil, false
+	}
+	if !sr.Completed() {
+		// we are not the final destination, datagrams are not forwarded by the host
+		ip.drop(&ip.drops.sourceRoute, "source route is not completed")
+		if ip.Icmp != nil && ip.Local(packet.Header.Dst) {
+			buf, err := packet.Serialize()
+			if err == nil {
+				err = ip.Icmp.Error(icmppacket.DestinationUnreachable, icmppacket.SourceRouteFailedCode, buf)
+			}
+			if err != nil {
+				ip.logger.Error(err)
+			}
+		}
+		return nil, false
+	}
+	return options, true
+}
+
+// stampOptions records the source address in record route and timestamp options to send.
+func (ip *Ipv4) stampOptions(options ipv4.Options, src ipv4.IPAddress) {
+	if rr := options.RecordRoute(); rr != nil {
+		rr.Record(src)
+	}
+	if ts := options.Timestamp(); ts != nil {
+		ts.Stamp(src, ip.Clock.Now())
+	}
+}
@@ -1,4 +1,247 @@
 package ipv4
 
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/terassyi/gotcp/pkg/packet/ipv4"
+)
+
+// Route is the entry of the routing table.
+type Route struct {
+	Destination ipv4.IPAddress
+	Netmask     ipv4.IPAddress
+	Gateway     *ipv4.IPAddress // next hop, nil when the destination is on the link
+	Dev         string          // egress interface, chosen by the gateway when empty
+	Metric      int             // the lower one is preferred among routes of the same prefix length
+	iface       *Interface      // the interface of the connected route
+}
+
+// ParseRoute parses the route in the form of `<destination>[/<prefix>]|default [via <gateway>] [dev <interface>] [metric <metric>]`.
+func ParseRoute(s string) (*Route, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty route")
+	}
+	r := &Route{}
+	if fields[0] != "default" {
+		dst := fields[0]
+		if !strings.Contains(dst, "/") {
+			dst += "/32"
+		}
+		_, network, err := net.ParseCIDR(dst)
+		if err != nil {
+			return nil, fmt.Errorf("invalid destination: %s", fields[0])
+		}
+		if network.IP.To4() == nil {
+			return nil, fmt.Errorf("invalid destination: %s", fields[0])
+		}
+		copy(r.Destination[:], network.IP.To4())
+		copy(r.Netmask[:], network.Mask)
+	}
+	for i := 1; i < len(fields); i += 2 {
+		if i+1 >= len(fields) {
+			return nil, fmt.Errorf("value of %s is missing", fields[i])
+		}
+		value := fields[i+1]
+		switch fields[i] {
+		case "via":
+			gw, err := ipv4.StringToIPAddress(value)
+			if err != nil {
+				return nil, err
+			}
+			r.Gateway = gw
+		case "dev":
+			r.Dev = value
+		case "metric":
+			metric, err := strconv.Atoi(value)
+			if err != nil || metric < 0 {
+				return nil, fmt.Errorf("invalid metric: %s", value)
+			}
+			r.Metric = metric
+		default:
+			return nil, fmt.Errorf("unknown keyword: %s", fields[i])
+		}
+	}
+	if r.Gateway == nil && r.Dev == "" {
+		return nil, fmt.Errorf("gateway or interface is required")
+	}
+	return r, nil
+}
+
+// Prefix returns the prefix length of the destination.
+func (r *Route) Prefix() int {
+	return bits.OnesCount32(binary.BigEndian.Uint32(r.Netmask[:]))
+}
+
+// Default returns true for the default route.
+func (r *Route) Default() bool {
+	return r.Prefix() == 0
+}
+
+// Connected returns true for the route derived from the address of the interface.
+func (r *Route) Connected() bool {
+	return r.iface != nil
+}
+
+// assumed returns true for the connected route of the interface without the netmask,
+// all destinations are only assumed to be on the link.
+func (r *Route) assumed() bool {
+	return r.iface != nil && r.iface.Netmask == nil
+}
+
+func (r *Route) contains(addr ipv4.IPAddress) bool {
+	for i := range addr {
+		if addr[i]&r.Netmask[i] != r.Destination[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *Route) String() string {
+	var b strings.Builder
+	if r.Default() {
+		b.WriteString("default")
+	} else {
+		fmt.Fprintf(&b, "%s/%d", r.Destination, r.Prefix())
+	}
+	if r.Gateway != nil {
+		fmt.Fprintf(&b, " via %s", r.Gateway)
+	}
+	if r.Dev != "" {
+		fmt.Fprintf(&b, " dev %s", r.Dev)
+	}
+	if r.iface != nil {
+		fmt.Fprintf(&b, " src %s", r.iface.Address)
+	}
+	if r.Metric != 0 {
+		fmt.Fprintf(&b, " metric %d", r.Metric)
+	}
+	return b.String()
+}
+
+// Table is the table of static routes.
 type Table struct {
+	routes []*Route
+	mutex  sync.RWMutex
+}
+
+func NewTable() *Table {
+	return &Table{}
+}
+
+// Add adds the route, the same route can not be added twice.
+func (t *Table) Add(r *Route) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for _, e := range t.routes {
+		if e.String() == r.String() {
+			return fmt.Errorf("route already exists: %s", r)
+		}
+	}
+	t.routes = append(t.routes, r)
+	return nil
+}
+
+// Delete deletes the route equal to r.
+func (t *Table) Delete(r *Route) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for i, e := range t.routes {
+		if e.String() == r.String() {
+			t.routes = append(t.routes[:i:i], t.routes[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("no such route: %s", r)
+}
+
+// Routes returns routes in the order of added.
+func (t *Table) Routes() []*Route {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return append([]*Route(nil), t.routes...)
+}
+
+// Lookup returns the route of the longest prefix matching the destination.
+func (t *Table) Lookup(dst ipv4.IPAddress) *Route {
+	return lookup(t.Routes(), dst)
+}
+
+// lookup prefers the longer prefix, then the explicit route over the assumed one,
+// then the lower metric, then the earlier route.
+func lookup(routes []*Route, dst ipv4.IPAddress) *Route {
+	var best *Route
+	for _, r := range routes {
+		if !r.contains(dst) {
+			continue
+		}
+		if best == nil || r.Prefix() > best.Prefix() {
+			best = r
+			continue
+		}
+		if r.Prefix() < best.Prefix() {
+			continue
+		}
+		if r.assumed() != best.assumed() {
+			if best.assumed() {
+				best = r
+			}
+			continue
+		}
+		if r.Metric < best.Metric {
+			best = r
+		}
+	}
+	return best
+}
+
+// ReadKernelRoutes reads routes of the kernel in the format of /proc/net/route.
+// Routes which are not up are skipped.
+func ReadKernelRoutes(reader io.Reader) ([]*Route, error) {
+	const rtfUp = 0x1
+	var routes []*Route
+	scanner := bufio.NewScanner(reader)
+	// header
+	scanner.Scan()
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 8 {
+			continue
+		}
+		var values [4]uint32
+		for i, n := range []int{1, 2, 3, 7} {
+			v, err := strconv.ParseUint(fields[n], 16, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid route: %s", scanner.Text())
+			}
+			values[i] = uint32(v)
+		}
+		dst, gw, flags, mask := values[0], values[1], values[2], values[3]
+		if flags&rtfUp == 0 {
+			continue
+		}
+		metric, err := strconv.Atoi(fields[6])
+		if err != nil {
+			return nil, fmt.Errorf("invalid route: %s", scanner.Text())
+		}
+		// addresses are in the host byte order of the little endian
+		r := &Route{Dev: fields[0], Metric: metric}
+		binary.LittleEndian.PutUint32(r.Destination[:], dst)
+		binary.LittleEndian.PutUint32(r.Netmask[:], mask)
+		if gw != 0 {
+			r.Gateway = &ipv4.IPAddress{}
+			binary.LittleEndian.PutUint32(r.Gateway[:], gw)
+		}
+		routes = append(routes, r)
+	}
+	return routes, scanner.Err()
 }
@@ -0,0 +1,118 @@
+package ipv4
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/terassyi/gotcp/pkg/packet/ipv4"
+)
+
+func TestParseRoute(t *testing.T) {
+	for _, s := range []string{
+		"default via 10.0.0.1",
+		"10.1.0.0/16 via 10.0.0.254 metric 10",
+		"10.2.0.0/24 dev eth1",
+		"10.3.0.1/32 via 10.0.0.1 dev eth0",
+	} {
+		r, err := ParseRoute(s)
+		if err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+		if r.String() != s {
+			t.Fatalf("want %s, got %s", s, r)
+		}
+	}
+	for _, s := range []string{"", "10.0.0.0/8", "10.0.0.0/8 via", "10.0.0.0/33 via 10.0.0.1", "default via 10.0.0.1 metric x", "default gw 10.0.0.1"} {
+		if _, err := ParseRoute(s); err == nil {
+			t.Fatalf("%q is accepted", s)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	table := NewTable()
+	for _, s := range []string{
+		"default via 10.0.0.1",
+		"10.1.0.0/16 via 10.0.0.2",
+		"10.1.2.0/24 via 10.0.0.3 metric 20",
+		"10.1.2.0/24 via 10.0.0.4 metric 10",
+	} {
+		r, err := ParseRoute(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := table.Add(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for dst, gw := range map[string]string{
+		"192.168.0.1": "10.0.0.1",
+		"10.1.0.1":    "10.0.0.2",
+		"10.1.2.1":    "10.0.0.4",
+	} {
+		addr, _ := ipv4.StringToIPAddress(dst)
+		r := table.Lookup(*addr)
+		if r == nil || r.Gateway.String() != gw {
+			t.Fatalf("%s: want via %s, got %v", dst, gw, r)
+		}
+	}
+	r, _ := ParseRoute("default via 10.0.0.1")
+	if err := table.Add(r); err == nil {
+		t.Fatal("duplicated route is added")
+	}
+	if err := table.Delete(r); err != nil {
+		t.Fatal(err)
+	}
+	addr, _ := ipv4.StringToIPAddress("192.168.0.1")
+	if r := table.Lookup(*addr); r != nil {
+		t.Fatalf("route is found: %s", r)
+	}
+}
+
+func TestLookupAssumed(t *testing.T) {
+	addr, _ := ipv4.StringToIPAddress("10.0.0.2")
+	// the connected route of the interface without the netmask
+	assumed := &Route{Dev: "eth0", iface: &Interface{Address: addr}}
+	def, err := ParseRoute("default via 10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst, _ := ipv4.StringToIPAddress("192.168.0.1")
+	for _, routes := range [][]*Route{{assumed, def}, {def, assumed}} {
+		if r := lookup(routes, *dst); r != def {
+			t.Fatalf("want %s, got %s", def, r)
+		}
+	}
+	if r := lookup([]*Route{assumed}, *dst); r != assumed {
+		t.Fatalf("want %s, got %v", assumed, r)
+	}
+	// the longer prefix still wins
+	host, err := ParseRoute("192.168.0.1/32 dev eth1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := lookup([]*Route{assumed, def, host}, *dst); r != host {
+		t.Fatalf("want %s, got %s", host, r)
+	}
+}
+
+func TestReadKernelRoutes(t *testing.T) {
+	proc := `Iface	Destination	Gateway 	Flags	RefCnt	Use	Metric	Mask		MTU	Window	IRTT
+eth0	00000000	010200C0	0003	0	0	100	00000000	0	0	0
+eth0	000200C0	00000000	0001	0	0	0	00FFFFFF	0	0	0
+eth1	0000010A	00000000	0000	0	0	0	0000FFFF	0	0	0
+`
+	routes, err := ReadKernelRoutes(strings.NewReader(proc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"default via 192.0.2.1 dev eth0 metric 100", "192.0.2.0/24 dev eth0"}
+	if len(routes) != len(want) {
+		t.Fatalf("want %d routes, got %d", len(want), len(routes))
+	}
+	for i, r := range routes {
+		if r.String() != want[i] {
+			t.Fatalf("want %s, got %s", want[i], r)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package ipv4
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+
+	"github.com/terassyi/gotcp/pkg/packet/ipv4"
+	"github.com/terassyi/gotcp/pkg/util"
+)
+
+// Drops is the number of received datagrams silently discarded for each reason.
+type Drops struct {
+	Truncated    uint64 // shorter than the header or the total length
+	Version      uint64
+	HeaderLength uint64
+	Checksum     uint64
+	Source       uint64 // broadcast, multicast, loopback or class E source
+	Destination  uint64 // not addressed to the host
+	Protocol     uint64 // protocol is not supported
+	Options      uint64 // malformed options
+	SourceRoute  uint64 // source routed datagrams not accepted or to be forwarded
+}
+
+type dropStats struct {
+	truncated    uint64
+	version      uint64
+	headerLength uint64
+	checksum     uint64
+	source       uint64
+	destination  uint64
+	protocol     uint64
+	options      uint64
+	sourceRoute  uint64
+}
+
+// Drops returns counters of discarded datagrams.
+func (ip *Ipv4) Drops() Drops {
+	return Drops{
+		Truncated:    atomic.LoadUint64(&ip.drops.truncated),
+		Version:      atomic.LoadUint64(&ip.drops.version),
+		HeaderLength: atomic.LoadUint64(&ip.drops.headerLength),
+		Checksum:     atomic.LoadUint64(&ip.drops.checksum),
+		Source:       atomic.LoadUint64(&ip.drops.source),
+		Destination:  atomic.LoadUint64(&ip.drops.destination),
+		Protocol:     atomic.LoadUint64(&ip.drops.protocol),
+		Options:      atomic.LoadUint64(&ip.drops.options),
+		SourceRoute:  atomic.LoadUint64(&ip.drops.sourceRoute),
+	}
+}
+
+func (ip *Ipv4) drop(counter *uint64, reason string) {
+	atomic.AddUint64(counter, 1)
+	ip.logger.Debugf("datagram is discarded: %s", reason)
+}
+
+// validate checks the header of the received datagram before parsing (RFC 1122 3.2.1).
+func (ip *Ipv4) validate(buf []byte) bool {
+	if len(buf) < 20 {
+		ip.drop(&ip.drops.truncated, "shorter than the header")
+		return false
+	}
+	if buf[0]>>4 != 4 {
+		ip.drop(&ip.drops.version, "version is not 4")
+		return false
+	}
+	headerLength := int(buf[0]&0x0f) * 4
+	if headerLength < 20 || headerLength > len(buf) {
+		ip.drop(&ip.drops.headerLength, "invalid header length")
+		return false
+	}
+	length := int(binary.BigEndian.Uint16(buf[2:4]))
+	if length < headerLength || length > len(buf) {
+		ip.drop(&ip.drops.truncated, "invalid total length")
+		return false
+	}
+	if util.Checksum2(buf, headerLength, 0) != 0 {
+		ip.drop(&ip.drops.checksum, "invalid checksum")
+		return false
+	}
+	return true
+}
+
+// accept checks addresses of the received datagram.
+// Datagrams to the address, the limited broadcast or the directed broadcast of interfaces are accepted.
+func (ip *Ipv4) accept(header *ipv4.Header) bool {
+	src := header.Src
+	if src[0] == 127 || src[0] >= 224 || ip.broadcast(src) {
+		ip.drop(&ip.drops.source, "invalid source address")
+		return false
+	}
+	if !ip.Local(header.Dst) && !ip.broadcast(header.Dst) {
+		ip.drop(&ip.drops.destination, "not addressed to the host")
+		return false
+	}
+	return true
+}
+
+// broadcast returns true for the limited broadcast and directed broadcasts of interfaces.
+func (ip *Ipv4) broadcast(addr ipv4.IPAddress) bool {
+	if addr == (ipv4.IPAddress{255, 255, 255, 255}) {
+		return true
+	}
+	for _, i := range ip.Interfaces() {
+		if b := i.Broadcast(); b != nil && *b == addr {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+package ipv4
+
+import (
+	"testing"
+	"time"
+
+	"github.com/terassyi/gotcp/pkg/packet/ipv4"
+	tcppacket "github.com/terassyi/gotcp/pkg/packet/tcp"
+	"github.com/terassyi/gotcp/pkg/proto/tcp"
+)
+
+func TestValidate(t *testing.T) {
+	addr, _ := ipv4.StringToIPAddress("10.0.0.1")
+	mask, _ := ipv4.StringToIPAddress("255.255.255.0")
+	ip := NewWithAddress(nil, addr, nil, nil, false)
+	ip.Netmask = mask
+
+	build := func(src, dst string, protocol ipv4.IPProtocol) []byte {
+		s, _ := ipv4.StringToIPAddress(src)
+		d, _ := ipv4.StringToIPAddress(dst)
+		packet, err := ipv4.Build(*s, *d, protocol, []byte{0, 1, 2, 3})
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := packet.Serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	valid := build("10.0.0.2", "10.0.0.1", ipv4.IPUDPProtocol)
+	corrupt := func(f func(b []byte)) []byte {
+		b := append([]byte(nil), valid...)
+		f(b)
+		return b
+	}
+	for _, buf := range [][]byte{
+		valid[:19],
+		corrupt(func(b []byte) { b[0] = 0x65 }),
+		corrupt(func(b []byte) { b[0] = 0x44 }),
+		corrupt(func(b []byte) { b[3] = 0xff }),
+		corrupt(func(b []byte) { b[10] ^= 0xff }),
+		build("255.255.255.255", "10.0.0.1", ipv4.IPUDPProtocol),
+		build("10.0.0.255", "10.0.0.1", ipv4.IPUDPProtocol),
+		build("10.0.0.2", "10.0.0.3", ipv4.IPUDPProtocol),
+		build("10.0.0.2", "224.0.0.1", ipv4.IPUDPProtocol),
+		valid,
+		build("10.0.0.2", "10.0.0.255", ipv4.IPUDPProtocol),
+		build("10.0.0.2", "255.255.255.255", ipv4.IPUDPProtocol),
+	} {
+		ip.HandlePacket(buf)
+	}
+	want := Drops{Truncated: 2, Version: 1, HeaderLength: 1, Checksum: 1, Source: 2, Destination: 2, Protocol: 3}
+	if drops := ip.Drops(); drops != want {
+		t.Fatalf("want %+v, got %+v", want, drops)
+	}
+}
+
+func TestTcpBroadcast(t *testing.T) {
+	addr, _ := ipv4.StringToIPAddress("10.0.0.1")
+	mask, _ := ipv4.StringToIPAddress("255.255.255.0")
+	tp, err := tcp.New(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip := NewWithAddress(nil, addr, nil, tp, false)
+	ip.Netmask = mask
+	l, err := tp.Listen("0.0.0.0", 8080)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go l.Accept()
+
+	syn := func(dst string, seq uint32) []byte {
+		segment, err := tcppacket.Build(40000, 8080, seq, 0, tcppacket.SYN, 29200, 0, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ts, err := tcppacket.NewTimeStamp()
+		if err != nil {
+			t.Fatal(err)
+		}
+		segment.AddOption(tcppacket.Options{*ts})
+		data, err := segment.Serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, _ := ipv4.StringToIPAddress("10.0.0.2")
+		d, _ := ipv4.StringToIPAddress(dst)
+		packet, err := ipv4.Build(*s, *d, ipv4.IPTCPProtocol, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := packet.Serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	// segments to broadcasts never reach the listener (RFC 1122 4.2.3.10)
+	ip.HandlePacket(syn("10.0.0.255", 100))
+	ip.HandlePacket(syn("255.255.255.255", 200))
+	ip.HandlePacket(syn("10.0.0.1", 300))
+	select {
+	case synAck := <-tp.SendQueue:
+		if synAck.Packet.Header.Ack != 301 {
+			t.Fatalf("actual ack: %d", synAck.Packet.Header.Ack)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("syn|ack is not sent")
+	}
+	if drops := ip.Drops(); drops.Destination != 2 {
+		t.Fatalf("actual drops: %+v", drops)
+	}
+}
@@ -15,6 +15,7 @@ type Table struct {
 type Peer struct {
 	PeerAddr *ipv4.IPAddress
 	PeerPort int
+	Addr     *ipv4.IPAddress // local address, nil until the peer is connected on the multi-homed stack
 	Port     int
 }
 
@@ -0,0 +1,110 @@
+package tcp
+
+import "sync"
+
+// congestion is the Reno congestion controller (RFC 5681) with the ECN response (RFC 3168).
+type congestion struct {
+	cwnd     uint32
+	ssthresh uint32
+	recover  uint32 // snd.NXT when the window was reduced last
+	reduced  bool
+	cwr      bool // set CWR in the next new data segment
+	mutex    *sync.Mutex
+	cond     *sync.Cond // on the tcb lock held by the writer
+}
+
+const (
+	initialWindow   uint32 = 10 * uint32(mss) // RFC 6928
+	initialSsthresh uint32 = 0xffffffff
+)
+
+func newCongestion(tcb sync.Locker) *congestion {
+	return &congestion{
+		cwnd:     initialWindow,
+		ssthresh: initialSsthresh,
+		mutex:    &sync.Mutex{},
+		cond:     sync.NewCond(tcb),
+	}
+}
+
+// wait blocks until both of the congestion window and the send window allow length bytes in addition to the flight.
+// At least one segment can be always sent.
+// It is called holding the tcb lock, which is released while blocking,
+// and returns false when the connection can not send any more.
+func (cc *congestion) wait(flight, window func() uint32, ready func() bool, length int) bool {
+	for ready() && flight() > 0 && (flight()+uint32(length) > cc.window() || flight()+uint32(length) > window()) {
+		cc.cond.Wait()
+	}
+	return ready()
+}
+
+func (cc *congestion) window() uint32 {
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+	return cc.cwnd
+}
+
+// update wakes up the writer after the send window or the state of the connection is updated.
+func (cc *congestion) update() {
+	cc.cond.Broadcast()
+}
+
+// acked grows the window by newly acknowledged bytes.
+func (cc *congestion) acked(n uint32) {
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+	if cc.cwnd < cc.ssthresh {
+		// slow start
+		if n > uint32(mss) {
+			n = uint32(mss)
+		}
+		cc.cwnd += n
+	} else {
+		// congestion avoidance
+		inc := uint32(mss) * uint32(mss) / cc.cwnd
+		if inc == 0 {
+			inc = 1
+		}
+		cc.cwnd += inc
+	}
+	cc.cond.Broadcast()
+}
+
+// ece reduces the window in response to an ECN echo, at most once per window of data.
+func (cc *congestion) ece(una, nxt, flight uint32) {
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+	if cc.reduced && int32(una-cc.recover) < 0 {
+		return
+	}
+	cc.reduce(flight)
+	cc.cwnd = cc.ssthresh
+	cc.recover = nxt
+	cc.reduced = true
+	cc.cwr = true
+}
+
+// timeout collapses the window after the retransmission timer expired.
+func (cc *congestion) timeout(flight uint32) {
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+	cc.reduce(flight)
+	cc.cwnd = uint32(mss)
+	cc.cond.Broadcast()
+}
+
+func (cc *congestion) reduce(flight uint32) {
+	cc.ssthresh = flight / 2
+	if cc.ssthresh < 2*uint32(mss) {
+		cc.ssthresh = 2 * uint32(mss)
+	}
+}
+
+// takeCwr reports whether CWR has to be set and clears it.
+func (cc *congestion) takeCwr() bool {
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+	cwr := cc.cwr
+	cc.cwr = false
+	return cwr
+}
@@ -0,0 +1,46 @@
+package tcp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/terassyi/gotcp/pkg/packet/ipv4"
+	"github.com/terassyi/gotcp/pkg/packet/tcp"
+)
+
+func TestWriteWaitingWindowReset(t *testing.T) {
+	tp, err := New(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := newEstablishedConn(t, tp)
+	conn.tcb.snd.WND = 100
+	if _, err := conn.Write(make([]byte, 100)); err != nil {
+		t.Fatal(err)
+	}
+	<-tp.SendQueue
+	written := make(chan error, 1)
+	go func() {
+		// the send window is full
+		_, err := conn.Write(make([]byte, 100))
+		written <- err
+	}()
+	select {
+	case err := <-written:
+		t.Fatalf("written beyond the send window: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	rst, err := tcp.Build(40000, 8080, 1000, 0, tcp.RST, 0, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tp.HandlePacket(&ipv4.IPAddress{192, 168, 0, 3}, serializeSegment(t, rst))
+	select {
+	case err := <-written:
+		if err == nil {
+			t.Fatal("written after reset")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("writer is not woken up by the reset")
+	}
+}
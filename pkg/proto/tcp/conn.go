@@ -2,10 +2,13 @@ package tcp
 
 import (
 	"fmt"
+	"io"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/terassyi/gotcp/pkg/logger"
+	"github.com/terassyi/gotcp/pkg/packet/ipv4"
 	"github.com/terassyi/gotcp/pkg/packet/tcp"
 	"github.com/terassyi/gotcp/pkg/proto/port"
 )
@@ -13,46 +16,87 @@ import (
 type Conn struct {
 	tcb                 *controlBlock
 	Peer                *port.Peer
-	retransmissionQueue chan *AddressedPacket
+	retransmissionQueue chan *retransmissionPacket
 	closeQueue          chan AddressedPacket
 	receivedAck         chan uint32
 	rcvBuffer           *rcvBuffer
+	cc                  *congestion
+	urg                 *urgent
+	rtt                 *rttEstimator
+	ooo                 map[uint32]AddressedPacket // out-of-order segments keyed by the sequence number
+	stats               connStats
 	mutex               sync.RWMutex
+	handleMutex         sync.Mutex // serializes segment handling
 	readyQueue          chan []byte
 	inner               *Tcp
 	pushFlag            bool
+	pending             *dialer // fast open handshake deferred until the first write
+	keepAlive           *keepAlive
+	lastReceived        int64 // unix nano of the last segment, accessed atomically
 	logger              *logger.Logger
 }
 
+// rcvBuffer holds received data until read.
+// The segment handler never blocks on it, readable is only a wake up signal for the reader.
 type rcvBuffer struct {
 	buf      []byte
+	closed   bool  // fin is received
+	err      error // returned instead of EOF when the connection is aborted
+	mutex    sync.Mutex
 	readable chan struct{}
 }
 
 func newRcvBuffer() *rcvBuffer {
 	return &rcvBuffer{
 		buf:      make([]byte, 0, window),
-		readable: make(chan struct{}),
+		readable: make(chan struct{}, 1),
 	}
 }
 
-func (r *rcvBuffer) init() {
-	r.buf = make([]byte, 0, window)
-	r.readable = make(chan struct{})
+func (r *rcvBuffer) notify() {
+	select {
+	case r.readable <- struct{}{}:
+	default:
+	}
+}
+
+func (r *rcvBuffer) close() {
+	r.mutex.Lock()
+	r.closed = true
+	r.mutex.Unlock()
+	r.notify()
+}
+
+func (r *rcvBuffer) abort(err error) {
+	r.mutex.Lock()
+	r.closed = true
+	r.err = err
+	r.mutex.Unlock()
+	r.notify()
+}
+
+func (r *rcvBuffer) isClosed() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.closed
 }
 
 const (
 	window uint32 = 3000
-	rto    int    = 30   // select a better value
 	mss    int    = 1448 // max segment size
 )
 
 func newConn(peer *port.Peer, debug bool) (*Conn, error) {
+	tcb := NewControlBlock(peer, debug)
 	conn := &Conn{
-		tcb:                 NewControlBlock(peer, debug),
+		tcb:                 tcb,
 		Peer:                peer,
-		retransmissionQueue: make(chan *AddressedPacket, 100),
+		retransmissionQueue: make(chan *retransmissionPacket, 100),
 		rcvBuffer:           newRcvBuffer(),
+		cc:                  newCongestion(tcb.mutex),
+		urg:                 newUrgent(),
+		rtt:                 newRttEstimator(),
+		ooo:                 make(map[uint32]AddressedPacket),
 		mutex:               sync.RWMutex{},
 	}
 	conn.tcb.rcv.WND = window
@@ -60,81 +104,122 @@ func newConn(peer *port.Peer, debug bool) (*Conn, error) {
 }
 
 func (t *Tcp) Dial(addr string, peerport int) (*Conn, error) {
-	return t.doDial(addr, peerport)
+	return t.doDial(addr, peerport, &Dialer{})
 }
 
-func (t *Tcp) doDial(addr string, peerport int) (*Conn, error) {
-	dialer, err := t.dial(addr, peerport)
+// DialWith connects to the peer with the options of the Dialer.
+func (t *Tcp) DialWith(d *Dialer, addr string, peerport int) (*Conn, error) {
+	return t.doDial(addr, peerport, d)
+}
+
+func (t *Tcp) doDial(addr string, peerport int, opts *Dialer) (*Conn, error) {
+	dialer, err := t.dial(addr, peerport, opts)
 	if err != nil {
 		return nil, err
 	}
+	if dialer.fastOpen {
+		conn := dialer.newConn()
+		conn.pending = dialer
+		return conn, nil
+	}
+	if _, err := dialer.establish(nil); err != nil {
+		return nil, err
+	}
 	return dialer.getConnection()
 }
 
+// openFast completes the deferred fast open handshake carrying data in the syn.
+func (c *Conn) openFast(data []byte) (int, error) {
+	d := c.pending
+	c.pending = nil
+	n, err := d.establish(data)
+	if err != nil {
+		return 0, err
+	}
+	d.register(c)
+	return n, nil
+}
+
 func (c *Conn) Close() error {
 	return c.activeClose()
 }
 
 func (c *Conn) activeClose() error {
 	// close tcb
-	c.tcb.mutex.RLock()
-	defer c.tcb.mutex.RUnlock()
+	// the lock is released while waiting, the segment handler updates the tcb meanwhile
+	c.tcb.mutex.Lock()
 	if c.tcb.state != ESTABLISHED && c.tcb.state != CLOSE_WAIT && c.tcb.state != SYN_RECVD {
+		c.tcb.mutex.Unlock()
 		return fmt.Errorf("invalid state")
 	}
 	if err := c.send(tcp.ACK|tcp.FIN, nil); err != nil {
+		c.tcb.mutex.Unlock()
 		return err
 	}
 	c.tcb.snd.NXT++
 	c.tcb.finSend = true
-	if c.tcb.state == SYN_RECVD || c.tcb.state == ESTABLISHED {
-		c.tcb.FIN_WAIT1()
-		// wait ack of fin
-		p, ok := <-c.closeQueue
-		if !ok {
-			return fmt.Errorf("failed to recieve ack of fin.")
-		}
-		if p.Packet.Header.OffsetControlFlag.ControlFlag().Fin() {
-			// simultaneous close
-			c.tcb.rcv.NXT += 1
-			if err := c.send(tcp.ACK, nil); err != nil {
-				return err
-			}
-			c.tcb.CLOSING()
-			c.tcb.TIME_WAIT()
-			c.tcb.startMSL()
-			c.tcb.CLOSED()
-			return nil
-		}
-		// got ack
-
-		c.tcb.FIN_WAIT2()
-		// wait fin
-		_, ok = <-c.closeQueue
-		if !ok {
-			return fmt.Errorf("failed to recieve ack of fin.")
-		}
-
-		c.tcb.TIME_WAIT()
-
+	// writers waiting for the window give up
+	c.cc.update()
+	if c.tcb.state != SYN_RECVD && c.tcb.state != ESTABLISHED {
+		c.tcb.CLOSE_WAIT()
+		c.tcb.mutex.Unlock()
+		return nil
+	}
+	c.tcb.FIN_WAIT1()
+	c.tcb.mutex.Unlock()
+	// wait ack of fin
+	p, ok := <-c.closeQueue
+	if !ok {
+		return fmt.Errorf("failed to recieve ack of fin.")
+	}
+	c.tcb.mutex.Lock()
+	if p.Packet.Header.OffsetControlFlag.ControlFlag().Fin() {
+		// simultaneous close
+		c.tcb.rcv.NXT += 1
 		if err := c.send(tcp.ACK, nil); err != nil {
+			c.tcb.mutex.Unlock()
 			return err
 		}
-		c.tcb.startMSL()
+		c.tcb.CLOSING()
+		c.tcb.TIME_WAIT()
+		c.tcb.mutex.Unlock()
+		c.tcb.startMSL(c.inner.MSL)
+		c.tcb.mutex.Lock()
 		c.tcb.CLOSED()
-		delete(c.inner.connections, c.Peer.Port)
-		c.logger.Info("connection closed.")
+		c.tcb.mutex.Unlock()
+		return nil
+	}
+	// got ack
+
+	c.tcb.FIN_WAIT2()
+	c.tcb.mutex.Unlock()
+	// wait fin
+	_, ok = <-c.closeQueue
+	if !ok {
+		return fmt.Errorf("failed to recieve ack of fin.")
+	}
 
-	} else {
-		c.tcb.CLOSE_WAIT()
+	c.tcb.mutex.Lock()
+	c.tcb.TIME_WAIT()
+
+	if err := c.send(tcp.ACK, nil); err != nil {
+		c.tcb.mutex.Unlock()
+		return err
 	}
+	c.tcb.mutex.Unlock()
+	c.tcb.startMSL(c.inner.MSL)
+	c.tcb.mutex.Lock()
+	c.tcb.CLOSED()
+	c.cc.update()
+	c.tcb.mutex.Unlock()
+	c.inner.removeConnection(c.Peer.Port)
+	c.logger.Info("connection closed.")
 	return nil
 }
 
+// passiveClose is called by the segment handler holding the tcb lock.
 func (c *Conn) passiveClose(fin AddressedPacket) error {
 	// close tcb
-	c.tcb.mutex.RLock()
-	defer c.tcb.mutex.RUnlock()
 	c.tcb.rcv.NXT += 1
 	if c.tcb.state == CLOSED || c.tcb.state == LISTEN || c.tcb.state == SYN_SENT {
 		// drop packet
@@ -169,16 +254,71 @@ func (c *Conn) passiveClose(fin AddressedPacket) error {
 	if c.tcb.state == LAST_ACK {
 		c.tcb.CLOSED()
 	}
+	c.cc.update()
 	if c.tcb.state == TIME_WAIT {
-		// restart 2MSL
-		c.tcb.startMSL()
-		c.tcb.startMSL()
+		// restart 2MSL without blocking the segment handler
+		go func() {
+			c.tcb.startMSL(2 * c.inner.MSL)
+			c.tcb.mutex.Lock()
+			c.tcb.CLOSED()
+			c.cc.update()
+			c.tcb.mutex.Unlock()
+			c.inner.removeConnection(c.Peer.Port)
+		}()
+		return nil
 	}
-	delete(c.inner.connections, c.Peer.Port)
+	c.inner.removeConnection(c.Peer.Port)
 	return nil
 }
 
+// trimDuplicate removes the text already received from the head of the retransmitted segment.
+func (c *Conn) trimDuplicate(packet *AddressedPacket) {
+	hdr := packet.Packet.Header
+	dup := c.tcb.rcv.NXT - hdr.Sequence
+	if int32(dup) <= 0 || int(dup) >= len(packet.Packet.Data) || hdr.OffsetControlFlag.ControlFlag().Syn() {
+		return
+	}
+	trimmed := *packet.Packet
+	trimmed.Header.Sequence = c.tcb.rcv.NXT
+	trimmed.Data = packet.Packet.Data[dup:]
+	packet.Packet = &trimmed
+}
+
+// notifyClose passes the segment to the closing routine if it waits.
+func (c *Conn) notifyClose(packet AddressedPacket) {
+	select {
+	case c.closeQueue <- packet:
+	default:
+	}
+}
+
+// receive handles the segment routed to the connection.
+func (c *Conn) receive(packet AddressedPacket) error {
+	c.handleMutex.Lock()
+	defer c.handleMutex.Unlock()
+	c.tcb.mutex.Lock()
+	defer c.tcb.mutex.Unlock()
+	return c.handle(packet)
+}
+
+// drain handles the segments queued before the connection is registered.
+func (c *Conn) drain(queue chan AddressedPacket) {
+	c.tcb.mutex.Lock()
+	defer c.tcb.mutex.Unlock()
+	for {
+		select {
+		case p := <-queue:
+			if err := c.handle(p); err != nil {
+				c.logger.Error(err)
+			}
+		default:
+			return
+		}
+	}
+}
+
 func (c *Conn) handle(packet AddressedPacket) error {
+	c.touch()
 
 	// handle incoming segment
 
@@ -197,18 +337,39 @@ func (c *Conn) handle(packet AddressedPacket) error {
 	     >0      >0     RCV.NXT =< SEG.SEQ < RCV.NXT+RCV.WND
 	                 or RCV.NXT =< SEG.SEQ+SEG.LEN-1 < RCV.NXT+RCV.WND
 	*/
-	if c.tcb.rcv.WND == 0 || packet.Packet.Header.Sequence != c.tcb.rcv.NXT {
+	c.trimDuplicate(&packet)
+	if wnd := c.rcvWindow(); wnd == 0 || packet.Packet.Header.Sequence != c.tcb.rcv.NXT {
+		if wnd != 0 && c.queueOutOfOrder(packet) {
+			return nil
+		}
+		// an unacceptable segment is acknowledged, keep-alive probes are answered by it
+		if !packet.Packet.Header.OffsetControlFlag.ControlFlag().Rst() && c.tcb.IsReadySend() {
+			if err := c.send(tcp.ACK, nil); err != nil {
+				return err
+			}
+		}
 		return fmt.Errorf("recieve window is invalid: seq=%x rcv.nxt=%x", packet.Packet.Header.Sequence, c.tcb.rcv.NXT)
 	}
 
 	// second check the RST bit,
 	if packet.Packet.Header.OffsetControlFlag.ControlFlag().Rst() {
 		c.tcb.CLOSED()
+		c.cc.update()
 		return nil
 	}
 	// third check security and precedence
 	// TODO
 
+	// ECN (RFC 3168 6.1.3), echo congestion until the sender reduces the window
+	if c.tcb.ecn {
+		if packet.Packet.Header.OffsetControlFlag.ControlFlag().Cwr() {
+			c.tcb.ecnEcho = false
+		}
+		if packet.ECN == ipv4.ECNCE {
+			c.tcb.ecnEcho = true
+		}
+	}
+
 	// fourth, check the SYN bit
 	if packet.Packet.Header.OffsetControlFlag.ControlFlag().Syn() {
 		c.tcb.rcv.NXT += 1
@@ -216,6 +377,7 @@ func (c *Conn) handle(packet AddressedPacket) error {
 			return err
 		}
 		c.tcb.CLOSED()
+		c.cc.update()
 		return nil
 	}
 	// fifth check the ACK field
@@ -226,16 +388,13 @@ func (c *Conn) handle(packet AddressedPacket) error {
 			c.handleEstablished(packet)
 		case FIN_WAIT1:
 			c.handleEstablished(packet)
-			if c.tcb.finSend {
-				c.closeQueue <- packet
+			// our fin is acknowledged or simultaneous close
+			if c.tcb.finSend && (packet.Packet.Header.Ack == c.tcb.snd.NXT || packet.Packet.Header.OffsetControlFlag.ControlFlag().Fin()) {
+				c.notifyClose(packet)
 			}
-			//c.closeQueue <- packet
 			return nil
 		case FIN_WAIT2:
 			c.handleEstablished(packet)
-			if packet.Packet.Data == nil {
-				c.closeQueue <- packet
-			}
 		case CLOSE_WAIT:
 			c.handleEstablished(packet)
 		case CLOSING:
@@ -247,6 +406,7 @@ func (c *Conn) handle(packet AddressedPacket) error {
 			// only reach here is when acknowledgement of my FIN
 			if c.tcb.finSend {
 				c.tcb.CLOSED()
+				c.cc.update()
 			}
 		case TIME_WAIT:
 			// resend fin
@@ -259,7 +419,7 @@ func (c *Conn) handle(packet AddressedPacket) error {
 		return fmt.Errorf("ack field is not set")
 	}
 	// sixth check the URG bit
-	// TODO
+	c.handleUrgent(packet)
 
 	// seventh process the segment text
 	switch c.tcb.state {
@@ -276,36 +436,54 @@ func (c *Conn) handle(packet AddressedPacket) error {
 		case CLOSED, LISTEN, SYN_SENT:
 			// ignore
 		case SYN_RECVD, ESTABLISHED:
+			c.rcvBuffer.close()
 			if err := c.passiveClose(packet); err != nil {
 				return err
 			}
 		case FIN_WAIT1:
 			// ack
 		case FIN_WAIT2:
-			c.closeQueue <- packet
-			if err := c.passiveClose(packet); err != nil {
-				return err
-			}
-			c.tcb.TIME_WAIT()
+			// the closing routine acks the fin and waits in TIME_WAIT
+			c.tcb.rcv.NXT += 1
+			c.rcvBuffer.close()
+			c.notifyClose(packet)
 		default:
 			// stay
 		}
 	}
+	// the queued segment may be in order now
+	if next, ok := c.nextInOrder(); ok {
+		return c.handle(next)
+	}
 	return nil
 }
 
 func (c *Conn) handleEstablished(packet AddressedPacket) {
-	if c.tcb.snd.UNA < packet.Packet.Header.Ack && packet.Packet.Header.Ack <= c.tcb.snd.NXT {
-		c.tcb.snd.UNA = packet.Packet.Header.Ack
-
+	ack := packet.Packet.Header.Ack
+	if int32(ack-c.tcb.snd.UNA) > 0 && int32(ack-c.tcb.snd.NXT) <= 0 {
+		acked := ack - c.tcb.snd.UNA
+		c.tcb.snd.UNA = ack
+		c.cc.acked(acked)
+		atomic.AddUint64(&c.stats.bytesAcked, uint64(acked))
+	} else if ack == c.tcb.snd.UNA && len(packet.Packet.Data) == 0 && c.flight() > 0 &&
+		packet.Packet.Header.WindowSize == c.tcb.snd.WND {
+		// duplicate ack (RFC 5681)
+		atomic.AddUint64(&c.stats.dupAcks, 1)
+	}
+	// the window is updated also by segments not acknowledging new data
+	if int32(ack-c.tcb.snd.UNA) >= 0 && int32(ack-c.tcb.snd.NXT) <= 0 {
 		// SND.WL1 < SEG.SEQ or (SND.WL1 = SEG.SEQ and SND.WL2 =< SEG.ACK)
-		if c.tcb.snd.WL1 < packet.Packet.Header.Sequence || (c.tcb.snd.WL1 == packet.Packet.Header.Sequence && c.tcb.snd.WL2 <= packet.Packet.Header.Ack) {
+		seq := packet.Packet.Header.Sequence
+		if int32(seq-c.tcb.snd.WL1) > 0 || (c.tcb.snd.WL1 == seq && int32(ack-c.tcb.snd.WL2) >= 0) {
 			c.tcb.snd.WND = packet.Packet.Header.WindowSize
-			c.tcb.snd.WL1 = packet.Packet.Header.Sequence
-			c.tcb.snd.WL2 = packet.Packet.Header.Ack
-
+			c.tcb.snd.WL1 = seq
+			c.tcb.snd.WL2 = ack
+			c.cc.update()
 		}
 	}
+	if c.tcb.ecn && packet.Packet.Header.OffsetControlFlag.ControlFlag().Ecn() {
+		c.cc.ece(c.tcb.snd.UNA, c.tcb.snd.NXT, c.flight())
+	}
 	// send signal retransmission routine
 	c.receivedAck <- packet.Packet.Header.Ack
 }
@@ -316,138 +494,260 @@ func (c *Conn) handleSegment(packet AddressedPacket) error {
 	}
 
 	// Do not check PSH flag.
-	l := len(packet.Packet.Data)
-	if len(c.rcvBuffer.buf)+l >= cap(c.rcvBuffer.buf) {
-		c.rcvBuffer.init()
-		c.tcb.rcv.WND = window
-		c.logger.Debug("recieve buffer is full. drop buffer.")
+	// The text beyond the window is trimmed, the peer sends it again.
+	data := packet.Packet.Data
+	c.rcvBuffer.mutex.Lock()
+	if uint32(len(data)) > c.tcb.rcv.WND {
+		data = data[:c.tcb.rcv.WND]
 	}
-	c.rcvBuffer.buf = append(c.rcvBuffer.buf, packet.Packet.Data...)
+	l := len(data)
+	c.rcvBuffer.buf = append(c.rcvBuffer.buf, c.extractUrgent(packet.Packet.Header.Sequence, data)...)
 	c.tcb.rcv.NXT = c.tcb.rcv.NXT + uint32(l)
 	c.tcb.rcv.WND = c.tcb.rcv.WND - uint32(l)
+	c.rcvBuffer.mutex.Unlock()
+	atomic.AddUint64(&c.stats.bytesReceived, uint64(l))
 	if err := c.send(tcp.ACK, nil); err != nil {
 		return err
 	}
 
-	c.rcvBuffer.readable <- struct{}{}
+	c.rcvBuffer.notify()
 	return nil
 }
 
+// rcvWindow returns rcv.WND updated by the reader concurrently.
+func (c *Conn) rcvWindow() uint32 {
+	c.rcvBuffer.mutex.Lock()
+	defer c.rcvBuffer.mutex.Unlock()
+	return c.tcb.rcv.WND
+}
+
 func (c *Conn) handleFin(packet AddressedPacket) error {
 	return c.send(tcp.ACK, nil)
 }
 
 func (c *Conn) send(flag tcp.ControlFlag, data []byte) error {
+	if c.tcb.ecnEcho {
+		flag |= tcp.ECN
+	}
+	if c.tcb.ecn && data != nil && c.cc.takeCwr() {
+		flag |= tcp.CWR
+	}
+	var up uint16
+	if data != nil {
+		if p, ok := c.urgentPointer(c.tcb.snd.NXT); ok {
+			flag |= tcp.URG
+			up = p
+		}
+	}
 	p, err := tcp.Build(
 		uint16(c.tcb.peer.Port), uint16(c.tcb.peer.PeerPort),
-		c.tcb.snd.NXT, c.tcb.rcv.NXT, flag, uint16(c.tcb.rcv.WND), 0, data)
+		c.tcb.snd.NXT, c.tcb.rcv.NXT, flag, uint16(c.rcvWindow()), up, data)
 	if err != nil {
 		return err
 	}
-	c.inner.enqueue(c.tcb.peer.PeerAddr, p)
+	if c.tcb.ecn && data != nil {
+		c.inner.enqueueECT(c.tcb.peer.Addr, c.tcb.peer.PeerAddr, p)
+	} else {
+		c.inner.enqueue(c.tcb.peer.Addr, c.tcb.peer.PeerAddr, p)
+	}
 	if data != nil {
 		c.tcb.snd.NXT += uint32(len(data))
+		atomic.AddUint64(&c.stats.bytesSent, uint64(len(data)))
 	}
 	// add retransmission queue
 	if c.tcb.IsReadyRecv() && data != nil {
-		c.retransmissionQueue <- &AddressedPacket{
-			Packet:  p,
-			Address: c.tcb.peer.PeerAddr,
+		c.retransmissionQueue <- &retransmissionPacket{
+			timeStamp: c.inner.Clock.Now(),
+			ackNum:    c.tcb.snd.NXT,
+			packet: &AddressedPacket{
+				Packet:  p,
+				Address: c.tcb.peer.PeerAddr,
+			},
 		}
 	}
 	return nil
 }
 
+// sendWindow returns the window advertised by the peer.
+func (c *Conn) sendWindow() uint32 {
+	return uint32(c.tcb.snd.WND) << c.tcb.options.SndWindowScale
+}
+
+// flight returns the number of bytes sent but not acknowledged yet.
+func (c *Conn) flight() uint32 {
+	return c.tcb.snd.NXT - c.tcb.snd.UNA
+}
+
 func (c *Conn) resend(packet *AddressedPacket) error {
-	c.inner.enqueue(c.tcb.peer.PeerAddr, packet.Packet)
+	c.inner.enqueue(c.tcb.peer.Addr, c.tcb.peer.PeerAddr, packet.Packet)
+	atomic.AddUint64(&c.stats.retransmits, 1)
 	return nil
 }
 
 func (c *Conn) Read(b []byte) (int, error) {
-	if !c.tcb.IsReadyRecv() {
+	if c.pending != nil {
+		if _, err := c.openFast(nil); err != nil {
+			return 0, err
+		}
+	}
+	// the data received before fin can be read after closed
+	c.tcb.mutex.RLock()
+	ready := c.tcb.IsReadyRecv()
+	c.tcb.mutex.RUnlock()
+	if !ready && !c.rcvBuffer.isClosed() {
 		return 0, fmt.Errorf("invalid state")
 	}
 	return c.read(b)
 }
 
 func (c *Conn) read(b []byte) (int, error) {
-	if _, ok := <-c.rcvBuffer.readable; !ok {
-		return 0, fmt.Errorf("failed to recv")
+	for {
+		c.rcvBuffer.mutex.Lock()
+		if len(c.rcvBuffer.buf) > 0 {
+			l := copy(b, c.rcvBuffer.buf)
+			c.rcvBuffer.buf = append(c.rcvBuffer.buf[:0], c.rcvBuffer.buf[l:]...)
+			closed := c.tcb.rcv.WND < uint32(mss)
+			c.tcb.rcv.WND = window - uint32(len(c.rcvBuffer.buf))
+			opened := closed && c.tcb.rcv.WND >= uint32(mss)
+			c.rcvBuffer.mutex.Unlock()
+			if opened {
+				// window update
+				if err := c.sendWindowUpdate(); err != nil {
+					return l, err
+				}
+			}
+			return l, nil
+		}
+		if c.rcvBuffer.closed {
+			err := c.rcvBuffer.err
+			c.rcvBuffer.mutex.Unlock()
+			if err != nil {
+				return 0, err
+			}
+			return 0, io.EOF
+		}
+		c.rcvBuffer.mutex.Unlock()
+		<-c.rcvBuffer.readable
+	}
+}
+
+// sendWindowUpdate acknowledges the window opened by the reader.
+func (c *Conn) sendWindowUpdate() error {
+	c.tcb.mutex.Lock()
+	defer c.tcb.mutex.Unlock()
+	if !c.tcb.IsReadyRecv() {
+		return nil
 	}
-	l := copy(b, c.rcvBuffer.buf)
-	c.rcvBuffer.init()
-	c.tcb.rcv.WND = window
-	return l, nil
+	return c.send(tcp.ACK, nil)
 }
 
 func (c *Conn) Write(b []byte) (int, error) {
-	if !c.tcb.IsReadySend() {
-		return 0, fmt.Errorf("invalid state")
+	if c.pending != nil {
+		n, err := c.openFast(b)
+		if err != nil || n == len(b) {
+			return n, err
+		}
+		m, err := c.write(b[n:])
+		return n + m, err
 	}
 	return c.write(b)
 }
 
 func (c *Conn) write(b []byte) (int, error) {
+	c.tcb.mutex.Lock()
+	defer c.tcb.mutex.Unlock()
+	if !c.tcb.IsReadySend() {
+		return 0, fmt.Errorf("invalid state")
+	}
 	return c.writeWithSegment(b)
 }
 
+// writeWithSegment is called holding the tcb lock, the lock is released while waiting for the window.
 func (c *Conn) writeWithSegment(b []byte) (int, error) {
 	count := 0
 	for i := mss; i < len(b); i += mss {
 		flag := tcp.ACK
+		if !c.cc.wait(c.flight, c.sendWindow, c.tcb.IsReadySend, mss) {
+			return count, fmt.Errorf("connection is closed")
+		}
 		if err := c.send(flag, b[count:i]); err != nil {
 			return count, err
 		}
 		count += mss
 	}
 	flag := tcp.ACK + tcp.PSH
+	if !c.cc.wait(c.flight, c.sendWindow, c.tcb.IsReadySend, len(b)-count) {
+		return count, fmt.Errorf("connection is closed")
+	}
 	if err := c.send(flag, b[count:]); err != nil {
 		return count, err
 	}
-	return count, nil
+	return len(b), nil
 }
 
+// retransmissionHandler starts the retransmission routine, the timer is running on return.
 func (c *Conn) retransmissionHandler() {
 
-	queue := make([]retransmissionPacket, 0, 100)
+	queue := make([]*retransmissionPacket, 0, 100)
+	ticker := c.inner.Clock.NewTicker(100 * time.Millisecond) // scheduler
 
 	go func() {
-		ticker := time.NewTicker(1 * time.Second) // scheduler
 		for {
 			select {
 			case q := <-c.retransmissionQueue:
-				queue = append(queue, retransmissionPacket{
-					timeStamp: time.Now(),
-					ackNum:    q.Packet.Header.Sequence + uint32(len(q.Packet.Data)),
-					packet:    q,
-				})
+				queue = append(queue, q)
 			case ack := <-c.receivedAck:
-				idx := -1
-				for i, p := range queue {
-					if p.ackNum == ack {
-						idx = i
+				// remove the segments acknowledged cumulatively
+				var measured *retransmissionPacket
+				remaining := queue[:0]
+				for _, p := range queue {
+					if int32(ack-p.ackNum) < 0 {
+						remaining = append(remaining, p)
+						continue
+					}
+					// Karn's algorithm, do not measure retransmitted segments
+					if !p.retransmitted {
+						measured = p
 					}
 				}
-				if idx == -1 {
-					continue
+				queue = remaining
+				if measured != nil {
+					c.rtt.sample(c.inner.Clock.Since(measured.timeStamp))
 				}
-				if idx == 0 {
-					queue = queue[1:]
-				} else if idx == len(queue) {
-					queue = queue[:len(queue)-1]
-				} else {
-					queue = append(queue[:idx-1], queue[idx:]...)
+			case <-c.inner.Done:
+				ticker.Stop()
+				return
+			case n := <-ticker.C():
+				// segments sent before the tick are also checked
+			drain:
+				for {
+					select {
+					case q := <-c.retransmissionQueue:
+						queue = append(queue, q)
+					default:
+						break drain
+					}
 				}
-			case n := <-ticker.C:
+				expired := false
+				rto := c.rtt.timeout()
+				// the flight is the data in the queue, the timer does not wait for the tcb lock held by the writer
+				flight := uint32(0)
 				for _, q := range queue {
-					if n.Unix() >= q.timeStamp.Unix()+int64(rto) {
+					flight += uint32(len(q.packet.Packet.Data))
+					if n.Sub(q.timeStamp) >= rto {
 						if err := c.resend(q.packet); err != nil {
 							c.logger.Error(err)
 							continue
 						}
 						q.timeStamp = n // reset timestamp
+						q.retransmitted = true
+						expired = true
 					}
 				}
+				if expired {
+					c.cc.timeout(flight)
+					c.rtt.backoff()
+				}
 			}
 		}
 	}()
@@ -6,6 +6,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/terassyi/gotcp/pkg/clock"
 	"github.com/terassyi/gotcp/pkg/logger"
 	"github.com/terassyi/gotcp/pkg/packet/tcp"
 	"github.com/terassyi/gotcp/pkg/proto/port"
@@ -20,6 +21,10 @@ type controlBlock struct {
 	ack     chan uint32
 	Window  []byte
 	finSend bool
+	ecn     bool // ECN is negotiated in the handshake
+	ecnEcho bool // set ECE in acks until CWR is received
+	options NegotiatedOptions
+	clock   clock.Clock
 	mutex   *sync.RWMutex
 	logger  *logger.Logger
 }
@@ -127,7 +132,7 @@ func (cb *controlBlock) LAST_ACK() {
 	cb.logger.Debug(cb.state.String())
 }
 
-func (cb *controlBlock) activeOpen() (*tcp.Packet, error) {
+func (cb *controlBlock) activeOpen(extra ...tcp.Option) (*tcp.Packet, error) {
 	// client
 	// send syn
 	// move to SYN_SENT
@@ -142,11 +147,11 @@ func (cb *controlBlock) activeOpen() (*tcp.Packet, error) {
 		return nil, err
 	}
 	// add option
-	t, err := tcp.NewTimeStamp()
+	t, err := tcp.NewTimeStampAt(cb.clock.Now())
 	if err != nil {
 		return nil, err
 	}
-	packet.AddOption(tcp.Options{tcp.MaxSegmentSize(1460), tcp.WindowScale(7), *t})
+	packet.AddOption(append(tcp.Options{tcp.MaxSegmentSize(1460), tcp.WindowScale(0), *t}, extra...))
 	cb.SYN_SENT()
 	return packet, nil
 }
@@ -190,8 +195,8 @@ func (cb *controlBlock) IsReadySend() bool {
 	}
 }
 
-func (cb *controlBlock) startMSL() {
-	time.Sleep(time.Second * 10)
+func (cb *controlBlock) startMSL(msl time.Duration) {
+	cb.clock.Sleep(msl)
 }
 
 func (cb *controlBlock) showSeq() string {
@@ -211,11 +216,19 @@ func NewControlBlock(peer *port.Peer, debug bool) *controlBlock {
 		finSend: false,
 		retrans: make(chan AddressedPacket, 100),
 		Window:  make([]byte, 0, 65535),
+		clock:   clock.Real,
 		mutex:   &sync.RWMutex{},
 		logger:  logger.New(debug, "tcp"),
 	}
 }
 
+// newControlBlock returns the control block driven by the clock of the protocol.
+func (t *Tcp) newControlBlock(peer *port.Peer) *controlBlock {
+	cb := NewControlBlock(peer, t.logger.DebugMode())
+	cb.clock = t.Clock
+	return cb
+}
+
 func newSnd() *SendSequence {
 	return &SendSequence{
 		UNA: 0,
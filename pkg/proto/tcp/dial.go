@@ -10,24 +10,35 @@ import (
 	"github.com/terassyi/gotcp/pkg/proto/port"
 )
 
+// Dialer contains options for connecting to a peer.
+type Dialer struct {
+	// FastOpen enables TCP Fast Open.
+	// The handshake is deferred until the first Write, and the written data is sent in the SYN
+	// when a cookie for the peer has been cached by a previous connection.
+	FastOpen bool
+	// MD5Key signs all segments of the connection with the TCP MD5 signature option.
+	MD5Key []byte
+}
+
 type dialer struct {
-	tcb    *controlBlock
-	peer   *port.Peer
-	queue  chan AddressedPacket
-	inner  *Tcp
-	logger *logger.Logger
+	tcb      *controlBlock
+	peer     *port.Peer
+	queue    chan AddressedPacket
+	inner    *Tcp
+	fastOpen bool
+	logger   *logger.Logger
 }
 
 func newDialer(inner *Tcp, peer *port.Peer) (*dialer, error) {
 	return &dialer{
-		tcb:    NewControlBlock(peer, inner.logger.DebugMode()),
+		tcb:    inner.newControlBlock(peer),
 		queue:  make(chan AddressedPacket, 100),
 		inner:  inner,
 		logger: inner.logger,
 	}, nil
 }
 
-func (t *Tcp) dial(addr string, peerport int) (*dialer, error) {
+func (t *Tcp) dial(addr string, peerport int, opts *Dialer) (*dialer, error) {
 	peerAddr, err := ipv4.StringToIPAddress(addr)
 	if err != nil {
 		return nil, err
@@ -37,29 +48,55 @@ func (t *Tcp) dial(addr string, peerport int) (*dialer, error) {
 		return nil, err
 	}
 	d := &dialer{
-		tcb:    NewControlBlock(peer, t.logger.DebugMode()),
+		tcb:    t.newControlBlock(peer),
 		peer:   peer,
 		queue:  make(chan AddressedPacket, 100),
 		inner:  t,
 		logger: t.logger,
 	}
 	d.tcb.rcv.WND = window
-	t.dialers[peer.Port] = d
-	if err := d.establish(); err != nil {
-		return nil, err
+	// no room for the cookie with the md5 signature
+	d.fastOpen = opts.FastOpen && opts.MD5Key == nil
+	if opts.MD5Key != nil {
+		t.md5.set(peer.Port, peerAddr, opts.MD5Key)
 	}
+	t.mutex.Lock()
+	t.dialers[peer.Port] = d
+	t.mutex.Unlock()
 	return d, nil
 }
 
-func (d *dialer) establish() error {
+// establish performs the 3 way handshake.
+// With fast open, data is sent in the syn and the length of data acknowledged by the peer is returned.
+func (d *dialer) establish(data []byte) (int, error) {
 	// tcp active open
 	d.tcb.mutex.RLock()
 	defer d.tcb.mutex.RUnlock()
-	p, err := d.tcb.activeOpen()
+	var extra tcp.Options
+	var synData []byte
+	if d.fastOpen {
+		if cookie := d.inner.fastOpen.lookup(d.peer.PeerAddr); cookie != nil && len(data) > 0 {
+			extra = append(extra, cookie)
+			synData = data
+			if len(synData) > mss {
+				synData = synData[:mss]
+			}
+		} else {
+			// request a cookie
+			extra = append(extra, tcp.FastOpenCookie{})
+		}
+	}
+	p, err := d.tcb.activeOpen(extra...)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	d.inner.enqueue(d.peer.PeerAddr, p)
+	p.Data = synData
+	d.tcb.snd.NXT += uint32(len(synData))
+	if d.inner.ECN {
+		// ECN-setup syn
+		p.SetFlag(tcp.ECN | tcp.CWR)
+	}
+	d.inner.enqueue(d.peer.Addr, d.peer.PeerAddr, p)
 	// wait to receive syn|ack packet
 	synAck, ok := <-d.queue
 	if !ok {
@@ -68,16 +105,38 @@ func (d *dialer) establish() error {
 		rep, err := tcp.Build(synAck.Packet.Header.DestinationPort, synAck.Packet.Header.SourcePort,
 			0, 0, tcp.RST, 0, 0, nil)
 		if err != nil {
-			return err
+			return 0, err
 		}
-		d.inner.enqueue(synAck.Address, rep)
-		return fmt.Errorf("received packet is not set syn|ack.")
+		d.inner.enqueue(synAck.Local, synAck.Address, rep)
+		return 0, fmt.Errorf("received packet is not set syn|ack.")
 	}
 	// handle syn|ack
+	// the connection keeps the address chosen for the syn by the ip layer
+	d.peer.Addr = synAck.Local
 	// This step should be reached only if the ACK is ok, or there is no ACK, and it the segment did not contain a RST.
 	d.tcb.rcv.NXT = synAck.Packet.Header.Sequence + 1
 	d.tcb.rcv.IRS = synAck.Packet.Header.Sequence
 	d.tcb.snd.UNA = synAck.Packet.Header.Ack
+	// the window in the syn is never scaled
+	d.tcb.snd.WND = synAck.Packet.Header.WindowSize
+	d.tcb.snd.WL1 = synAck.Packet.Header.Sequence
+	d.tcb.snd.WL2 = synAck.Packet.Header.Ack
+	d.tcb.negotiate(p.Option, synAck.Packet.Option)
+	if d.inner.ECN && synAck.Packet.Header.OffsetControlFlag.ControlFlag().Ecn() && !synAck.Packet.Header.OffsetControlFlag.ControlFlag().Cwr() {
+		d.tcb.ecn = true
+	}
+	acked := 0
+	if d.fastOpen {
+		if cookie := synAck.Packet.Option.FastOpenCookie(); cookie != nil && len(*cookie) > 0 {
+			d.inner.fastOpen.store(d.peer.PeerAddr, *cookie)
+		}
+		// the data not acknowledged in the syn|ack is sent again after the handshake
+		if n := int(d.tcb.snd.UNA - d.tcb.snd.ISS - 1); n > 0 && n <= len(synData) {
+			acked = n
+			d.tcb.options.FastOpen = true
+		}
+		d.tcb.snd.NXT = d.tcb.snd.ISS + 1 + uint32(acked)
+	}
 	if d.tcb.snd.ISS < d.tcb.snd.UNA {
 		d.tcb.ESTABLISHED()
 		ack, err := tcp.Build(
@@ -86,33 +145,54 @@ func (d *dialer) establish() error {
 			tcp.ACK,
 			synAck.Packet.Header.WindowSize, 0, nil)
 		if err != nil {
-			return err
+			return 0, err
 		}
 		// send ack packet
-		d.inner.enqueue(d.tcb.peer.PeerAddr, ack)
+		d.inner.enqueue(d.tcb.peer.Addr, d.tcb.peer.PeerAddr, ack)
 		d.logger.Debug("completed 3 way handshake")
-		return nil
+		return acked, nil
 	}
 	d.tcb.snd.Show()
-	return fmt.Errorf("invalid tcb")
+	return 0, fmt.Errorf("invalid tcb")
 }
 
 func (d *dialer) getConnection() (*Conn, error) {
+	conn := d.newConn()
+	d.register(conn)
+	return conn, nil
+}
+
+func (d *dialer) newConn() *Conn {
 	conn := &Conn{
 		tcb:                 d.tcb,
 		Peer:                d.peer,
-		retransmissionQueue: make(chan *AddressedPacket, 100),
+		retransmissionQueue: make(chan *retransmissionPacket, 100),
 		receivedAck:         make(chan uint32, 100),
 		closeQueue:          make(chan AddressedPacket, 1),
 		rcvBuffer:           newRcvBuffer(),
+		cc:                  newCongestion(d.tcb.mutex),
+		urg:                 newUrgent(),
+		rtt:                 newRttEstimator(),
+		ooo:                 make(map[uint32]AddressedPacket),
 		mutex:               sync.RWMutex{},
 		inner:               d.inner,
 		logger:              d.inner.logger,
 	}
 	conn.pushFlag = true
+	return conn
+}
+
+func (d *dialer) register(conn *Conn) {
+	conn.handleMutex.Lock()
+	defer conn.handleMutex.Unlock()
+	d.inner.mutex.Lock()
 	// entry connection list
 	d.inner.connections[conn.Peer.Port] = conn
 	// delete dialer from dialer list
 	delete(d.inner.dialers, conn.Peer.Port)
-	return conn, nil
+	d.inner.mutex.Unlock()
+	// segments following the handshake may be queued to the dialer
+	conn.drain(d.queue)
+	// start retransmission routine
+	conn.retransmissionHandler()
 }
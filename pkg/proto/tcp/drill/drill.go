@@ -0,0 +1,685 @@
+// Package drill runs scripted tcp tests in the style of packetdrill.
+//
+// Each line of the script is the time and the event.
+//
+//	0     listen 8080
+//	+0    < S 0:0(0) win 29200 <mss 1460,TS val 1 ecr 0>
+//	+0    > S. 0:0(0) ack 1 <mss 1460,sackOK,wscale 0,TS>
+//	+0    < . 1:1(0) ack 1 win 29200
+//	+0    accept
+//	+0.1  < P. 1:101(100) ack 1 win 29200
+//	+0    > . 1:1(0) ack 101
+//	+0    read 100
+//
+// The time is seconds from the start or, with +, from the previous line.
+// The virtual clock of the stack is advanced to the time before the event.
+//
+// Segments are written as FLAGS START:END(LEN) [ack N] [win N] [<OPTIONS>].
+// < is the segment sent by the peer and > is the segment expected to be sent by the stack.
+// FLAGS are S, F, R, P and . for ACK.
+// Sequence numbers are relative to the initial sequence number of the sender,
+// and ack numbers are relative to the initial sequence number of the receiver.
+// * matches any value in expected segments, and ack, win and options not written are not checked.
+// Options are mss N, wscale N, sackOK, TS val N ecr N and nop. TS without values matches any timestamp.
+//
+// Socket calls are listen PORT, accept, connect PORT, write N, read N, read EOF, close and state STATE.
+// accept waits for the connection accepted in the background after listen,
+// connect and close run in the background because they block until segments are exchanged.
+// Segments sent but not expected in the script are errors.
+package drill
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/terassyi/gotcp/pkg/clock"
+	"github.com/terassyi/gotcp/pkg/packet/ipv4"
+	"github.com/terassyi/gotcp/pkg/packet/tcp"
+	proto "github.com/terassyi/gotcp/pkg/proto/tcp"
+)
+
+const (
+	remoteISN  uint32 = 1000000
+	remotePort uint16 = 40000
+	defaultWin uint16 = 29200
+)
+
+var remoteAddr = ipv4.IPAddress{192, 168, 0, 3}
+
+// Script is the parsed script.
+type Script struct {
+	Name  string
+	Lines []*Line
+}
+
+// Line is the event of the script.
+type Line struct {
+	Number   int
+	Time     time.Duration
+	Relative bool
+	Inbound  *Segment
+	Outbound *Segment
+	Call     string
+	Args     []string
+}
+
+// Segment is the segment written in the script, nil values match any value.
+type Segment struct {
+	Flags   tcp.ControlFlag
+	Start   *uint32
+	Length  int
+	Ack     *uint32
+	Win     *uint16
+	Options []Option
+}
+
+// Option is the tcp option written in the script.
+type Option struct {
+	Kind   string
+	Values []*uint32 // nil matches any value
+}
+
+// ParseFile parses the script file.
+func ParseFile(path string) (*Script, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	s, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	s.Name = path
+	return s, nil
+}
+
+// Parse parses the script.
+func Parse(r io.Reader) (*Script, error) {
+	s := &Script{}
+	scanner := bufio.NewScanner(r)
+	n := 0
+	for scanner.Scan() {
+		n++
+		text := scanner.Text()
+		if i := strings.Index(text, "//"); i >= 0 {
+			text = text[:i]
+		}
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		line, err := parseLine(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		line.Number = n
+		s.Lines = append(s.Lines, line)
+	}
+	return s, scanner.Err()
+}
+
+func parseLine(text string) (*Line, error) {
+	fields := strings.Fields(text)
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("event is missing")
+	}
+	line := &Line{}
+	t := fields[0]
+	if strings.HasPrefix(t, "+") {
+		line.Relative = true
+		t = t[1:]
+	}
+	sec, err := strconv.ParseFloat(t, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time: %s", fields[0])
+	}
+	line.Time = time.Duration(sec * float64(time.Second))
+	rest := strings.TrimSpace(text[len(fields[0]):])
+	switch fields[1] {
+	case "<":
+		line.Inbound, err = parseSegment(strings.TrimSpace(rest[1:]), false)
+	case ">":
+		line.Outbound, err = parseSegment(strings.TrimSpace(rest[1:]), true)
+	default:
+		line.Call = fields[1]
+		line.Args = fields[2:]
+	}
+	return line, err
+}
+
+func parseSegment(text string, wildcard bool) (*Segment, error) {
+	var options string
+	if i := strings.Index(text, "<"); i >= 0 {
+		if !strings.HasSuffix(text, ">") {
+			return nil, fmt.Errorf("options are not closed")
+		}
+		options = text[i+1 : len(text)-1]
+		text = text[:i]
+	}
+	fields := strings.Fields(text)
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("flags and sequence number are required")
+	}
+	seg := &Segment{}
+	for _, c := range fields[0] {
+		switch c {
+		case 'S':
+			seg.Flags |= tcp.SYN
+		case 'F':
+			seg.Flags |= tcp.FIN
+		case 'R':
+			seg.Flags |= tcp.RST
+		case 'P':
+			seg.Flags |= tcp.PSH
+		case '.':
+			seg.Flags |= tcp.ACK
+		default:
+			return nil, fmt.Errorf("unknown flag: %c", c)
+		}
+	}
+	if err := seg.parseSequence(fields[1], wildcard); err != nil {
+		return nil, err
+	}
+	for i := 2; i < len(fields); i += 2 {
+		if i+1 >= len(fields) {
+			return nil, fmt.Errorf("value of %s is missing", fields[i])
+		}
+		v, err := parseValue(fields[i+1], wildcard)
+		if err != nil {
+			return nil, err
+		}
+		switch fields[i] {
+		case "ack":
+			seg.Ack = v
+		case "win":
+			if v != nil {
+				w := uint16(*v)
+				seg.Win = &w
+			}
+		default:
+			return nil, fmt.Errorf("unknown field: %s", fields[i])
+		}
+	}
+	if !wildcard && seg.Win == nil {
+		w := defaultWin
+		seg.Win = &w
+	}
+	if options != "" {
+		for _, o := range strings.Split(options, ",") {
+			op, err := parseOption(strings.TrimSpace(o), wildcard)
+			if err != nil {
+				return nil, err
+			}
+			seg.Options = append(seg.Options, op)
+		}
+	}
+	return seg, nil
+}
+
+// parseSequence parses start:end(length).
+func (seg *Segment) parseSequence(s string, wildcard bool) error {
+	if s == "*" && wildcard {
+		return nil
+	}
+	var start, end uint32
+	var length int
+	if _, err := fmt.Sscanf(s, "%d:%d(%d)", &start, &end, &length); err != nil {
+		return fmt.Errorf("invalid sequence number: %s", s)
+	}
+	if end-start != uint32(length) {
+		return fmt.Errorf("length does not match the sequence numbers: %s", s)
+	}
+	seg.Start = &start
+	seg.Length = length
+	return nil
+}
+
+func parseValue(s string, wildcard bool) (*uint32, error) {
+	if s == "*" {
+		if !wildcard {
+			return nil, fmt.Errorf("wildcard is not allowed in inbound segments")
+		}
+		return nil, nil
+	}
+	v, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value: %s", s)
+	}
+	n := uint32(v)
+	return &n, nil
+}
+
+func parseOption(s string, wildcard bool) (Option, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return Option{}, fmt.Errorf("empty option")
+	}
+	op := Option{Kind: fields[0]}
+	var names []string
+	switch op.Kind {
+	case "mss", "wscale":
+		names = []string{""}
+	case "TS":
+		names = []string{"val", "ecr"}
+	case "sackOK", "nop":
+	default:
+		return op, fmt.Errorf("unknown option: %s", op.Kind)
+	}
+	args := fields[1:]
+	if len(args) == 0 && wildcard {
+		// any value
+		op.Values = make([]*uint32, len(names))
+		return op, nil
+	}
+	for _, name := range names {
+		if name != "" {
+			if len(args) == 0 || args[0] != name {
+				return op, fmt.Errorf("%s of %s is missing", name, op.Kind)
+			}
+			args = args[1:]
+		}
+		if len(args) == 0 {
+			return op, fmt.Errorf("value of %s is missing", op.Kind)
+		}
+		v, err := parseValue(args[0], wildcard)
+		if err != nil {
+			return op, err
+		}
+		op.Values = append(op.Values, v)
+		args = args[1:]
+	}
+	if len(args) != 0 {
+		return op, fmt.Errorf("too many values of %s", op.Kind)
+	}
+	return op, nil
+}
+
+// Runner runs scripts against the tcp protocol driven by the virtual clock.
+type Runner struct {
+	Tcp     *proto.Tcp
+	Clock   *clock.Virtual
+	Timeout time.Duration // real time to wait for segments and blocking calls
+
+	start     time.Time
+	now       time.Duration
+	localISN  *uint32
+	localPort uint16
+	accepted  chan result
+	connected chan result
+	closed    chan error
+	conn      *proto.Conn
+}
+
+type result struct {
+	conn *proto.Conn
+	err  error
+}
+
+// NewRunner returns the runner with the new tcp protocol.
+func NewRunner() (*Runner, error) {
+	tp, err := proto.New(false)
+	if err != nil {
+		return nil, err
+	}
+	v := clock.NewVirtual(time.Unix(0, 0))
+	tp.Clock = v
+	return &Runner{
+		Tcp:     tp,
+		Clock:   v,
+		Timeout: time.Second,
+		start:   v.Now(),
+		closed:  make(chan error, 1),
+	}, nil
+}
+
+// Run runs the script and returns the first failure.
+func (r *Runner) Run(s *Script) error {
+	defer r.Tcp.Stop()
+	for _, line := range s.Lines {
+		if err := r.run(line); err != nil {
+			return fmt.Errorf("%s:%d: %v", s.Name, line.Number, err)
+		}
+	}
+	// segments must not be sent more
+	select {
+	case p := <-r.Tcp.SendQueue:
+		return fmt.Errorf("%s: unexpected segment: %s", s.Name, r.format(p.Packet))
+	case <-time.After(r.Timeout / 10):
+	}
+	select {
+	case err := <-r.closed:
+		if err != nil {
+			return fmt.Errorf("%s: close: %v", s.Name, err)
+		}
+	default:
+	}
+	return nil
+}
+
+func (r *Runner) run(line *Line) error {
+	at := line.Time
+	if line.Relative {
+		at += r.now
+	}
+	if at > r.now {
+		r.Clock.Advance(at - r.now)
+		r.now = at
+	}
+	switch {
+	case line.Inbound != nil:
+		select {
+		case p := <-r.Tcp.SendQueue:
+			return fmt.Errorf("unexpected segment: %s", r.format(p.Packet))
+		default:
+		}
+		return r.inbound(line.Inbound)
+	case line.Outbound != nil:
+		return r.outbound(line.Outbound)
+	default:
+		return r.call(line.Call, line.Args)
+	}
+}
+
+func (r *Runner) inbound(seg *Segment) error {
+	if r.localPort == 0 {
+		return fmt.Errorf("local port is unknown, listen or connect first")
+	}
+	var ack uint32
+	if seg.Ack != nil {
+		if r.localISN == nil {
+			return fmt.Errorf("ack is written before the initial sequence number is sent")
+		}
+		ack = *r.localISN + *seg.Ack
+	}
+	p, err := tcp.Build(remotePort, r.localPort, remoteISN+*seg.Start, ack, seg.Flags, *seg.Win, 0, make([]byte, seg.Length))
+	if err != nil {
+		return err
+	}
+	if len(seg.Options) > 0 {
+		var ops tcp.Options
+		for _, o := range seg.Options {
+			switch o.Kind {
+			case "mss":
+				ops = append(ops, tcp.MaxSegmentSize(*o.Values[0]))
+			case "wscale":
+				ops = append(ops, tcp.WindowScale(*o.Values[0]))
+			case "sackOK":
+				ops = append(ops, tcp.SACKPermitted{})
+			case "nop":
+				ops = append(ops, tcp.NoOperation{})
+			case "TS":
+				ts := make(tcp.TimeStamp, 8)
+				for i, v := range o.Values {
+					ts[i*4], ts[i*4+1], ts[i*4+2], ts[i*4+3] = byte(*v>>24), byte(*v>>16), byte(*v>>8), byte(*v)
+				}
+				ops = append(ops, ts)
+			}
+		}
+		p.AddOption(ops)
+	}
+	data, err := p.Serialize()
+	if err != nil {
+		return err
+	}
+	r.Tcp.HandlePacket(&remoteAddr, data)
+	return nil
+}
+
+func (r *Runner) outbound(seg *Segment) error {
+	var p *tcp.Packet
+	select {
+	case a := <-r.Tcp.SendQueue:
+		p = a.Packet
+	case <-time.After(r.Timeout):
+		return fmt.Errorf("segment is not sent")
+	}
+	flags := p.Header.OffsetControlFlag.ControlFlag() & (tcp.SYN | tcp.FIN | tcp.RST | tcp.PSH | tcp.ACK)
+	if flags != seg.Flags {
+		return fmt.Errorf("flags mismatch: %s", r.format(p))
+	}
+	if flags.Syn() && r.localISN == nil {
+		isn := p.Header.Sequence
+		if seg.Start != nil {
+			isn -= *seg.Start
+		}
+		r.localISN = &isn
+		r.localPort = p.Header.SourcePort
+	}
+	if seg.Start != nil {
+		if r.localISN == nil {
+			return fmt.Errorf("sequence number is written before the initial sequence number is sent")
+		}
+		if p.Header.Sequence-*r.localISN != *seg.Start || len(p.Data) != seg.Length {
+			return fmt.Errorf("sequence number mismatch: %s", r.format(p))
+		}
+	}
+	if seg.Ack != nil && p.Header.Ack-remoteISN != *seg.Ack {
+		return fmt.Errorf("ack mismatch: %s", r.format(p))
+	}
+	if seg.Win != nil && p.Header.WindowSize != *seg.Win {
+		return fmt.Errorf("window mismatch: %s", r.format(p))
+	}
+	for _, o := range seg.Options {
+		if !hasOption(p.Option, o) {
+			return fmt.Errorf("option %s mismatch: %s", o.Kind, r.format(p))
+		}
+	}
+	return nil
+}
+
+func hasOption(ops tcp.Options, o Option) bool {
+	match := func(v *uint32, actual uint32) bool {
+		return v == nil || *v == actual
+	}
+	switch o.Kind {
+	case "mss":
+		m := ops.MaxSegmentSize()
+		return m != nil && match(o.Values[0], uint32(*m))
+	case "wscale":
+		w := ops.WindowScale()
+		return w != nil && match(o.Values[0], uint32(*w))
+	case "sackOK":
+		return ops.SACKPermitted()
+	case "TS":
+		ts := ops.TimeStamp()
+		if ts == nil || len(*ts) != 8 {
+			return false
+		}
+		d := *ts
+		return match(o.Values[0], uint32(d[0])<<24|uint32(d[1])<<16|uint32(d[2])<<8|uint32(d[3])) &&
+			match(o.Values[1], uint32(d[4])<<24|uint32(d[5])<<16|uint32(d[6])<<8|uint32(d[7]))
+	default:
+		return true
+	}
+}
+
+// format shows the segment in the script syntax with relative numbers.
+func (r *Runner) format(p *tcp.Packet) string {
+	flag := p.Header.OffsetControlFlag.ControlFlag()
+	var flags string
+	for _, f := range []struct {
+		set bool
+		c   string
+	}{{flag.Syn(), "S"}, {flag.Fin(), "F"}, {flag.Rst(), "R"}, {flag.Psh(), "P"}, {flag.Ack(), "."}} {
+		if f.set {
+			flags += f.c
+		}
+	}
+	seq := p.Header.Sequence
+	if r.localISN != nil {
+		seq -= *r.localISN
+	}
+	return fmt.Sprintf("%s %d:%d(%d) ack %d win %d", flags, seq, seq+uint32(len(p.Data)), len(p.Data), p.Header.Ack-remoteISN, p.Header.WindowSize)
+}
+
+func (r *Runner) call(name string, args []string) error {
+	arg := func() (int, error) {
+		if len(args) != 1 {
+			return 0, fmt.Errorf("%s requires an argument", name)
+		}
+		return strconv.Atoi(args[0])
+	}
+	switch name {
+	case "listen":
+		port, err := arg()
+		if err != nil {
+			return err
+		}
+		l, err := r.Tcp.Listen("0.0.0.0", port)
+		if err != nil {
+			return err
+		}
+		r.localPort = uint16(port)
+		r.accepted = make(chan result, 1)
+		go func() {
+			conn, err := l.Accept()
+			r.accepted <- result{conn: conn, err: err}
+		}()
+		return nil
+	case "accept":
+		if r.accepted == nil {
+			return fmt.Errorf("not listening")
+		}
+		_, err := r.wait(r.accepted)
+		return err
+	case "connect":
+		port, err := arg()
+		if err != nil {
+			return err
+		}
+		r.connected = make(chan result, 1)
+		go func() {
+			conn, err := r.Tcp.Dial(remoteAddr.String(), port)
+			r.connected <- result{conn: conn, err: err}
+		}()
+		return nil
+	case "write":
+		n, err := arg()
+		if err != nil {
+			return err
+		}
+		conn, err := r.connection()
+		if err != nil {
+			return err
+		}
+		done := make(chan error, 1)
+		go func() {
+			_, err := conn.Write(make([]byte, n))
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			return err
+		case <-time.After(r.Timeout):
+			return fmt.Errorf("write blocks")
+		}
+	case "read":
+		if len(args) != 1 {
+			return fmt.Errorf("read requires an argument")
+		}
+		conn, err := r.connection()
+		if err != nil {
+			return err
+		}
+		return r.read(conn, args[0])
+	case "close":
+		conn, err := r.connection()
+		if err != nil {
+			return err
+		}
+		go func() { r.closed <- conn.Close() }()
+		return nil
+	case "state":
+		if len(args) != 1 {
+			return fmt.Errorf("state requires an argument")
+		}
+		conn, err := r.connection()
+		if err != nil {
+			return err
+		}
+		deadline := time.Now().Add(r.Timeout)
+		for conn.Info().State != args[0] {
+			if time.Now().After(deadline) {
+				return fmt.Errorf("state is %s, want %s", conn.Info().State, args[0])
+			}
+			time.Sleep(time.Millisecond)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown call: %s", name)
+	}
+}
+
+func (r *Runner) read(conn *proto.Conn, arg string) error {
+	done := make(chan error, 1)
+	go func() {
+		if arg == "EOF" {
+			n, err := conn.Read(make([]byte, 1))
+			if err != io.EOF {
+				done <- fmt.Errorf("read %d bytes, want EOF: %v", n, err)
+				return
+			}
+			done <- nil
+			return
+		}
+		want, err := strconv.Atoi(arg)
+		if err != nil {
+			done <- err
+			return
+		}
+		buf := make([]byte, want)
+		read := 0
+		for read < want {
+			n, err := conn.Read(buf[read:])
+			if err != nil {
+				done <- fmt.Errorf("read %d bytes, want %d: %v", read, want, err)
+				return
+			}
+			read += n
+		}
+		done <- nil
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(r.Timeout):
+		return fmt.Errorf("read blocks")
+	}
+}
+
+// connection returns the connection accepted or connected.
+func (r *Runner) connection() (*proto.Conn, error) {
+	if r.conn != nil {
+		return r.conn, nil
+	}
+	switch {
+	case r.accepted != nil:
+		return r.wait(r.accepted)
+	case r.connected != nil:
+		return r.wait(r.connected)
+	default:
+		return nil, fmt.Errorf("no connection")
+	}
+}
+
+func (r *Runner) wait(ch chan result) (*proto.Conn, error) {
+	if r.conn != nil {
+		return r.conn, nil
+	}
+	select {
+	case res := <-ch:
+		if res.err != nil {
+			return nil, res.err
+		}
+		r.conn = res.conn
+		return r.conn, nil
+	case <-time.After(r.Timeout):
+		return nil, fmt.Errorf("connection is not established")
+	}
+}
@@ -0,0 +1,60 @@
+package drill
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestScripts(t *testing.T) {
+	paths, err := filepath.Glob("testdata/*.pkt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("no script")
+	}
+	for _, path := range paths {
+		path := path
+		t.Run(strings.TrimSuffix(filepath.Base(path), ".pkt"), func(t *testing.T) {
+			s, err := ParseFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r, err := NewRunner()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := r.Run(s); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	s, err := Parse(strings.NewReader(`
+0    listen 8080 // comment
++0.5 > S. 0:0(0) ack * win 3000 <mss 1460,TS>
+1.5  < P. 1:101(100) ack 1 <TS val 5 ecr 6>
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Lines) != 3 || s.Lines[0].Call != "listen" || s.Lines[0].Args[0] != "8080" {
+		t.Fatalf("actual %+v", s.Lines)
+	}
+	out := s.Lines[1].Outbound
+	if !s.Lines[1].Relative || out.Ack != nil || *out.Win != 3000 || len(out.Options) != 2 || out.Options[1].Values[0] != nil {
+		t.Fatalf("actual %+v", out)
+	}
+	in := s.Lines[2].Inbound
+	if in.Length != 100 || *in.Win != defaultWin || *in.Options[0].Values[1] != 6 {
+		t.Fatalf("actual %+v", in)
+	}
+	for _, bad := range []string{"0 < S 0:1(0)", "0 < S 0:0(0) ack *", "0 < X 0:0(0)", "0 > S. 0:0(0) <mss"} {
+		if _, err := Parse(strings.NewReader(bad)); err == nil {
+			t.Fatalf("%q is accepted", bad)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package tcp
+
+import (
+	"testing"
+
+	"github.com/terassyi/gotcp/pkg/packet/ipv4"
+	"github.com/terassyi/gotcp/pkg/packet/tcp"
+	"github.com/terassyi/gotcp/pkg/proto/port"
+)
+
+func newEstablishedConn(t *testing.T, tp *Tcp) *Conn {
+	t.Helper()
+	peer := port.NewPeer(&ipv4.IPAddress{192, 168, 0, 3}, 40000, 8080)
+	l := &Listener{
+		inner: tp,
+		tcb:   tp.newControlBlock(peer),
+	}
+	l.tcb.rcv.NXT = 1000
+	l.tcb.rcv.WND = window
+	l.tcb.snd.ISS = 4999
+	l.tcb.snd.UNA = 5000
+	l.tcb.snd.NXT = 5000
+	l.tcb.snd.WND = 29200
+	l.tcb.ESTABLISHED()
+	conn, err := l.getConnection()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+// datagram builds the ip datagram carrying the segment, marked with CE when ce is set.
+func datagram(t *testing.T, p *tcp.Packet, ce bool) *ipv4.Packet {
+	t.Helper()
+	ip, err := ipv4.Build(ipv4.IPAddress{192, 168, 0, 3}, ipv4.IPAddress{192, 168, 0, 2}, ipv4.IPTCPProtocol, serializeSegment(t, p))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip.Header.SetECN(ipv4.ECNECT0)
+	if ce {
+		if err := ip.MarkCE(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return ip
+}
+
+func TestListenerECNSetup(t *testing.T) {
+	tp, err := New(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tp.ECN = true
+	l, err := tp.Listen("0.0.0.0", 8080)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go l.Accept()
+	syn, err := tcp.Build(40000, 8080, 1000, 0, tcp.SYN|tcp.ECN|tcp.CWR, 29200, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, err := tcp.NewTimeStamp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	syn.AddOption(tcp.Options{*ts})
+	tp.HandlePacket(&ipv4.IPAddress{192, 168, 0, 3}, serializeSegment(t, syn))
+	synAck := <-tp.SendQueue
+	flag := synAck.Packet.Header.OffsetControlFlag.ControlFlag()
+	if !flag.Ecn() || flag.Cwr() {
+		t.Fatalf("actual flag: %s", flag.String())
+	}
+	if !l.tcb.ecn {
+		t.Fatal("ecn is not negotiated")
+	}
+}
+
+func TestConnECNEcho(t *testing.T) {
+	tp, err := New(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := newEstablishedConn(t, tp)
+	conn.tcb.ecn = true
+	// the segment is handled until the data is read
+	deliver := func(ip *ipv4.Packet) {
+		go tp.HandleDatagram(&ip.Header, ip.Data)
+		if _, err := conn.Read(make([]byte, 100)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := tcp.Build(40000, 8080, 1000, 5000, tcp.ACK|tcp.PSH, 29200, 0, []byte("congested"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deliver(datagram(t, data, true))
+	ack := <-tp.SendQueue
+	if !ack.Packet.Header.OffsetControlFlag.ControlFlag().Ecn() {
+		t.Fatalf("ece is not set: %s", ack.Packet.Header.OffsetControlFlag.ControlFlag().String())
+	}
+
+	// keep echoing until cwr is received
+	data, err = tcp.Build(40000, 8080, 1009, 5000, tcp.ACK|tcp.PSH, 29200, 0, []byte("more"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deliver(datagram(t, data, false))
+	ack = <-tp.SendQueue
+	if !ack.Packet.Header.OffsetControlFlag.ControlFlag().Ecn() {
+		t.Fatal("ece is not set until cwr")
+	}
+
+	data, err = tcp.Build(40000, 8080, 1013, 5000, tcp.ACK|tcp.PSH|tcp.CWR, 29200, 0, []byte("reduced"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deliver(datagram(t, data, false))
+	ack = <-tp.SendQueue
+	if ack.Packet.Header.OffsetControlFlag.ControlFlag().Ecn() {
+		t.Fatal("ece is set after cwr")
+	}
+}
+
+func TestConnECNResponse(t *testing.T) {
+	tp, err := New(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := newEstablishedConn(t, tp)
+	conn.tcb.ecn = true
+
+	if _, err := conn.Write(make([]byte, 4*mss)); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		p := <-tp.SendQueue
+		if p.ECN != ipv4.ECNECT0 {
+			t.Fatalf("data segment is not ECT(0): %d", p.ECN)
+		}
+	}
+	before := conn.cc.cwnd
+	ack, err := tcp.Build(40000, 8080, 1000, 5000+uint32(mss), tcp.ACK|tcp.ECN, 29200, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tp.HandlePacket(&ipv4.IPAddress{192, 168, 0, 3}, serializeSegment(t, ack))
+	if conn.cc.cwnd >= before {
+		t.Fatalf("cwnd is not reduced: %d -> %d", before, conn.cc.cwnd)
+	}
+	reduced := conn.cc.cwnd
+
+	// once per window
+	ack, err = tcp.Build(40000, 8080, 1000, 5000+2*uint32(mss), tcp.ACK|tcp.ECN, 29200, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tp.HandlePacket(&ipv4.IPAddress{192, 168, 0, 3}, serializeSegment(t, ack))
+	if conn.cc.cwnd < reduced {
+		t.Fatalf("cwnd is reduced twice in a window: %d -> %d", reduced, conn.cc.cwnd)
+	}
+
+	if _, err := conn.Write([]byte("next")); err != nil {
+		t.Fatal(err)
+	}
+	p := <-tp.SendQueue
+	if !p.Packet.Header.OffsetControlFlag.ControlFlag().Cwr() {
+		t.Fatalf("cwr is not set: %s", p.Packet.Header.OffsetControlFlag.ControlFlag().String())
+	}
+	if _, err := conn.Write([]byte("after")); err != nil {
+		t.Fatal(err)
+	}
+	p = <-tp.SendQueue
+	if p.Packet.Header.OffsetControlFlag.ControlFlag().Cwr() {
+		t.Fatal("cwr is set twice")
+	}
+}
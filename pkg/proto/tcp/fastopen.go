@@ -0,0 +1,66 @@
+package tcp
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/subtle"
+	"sync"
+
+	"github.com/terassyi/gotcp/pkg/packet/ipv4"
+	"github.com/terassyi/gotcp/pkg/packet/tcp"
+)
+
+// TCP Fast Open (RFC 7413)
+
+const fastOpenCookieLength int = 8
+
+type fastOpen struct {
+	secret cipher.Block
+	cache  map[ipv4.IPAddress]tcp.FastOpenCookie
+	mutex  *sync.RWMutex
+}
+
+func newFastOpen() (*fastOpen, error) {
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &fastOpen{
+		secret: block,
+		cache:  make(map[ipv4.IPAddress]tcp.FastOpenCookie),
+		mutex:  &sync.RWMutex{},
+	}, nil
+}
+
+// cookie generates the server side cookie for the client address.
+func (f *fastOpen) cookie(addr *ipv4.IPAddress) tcp.FastOpenCookie {
+	src := make([]byte, aes.BlockSize)
+	dst := make([]byte, aes.BlockSize)
+	copy(src, addr.Bytes())
+	f.secret.Encrypt(dst, src)
+	return tcp.FastOpenCookie(dst[:fastOpenCookieLength])
+}
+
+func (f *fastOpen) valid(addr *ipv4.IPAddress, cookie tcp.FastOpenCookie) bool {
+	return subtle.ConstantTimeCompare(f.cookie(addr), cookie) == 1
+}
+
+// lookup returns the cookie received from the server before.
+func (f *fastOpen) lookup(addr *ipv4.IPAddress) tcp.FastOpenCookie {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	return f.cache[*addr]
+}
+
+func (f *fastOpen) store(addr *ipv4.IPAddress, cookie tcp.FastOpenCookie) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	c := make(tcp.FastOpenCookie, len(cookie))
+	copy(c, cookie)
+	f.cache[*addr] = c
+}
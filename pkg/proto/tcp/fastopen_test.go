@@ -0,0 +1,164 @@
+package tcp
+
+import (
+	"testing"
+
+	"github.com/terassyi/gotcp/pkg/packet/ipv4"
+	"github.com/terassyi/gotcp/pkg/packet/tcp"
+)
+
+func TestFastOpenCookie(t *testing.T) {
+	fo, err := newFastOpen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := &ipv4.IPAddress{192, 168, 0, 3}
+	cookie := fo.cookie(addr)
+	if len(cookie) != fastOpenCookieLength {
+		t.Fatalf("actual length: %d", len(cookie))
+	}
+	if !fo.valid(addr, cookie) {
+		t.Fatal("generated cookie is invalid")
+	}
+	if fo.valid(&ipv4.IPAddress{192, 168, 0, 4}, cookie) {
+		t.Fatal("cookie is valid for another address")
+	}
+	if fo.lookup(addr) != nil {
+		t.Fatal("cookie is cached before stored")
+	}
+	fo.store(addr, cookie)
+	if !fo.valid(addr, fo.lookup(addr)) {
+		t.Fatalf("actual: %v", fo.lookup(addr))
+	}
+}
+
+func serializeSegment(t *testing.T, p *tcp.Packet) []byte {
+	t.Helper()
+	b, err := p.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func fastOpenSyn(t *testing.T, cookie tcp.FastOpenCookie, data []byte) []byte {
+	t.Helper()
+	syn, err := tcp.Build(40000, 8080, 1000, 0, tcp.SYN, 29200, 0, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, err := tcp.NewTimeStamp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	syn.AddOption(tcp.Options{tcp.MaxSegmentSize(1460), *ts, cookie})
+	return serializeSegment(t, syn)
+}
+
+func TestListenerFastOpen(t *testing.T) {
+	tp, err := New(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &ipv4.IPAddress{192, 168, 0, 3}
+
+	// first connection requests a cookie
+	l, err := tp.Listen("0.0.0.0", 8080)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.FastOpen = true
+	accepted := make(chan *Conn)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			t.Error(err)
+		}
+		accepted <- conn
+	}()
+	tp.HandlePacket(client, fastOpenSyn(t, tcp.FastOpenCookie{}, []byte("ignored")))
+	synAck := <-tp.SendQueue
+	if synAck.Packet.Header.Ack != 1001 {
+		t.Fatalf("syn data is acknowledged without cookie: ack=%d", synAck.Packet.Header.Ack)
+	}
+	cookie := synAck.Packet.Option.FastOpenCookie()
+	if cookie == nil || !tp.fastOpen.valid(client, *cookie) {
+		t.Fatalf("actual cookie: %v", cookie)
+	}
+	ack, err := tcp.Build(40000, 8080, 1001, synAck.Packet.Header.Sequence+1, tcp.ACK, 29200, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tp.HandlePacket(client, serializeSegment(t, ack))
+	<-accepted
+	delete(tp.connections, 8080)
+	tp.Table.Entry = nil
+
+	// second connection sends data with the cookie
+	l, err = tp.Listen("0.0.0.0", 8080)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.FastOpen = true
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			t.Error(err)
+		}
+		accepted <- conn
+	}()
+	tp.HandlePacket(client, fastOpenSyn(t, *cookie, []byte("hello")))
+	synAck = <-tp.SendQueue
+	if synAck.Packet.Header.Ack != 1006 {
+		t.Fatalf("syn data is not acknowledged: ack=%d", synAck.Packet.Header.Ack)
+	}
+	ack, err = tcp.Build(40000, 8080, 1006, synAck.Packet.Header.Sequence+1, tcp.ACK, 29200, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tp.HandlePacket(client, serializeSegment(t, ack))
+	conn := <-accepted
+	buf := make([]byte, 100)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "hello" {
+		t.Fatalf("actual: %s", buf[:n])
+	}
+}
+
+func TestListenerFastOpenBeyondWindow(t *testing.T) {
+	tp, err := New(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &ipv4.IPAddress{192, 168, 0, 3}
+	l, err := tp.Listen("0.0.0.0", 8080)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.FastOpen = true
+	accepted := make(chan *Conn)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			t.Error(err)
+		}
+		accepted <- conn
+	}()
+	tp.HandlePacket(client, fastOpenSyn(t, tp.fastOpen.cookie(client), make([]byte, window+100)))
+	synAck := <-tp.SendQueue
+	if synAck.Packet.Header.Ack != 1001+window {
+		t.Fatalf("syn data beyond the window is acknowledged: ack=%d", synAck.Packet.Header.Ack)
+	}
+	ack, err := tcp.Build(40000, 8080, 1001+window, synAck.Packet.Header.Sequence+1, tcp.ACK, 29200, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tp.HandlePacket(client, serializeSegment(t, ack))
+	conn := <-accepted
+	if len(conn.rcvBuffer.buf) != int(window) || conn.tcb.rcv.WND != 0 {
+		t.Fatalf("actual buffered=%d rcv.wnd=%d", len(conn.rcvBuffer.buf), conn.tcb.rcv.WND)
+	}
+}
@@ -2,7 +2,9 @@ package tcp
 
 import (
 	"sync"
+	"time"
 
+	"github.com/terassyi/gotcp/pkg/clock"
 	"github.com/terassyi/gotcp/pkg/logger"
 	"github.com/terassyi/gotcp/pkg/packet/ipv4"
 	"github.com/terassyi/gotcp/pkg/packet/tcp"
@@ -18,6 +20,11 @@ type Tcp struct {
 	listeners   map[int]*Listener
 	dialers     map[int]*dialer
 	connections map[int]*Conn
+	fastOpen    *fastOpen
+	md5         *md5Keys
+	ECN         bool          // negotiate Explicit Congestion Notification (RFC 3168)
+	MSL         time.Duration // maximum segment lifetime
+	Clock       clock.Clock   // timers of connections
 	mutex       *sync.RWMutex
 	logger      *logger.Logger
 }
@@ -25,6 +32,8 @@ type Tcp struct {
 type AddressedPacket struct {
 	Packet  *tcp.Packet
 	Address *ipv4.IPAddress
+	Local   *ipv4.IPAddress // our address, the source is chosen by the ip layer when nil
+	ECN     uint8           // ECN codepoint of the ip header
 }
 
 func New(debug bool) (*Tcp, error) {
@@ -32,6 +41,10 @@ func New(debug bool) (*Tcp, error) {
 	if err != nil {
 		return nil, err
 	}
+	fo, err := newFastOpen()
+	if err != nil {
+		return nil, err
+	}
 	return &Tcp{
 		ProtocolBuffer: proto.NewProtocolBuffer(),
 		SendQueue:      make(chan AddressedPacket, 100),
@@ -40,27 +53,48 @@ func New(debug bool) (*Tcp, error) {
 		listeners:      make(map[int]*Listener),
 		dialers:        make(map[int]*dialer),
 		connections:    make(map[int]*Conn),
+		fastOpen:       fo,
+		md5:            newMD5Keys(),
+		MSL:            defaultMSL,
+		Clock:          clock.Real,
 		mutex:          &sync.RWMutex{},
 		logger:         logger.New(debug, "tcp"),
 	}, nil
 }
 
+const defaultMSL time.Duration = 10 * time.Second
+
 func (t *Tcp) Recv(buf []byte) {
 	t.Buffer <- buf
 }
 
-func (t *Tcp) enqueue(addr *ipv4.IPAddress, packet *tcp.Packet) {
+func (t *Tcp) enqueue(local, addr *ipv4.IPAddress, packet *tcp.Packet) {
+	t.sign(addr, packet)
 	t.SendQueue <- AddressedPacket{
 		Packet:  packet,
 		Address: addr,
+		Local:   local,
+	}
+}
+
+// enqueueECT sends the packet marked as ECN-capable transport.
+func (t *Tcp) enqueueECT(local, addr *ipv4.IPAddress, packet *tcp.Packet) {
+	t.sign(addr, packet)
+	t.SendQueue <- AddressedPacket{
+		Packet:  packet,
+		Address: addr,
+		Local:   local,
+		ECN:     ipv4.ECNECT0,
 	}
 }
 
 func (t *Tcp) Handle() {
 	for {
-		buf, ok := <-t.Buffer
-		if !ok {
-			continue
+		var buf []byte
+		select {
+		case buf = <-t.Buffer:
+		case <-t.Done:
+			return
 		}
 		packet, err := tcp.New(buf)
 		if err != nil {
@@ -72,40 +106,50 @@ func (t *Tcp) Handle() {
 }
 
 func (t *Tcp) HandlePacket(src *ipv4.IPAddress, buf []byte) {
+	t.HandleDatagram(&ipv4.Header{Src: *src}, buf)
+}
 
+// HandleDatagram handles the segment with the header of the ip datagram carrying it.
+func (t *Tcp) HandleDatagram(hdr *ipv4.Header, buf []byte) {
+	src := &hdr.Src
 	packet, err := tcp.New(buf)
 	if err != nil {
 		t.logger.Errorf("tcp packet serialize error: %v\n", err)
 		return
 	}
+	if !t.verifySignature(hdr, packet) {
+		// drop silently
+		t.logger.Debug("md5 signature is missing or invalid")
+		return
+	}
 	// handle packet
+	port := int(packet.Header.DestinationPort)
+	t.mutex.RLock()
+	l, lok := t.listeners[port]
+	d, dok := t.dialers[port]
+	c, cok := t.connections[port]
+	t.mutex.RUnlock()
+	received := AddressedPacket{
+		Packet:  packet,
+		Address: src,
+		Local:   &hdr.Dst,
+		ECN:     hdr.ECN(),
+	}
 	// listener
-	l, ok := t.listeners[int(packet.Header.DestinationPort)]
-	if ok {
-		l.queue <- AddressedPacket{
-			Packet:  packet,
-			Address: src,
-		}
+	if lok && (l.addr == nil || *l.addr == hdr.Dst) {
+		t.deliver(l.queue, received)
 		return
 	}
 
 	// dialer
-	d, ok := t.dialers[int(packet.Header.DestinationPort)]
-	if ok {
-		d.queue <- AddressedPacket{
-			Packet:  packet,
-			Address: src,
-		}
+	if dok {
+		t.deliver(d.queue, received)
 		return
 	}
 
 	// connection
-	c, ok := t.connections[int(packet.Header.DestinationPort)]
-	if ok {
-		if err := c.handle(AddressedPacket{
-			Packet:  packet,
-			Address: src,
-		}); err != nil {
+	if cok {
+		if err := c.receive(received); err != nil {
 			t.logger.Error(err)
 			return
 		}
@@ -117,3 +161,28 @@ func (t *Tcp) HandlePacket(src *ipv4.IPAddress, buf []byte) {
 	t.logger.Info("received packet is not handled. invalid peer.")
 	return
 }
+
+// own copies the text out of the buffer of the received frame to keep the segment.
+// Options are already decoded into their own buffer.
+func (p AddressedPacket) own() AddressedPacket {
+	packet := *p.Packet
+	packet.Data = append([]byte(nil), p.Packet.Data...)
+	p.Packet = &packet
+	return p
+}
+
+// deliver queues the segment to the handshake routine, the segment is dropped when the queue is full.
+func (t *Tcp) deliver(queue chan AddressedPacket, packet AddressedPacket) {
+	select {
+	case queue <- packet.own():
+	default:
+		t.logger.Debug("handshake queue is full. drop segment.")
+	}
+}
+
+func (t *Tcp) removeConnection(port int) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.connections, port)
+	t.md5.remove(port)
+}
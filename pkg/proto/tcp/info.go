@@ -0,0 +1,100 @@
+package tcp
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/terassyi/gotcp/pkg/packet/tcp"
+)
+
+// Info is the snapshot of the connection like tcp_info of Linux.
+type Info struct {
+	State         string
+	Snd           SendSequence
+	Rcv           ReceiveSequence
+	Cwnd          uint32
+	Ssthresh      uint32
+	SRTT          time.Duration
+	RTTVar        time.Duration
+	RTO           time.Duration
+	Retransmits   uint64 // retransmitted segments
+	BytesSent     uint64 // bytes of new data sent
+	BytesAcked    uint64
+	BytesReceived uint64
+	DupAcks       uint64
+	OutOfOrder    int // segments in the out-of-order queue
+	Options       NegotiatedOptions
+}
+
+// NegotiatedOptions is the result of the option negotiation in the handshake.
+type NegotiatedOptions struct {
+	MSS            uint16 // mss of the peer
+	WindowScale    bool
+	SndWindowScale uint8 // shift count of the peer
+	RcvWindowScale uint8 // shift count of ours
+	TimeStamp      bool
+	SACK           bool
+	ECN            bool
+	FastOpen       bool
+	MD5            bool
+}
+
+type connStats struct {
+	retransmits   uint64
+	bytesSent     uint64
+	bytesAcked    uint64
+	bytesReceived uint64
+	dupAcks       uint64
+}
+
+// negotiate records the options both sides sent in the handshake.
+func (cb *controlBlock) negotiate(sent, received tcp.Options) {
+	if m := received.MaxSegmentSize(); m != nil {
+		cb.options.MSS = uint16(*m)
+	}
+	ours, theirs := sent.WindowScale(), received.WindowScale()
+	if ours != nil && theirs != nil {
+		cb.options.WindowScale = true
+		cb.options.RcvWindowScale = uint8(*ours)
+		cb.options.SndWindowScale = uint8(*theirs)
+	}
+	cb.options.TimeStamp = sent.TimeStamp() != nil && received.TimeStamp() != nil
+	cb.options.SACK = sent.SACKPermitted() && received.SACKPermitted()
+}
+
+// Info returns the current state and statistics of the connection.
+func (c *Conn) Info() Info {
+	c.mutex.RLock()
+	ooo := len(c.ooo)
+	c.mutex.RUnlock()
+	c.cc.mutex.Lock()
+	cwnd, ssthresh := c.cc.cwnd, c.cc.ssthresh
+	c.cc.mutex.Unlock()
+	c.rtt.mutex.RLock()
+	srtt, rttvar, rto := c.rtt.srtt, c.rtt.rttvar, c.rtt.rto
+	c.rtt.mutex.RUnlock()
+
+	c.tcb.mutex.RLock()
+	state, snd, rcv := c.tcb.state, *c.tcb.snd, *c.tcb.rcv
+	options := c.tcb.options
+	options.ECN = c.tcb.ecn
+	c.tcb.mutex.RUnlock()
+	options.MD5 = c.inner.md5.lookup(c.tcb.peer.Port, c.tcb.peer.PeerAddr) != nil
+	return Info{
+		State:         state.String(),
+		Snd:           snd,
+		Rcv:           rcv,
+		Cwnd:          cwnd,
+		Ssthresh:      ssthresh,
+		SRTT:          srtt,
+		RTTVar:        rttvar,
+		RTO:           rto,
+		Retransmits:   atomic.LoadUint64(&c.stats.retransmits),
+		BytesSent:     atomic.LoadUint64(&c.stats.bytesSent),
+		BytesAcked:    atomic.LoadUint64(&c.stats.bytesAcked),
+		BytesReceived: atomic.LoadUint64(&c.stats.bytesReceived),
+		DupAcks:       atomic.LoadUint64(&c.stats.dupAcks),
+		OutOfOrder:    ooo,
+		Options:       options,
+	}
+}
@@ -0,0 +1,133 @@
+package tcp
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/terassyi/gotcp/pkg/packet/ipv4"
+	"github.com/terassyi/gotcp/pkg/packet/tcp"
+)
+
+func TestConnInfo(t *testing.T) {
+	tp, err := New(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := newEstablishedConn(t, tp)
+	if _, err := conn.Write(make([]byte, 100)); err != nil {
+		t.Fatal(err)
+	}
+	<-tp.SendQueue
+	ack, err := tcp.Build(40000, 8080, 1000, 5100, tcp.ACK, 29200, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tp.HandlePacket(&ipv4.IPAddress{192, 168, 0, 3}, serializeSegment(t, ack))
+
+	// a segment beyond rcv.nxt is queued and acked with rcv.nxt
+	data, err := tcp.Build(40000, 8080, 1005, 5100, tcp.ACK|tcp.PSH, 29200, 0, []byte("world"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tp.HandlePacket(&ipv4.IPAddress{192, 168, 0, 3}, serializeSegment(t, data))
+	dup := <-tp.SendQueue
+	if dup.Packet.Header.Ack != 1000 {
+		t.Fatalf("actual ack: %d", dup.Packet.Header.Ack)
+	}
+
+	info := conn.Info()
+	if info.State != "ESTABLISHED" {
+		t.Fatalf("actual state: %s", info.State)
+	}
+	if info.BytesSent != 100 || info.BytesAcked != 100 {
+		t.Fatalf("actual bytes sent=%d acked=%d", info.BytesSent, info.BytesAcked)
+	}
+	if info.Snd.UNA != 5100 || info.Rcv.NXT != 1000 {
+		t.Fatalf("actual snd.una=%d rcv.nxt=%d", info.Snd.UNA, info.Rcv.NXT)
+	}
+	if info.OutOfOrder != 1 {
+		t.Fatalf("actual out-of-order queue length: %d", info.OutOfOrder)
+	}
+	if info.RTO != initialRto {
+		t.Fatalf("actual rto: %v", info.RTO)
+	}
+}
+
+func TestConnReadAfterFin(t *testing.T) {
+	tp, err := New(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := newEstablishedConn(t, tp)
+	// handled without a reader
+	for i, s := range []string{"hello", "world"} {
+		flag := tcp.ACK | tcp.PSH
+		if i == 1 {
+			flag |= tcp.FIN
+		}
+		data, err := tcp.Build(40000, 8080, 1000+uint32(5*i), 5000, flag, 29200, 0, []byte(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tp.HandlePacket(&ipv4.IPAddress{192, 168, 0, 3}, serializeSegment(t, data))
+	}
+	buf := make([]byte, 3)
+	got := ""
+	for {
+		n, err := conn.Read(buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got += string(buf[:n])
+	}
+	if got != "helloworld" {
+		t.Fatalf("actual: %s", got)
+	}
+}
+
+func TestConnInfoWhileClosing(t *testing.T) {
+	tp, err := New(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tp.MSL = time.Millisecond
+	conn := newEstablishedConn(t, tp)
+	closed := make(chan error)
+	go func() {
+		closed <- conn.Close()
+	}()
+	// taken while the closing routine updates the tcb, run with -race
+	stop := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				conn.Info()
+			}
+		}
+	}()
+	defer close(stop)
+
+	fin := <-tp.SendQueue
+	if !fin.Packet.Header.OffsetControlFlag.ControlFlag().Fin() {
+		t.Fatalf("actual flag: %s", fin.Packet.Header.OffsetControlFlag.ControlFlag())
+	}
+	// simultaneous close
+	finAck, err := tcp.Build(40000, 8080, 1000, 5001, tcp.ACK|tcp.FIN, 29200, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tp.HandlePacket(&ipv4.IPAddress{192, 168, 0, 3}, serializeSegment(t, finAck))
+	if err := <-closed; err != nil {
+		t.Fatal(err)
+	}
+	if info := conn.Info(); info.State != "CLOSED" || info.Rcv.NXT != 1001 {
+		t.Fatalf("actual state=%s rcv.nxt=%d", info.State, info.Rcv.NXT)
+	}
+}
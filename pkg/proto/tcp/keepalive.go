@@ -0,0 +1,112 @@
+package tcp
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/terassyi/gotcp/pkg/packet/tcp"
+)
+
+// Keep-alive (RFC 1122 4.2.3.6)
+// A probe is sent after the connection is idle, the connection is aborted when probes are not answered.
+
+type keepAlive struct {
+	idle     time.Duration
+	interval time.Duration
+	probes   int
+	stop     chan struct{}
+}
+
+// SetKeepAlive starts sending probes after idle without received segments.
+// Probes are sent every interval and the connection is aborted after probes are not answered.
+// idle 0 disables keep-alive.
+func (c *Conn) SetKeepAlive(idle, interval time.Duration, probes int) error {
+	if idle < 0 || interval <= 0 && idle > 0 || probes < 0 {
+		return fmt.Errorf("invalid keep-alive parameters")
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.keepAlive != nil {
+		close(c.keepAlive.stop)
+		c.keepAlive = nil
+	}
+	if idle == 0 {
+		return nil
+	}
+	c.keepAlive = &keepAlive{idle: idle, interval: interval, probes: probes, stop: make(chan struct{})}
+	atomic.CompareAndSwapInt64(&c.lastReceived, 0, c.inner.Clock.Now().UnixNano())
+	go c.keepAliveHandler(c.keepAlive)
+	return nil
+}
+
+// touch records the time when the segment is received.
+func (c *Conn) touch() {
+	atomic.StoreInt64(&c.lastReceived, c.inner.Clock.Now().UnixNano())
+}
+
+func (c *Conn) received() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&c.lastReceived))
+}
+
+func (c *Conn) keepAliveHandler(k *keepAlive) {
+	sent := 0
+	var probed time.Time
+	wait := k.idle
+	for {
+		select {
+		case <-c.inner.Clock.After(wait):
+		case <-k.stop:
+			return
+		case <-c.inner.Done:
+			return
+		}
+		if !c.tcb.IsReadySend() {
+			return
+		}
+		received := c.received()
+		if sent > 0 && !received.Before(probed) || sent == 0 && c.inner.Clock.Since(received) < k.idle {
+			// the peer is alive
+			sent = 0
+			wait = k.idle - c.inner.Clock.Since(received)
+			continue
+		}
+		if sent >= k.probes {
+			c.abort(fmt.Errorf("connection timed out"))
+			return
+		}
+		if err := c.probe(); err != nil {
+			c.logger.Error(err)
+		}
+		probed = c.inner.Clock.Now()
+		sent++
+		wait = k.interval
+	}
+}
+
+// probe sends the keep-alive segment whose sequence number is already acknowledged to elicit an ack.
+func (c *Conn) probe() error {
+	c.tcb.mutex.RLock()
+	defer c.tcb.mutex.RUnlock()
+	p, err := tcp.Build(
+		uint16(c.tcb.peer.Port), uint16(c.tcb.peer.PeerPort),
+		c.tcb.snd.NXT-1, c.tcb.rcv.NXT, tcp.ACK, uint16(c.rcvWindow()), 0, nil)
+	if err != nil {
+		return err
+	}
+	c.inner.enqueue(c.tcb.peer.Addr, c.tcb.peer.PeerAddr, p)
+	return nil
+}
+
+// abort closes the connection without the closing handshake, reads return err.
+func (c *Conn) abort(err error) {
+	c.handleMutex.Lock()
+	defer c.handleMutex.Unlock()
+	c.tcb.mutex.Lock()
+	c.tcb.CLOSED()
+	c.cc.update()
+	c.tcb.mutex.Unlock()
+	c.rcvBuffer.abort(err)
+	c.inner.removeConnection(c.Peer.Port)
+	c.logger.Info(err)
+}
@@ -12,6 +12,11 @@ type Listener struct {
 	inner *Tcp // TODO どうにかする
 	queue chan AddressedPacket
 	tcb   *controlBlock
+	addr  *ipv4.IPAddress // segments to other addresses are not accepted, nil for any address
+	// FastOpen enables TCP Fast Open. Cookies are issued to clients requesting them,
+	// and data carried in a SYN with a valid cookie is delivered to the accepted connection.
+	FastOpen bool
+	synData  []byte
 }
 
 func (t *Tcp) Listen(addr string, port int) (*Listener, error) {
@@ -20,12 +25,15 @@ func (t *Tcp) Listen(addr string, port int) (*Listener, error) {
 		return nil, err
 	}
 	t.logger.Info("start to listen")
-	t.mutex.RLock()
-	defer t.mutex.RUnlock()
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
 	l, err := t.listen(a, port)
 	if err != nil {
 		return nil, err
 	}
+	if *a != (ipv4.IPAddress{}) {
+		l.addr = a
+	}
 	t.listeners[port] = l
 	return l, nil
 }
@@ -50,7 +58,7 @@ func (t *Tcp) bind(port int) (*controlBlock, error) {
 	if err != nil {
 		return nil, err
 	}
-	cb := NewControlBlock(peer, t.logger.DebugMode())
+	cb := t.newControlBlock(peer)
 	if err := cb.passiveOpen(); err != nil {
 		return nil, err
 	}
@@ -79,11 +87,12 @@ func (l *Listener) establish() error {
 		if err != nil {
 			return err
 		}
-		l.inner.enqueue(syn.Address, rep)
+		l.inner.enqueue(syn.Local, syn.Address, rep)
 		return fmt.Errorf("an packet to unbinded port is recieved")
 	}
 
 	l.tcb.peer.PeerAddr = syn.Address
+	l.tcb.peer.Addr = syn.Local
 	l.tcb.peer.PeerPort = int(syn.Packet.Header.SourcePort)
 
 	// update recv sequence
@@ -92,17 +101,30 @@ func (l *Listener) establish() error {
 	l.tcb.snd.ISS = Random()
 	l.tcb.snd.NXT = l.tcb.snd.ISS + 1
 	l.tcb.snd.UNA = l.tcb.snd.ISS
+	// the window in the syn is never scaled
+	l.tcb.snd.WND = syn.Packet.Header.WindowSize
+	l.tcb.snd.WL1 = syn.Packet.Header.Sequence
+
+	opTimeStamp := syn.Packet.Option.TimeStamp()
+	ops := tcp.Options{tcp.MaxSegmentSize(1460), tcp.SACKPermitted{}, tcp.WindowScale(0), opTimeStamp.Exchange()}
+	ops = append(ops, l.fastOpen(syn)...)
 
 	synAck, err := tcp.Build(uint16(l.tcb.peer.Port), uint16(l.tcb.peer.PeerPort),
 		l.tcb.snd.ISS, l.tcb.rcv.NXT,
 		tcp.SYN|tcp.ACK,
-		syn.Packet.Header.WindowSize, 0, nil)
+		uint16(window), 0, nil)
 	if err != nil {
 		return err
 	}
-	opTimeStamp := syn.Packet.Option.TimeStamp()
-	synAck.AddOption(tcp.Options{tcp.MaxSegmentSize(1460), tcp.SACKPermitted{}, tcp.WindowScale(7), opTimeStamp.Exchange()})
-	l.inner.enqueue(l.tcb.peer.PeerAddr, synAck)
+	synAck.AddOption(ops)
+	l.tcb.negotiate(ops, syn.Packet.Option)
+	synFlag := syn.Packet.Header.OffsetControlFlag.ControlFlag()
+	if l.inner.ECN && synFlag.Ecn() && synFlag.Cwr() {
+		// ECN-setup syn|ack
+		synAck.SetFlag(tcp.ECN)
+		l.tcb.ecn = true
+	}
+	l.inner.enqueue(l.tcb.peer.Addr, l.tcb.peer.PeerAddr, synAck)
 
 	l.tcb.showSeq()
 	l.tcb.SYN_RECVD()
@@ -119,7 +141,7 @@ func (l *Listener) establish() error {
 		if err != nil {
 			return err
 		}
-		l.inner.enqueue(syn.Address, rep)
+		l.inner.enqueue(syn.Local, syn.Address, rep)
 	}
 	if l.tcb.snd.UNA <= ack.Packet.Header.Ack && ack.Packet.Header.Ack <= l.tcb.snd.NXT {
 		l.tcb.ESTABLISHED()
@@ -129,30 +151,72 @@ func (l *Listener) establish() error {
 		if err != nil {
 			return err
 		}
-		l.inner.enqueue(syn.Address, rep)
+		l.inner.enqueue(syn.Local, syn.Address, rep)
 	}
 	return nil
 }
 
+// fastOpen handles the fast open option in the syn and returns options to add to the syn|ack.
+// When the cookie is valid, the data in the syn is accepted.
+func (l *Listener) fastOpen(syn AddressedPacket) tcp.Options {
+	// no room for the cookie with the md5 signature
+	if !l.FastOpen || l.inner.md5.lookup(l.tcb.peer.Port, syn.Address) != nil {
+		return nil
+	}
+	cookie := syn.Packet.Option.FastOpenCookie()
+	if cookie == nil {
+		return nil
+	}
+	if len(*cookie) > 0 && l.inner.fastOpen.valid(syn.Address, *cookie) {
+		// the text beyond the receive window is trimmed, the peer sends it again
+		data := syn.Packet.Data
+		if uint32(len(data)) > window {
+			data = data[:window]
+		}
+		l.synData = data
+		l.tcb.rcv.NXT += uint32(len(data))
+		l.tcb.options.FastOpen = true
+		return nil
+	}
+	// cookie request or invalid cookie, the data is ignored
+	return tcp.Options{l.inner.fastOpen.cookie(syn.Address)}
+}
+
 func (l *Listener) getConnection() (*Conn, error) {
 	conn := &Conn{
 		tcb:                 l.tcb,
 		Peer:                l.tcb.peer,
-		retransmissionQueue: make(chan *AddressedPacket, 100),
+		retransmissionQueue: make(chan *retransmissionPacket, 100),
 		receivedAck:         make(chan uint32, 100),
 		closeQueue:          make(chan AddressedPacket, 1),
 		rcvBuffer:           newRcvBuffer(),
+		cc:                  newCongestion(l.tcb.mutex),
+		urg:                 newUrgent(),
+		rtt:                 newRttEstimator(),
+		ooo:                 make(map[uint32]AddressedPacket),
 		mutex:               sync.RWMutex{},
 		inner:               l.inner,
 		logger:              l.inner.logger,
 	}
 	conn.pushFlag = true
+	conn.tcb.rcv.WND = window
+	if len(l.synData) > 0 {
+		conn.rcvBuffer.buf = append(conn.rcvBuffer.buf, l.synData...)
+		conn.tcb.rcv.WND -= uint32(len(l.synData))
+		conn.rcvBuffer.notify()
+	}
+	conn.handleMutex.Lock()
+	defer conn.handleMutex.Unlock()
+	l.inner.mutex.Lock()
 	// entry connection list
 	l.inner.connections[conn.Peer.Port] = conn
 	// delete from listener list
 	delete(l.inner.listeners, conn.Peer.Port)
+	l.inner.mutex.Unlock()
+	// segments following the handshake may be queued to the listener
+	conn.drain(l.queue)
 	// start retransmission routine
 	conn.logger.Debug("retransmission routine start ")
-	go conn.retransmissionHandler()
+	conn.retransmissionHandler()
 	return conn, nil
 }
@@ -0,0 +1,82 @@
+package tcp
+
+import (
+	"sync"
+
+	"github.com/terassyi/gotcp/pkg/packet/ipv4"
+	"github.com/terassyi/gotcp/pkg/packet/tcp"
+)
+
+// TCP MD5 signature option (RFC 2385)
+// Keys are configured for a pair of the local port and the peer address.
+
+type md5Peer struct {
+	port int
+	addr ipv4.IPAddress
+}
+
+type md5Keys struct {
+	keys  map[md5Peer][]byte
+	mutex *sync.RWMutex
+}
+
+func newMD5Keys() *md5Keys {
+	return &md5Keys{
+		keys:  make(map[md5Peer][]byte),
+		mutex: &sync.RWMutex{},
+	}
+}
+
+func (m *md5Keys) set(port int, addr *ipv4.IPAddress, key []byte) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if key == nil {
+		delete(m.keys, md5Peer{port: port, addr: *addr})
+		return
+	}
+	m.keys[md5Peer{port: port, addr: *addr}] = key
+}
+
+// remove deletes the keys of the local port when the connection on it is closed.
+func (m *md5Keys) remove(port int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for peer := range m.keys {
+		if peer.port == port {
+			delete(m.keys, peer)
+		}
+	}
+}
+
+func (m *md5Keys) lookup(port int, addr *ipv4.IPAddress) []byte {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.keys[md5Peer{port: port, addr: *addr}]
+}
+
+// SetMD5Key configures the key to sign segments exchanged with the peer.
+// Segments from the peer without a valid signature are dropped. A nil key removes the configuration.
+func (l *Listener) SetMD5Key(addr string, key []byte) error {
+	a, err := ipv4.StringToIPAddress(addr)
+	if err != nil {
+		return err
+	}
+	l.inner.md5.set(l.tcb.peer.Port, a, key)
+	return nil
+}
+
+func (t *Tcp) sign(addr *ipv4.IPAddress, packet *tcp.Packet) {
+	if key := t.md5.lookup(int(packet.Header.SourcePort), addr); key != nil {
+		packet.AddSignature(key)
+	}
+}
+
+// verifySignature checks the signature if a key is configured for the peer.
+// A signed segment is not accepted when no key is configured.
+func (t *Tcp) verifySignature(hdr *ipv4.Header, packet *tcp.Packet) bool {
+	key := t.md5.lookup(int(packet.Header.DestinationPort), &hdr.Src)
+	if key == nil {
+		return packet.Option.MD5Signature() == nil
+	}
+	return packet.VerifySignature(hdr.Src, hdr.Dst, key)
+}
@@ -0,0 +1,101 @@
+package tcp
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/terassyi/gotcp/pkg/packet/ipv4"
+	"github.com/terassyi/gotcp/pkg/packet/tcp"
+)
+
+func TestListenerMD5(t *testing.T) {
+	tp, err := New(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := ipv4.IPAddress{10, 0, 0, 2}
+	peer := ipv4.IPAddress{10, 0, 0, 1}
+	key := []byte("bgp-secret")
+	l, err := tp.Listen("0.0.0.0", 179)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.SetMD5Key("10.0.0.1", key); err != nil {
+		t.Fatal(err)
+	}
+	syn := func(key []byte) []byte {
+		p, err := tcp.Build(40000, 179, 1000, 0, tcp.SYN, 29200, 0, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ts, err := tcp.NewTimeStamp()
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.AddOption(tcp.Options{tcp.MaxSegmentSize(1460), *ts})
+		if key != nil {
+			p.AddSignature(key)
+		}
+		if err := p.ReCalculateChecksum(peer, local); err != nil {
+			t.Fatal(err)
+		}
+		return serializeSegment(t, p)
+	}
+	hdr := &ipv4.Header{Src: peer, Dst: local}
+
+	tp.HandleDatagram(hdr, syn(nil))
+	if len(l.queue) != 0 {
+		t.Fatal("segment without signature is accepted")
+	}
+	tp.HandleDatagram(hdr, syn([]byte("wrong")))
+	if len(l.queue) != 0 {
+		t.Fatal("segment with a bad signature is accepted")
+	}
+	tp.HandleDatagram(hdr, syn(key))
+	if len(l.queue) != 1 {
+		t.Fatal("segment with a valid signature is dropped")
+	}
+
+	go l.Accept()
+	synAck := <-tp.SendQueue
+	if err := synAck.Packet.ReCalculateChecksum(local, peer); err != nil {
+		t.Fatal(err)
+	}
+	if !synAck.Packet.VerifySignature(local, peer, key) {
+		t.Fatal("syn|ack is not signed")
+	}
+}
+
+func TestUnexpectedSignature(t *testing.T) {
+	tp, err := New(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := tp.Listen("0.0.0.0", 179)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := tcp.Build(40000, 179, 1000, 0, tcp.SYN, 29200, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.AddSignature([]byte("bgp-secret"))
+	tp.HandleDatagram(&ipv4.Header{Src: ipv4.IPAddress{10, 0, 0, 1}}, serializeSegment(t, p))
+	if len(l.queue) != 0 {
+		t.Fatal("signed segment is accepted without a key")
+	}
+}
+
+func TestMD5KeyRemovedWithConnection(t *testing.T) {
+	tp, err := New(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := newEstablishedConn(t, tp)
+	// configured by the dialer for the connection
+	tp.md5.set(conn.Peer.Port, conn.Peer.PeerAddr, []byte("bgp-secret"))
+	conn.abort(fmt.Errorf("connection timed out"))
+	if key := tp.md5.lookup(conn.Peer.Port, conn.Peer.PeerAddr); key != nil {
+		t.Fatalf("key is kept after the connection is closed: %s", key)
+	}
+}
@@ -0,0 +1,39 @@
+package tcp
+
+import "github.com/terassyi/gotcp/pkg/packet/tcp"
+
+// queueOutOfOrder keeps the segment arriving ahead of rcv.NXT within the window.
+// It reports whether the segment is queued.
+func (c *Conn) queueOutOfOrder(packet AddressedPacket) bool {
+	l := len(packet.Packet.Data)
+	off := packet.Packet.Header.Sequence - c.tcb.rcv.NXT
+	if l == 0 || int32(off) <= 0 || off+uint32(l) > c.rcvWindow() {
+		return false
+	}
+	c.mutex.Lock()
+	if _, ok := c.ooo[packet.Packet.Header.Sequence]; !ok {
+		c.ooo[packet.Packet.Header.Sequence] = packet.own()
+	}
+	c.mutex.Unlock()
+	// duplicate ack to inform the peer of the hole
+	if err := c.send(tcp.ACK, nil); err != nil {
+		c.logger.Error(err)
+	}
+	return true
+}
+
+// nextInOrder returns the queued segment starting at rcv.NXT and discards the stale ones.
+func (c *Conn) nextInOrder() (AddressedPacket, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for seq := range c.ooo {
+		if int32(seq-c.tcb.rcv.NXT) < 0 {
+			delete(c.ooo, seq)
+		}
+	}
+	p, ok := c.ooo[c.tcb.rcv.NXT]
+	if ok {
+		delete(c.ooo, c.tcb.rcv.NXT)
+	}
+	return p, ok
+}
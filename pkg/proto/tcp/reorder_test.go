@@ -0,0 +1,56 @@
+package tcp
+
+import (
+	"testing"
+
+	"github.com/terassyi/gotcp/pkg/packet/ipv4"
+	"github.com/terassyi/gotcp/pkg/packet/tcp"
+)
+
+func TestOutOfOrderQueue(t *testing.T) {
+	tp, err := New(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := newEstablishedConn(t, tp)
+	// handled without a reader
+	conn.rcvBuffer.readable = make(chan struct{}, 2)
+
+	// a segment beyond rcv.nxt is queued and acked with rcv.nxt
+	world, err := tcp.Build(40000, 8080, 1005, 5000, tcp.ACK|tcp.PSH, 29200, 0, []byte("world"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := serializeSegment(t, world)
+	tp.HandlePacket(&ipv4.IPAddress{192, 168, 0, 3}, buf)
+	// the buffer of the frame is reused after handled
+	for i := range buf {
+		buf[i] = 0
+	}
+	dup := <-tp.SendQueue
+	if dup.Packet.Header.Ack != 1000 {
+		t.Fatalf("actual ack: %d", dup.Packet.Header.Ack)
+	}
+	if len(conn.ooo) != 1 {
+		t.Fatalf("actual out-of-order queue length: %d", len(conn.ooo))
+	}
+
+	// filling the hole delivers the queued segment
+	hello, err := tcp.Build(40000, 8080, 1000, 5000, tcp.ACK|tcp.PSH, 29200, 0, []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tp.HandlePacket(&ipv4.IPAddress{192, 168, 0, 3}, serializeSegment(t, hello))
+	for _, want := range []uint32{1005, 1010} {
+		ack := <-tp.SendQueue
+		if ack.Packet.Header.Ack != want {
+			t.Fatalf("actual ack: %d", ack.Packet.Header.Ack)
+		}
+	}
+	if string(conn.rcvBuffer.buf) != "helloworld" {
+		t.Fatalf("actual: %s", conn.rcvBuffer.buf)
+	}
+	if conn.tcb.rcv.NXT != 1010 || len(conn.ooo) != 0 {
+		t.Fatalf("actual rcv.nxt=%d out-of-order queue length=%d", conn.tcb.rcv.NXT, len(conn.ooo))
+	}
+}
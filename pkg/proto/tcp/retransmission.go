@@ -1,11 +1,79 @@
 package tcp
 
-import "time"
+import (
+	"sync"
+	"time"
+)
 
 type retransmissionPacket struct {
-	timeStamp time.Time
-	ackNum uint32
-	packet *AddressedPacket
+	timeStamp     time.Time
+	ackNum        uint32
+	packet        *AddressedPacket
+	retransmitted bool
 }
 
-type retransmissionFunc func(queue []retransmissionPacket) error
\ No newline at end of file
+type retransmissionFunc func(queue []retransmissionPacket) error
+
+const (
+	initialRto time.Duration = time.Second // RFC 6298
+	minRto     time.Duration = time.Second
+	maxRto     time.Duration = 60 * time.Second
+)
+
+// rttEstimator computes the retransmission timeout (RFC 6298).
+type rttEstimator struct {
+	srtt     time.Duration
+	rttvar   time.Duration
+	rto      time.Duration
+	measured bool
+	mutex    *sync.RWMutex
+}
+
+func newRttEstimator() *rttEstimator {
+	return &rttEstimator{
+		rto:   initialRto,
+		mutex: &sync.RWMutex{},
+	}
+}
+
+// sample updates the estimation with the round trip time measured for a segment not retransmitted.
+func (r *rttEstimator) sample(m time.Duration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if !r.measured {
+		r.srtt = m
+		r.rttvar = m / 2
+		r.measured = true
+	} else {
+		diff := r.srtt - m
+		if diff < 0 {
+			diff = -diff
+		}
+		// alpha = 1/8, beta = 1/4
+		r.rttvar = (3*r.rttvar + diff) / 4
+		r.srtt = (7*r.srtt + m) / 8
+	}
+	r.rto = r.srtt + 4*r.rttvar
+	if r.rto < minRto {
+		r.rto = minRto
+	}
+	if r.rto > maxRto {
+		r.rto = maxRto
+	}
+}
+
+// backoff doubles the timeout after the timer expired.
+func (r *rttEstimator) backoff() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.rto *= 2
+	if r.rto > maxRto {
+		r.rto = maxRto
+	}
+}
+
+func (r *rttEstimator) timeout() time.Duration {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.rto
+}
@@ -0,0 +1,102 @@
+package tcp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/terassyi/gotcp/pkg/packet/ipv4"
+	"github.com/terassyi/gotcp/pkg/packet/tcp"
+)
+
+func TestRttEstimator(t *testing.T) {
+	r := newRttEstimator()
+	r.sample(2 * time.Second)
+	if r.srtt != 2*time.Second || r.rttvar != time.Second || r.rto != 6*time.Second {
+		t.Fatalf("actual srtt=%v rttvar=%v rto=%v", r.srtt, r.rttvar, r.rto)
+	}
+	r.sample(2 * time.Second)
+	if r.srtt != 2*time.Second || r.rttvar != 750*time.Millisecond || r.rto != 5*time.Second {
+		t.Fatalf("actual srtt=%v rttvar=%v rto=%v", r.srtt, r.rttvar, r.rto)
+	}
+	r.backoff()
+	if r.rto != 10*time.Second {
+		t.Fatalf("actual rto: %v", r.rto)
+	}
+	for i := 0; i < 10; i++ {
+		r.backoff()
+	}
+	if r.rto != maxRto {
+		t.Fatalf("actual rto: %v", r.rto)
+	}
+}
+
+// waitRtt polls the estimator until cond holds.
+func waitRtt(t *testing.T, r *rttEstimator, cond func(r *rttEstimator) bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		r.mutex.RLock()
+		ok := cond(r)
+		r.mutex.RUnlock()
+		if ok {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("actual srtt=%v rto=%v", r.srtt, r.rto)
+}
+
+func TestRetransmission(t *testing.T) {
+	tp, err := New(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := newEstablishedConn(t, tp)
+	ack := func(n uint32) {
+		// the handler takes the sent segments before the ack
+		for len(conn.retransmissionQueue) > 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		p, err := tcp.Build(40000, 8080, 1000, n, tcp.ACK, 29200, 0, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tp.HandlePacket(&ipv4.IPAddress{192, 168, 0, 3}, serializeSegment(t, p))
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := conn.Write(make([]byte, 100)); err != nil {
+			t.Fatal(err)
+		}
+		<-tp.SendQueue
+	}
+	// one ack removes both segments and gives a sample
+	ack(5200)
+	waitRtt(t, conn.rtt, func(r *rttEstimator) bool { return r.measured })
+	conn.rtt.mutex.RLock()
+	srtt := conn.rtt.srtt
+	conn.rtt.mutex.RUnlock()
+
+	if _, err := conn.Write(make([]byte, 100)); err != nil {
+		t.Fatal(err)
+	}
+	<-tp.SendQueue
+	select {
+	case p := <-tp.SendQueue:
+		// only the unacknowledged segment is sent again
+		if p.Packet.Header.Sequence != 5200 {
+			t.Fatalf("actual retransmitted sequence: %d", p.Packet.Header.Sequence)
+		}
+	case <-time.After(3 * minRto):
+		t.Fatal("not retransmitted")
+	}
+	waitRtt(t, conn.rtt, func(r *rttEstimator) bool { return r.rto == 2*minRto })
+
+	// Karn's algorithm, the ack of the retransmitted segment is not sampled
+	ack(5300)
+	time.Sleep(200 * time.Millisecond)
+	conn.rtt.mutex.RLock()
+	defer conn.rtt.mutex.RUnlock()
+	if conn.rtt.srtt != srtt || conn.rtt.rto != 2*minRto {
+		t.Fatalf("actual srtt=%v rto=%v", conn.rtt.srtt, conn.rtt.rto)
+	}
+}
@@ -0,0 +1,155 @@
+package tcp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/terassyi/gotcp/pkg/clock"
+	"github.com/terassyi/gotcp/pkg/packet/ipv4"
+	"github.com/terassyi/gotcp/pkg/packet/tcp"
+)
+
+// newVirtualConn returns the established connection whose timers are driven by the virtual clock.
+// The retransmission ticker is already waiting on the clock.
+func newVirtualConn(t *testing.T) (*Tcp, *Conn, *clock.Virtual) {
+	t.Helper()
+	tp, err := New(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := clock.NewVirtual(time.Unix(0, 0))
+	tp.Clock = v
+	conn := newEstablishedConn(t, tp)
+	v.BlockUntil(1)
+	return tp, conn, v
+}
+
+// peerSegment passes the segment from the peer of newEstablishedConn.
+func peerSegment(t *testing.T, tp *Tcp, seq, ack uint32, flag tcp.ControlFlag) {
+	t.Helper()
+	p, err := tcp.Build(40000, 8080, seq, ack, flag, 29200, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tp.HandlePacket(&ipv4.IPAddress{192, 168, 0, 3}, serializeSegment(t, p))
+}
+
+func sent(t *testing.T, tp *Tcp) *tcp.Packet {
+	t.Helper()
+	select {
+	case p := <-tp.SendQueue:
+		return p.Packet
+	case <-time.After(time.Second):
+		t.Fatal("no segment is sent")
+	}
+	return nil
+}
+
+func notSent(t *testing.T, tp *Tcp) {
+	t.Helper()
+	select {
+	case p := <-tp.SendQueue:
+		t.Fatalf("unexpected segment: seq=%d", p.Packet.Header.Sequence)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func waitConnState(t *testing.T, conn *Conn, state string) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for conn.Info().State != state {
+		if time.Now().After(deadline) {
+			t.Fatalf("state is %s, want %s", conn.Info().State, state)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRetransmissionBackoff(t *testing.T) {
+	tp, conn, v := newVirtualConn(t)
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	first := sent(t, tp)
+
+	for _, rto := range []time.Duration{initialRto, 2 * initialRto, 4 * initialRto} {
+		v.Advance(rto - 100*time.Millisecond)
+		notSent(t, tp)
+		v.Advance(100 * time.Millisecond)
+		p := sent(t, tp)
+		if p.Header.Sequence != first.Header.Sequence || string(p.Data) != "hello" {
+			t.Fatalf("retransmitted seq=%d data=%q", p.Header.Sequence, p.Data)
+		}
+	}
+	info := conn.Info()
+	if info.Retransmits != 3 || info.RTO != 8*initialRto {
+		t.Fatalf("retransmits=%d rto=%v", info.Retransmits, info.RTO)
+	}
+}
+
+func TestTimeWait(t *testing.T) {
+	tp, conn, v := newVirtualConn(t)
+	tp.MSL = time.Minute
+	closed := make(chan error, 1)
+	go func() { closed <- conn.Close() }()
+	fin := sent(t, tp)
+	if !fin.Header.OffsetControlFlag.ControlFlag().Fin() {
+		t.Fatal("fin is not sent")
+	}
+	peerSegment(t, tp, 1000, 5001, tcp.ACK)
+	peerSegment(t, tp, 1000, 5001, tcp.FIN|tcp.ACK)
+	sent(t, tp)
+	waitConnState(t, conn, "TIME_WAIT")
+	// the ticker and the timer of TIME_WAIT
+	v.BlockUntil(2)
+	v.Advance(tp.MSL - time.Second)
+	select {
+	case <-closed:
+		t.Fatal("closed before the timer expires")
+	case <-time.After(50 * time.Millisecond):
+	}
+	v.Advance(time.Second)
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("TIME_WAIT does not expire")
+	}
+	waitConnState(t, conn, "CLOSED")
+}
+
+func TestKeepAlive(t *testing.T) {
+	tp, conn, v := newVirtualConn(t)
+	if err := conn.SetKeepAlive(10*time.Second, time.Second, 3); err != nil {
+		t.Fatal(err)
+	}
+	// the ticker and the timer of keep-alive
+	v.BlockUntil(2)
+	v.Advance(10 * time.Second)
+	probe := sent(t, tp)
+	if probe.Header.Sequence != 4999 || len(probe.Data) != 0 {
+		t.Fatalf("probe seq=%d len=%d", probe.Header.Sequence, len(probe.Data))
+	}
+	peerSegment(t, tp, 1000, 5000, tcp.ACK)
+
+	// the answered probe restarts the idle timer
+	v.BlockUntil(2)
+	v.Advance(time.Second)
+	notSent(t, tp)
+	v.BlockUntil(2)
+	v.Advance(9 * time.Second)
+	sent(t, tp)
+	for n := 0; n < 2; n++ {
+		v.BlockUntil(2)
+		v.Advance(time.Second)
+		sent(t, tp)
+	}
+	v.BlockUntil(2)
+	v.Advance(time.Second)
+	waitConnState(t, conn, "CLOSED")
+	if _, err := conn.Read(make([]byte, 1)); err == nil || err.Error() != "connection timed out" {
+		t.Fatalf("read error: %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package tcp
+
+import "fmt"
+
+// Urgent data (RFC 793, RFC 6093)
+// The urgent pointer points to the byte following the last byte of urgent data.
+
+type urgent struct {
+	inline bool // deliver urgent data in the stream
+	mark   bool // rcv.UP is valid
+	oob    byte // inline, oob and hasOob are guarded by the mutex of the conn
+	hasOob bool
+	notify chan struct{}
+}
+
+func newUrgent() *urgent {
+	return &urgent{
+		inline: true,
+		notify: make(chan struct{}, 1),
+	}
+}
+
+// WriteUrgent sends b as urgent data.
+func (c *Conn) WriteUrgent(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, fmt.Errorf("urgent data is empty")
+	}
+	c.tcb.mutex.Lock()
+	defer c.tcb.mutex.Unlock()
+	if !c.tcb.IsReadySend() {
+		return 0, fmt.Errorf("invalid state")
+	}
+	c.tcb.snd.UP = c.tcb.snd.NXT + uint32(len(b))
+	return c.writeWithSegment(b)
+}
+
+// SetUrgentInline selects how received urgent data is delivered.
+// Inline delivery is the default as recommended by RFC 6093, urgent data stays in the stream and only notified.
+// Otherwise the last byte of urgent data is removed from the stream and read by ReadUrgent as out-of-band data.
+func (c *Conn) SetUrgentInline(inline bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.urg.inline = inline
+}
+
+// Urgent returns the channel notified when the peer advances the urgent pointer.
+func (c *Conn) Urgent() <-chan struct{} {
+	return c.urg.notify
+}
+
+// ReadUrgent returns the out-of-band byte.
+func (c *Conn) ReadUrgent() (byte, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.urg.inline {
+		return 0, fmt.Errorf("urgent data is delivered inline")
+	}
+	if !c.urg.hasOob {
+		return 0, fmt.Errorf("no urgent data")
+	}
+	c.urg.hasOob = false
+	return c.urg.oob, nil
+}
+
+// urgentPointer returns the urgent pointer for the segment starting with seq.
+func (c *Conn) urgentPointer(seq uint32) (uint16, bool) {
+	off := int32(c.tcb.snd.UP - seq)
+	if off <= 0 {
+		return 0, false
+	}
+	if off > 0xffff {
+		return 0xffff, true
+	}
+	return uint16(off), true
+}
+
+func (c *Conn) handleUrgent(packet AddressedPacket) {
+	if !packet.Packet.Header.OffsetControlFlag.ControlFlag().Urg() {
+		return
+	}
+	switch c.tcb.state {
+	case ESTABLISHED, FIN_WAIT1, FIN_WAIT2:
+	default:
+		// ignore the urg
+		return
+	}
+	up := packet.Packet.Header.Sequence + uint32(packet.Packet.Header.Urgent)
+	if c.urg.mark && int32(up-c.tcb.rcv.UP) <= 0 {
+		return
+	}
+	c.tcb.rcv.UP = up
+	c.urg.mark = true
+	select {
+	case c.urg.notify <- struct{}{}:
+	default:
+	}
+}
+
+// extractUrgent removes the out-of-band byte from the segment text.
+func (c *Conn) extractUrgent(seq uint32, data []byte) []byte {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.urg.inline || !c.urg.mark {
+		return data
+	}
+	off := int32(c.tcb.rcv.UP - 1 - seq)
+	if off < 0 || int(off) >= len(data) {
+		return data
+	}
+	c.urg.oob = data[off]
+	c.urg.hasOob = true
+	d := make([]byte, 0, len(data)-1)
+	d = append(d, data[:off]...)
+	return append(d, data[off+1:]...)
+}
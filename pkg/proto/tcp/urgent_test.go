@@ -0,0 +1,133 @@
+package tcp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/terassyi/gotcp/pkg/packet/ipv4"
+	"github.com/terassyi/gotcp/pkg/packet/tcp"
+)
+
+func TestWriteUrgent(t *testing.T) {
+	tp, err := New(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := newEstablishedConn(t, tp)
+	if _, err := conn.Write([]byte("normal")); err != nil {
+		t.Fatal(err)
+	}
+	p := <-tp.SendQueue
+	if p.Packet.Header.OffsetControlFlag.ControlFlag().Urg() {
+		t.Fatal("urg is set for normal data")
+	}
+	if _, err := conn.WriteUrgent([]byte{0xff, 0xf4}); err != nil {
+		t.Fatal(err)
+	}
+	p = <-tp.SendQueue
+	if !p.Packet.Header.OffsetControlFlag.ControlFlag().Urg() {
+		t.Fatal("urg is not set")
+	}
+	// points to the byte following the urgent data
+	if p.Packet.Header.Urgent != 2 {
+		t.Fatalf("actual urgent pointer: %d", p.Packet.Header.Urgent)
+	}
+	if conn.tcb.snd.UP != p.Packet.Header.Sequence+2 {
+		t.Fatalf("actual snd.up: %d", conn.tcb.snd.UP)
+	}
+}
+
+func TestReceiveUrgent(t *testing.T) {
+	for _, inline := range []bool{true, false} {
+		tp, err := New(false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn := newEstablishedConn(t, tp)
+		conn.SetUrgentInline(inline)
+		data, err := tcp.Build(40000, 8080, 1000, 5000, tcp.ACK|tcp.PSH|tcp.URG, 29200, 3, []byte("ab!cd"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		go tp.HandlePacket(&ipv4.IPAddress{192, 168, 0, 3}, serializeSegment(t, data))
+		buf := make([]byte, 100)
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-conn.Urgent():
+		default:
+			t.Fatal("urgent data is not notified")
+		}
+		if conn.tcb.rcv.UP != 1003 {
+			t.Fatalf("actual rcv.up: %d", conn.tcb.rcv.UP)
+		}
+		if inline {
+			if string(buf[:n]) != "ab!cd" {
+				t.Fatalf("actual: %s", buf[:n])
+			}
+			if _, err := conn.ReadUrgent(); err == nil {
+				t.Fatal("out-of-band data is read in inline mode")
+			}
+			continue
+		}
+		if string(buf[:n]) != "abcd" {
+			t.Fatalf("actual: %s", buf[:n])
+		}
+		b, err := conn.ReadUrgent()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b != '!' {
+			t.Fatalf("actual: %c", b)
+		}
+		if _, err := conn.ReadUrgent(); err == nil {
+			t.Fatal("out-of-band data is read twice")
+		}
+	}
+}
+
+func TestReadUrgentWhileReceiving(t *testing.T) {
+	tp, err := New(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := newEstablishedConn(t, tp)
+	conn.SetUrgentInline(false)
+	data, err := tcp.Build(40000, 8080, 1000, 5000, tcp.ACK|tcp.PSH|tcp.URG, 29200, 3, []byte("ab!cd"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan byte, 1)
+	go func() {
+		// read out-of-band data concurrently with the segment handler
+		deadline := time.After(time.Second)
+		select {
+		case <-conn.Urgent():
+		case <-deadline:
+			return
+		}
+		// the urgent pointer is notified before the byte is extracted
+		for {
+			if b, err := conn.ReadUrgent(); err == nil {
+				received <- b
+				return
+			}
+			select {
+			case <-deadline:
+				return
+			case <-time.After(time.Millisecond):
+			}
+		}
+	}()
+	go tp.HandlePacket(&ipv4.IPAddress{192, 168, 0, 3}, serializeSegment(t, data))
+	select {
+	case b := <-received:
+		if b != '!' {
+			t.Fatalf("actual: %c", b)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("out-of-band data is not received")
+	}
+}